@@ -2,58 +2,756 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
+// Package bark provides functionality for sending push notifications to iOS devices via Bark.
+// It works with the public server at api.day.app as well as self-hosted bark-server instances.
 package bark
 
-import "github.com/sk-pkg/notify/msgid"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// Constants used throughout the package
+const (
+	// defaultServer is the URL of the public Bark server.
+	defaultServer = "https://api.day.app"
+
+	// pushAPI is the URL for sending pushes.
+	pushAPI = "/push"
+
+	// missingDeviceKeyMessage is the error message of servers which ignore device_keys in a batch push.
+	missingDeviceKeyMessage = "device key is empty"
+)
+
+// Interruption levels of a push, which control how it is presented on the device.
+const (
+	// LevelActive lights up the screen and plays a sound. It is the default of the Bark app.
+	LevelActive = "active"
+
+	// LevelTimeSensitive is presented immediately, even during a Focus.
+	LevelTimeSensitive = "timeSensitive"
+
+	// LevelPassive adds the push to the notification list without lighting up the screen.
+	LevelPassive = "passive"
+
+	// LevelCritical plays a sound even if the device is muted or in Do Not Disturb.
+	LevelCritical = "critical"
+)
+
+// defaultLevels maps message levels to the interruption levels used when Config.Levels does not override them.
+var defaultLevels = map[string]string{
+	"info":    LevelPassive,
+	"success": LevelActive,
+	"warn":    LevelTimeSensitive,
+	"error":   LevelTimeSensitive,
+}
+
+// Config represents the configuration for the Bark notifier.
+type Config struct {
+	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
+	Enabled bool
+
+	// ChannelSize defines the buffer size for the message channel.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	ChannelSize int
+
+	// PoolSize defines the number of goroutines in the worker pool.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	PoolSize int
+
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
+	// IdleSize is kept for compatibility and is currently unused.
+	IdleSize int
+
+	// Server is the URL of the Bark server, e.g. a self-hosted bark-server.
+	// If empty, it defaults to https://api.day.app.
+	Server string
+
+	// Devices is a map of device names to their corresponding configurations.
+	Devices map[string]Device
+
+	// Groups is a map of group names to the names of their devices.
+	// A group name can be used wherever a device name is expected.
+	Groups map[string][]string
+
+	// DefaultDevices is the list of device or group names pushed to when a message does not specify any.
+	DefaultDevices []string
+
+	// Levels maps message levels (info, success, warn, error) to interruption levels.
+	// Levels that are not set use the defaults: passive for info, active for success,
+	// and timeSensitive for warn and error.
+	Levels map[string]string
+
+	// Retry is the policy applied to failed deliveries: transport errors, 408, 429 and 5xx responses.
+	// Only the devices that did not receive the push are retried.
+	// The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+}
+
+// Device represents the configuration for a device registered with Bark.
+type Device struct {
+	// Key is the device key shown in the Bark app.
+	Key string
+
+	// Encryption encrypts pushes to the device so that their content is hidden from the Bark server.
+	// It must match the encryption settings in the Bark app. If nil, pushes are sent in plain text.
+	Encryption *Encryption
+}
+
+// Notify is the interface that wraps the basic methods for the notifier.
+type Notify interface {
+	// StartProcessor initiates the message processing routine.
+	// It should be called once before submitting any messages.
+	StartProcessor()
+
+	// SubmitMessage adds a new message to the processing queue.
+	// The message will be processed asynchronously by the processor started with StartProcessor.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message.
+	SubmitMessage(message Message) (msgID string, err error)
+
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
+	// Send pushes a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- results: The result of every target device, in the order they were given.
+	// 	- err: An error if the message is invalid, or the errors of all failed devices joined together.
+	Send(message Message) (results []DeviceResult, err error)
+
+	// SendContext pushes a message immediately, bypassing the processing queue, and stops retrying
+	// once ctx is done.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- results: The result of every target device, in the order they were given.
+	// 	- attempts: The number of attempts made.
+	// 	- err: An error if the message is invalid, or the errors of all failed devices joined together.
+	SendContext(ctx context.Context, message Message) (results []DeviceResult, attempts int, err error)
+
+	// Subscribe registers a handler called with the result of every delivery attempt, including the
+	// retries and the messages dropped before delivery, of the queued messages and of SendContext.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
+	// Close stops the notifier, ensuring all pending messages are processed
+	// before shutting down. After calling Close, the notifier should not be used anymore.
+	Close()
+}
+
+// DeviceResult represents the result of pushing a message to a device.
+type DeviceResult struct {
+	// Device is the name of the device.
+	Device string
+
+	// Err is the error that occurred while pushing to the device, nil if the server accepted the push.
+	Err error
+}
+
+// notify implements the Notify interface.
+type notify struct {
+	// host is the URL of the Bark server.
+	host string
+
+	// msgID is ID instance used for generating unique message IDs.
+	msgID *msgid.ID
+
+	// request is a resty client used for making HTTP requests to the Bark server.
+	request *resty.Client
+
+	// devices is a map of device names to their corresponding configurations.
+	devices map[string]Device
+
+	// groups is a map of group names to the names of their devices.
+	groups map[string][]string
+
+	// defaultDevices is the list of device or group names pushed to when a message does not specify any.
+	defaultDevices []string
 
-type (
-	Config struct {
-		Enabled bool
+	// batchUnsupported is set once the server rejects a batch push, after which devices are pushed one by one.
+	batchUnsupported atomic.Bool
 
-		ChannelSize int
-		PoolSize    int
-		IdleSize    int
+	// levels maps message levels to interruption levels.
+	levels map[string]string
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
+}
+
+// Message represents a message to be sent via the notifier.
+type Message struct {
+	// ID is a unique identifier for the message. It can be used for tracking or deduplication.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets. It is reported in its SendResults.
+	DeliveryID string
+
+	// SendTo is a comma-separated list of device or group names, e.g. "iphone,oncall".
+	// If empty, the DefaultDevices from the Config will be used.
+	SendTo string
+
+	// MsgLevel indicates the importance or category of the message (e.g., "info", "warn", "error", "success").
+	// It selects the interruption level if InterruptionLevel is empty.
+	MsgLevel string
+
+	// Title is the title of the push.
+	Title string
+
+	// Content is the body of the push.
+	Content string
+
+	// Sound is the name of the sound played, e.g. "alarm". The sounds are listed in the Bark app.
+	Sound string
+
+	// Icon is the URL of a custom icon shown with the push.
+	Icon string
+
+	// Group groups pushes in the notification list and the history of the Bark app.
+	Group string
+
+	// URL is opened when the push is tapped.
+	URL string
+
+	// Badge is the number shown on the app icon. If 0, the badge is not changed.
+	Badge int
+
+	// Copy is the text copied when the push is copied. If empty, the content is copied.
+	Copy string
+
+	// AutoCopy copies the content, or Copy if set, to the clipboard when the push arrives.
+	AutoCopy bool
+
+	// IsArchive saves the push to the history of the Bark app regardless of the app settings.
+	IsArchive bool
+
+	// InterruptionLevel is the interruption level of the push: active, timeSensitive, passive or critical.
+	// If empty, the level mapped from MsgLevel is used.
+	InterruptionLevel string
+}
+
+// pushResp represents the response from the Bark push API.
+type pushResp struct {
+	Code      int    `json:"code"`      // Response code, 200 indicates success
+	Message   string `json:"message"`   // Error message if the request failed
+	Timestamp int64  `json:"timestamp"` // Server time of the response
+
+	// Data holds the per-device results of a batch push
+	Data json.RawMessage `json:"data"`
+}
+
+// batchResult represents the result of a device in a batch push.
+type batchResult struct {
+	Code      int    `json:"code"`       // Response code, 200 indicates success
+	Message   string `json:"message"`    // Error message if the push failed
+	DeviceKey string `json:"device_key"` // The key of the device
+}
+
+// target is a device a message is pushed to.
+type target struct {
+	name   string // The name of the device
+	device Device // The device configuration
+}
+
+// validateConfig checks the provided configuration for validity.
+//
+// Parameters:
+//   - config: A pointer to the Config struct to be validated.
+//
+// Returns:
+//   - error: An error if the configuration is invalid, nil otherwise.
+func validateConfig(config *Config) error {
+	if len(config.Devices) == 0 {
+		return errors.New("there are no available devices for bark, please configure Devices")
 	}
 
-	Notify interface {
-		StartProcessor()
-		SubmitMessage(message Message) (msgID string)
-		Close()
+	for name, device := range config.Devices {
+		if device.Key == "" {
+			return fmt.Errorf("bark device config error: %s", name)
+		}
+
+		if device.Encryption != nil {
+			if err := device.Encryption.validate(); err != nil {
+				return fmt.Errorf("bark device %s: %w", name, err)
+			}
+		}
 	}
 
-	notify struct {
-		msgID    *msgid.ID
-		messages chan Message
+	for group, names := range config.Groups {
+		if _, ok := config.Devices[group]; ok {
+			return fmt.Errorf("bark group %s has the same name as a device", group)
+		}
+
+		if len(names) == 0 {
+			return fmt.Errorf("bark group %s has no devices", group)
+		}
+
+		for _, name := range names {
+			if _, ok := config.Devices[name]; !ok {
+				return fmt.Errorf("device %s of group %s is not found in bark", name, group)
+			}
+		}
 	}
 
-	Message struct {
-		ID      string
-		Title   string
-		Content string
+	for _, name := range config.DefaultDevices {
+		_, isDevice := config.Devices[name]
+		_, isGroup := config.Groups[name]
+		if !isDevice && !isGroup {
+			return fmt.Errorf("default device %s is not found in bark", name)
+		}
 	}
-)
 
-func (n *notify) Close() {
-	return
+	for level, interruptionLevel := range config.Levels {
+		if !validInterruptionLevel(interruptionLevel) {
+			return fmt.Errorf("invalid bark interruption level %q for level %s", interruptionLevel, level)
+		}
+	}
+
+	if config.Server == "" {
+		config.Server = defaultServer
+	}
+	config.Server = strings.TrimSuffix(config.Server, "/")
+
+	// Set default values for ChannelSize and PoolSize if not provided
+	// Default to GOMAXPROCS * 10
+	if config.ChannelSize == 0 {
+		config.ChannelSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid bark retry policy: %w", err)
+	}
+
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid bark outbox: %w", err)
+	}
+
+	return nil
+}
+
+// validInterruptionLevel reports whether an interruption level is supported by Bark.
+func validInterruptionLevel(level string) bool {
+	switch level {
+	case LevelActive, LevelTimeSensitive, LevelPassive, LevelCritical:
+		return true
+	default:
+		return false
+	}
 }
 
+// StartProcessor starts the message processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
 func (n *notify) StartProcessor() {
+	n.queue.Start()
+}
 
+// SubmitMessage submits a message to the notifier's message queue.
+//
+// Parameters:
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
+func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
 }
 
-func (n *notify) SubmitMessage(message Message) (msgID string) {
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
 	if message.ID == "" {
 		message.ID = n.msgID.New()
 	}
 
-	n.messages <- message
+	key := outbox.Key(message.ID, message.SendTo)
 
-	return message.ID
+	return message.ID, n.queue.Submit(ctx, key, message)
 }
 
+// New creates a new Notify instance with the provided configuration.
+//
+// Parameters:
+//   - config: The Config struct containing the notifier configuration.
+//
+// Returns:
+//   - Notify: A new Notify instance.
+//   - error: An error if the configuration is invalid or if the goroutine pool cannot be created.
 func New(config Config) (Notify, error) {
-	return &notify{
-		messages: make(chan Message, config.ChannelSize),
-		msgID:    msgid.NewMessageID(),
-	}, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	levels := make(map[string]string, len(defaultLevels))
+	for level, interruptionLevel := range defaultLevels {
+		levels[level] = interruptionLevel
+	}
+	for level, interruptionLevel := range config.Levels {
+		levels[level] = interruptionLevel
+	}
+
+	n := &notify{
+		host:           config.Server,
+		msgID:          msgid.NewMessageID(),
+		request:        resty.New(),
+		devices:        config.Devices,
+		groups:         config.Groups,
+		defaultDevices: config.DefaultDevices,
+		levels:         levels,
+		retry:          config.Retry,
+	}
+
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "bark",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send: func(_ context.Context, m Message) (string, error) {
+			_, err := n.sendMsg(m)
+
+			return "", err
+		},
+		// Only the devices that did not receive the message are retried
+		Retried: func(m Message, err error) (Message, bool) {
+			return n.failedDevices(m, deviceResults(err))
+		},
+		Report: n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
+	return n, nil
+}
+
+// Send pushes a message immediately and reports the result of every device.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - []DeviceResult: The result of every target device, nil if the message is invalid.
+//   - error: An error if the message is invalid, or the errors of all failed devices joined together.
+func (n *notify) Send(message Message) ([]DeviceResult, error) {
+	results, _, err := n.SendContext(context.Background(), message)
+
+	return results, err
+}
+
+// SendContext pushes a message immediately and reports the result of every device, and stops retrying
+// once ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - []DeviceResult: The result of every target device, nil if the message is invalid.
+//   - int: The number of attempts made.
+//   - error: An error if the message is invalid, or the errors of all failed devices joined together.
+func (n *notify) SendContext(ctx context.Context, message Message) ([]DeviceResult, int, error) {
+	if message.ID == "" {
+		message.ID = n.msgID.New()
+	}
+
+	if err := ctx.Err(); err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return nil, 0, err
+	}
+
+	results, err := n.sendMsg(message)
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	for attempt := 1; ; attempt++ {
+		result := queue.Result{State: StateFailed, Err: err, Attempts: attempt}
+		if err == nil {
+			result.State = StateSent
+		}
+
+		delay, ok := n.retry.Delay(attempt, err)
+		if !ok {
+			n.report(message, result)
+			return results, attempt, err
+		}
+
+		retried, ok := n.failedDevices(message, results)
+		if !ok {
+			n.report(message, result)
+			return results, attempt, err
+		}
+
+		result.State = StateRetrying
+		n.report(message, result)
+
+		if waitErr := retry.Wait(ctx, delay); waitErr != nil {
+			result.State, result.Err = StateFailed, fmt.Errorf("%w, retry aborted: %w", err, waitErr)
+			n.report(message, result)
+
+			return results, attempt, result.Err
+		}
+
+		retryResults, _ := n.sendMsg(retried)
+		for _, r := range retryResults {
+			for i := range results {
+				if results[i].Device == r.Device {
+					results[i].Err = r.Err
+				}
+			}
+		}
+
+		err = joinDeviceErrors(results)
+	}
+}
+
+// failedDevices restricts the recipients of a message to the devices whose push failed with a retryable error,
+// so that a retry does not push the message twice to the other devices.
+//
+// Parameters:
+//   - m: The Message struct that was sent.
+//   - results: The results of the devices.
+//
+// Returns:
+//   - Message: The message to be retried.
+//   - bool: false if no device is worth retrying.
+func (n *notify) failedDevices(m Message, results []DeviceResult) (Message, bool) {
+	var names []string
+	for _, result := range results {
+		if n.retry.IsRetryable(result.Err) {
+			names = append(names, result.Device)
+		}
+	}
+
+	m.SendTo = strings.Join(names, ",")
+
+	return m, len(names) > 0
+}
+
+// sendMsg pushes a message to every target device.
+// Delivery continues with the remaining devices if one of them fails.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - []DeviceResult: The result of every target device, nil if the message is invalid.
+//   - error: An error if the message is invalid, or the errors of all failed devices joined together.
+func (n *notify) sendMsg(m Message) ([]DeviceResult, error) {
+	names := n.defaultDevices
+	if m.SendTo != "" {
+		names = splitDeviceNames(m.SendTo)
+	}
+
+	targets, err := n.resolveTargets(names)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := n.buildPushParams(m)
+	if err != nil {
+		return nil, err
+	}
+
+	results := n.deliver(targets, params)
+
+	return results, joinDeviceErrors(results)
+}
+
+// deviceError is the error of the push to a device.
+type deviceError struct {
+	// device is the name of the device.
+	device string
+
+	// err is the error of the push.
+	err error
+}
+
+// Error returns the name of the device and the error of the push.
+func (e *deviceError) Error() string {
+	return fmt.Sprintf("device %s: %v", e.device, e.err)
+}
+
+// Unwrap returns the error of the push.
+func (e *deviceError) Unwrap() error {
+	return e.err
+}
+
+// joinDeviceErrors joins the errors of the failed devices together.
+func joinDeviceErrors(results []DeviceResult) error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, &deviceError{device: result.Device, err: result.Err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deviceResults returns the results of the failed devices from the errors joined by joinDeviceErrors.
+//
+// Parameters:
+//   - err: The error returned by sendMsg.
+//
+// Returns:
+//   - []DeviceResult: The results of the failed devices, nil if the message failed as a whole.
+func deviceResults(err error) []DeviceResult {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return nil
+	}
+
+	var results []DeviceResult
+	for _, e := range joined.Unwrap() {
+		var devErr *deviceError
+		if errors.As(e, &devErr) {
+			results = append(results, DeviceResult{Device: devErr.device, Err: devErr.err})
+		}
+	}
+
+	return results
+}
+
+// resolveTargets expands device and group names into the devices to push to.
+// Devices named more than once, directly or through groups, are pushed to only once.
+//
+// Parameters:
+//   - names: The device or group names.
+//
+// Returns:
+//   - []target: The devices to push to, in the order they were named.
+//   - error: An error if there are no devices or a name is unknown.
+func (n *notify) resolveTargets(names []string) ([]target, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no devices for bark message")
+	}
+
+	var (
+		targets []target
+		seen    = make(map[string]bool)
+	)
+	add := func(name string) error {
+		device, ok := n.devices[name]
+		if !ok {
+			return fmt.Errorf("device %s is not found in bark", name)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			targets = append(targets, target{name: name, device: device})
+		}
+
+		return nil
+	}
+
+	for _, name := range names {
+		members, isGroup := n.groups[name]
+		if !isGroup {
+			members = []string{name}
+		}
+
+		for _, member := range members {
+			if err := add(member); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// splitDeviceNames splits a comma-separated list of device names.
+func splitDeviceNames(sendTo string) []string {
+	var names []string
+	for _, name := range strings.Split(sendTo, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Close stops the notifier, waits for all messages to be processed, and releases resources.
+func (n *notify) Close() {
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
+
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
+
+	log.Println("Bark notify closed")
 }
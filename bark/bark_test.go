@@ -0,0 +1,616 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a fake Bark server that records the body of every request
+// and fails pushes to the device keys in failKeys.
+// If batch is false, it rejects batch pushes like servers without device_keys support.
+func newTestServer(t *testing.T, batch bool, failKeys ...string) (*httptest.Server, func() []map[string]any) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []map[string]any
+	)
+
+	failed := func(key any) bool {
+		for _, k := range failKeys {
+			if key == k {
+				return true
+			}
+		}
+		return false
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pushAPI, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var params map[string]any
+		_ = json.Unmarshal(body, &params)
+
+		mu.Lock()
+		requests = append(requests, params)
+		mu.Unlock()
+
+		if keys, ok := params["device_keys"].([]any); ok && batch {
+			items := make([]batchResult, len(keys))
+			for i, key := range keys {
+				items[i] = batchResult{Code: 200, Message: "success", DeviceKey: key.(string)}
+				if failed(key) {
+					items[i] = batchResult{Code: 400, Message: "failed to get device token", DeviceKey: key.(string)}
+				}
+			}
+
+			data, _ := json.Marshal(items)
+			_ = json.NewEncoder(w).Encode(pushResp{Code: 200, Message: "success", Data: data})
+			return
+		}
+
+		if params["device_key"] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(pushResp{Code: 400, Message: "device key is empty"})
+			return
+		}
+
+		if failed(params["device_key"]) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(pushResp{Code: 400, Message: "failed to get device token"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(pushResp{Code: 200, Message: "success"})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]any(nil), requests...)
+	}
+}
+
+func newTestNotify(t *testing.T, config Config) *notify {
+	t.Helper()
+
+	config.Enabled = true
+	if config.Devices == nil {
+		config.Devices = map[string]Device{
+			"iphone": {Key: "iphone_key"},
+			"ipad":   {Key: "ipad_key"},
+		}
+		config.DefaultDevices = []string{"iphone"}
+	}
+
+	i, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return i.(*notify)
+}
+
+func TestNew(t *testing.T) {
+	devices := map[string]Device{"iphone": {Key: "iphone_key"}}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:    "Valid config",
+			config:  Config{Enabled: true, Devices: devices, DefaultDevices: []string{"iphone"}},
+			wantErr: false,
+		},
+		{
+			name:    "Valid config with levels",
+			config:  Config{Enabled: true, Devices: devices, Levels: map[string]string{"error": LevelCritical}},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid config - no devices",
+			config:  Config{Enabled: true},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - missing key",
+			config:  Config{Enabled: true, Devices: map[string]Device{"iphone": {}}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - unknown default device",
+			config:  Config{Enabled: true, Devices: devices, DefaultDevices: []string{"ipad"}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - unknown interruption level",
+			config:  Config{Enabled: true, Devices: devices, Levels: map[string]string{"error": "loud"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := New(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && n == nil {
+				t.Errorf("New() returned nil Notify")
+			}
+		})
+	}
+}
+
+func TestNew_Server(t *testing.T) {
+	n := newTestNotify(t, Config{})
+	if n.host != defaultServer {
+		t.Errorf("host = %q, want %q", n.host, defaultServer)
+	}
+
+	n = newTestNotify(t, Config{Server: "https://bark.example.com/"})
+	if n.host != "https://bark.example.com" {
+		t.Errorf("host = %q, want trailing slash trimmed", n.host)
+	}
+}
+
+func TestSubmitMessage(t *testing.T) {
+	srv, pushes := newTestServer(t, true)
+	n := newTestNotify(t, Config{Server: srv.URL})
+	n.StartProcessor()
+
+	msgID, err := n.SubmitMessage(Message{Title: "Deploy", Content: "done"})
+	if err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+	if msgID == "" {
+		t.Errorf("SubmitMessage() returned empty msgID")
+	}
+
+	n.Close()
+
+	got := pushes()
+	if len(got) != 1 || got[0]["device_key"] != "iphone_key" || got[0]["body"] != "done" {
+		t.Errorf("pushes = %v, want one push to iphone_key", got)
+	}
+}
+
+func TestSendMsg(t *testing.T) {
+	t.Run("All parameters", func(t *testing.T) {
+		srv, pushes := newTestServer(t, true)
+		n := newTestNotify(t, Config{Server: srv.URL})
+
+		_, err := n.sendMsg(Message{
+			Title:             "Disk full",
+			Content:           "db-1 /var is 95% full",
+			Sound:             "alarm",
+			Icon:              "https://example.com/icon.png",
+			Group:             "ops",
+			URL:               "https://grafana.example.com",
+			Badge:             3,
+			Copy:              "db-1",
+			AutoCopy:          true,
+			IsArchive:         true,
+			InterruptionLevel: LevelCritical,
+		})
+		if err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		want := map[string]any{
+			"device_key": "iphone_key",
+			"title":      "Disk full",
+			"body":       "db-1 /var is 95% full",
+			"sound":      "alarm",
+			"icon":       "https://example.com/icon.png",
+			"group":      "ops",
+			"url":        "https://grafana.example.com",
+			"badge":      float64(3),
+			"copy":       "db-1",
+			"autoCopy":   "1",
+			"isArchive":  "1",
+			"level":      LevelCritical,
+		}
+
+		got := pushes()
+		if len(got) != 1 {
+			t.Fatalf("got %d pushes, want 1", len(got))
+		}
+		if len(got[0]) != len(want) {
+			t.Errorf("push = %v, want %v", got[0], want)
+		}
+		for key, value := range want {
+			if got[0][key] != value {
+				t.Errorf("push[%s] = %v, want %v", key, got[0][key], value)
+			}
+		}
+	})
+
+	t.Run("Optional parameters omitted", func(t *testing.T) {
+		srv, pushes := newTestServer(t, true)
+		n := newTestNotify(t, Config{Server: srv.URL})
+
+		if _, err := n.sendMsg(Message{Content: "ping"}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := pushes()
+		if len(got) != 1 || len(got[0]) != 2 {
+			t.Errorf("push = %v, want only device_key and body", got)
+		}
+	})
+
+	t.Run("Level mapping", func(t *testing.T) {
+		srv, pushes := newTestServer(t, true)
+		n := newTestNotify(t, Config{Server: srv.URL, Levels: map[string]string{"error": LevelCritical}})
+
+		messages := []Message{
+			{Content: "a", MsgLevel: "info"},
+			{Content: "b", MsgLevel: "success"},
+			{Content: "c", MsgLevel: "warn"},
+			{Content: "d", MsgLevel: "error"},
+			{Content: "e", MsgLevel: "warn", InterruptionLevel: LevelPassive},
+		}
+		for _, m := range messages {
+			if _, err := n.sendMsg(m); err != nil {
+				t.Fatalf("sendMsg() error = %v", err)
+			}
+		}
+
+		want := []string{LevelPassive, LevelActive, LevelTimeSensitive, LevelCritical, LevelPassive}
+		got := pushes()
+		for i, level := range want {
+			if got[i]["level"] != level {
+				t.Errorf("push %s level = %v, want %s", got[i]["body"], got[i]["level"], level)
+			}
+		}
+	})
+
+	t.Run("Several devices", func(t *testing.T) {
+		srv, pushes := newTestServer(t, false, "iphone_key")
+		n := newTestNotify(t, Config{Server: srv.URL})
+		n.batchUnsupported.Store(true)
+
+		_, err := n.sendMsg(Message{SendTo: "iphone, ipad", Content: "hello"})
+		if err == nil {
+			t.Errorf("sendMsg() error = nil, want error for iphone")
+		}
+
+		got := pushes()
+		if len(got) != 2 || got[1]["device_key"] != "ipad_key" {
+			t.Errorf("pushes = %v, want delivery to continue with ipad", got)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		srv, pushes := newTestServer(t, true)
+		n := newTestNotify(t, Config{Server: srv.URL})
+
+		messages := []Message{
+			{SendTo: "watch", Content: "hello"},
+			{},
+			{Content: "hello", InterruptionLevel: "loud"},
+		}
+		for _, m := range messages {
+			if _, err := n.sendMsg(m); err == nil {
+				t.Errorf("sendMsg(%+v) error = nil, want error", m)
+			}
+		}
+
+		if got := pushes(); len(got) != 0 {
+			t.Errorf("pushes = %v, want none", got)
+		}
+	})
+}
+
+func TestSend_Batch(t *testing.T) {
+	devices := map[string]Device{
+		"alice":  {Key: "alice_key"},
+		"bob":    {Key: "bob_key"},
+		"carol":  {Key: "carol_key"},
+		"secure": {Key: "secure_key", Encryption: &Encryption{Mode: ModeECB, Key: "1234567890123456"}},
+	}
+	groups := map[string][]string{"oncall": {"alice", "bob", "secure"}}
+
+	t.Run("Single request", func(t *testing.T) {
+		srv, pushes := newTestServer(t, true, "bob_key")
+		n := newTestNotify(t, Config{Server: srv.URL, Devices: devices, Groups: groups})
+
+		results, err := n.Send(Message{SendTo: "oncall,carol,alice", Title: "db-1 is down"})
+		if err == nil {
+			t.Errorf("Send() error = nil, want error for bob")
+		}
+
+		want := []DeviceResult{{Device: "alice"}, {Device: "bob"}, {Device: "secure"}, {Device: "carol"}}
+		if len(results) != len(want) {
+			t.Fatalf("results = %v, want %v", results, want)
+		}
+		for i, result := range results {
+			if result.Device != want[i].Device || (result.Err != nil) != (result.Device == "bob") {
+				t.Errorf("results[%d] = %+v, want %s failed only for bob", i, result, want[i].Device)
+			}
+		}
+
+		got := pushes()
+		if len(got) != 2 {
+			t.Fatalf("got %d requests, want a batch and an encrypted push", len(got))
+		}
+
+		keys, _ := got[0]["device_keys"].([]any)
+		if len(keys) != 3 || keys[0] != "alice_key" || keys[1] != "bob_key" || keys[2] != "carol_key" {
+			t.Errorf("device_keys = %v, want alice, bob and carol", got[0]["device_keys"])
+		}
+		if got[0]["title"] != "db-1 is down" || got[0]["device_key"] != nil {
+			t.Errorf("batch push = %v", got[0])
+		}
+		if got[1]["device_key"] != "secure_key" || got[1]["ciphertext"] == nil {
+			t.Errorf("encrypted push = %v, want it sent on its own", got[1])
+		}
+	})
+
+	t.Run("Fallback to per-device requests", func(t *testing.T) {
+		srv, pushes := newTestServer(t, false, "bob_key")
+		n := newTestNotify(t, Config{Server: srv.URL, Devices: devices, Groups: groups})
+
+		results, err := n.Send(Message{SendTo: "alice,bob,carol", Content: "hello"})
+		if err == nil {
+			t.Errorf("Send() error = nil, want error for bob")
+		}
+		for _, result := range results {
+			if (result.Err != nil) != (result.Device == "bob") {
+				t.Errorf("result = %+v, want failed only for bob", result)
+			}
+		}
+
+		if got := pushes(); len(got) != 4 || got[0]["device_keys"] == nil {
+			t.Errorf("requests = %v, want a rejected batch and 3 single pushes", got)
+		}
+
+		// The server is remembered as not supporting batches
+		if _, err := n.Send(Message{SendTo: "alice,carol", Content: "hello"}); err != nil {
+			t.Errorf("Send() error = %v", err)
+		}
+		if got := pushes(); len(got) != 6 || got[4]["device_key"] != "alice_key" {
+			t.Errorf("requests = %v, want no further batch", got)
+		}
+	})
+
+	t.Run("Transient batch failure", func(t *testing.T) {
+		var (
+			mu       sync.Mutex
+			requests []map[string]any
+		)
+
+		// The first batch is answered 503, the next ones succeed
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var params map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&params)
+
+			mu.Lock()
+			requests = append(requests, params)
+			first := len(requests) == 1
+			mu.Unlock()
+
+			if first {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(pushResp{Code: 503, Message: "service unavailable"})
+				return
+			}
+
+			keys, _ := params["device_keys"].([]any)
+			items := make([]batchResult, len(keys))
+			for i, key := range keys {
+				items[i] = batchResult{Code: 200, Message: "success", DeviceKey: key.(string)}
+			}
+			data, _ := json.Marshal(items)
+			_ = json.NewEncoder(w).Encode(pushResp{Code: 200, Message: "success", Data: data})
+		}))
+		t.Cleanup(srv.Close)
+
+		n := newTestNotify(t, Config{Server: srv.URL, Devices: devices})
+		n.retry = retry.Policy{MaxAttempts: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+		if _, err := n.Send(Message{SendTo: "alice,bob", Content: "hello"}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(requests) != 2 || requests[1]["device_keys"] == nil || n.batchUnsupported.Load() {
+			t.Errorf("requests = %v, want the batch retried as a batch", requests)
+		}
+	})
+
+	t.Run("Push API not found", func(t *testing.T) {
+		var hits atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			http.NotFound(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		n := newTestNotify(t, Config{Server: srv.URL, Devices: devices})
+		n.retry = retry.Policy{MaxAttempts: 1}
+
+		if _, err := n.Send(Message{SendTo: "alice,bob", Content: "hello"}); err == nil {
+			t.Error("Send() error = nil, want error")
+		}
+		if !n.batchUnsupported.Load() || hits.Load() != 3 {
+			t.Errorf("batchUnsupported = %v after %d requests, want a batch and 2 single pushes", n.batchUnsupported.Load(), hits.Load())
+		}
+	})
+}
+
+func TestNew_Groups(t *testing.T) {
+	devices := map[string]Device{"alice": {Key: "alice_key"}}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:   "Valid config with group as default",
+			config: Config{Devices: devices, Groups: map[string][]string{"oncall": {"alice"}}, DefaultDevices: []string{"oncall"}},
+		},
+		{
+			name:    "Invalid config - unknown group member",
+			config:  Config{Devices: devices, Groups: map[string][]string{"oncall": {"bob"}}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - empty group",
+			config:  Config{Devices: devices, Groups: map[string][]string{"oncall": {}}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - group named like a device",
+			config:  Config{Devices: devices, Groups: map[string][]string{"alice": {"alice"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// newRetryTestServer creates a batch server unavailable for ipad in the first batch only.
+func newRetryTestServer(t *testing.T) (*httptest.Server, func() []map[string]any) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []map[string]any
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&params)
+
+		mu.Lock()
+		requests = append(requests, params)
+		mu.Unlock()
+
+		if keys, ok := params["device_keys"].([]any); ok {
+			items := make([]batchResult, len(keys))
+			for i, key := range keys {
+				items[i] = batchResult{Code: 200, Message: "success", DeviceKey: key.(string)}
+				if key == "ipad_key" {
+					items[i] = batchResult{Code: 503, Message: "service unavailable", DeviceKey: key.(string)}
+				}
+			}
+
+			data, _ := json.Marshal(items)
+			_ = json.NewEncoder(w).Encode(pushResp{Code: 200, Message: "success", Data: data})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(pushResp{Code: 200, Message: "success"})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]map[string]any(nil), requests...)
+	}
+}
+
+func TestSend_Retry(t *testing.T) {
+	srv, requests := newRetryTestServer(t)
+	n := newTestNotify(t, Config{Server: srv.URL})
+	n.retry = retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	results, attempts, err := n.SendContext(context.Background(), Message{SendTo: "iphone,ipad", Content: "hello"})
+	if err != nil || attempts != 2 {
+		t.Fatalf("SendContext() = %d attempts, %v, want 2 attempts", attempts, err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("results = %+v, want both devices pushed", results)
+	}
+
+	if got := requests(); len(got) != 2 || got[1]["device_key"] != "ipad_key" {
+		t.Errorf("requests = %v, want a batch and a retry to ipad only", got)
+	}
+}
+
+func TestSubmitMessage_Retry(t *testing.T) {
+	srv, requests := newRetryTestServer(t)
+	n := newTestNotify(t, Config{
+		Server: srv.URL,
+		Retry:  retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+
+	// The queued deliveries are reported to the subscribers
+	var states []string
+	n.Subscribe(func(r SendResult) { states = append(states, r.MsgID+":"+r.DeliveryID+":"+r.State) })
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{ID: "msg-1", DeliveryID: "1", SendTo: "iphone,ipad", Content: "hello"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	// Close waits for the scheduled retry, which is only pushed to the device that failed
+	n.Close()
+
+	if got := requests(); len(got) != 2 || got[1]["device_key"] != "ipad_key" {
+		t.Errorf("requests = %v, want a batch and a retry to ipad only", got)
+	}
+	if want := "[msg-1:1:retrying msg-1:1:sent]"; fmt.Sprint(states) != want {
+		t.Errorf("published %v, want %s", states, want)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		Devices:        map[string]Device{"iphone": {Key: "iphone_key"}, "ipad": {Key: "ipad_key"}},
+		DefaultDevices: []string{"iphone"},
+		Outbox:         outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	// A message is queued once per list of devices
+	message := Message{ID: "msg-1", Content: "disk full"}
+	for _, to := range []string{"", "iphone", "ipad"} {
+		message.SendTo = to
+		if _, err = i.SubmitMessage(message); err != nil {
+			t.Fatalf("SubmitMessage() to %q error = %v", to, err)
+		}
+	}
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+}
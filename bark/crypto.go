@@ -0,0 +1,156 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bark
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Encryption modes supported by the Bark app.
+const (
+	// ModeCBC encrypts with AES in CBC mode and PKCS#7 padding. It requires a 16 byte IV.
+	ModeCBC = "CBC"
+
+	// ModeECB encrypts with AES in ECB mode and PKCS#7 padding. It does not use an IV.
+	ModeECB = "ECB"
+
+	// ModeGCM encrypts with AES in GCM mode, appending the 16 byte tag to the ciphertext. It uses a random
+	// 12 byte nonce for every push, as reusing a nonce with the same key breaks GCM.
+	ModeGCM = "GCM"
+)
+
+// ivAlphabet is the set of characters random IVs are drawn from, since the Bark app reads the IV as text.
+const ivAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// Encryption represents the encryption settings of a device, matching the ones entered in the Bark app.
+type Encryption struct {
+	// Mode is the block cipher mode: CBC, ECB or GCM.
+	Mode string
+
+	// Key is the AES key. Its length of 16, 24 or 32 characters selects AES-128, AES-192 or AES-256.
+	Key string
+
+	// IV is the initialization vector of CBC, of 16 characters. It is unused for ECB, and must be empty
+	// for GCM, whose nonce is always random. If empty, a random IV is generated for every push and sent
+	// along with the ciphertext.
+	IV string
+}
+
+// ivSize returns the IV length required by the mode, or 0 if the mode does not use an IV.
+func (e *Encryption) ivSize() int {
+	switch e.Mode {
+	case ModeCBC:
+		return aes.BlockSize
+	case ModeGCM:
+		return 12
+	default:
+		return 0
+	}
+}
+
+// validate checks that the mode is supported and that the key and IV have valid lengths.
+func (e *Encryption) validate() error {
+	switch e.Mode {
+	case ModeCBC, ModeECB, ModeGCM:
+	default:
+		return fmt.Errorf("unsupported encryption mode: %q", e.Mode)
+	}
+
+	switch len(e.Key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(e.Key))
+	}
+
+	if e.Mode == ModeGCM && e.IV != "" {
+		return fmt.Errorf("encryption IV cannot be set in %s mode, a random nonce is used for every push", e.Mode)
+	}
+
+	if e.IV != "" && len(e.IV) != e.ivSize() {
+		return fmt.Errorf("encryption IV must be %d bytes in %s mode, got %d", e.ivSize(), e.Mode, len(e.IV))
+	}
+
+	return nil
+}
+
+// encrypt encrypts the parameters of a push as JSON.
+//
+// Parameters:
+//   - params: The parameters of the push, without the device key.
+//
+// Returns:
+//   - string: The base64 encoded ciphertext.
+//   - string: The IV or GCM nonce used, or empty in ECB mode.
+//   - error: An error if the parameters cannot be encrypted.
+func (e *Encryption) encrypt(params map[string]any) (string, string, error) {
+	plaintext, err := json.Marshal(params)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal push: %w", err)
+	}
+
+	block, err := aes.NewCipher([]byte(e.Key))
+	if err != nil {
+		return "", "", err
+	}
+
+	// A GCM nonce must never be reused with the same key, so it is random even if an IV is configured
+	iv := e.IV
+	if (iv == "" || e.Mode == ModeGCM) && e.ivSize() > 0 {
+		if iv, err = randomIV(e.ivSize()); err != nil {
+			return "", "", err
+		}
+	}
+
+	var ciphertext []byte
+	switch e.Mode {
+	case ModeCBC:
+		ciphertext = pkcs7Pad(plaintext, aes.BlockSize)
+		cipher.NewCBCEncrypter(block, []byte(iv)).CryptBlocks(ciphertext, ciphertext)
+	case ModeECB:
+		// The standard library has no ECB mode, each block is encrypted on its own
+		ciphertext = pkcs7Pad(plaintext, aes.BlockSize)
+		for i := 0; i < len(ciphertext); i += aes.BlockSize {
+			block.Encrypt(ciphertext[i:i+aes.BlockSize], ciphertext[i:i+aes.BlockSize])
+		}
+	case ModeGCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return "", "", err
+		}
+		ciphertext = aead.Seal(nil, []byte(iv), plaintext, nil)
+	default:
+		return "", "", fmt.Errorf("unsupported encryption mode: %q", e.Mode)
+	}
+
+	return base64.StdEncoding.EncodeToString(ciphertext), iv, nil
+}
+
+// pkcs7Pad appends PKCS#7 padding to data, always adding at least one byte.
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+// randomIV generates a random alphanumeric IV of the given length.
+func randomIV(size int) (string, error) {
+	iv := make([]byte, size)
+	max := big.NewInt(int64(len(ivAlphabet)))
+	for i := range iv {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate IV: %w", err)
+		}
+		iv[i] = ivAlphabet[idx.Int64()]
+	}
+
+	return string(iv), nil
+}
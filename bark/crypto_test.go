@@ -0,0 +1,261 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bark
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// decrypt decrypts a push the way the Bark app does: AES with PKCS#7 padding in CBC and ECB modes,
+// and a combined ciphertext and 16 byte tag in GCM mode.
+func decrypt(t *testing.T, e Encryption, ciphertext, iv string) map[string]any {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatalf("failed to decode ciphertext: %v", err)
+	}
+
+	block, err := aes.NewCipher([]byte(e.Key))
+	if err != nil {
+		t.Fatalf("aes.NewCipher() error = %v", err)
+	}
+
+	var plaintext []byte
+	switch e.Mode {
+	case ModeCBC, ModeECB:
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+			t.Fatalf("ciphertext length %d is not a multiple of the block size", len(data))
+		}
+
+		plaintext = make([]byte, len(data))
+		if e.Mode == ModeCBC {
+			cipher.NewCBCDecrypter(block, []byte(iv)).CryptBlocks(plaintext, data)
+		} else {
+			for i := 0; i < len(data); i += aes.BlockSize {
+				block.Decrypt(plaintext[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+			}
+		}
+
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			t.Fatalf("invalid padding %d", padding)
+		}
+		plaintext = plaintext[:len(plaintext)-padding]
+	case ModeGCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatalf("cipher.NewGCM() error = %v", err)
+		}
+		if plaintext, err = aead.Open(nil, []byte(iv), data, nil); err != nil {
+			t.Fatalf("failed to open GCM ciphertext: %v", err)
+		}
+	}
+
+	var params map[string]any
+	if err := json.Unmarshal(plaintext, &params); err != nil {
+		t.Fatalf("failed to unmarshal plaintext %q: %v", plaintext, err)
+	}
+
+	return params
+}
+
+func TestEncryption_Encrypt(t *testing.T) {
+	params := map[string]any{"title": "db-1.internal", "body": "connection refused", "level": LevelTimeSensitive}
+
+	for _, mode := range []string{ModeCBC, ModeECB, ModeGCM} {
+		for _, keySize := range []int{16, 24, 32} {
+			e := Encryption{Mode: mode, Key: "0123456789abcdefghijklmnopqrstuv"[:keySize]}
+			if mode == ModeCBC {
+				e.IV = "abcdefghijklmnop"
+			}
+
+			t.Run(fmt.Sprintf("%s AES-%d", mode, keySize*8), func(t *testing.T) {
+				if err := e.validate(); err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+
+				ciphertext, iv, err := e.encrypt(params)
+				if err != nil {
+					t.Fatalf("encrypt() error = %v", err)
+				}
+
+				if len(iv) != e.ivSize() {
+					t.Errorf("iv = %q, want %d bytes", iv, e.ivSize())
+				}
+				if e.IV != "" && iv != e.IV {
+					t.Errorf("iv = %q, want configured %q", iv, e.IV)
+				}
+
+				got := decrypt(t, e, ciphertext, iv)
+				for key, value := range params {
+					if got[key] != value {
+						t.Errorf("decrypted %s = %v, want %v", key, got[key], value)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncryption_KnownAnswer(t *testing.T) {
+	// Generated with the shell example of the Bark documentation:
+	// echo -n "$json" | openssl enc -aes-128-cbc -K $(printf $key | xxd -ps -c 200) -iv $(printf $iv | xxd -ps -c 200) | base64
+	params := map[string]any{"body": "test", "sound": "birdsong"}
+
+	tests := []struct {
+		name string
+		e    Encryption
+		want string
+	}{
+		{
+			name: "CBC",
+			e:    Encryption{Mode: ModeCBC, Key: "1234567890123456", IV: "abcdefghijklmnop"},
+			want: "jhnt6l2irloilwiQ359KMcqT81uMxL2Cw772iOMGzZ8f9/fonfVIWkCTupTT8m9j",
+		},
+		{
+			name: "ECB",
+			e:    Encryption{Mode: ModeECB, Key: "1234567890123456"},
+			want: "HoJPTeVBKoM8RtzYWztjEX9onEiiVgvmM8cSrMMTIGpb75SJeclntup12UhBVOgX",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := tt.e.encrypt(params)
+			if err != nil {
+				t.Fatalf("encrypt() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("encrypt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryption_RandomIV(t *testing.T) {
+	e := Encryption{Mode: ModeGCM, Key: "1234567890123456"}
+	params := map[string]any{"body": "hello"}
+
+	first, iv1, err := e.encrypt(params)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	second, iv2, err := e.encrypt(params)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	if iv1 == iv2 || first == second {
+		t.Errorf("encrypt() reused IV %q, want a new IV per push", iv1)
+	}
+	for _, c := range iv1 {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			t.Errorf("iv = %q, want alphanumeric characters", iv1)
+		}
+	}
+}
+
+func TestEncryption_GCMNonce(t *testing.T) {
+	// The nonce is random even if an IV slipped past validate
+	e := Encryption{Mode: ModeGCM, Key: "1234567890123456", IV: "abcdefghijkl"}
+	params := map[string]any{"body": "hello"}
+
+	_, nonce1, err := e.encrypt(params)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	ciphertext, nonce2, err := e.encrypt(params)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	if len(nonce1) != 12 || len(nonce2) != 12 {
+		t.Errorf("nonces = %q, %q, want 12 bytes", nonce1, nonce2)
+	}
+	if nonce1 == nonce2 || nonce1 == e.IV || nonce2 == e.IV {
+		t.Errorf("encrypt() nonces = %q, %q, want a new random nonce per push", nonce1, nonce2)
+	}
+
+	if got := decrypt(t, e, ciphertext, nonce2); got["body"] != "hello" {
+		t.Errorf("decrypted push = %v", got)
+	}
+}
+
+func TestEncryption_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		e       Encryption
+		wantErr bool
+	}{
+		{name: "Valid CBC", e: Encryption{Mode: ModeCBC, Key: "1234567890123456", IV: "abcdefghijklmnop"}},
+		{name: "Valid ECB", e: Encryption{Mode: ModeECB, Key: "123456789012345678901234"}},
+		{name: "Valid GCM without IV", e: Encryption{Mode: ModeGCM, Key: "12345678901234567890123456789012"}},
+		{name: "Invalid mode", e: Encryption{Mode: "CTR", Key: "1234567890123456"}, wantErr: true},
+		{name: "Invalid key length", e: Encryption{Mode: ModeCBC, Key: "short"}, wantErr: true},
+		{name: "Invalid IV length", e: Encryption{Mode: ModeCBC, Key: "1234567890123456", IV: "abcdefghijkl"}, wantErr: true},
+		{name: "Invalid GCM with IV", e: Encryption{Mode: ModeGCM, Key: "1234567890123456", IV: "abcdefghijkl"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.e.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendMsg_Encrypted(t *testing.T) {
+	srv, pushes := newTestServer(t, true)
+
+	e := Encryption{Mode: ModeCBC, Key: "1234567890123456"}
+	n := newTestNotify(t, Config{
+		Server: srv.URL,
+		Devices: map[string]Device{
+			"iphone": {Key: "iphone_key", Encryption: &e},
+			"ipad":   {Key: "ipad_key"},
+		},
+	})
+
+	_, err := n.sendMsg(Message{SendTo: "iphone,ipad", Title: "db-1.internal", Content: "down", Sound: "alarm"})
+	if err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	got := pushes()
+	if len(got) != 2 {
+		t.Fatalf("got %d pushes, want 2", len(got))
+	}
+
+	encrypted := got[0]
+	if len(encrypted) != 3 || encrypted["device_key"] != "iphone_key" {
+		t.Fatalf("push = %v, want only device_key, ciphertext and iv", encrypted)
+	}
+
+	params := decrypt(t, e, encrypted["ciphertext"].(string), encrypted["iv"].(string))
+	if params["title"] != "db-1.internal" || params["body"] != "down" || params["sound"] != "alarm" {
+		t.Errorf("decrypted push = %v", params)
+	}
+
+	if got[1]["title"] != "db-1.internal" {
+		t.Errorf("push to ipad = %v, want plain text", got[1])
+	}
+}
+
+func TestNew_InvalidEncryption(t *testing.T) {
+	_, err := New(Config{
+		Enabled: true,
+		Devices: map[string]Device{"iphone": {Key: "iphone_key", Encryption: &Encryption{Mode: ModeCBC, Key: "short"}}},
+	})
+	if err == nil {
+		t.Errorf("New() error = nil, want error for invalid encryption")
+	}
+}
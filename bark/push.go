@@ -0,0 +1,282 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/retry"
+	"github.com/sk-pkg/notify/util"
+	"log"
+	"net/http"
+)
+
+// buildPushParams serializes a Message into the parameters of the push API, without the device key.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - map[string]any: The parameters of the push.
+//   - error: An error if the message has no text or its interruption level is invalid.
+func (n *notify) buildPushParams(m Message) (map[string]any, error) {
+	if m.Title == "" && m.Content == "" {
+		return nil, errors.New("bark message text is empty")
+	}
+
+	level := m.InterruptionLevel
+	if level == "" {
+		level = n.levels[m.MsgLevel]
+	}
+
+	if level != "" && !validInterruptionLevel(level) {
+		return nil, fmt.Errorf("invalid bark interruption level: %s", level)
+	}
+
+	params := map[string]any{"body": m.Content}
+	optional := map[string]string{
+		"title": m.Title,
+		"sound": m.Sound,
+		"icon":  m.Icon,
+		"group": m.Group,
+		"url":   m.URL,
+		"copy":  m.Copy,
+		"level": level,
+	}
+	for key, value := range optional {
+		if value != "" {
+			params[key] = value
+		}
+	}
+
+	if m.Badge != 0 {
+		params["badge"] = m.Badge
+	}
+	if m.AutoCopy {
+		params["autoCopy"] = "1"
+	}
+	if m.IsArchive {
+		params["isArchive"] = "1"
+	}
+
+	return params, nil
+}
+
+// deliver pushes a message to its target devices.
+// Devices without encryption share the same payload and are pushed in a single batch request when there
+// are several of them, while encrypted devices are pushed one by one with their own ciphertext.
+//
+// Parameters:
+//   - targets: The devices to push to.
+//   - params: The parameters of the push, without the device key.
+//
+// Returns:
+//   - []DeviceResult: The result of every device, in the order of targets.
+func (n *notify) deliver(targets []target, params map[string]any) []DeviceResult {
+	results := make([]DeviceResult, len(targets))
+
+	var batch []int
+	for i, t := range targets {
+		results[i].Device = t.name
+		if t.device.Encryption == nil && !n.batchUnsupported.Load() {
+			batch = append(batch, i)
+		}
+	}
+
+	pushed := make([]bool, len(targets))
+	if len(batch) > 1 {
+		for i, err := range n.pushBatch(targets, batch, params) {
+			results[i].Err = err
+			pushed[i] = true
+		}
+	}
+
+	for i, t := range targets {
+		if !pushed[i] {
+			results[i].Err = n.push(t.device, params)
+		}
+	}
+
+	return results
+}
+
+// push sends a push to a device, encrypting its parameters if the device has encryption configured.
+//
+// Parameters:
+//   - device: The Device configuration.
+//   - params: The parameters of the push, without the device key.
+//
+// Returns:
+//   - error: An error if the push cannot be sent, nil otherwise.
+func (n *notify) push(device Device, params map[string]any) error {
+	body := make(map[string]any, len(params)+1)
+	if device.Encryption != nil {
+		ciphertext, iv, err := device.Encryption.encrypt(params)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt push: %w", err)
+		}
+
+		body["ciphertext"] = ciphertext
+		if iv != "" {
+			body["iv"] = iv
+		}
+	} else {
+		for key, value := range params {
+			body[key] = value
+		}
+	}
+	body["device_key"] = device.Key
+
+	rs, _, err := n.postPush(body)
+	if err != nil {
+		return err
+	}
+
+	return checkCode(rs.Code, rs.Message)
+}
+
+// checkCode returns an error for a code other than 200, marked as transient if it is a server error.
+//
+// Parameters:
+//   - code: The code of the push.
+//   - message: The error message of the push.
+//
+// Returns:
+//   - error: nil if code is 200.
+func checkCode(code int, message string) error {
+	if code == 200 {
+		return nil
+	}
+
+	err := fmt.Errorf("failed to send push: %d %s", code, message)
+	if retry.TransientStatus(code) {
+		return retry.Transient(err)
+	}
+
+	return err
+}
+
+// pushBatch sends a push to several devices in a single request using the device_keys parameter.
+//
+// Servers that do not support batching answer 404, or reject the request for its missing device key.
+// They are remembered, and the devices the server did not report on are left out of the returned map
+// so that the caller pushes them one by one. Any other failure of the request is the result of every
+// device of the batch, so that it follows the retry policy.
+//
+// Parameters:
+//   - targets: The devices of the message.
+//   - batch: The indexes in targets of the devices to push to.
+//   - params: The parameters of the push, without the device keys.
+//
+// Returns:
+//   - map[int]error: The result of every device the server reported on, by index in targets.
+func (n *notify) pushBatch(targets []target, batch []int, params map[string]any) map[int]error {
+	keys := make([]string, len(batch))
+	for i, idx := range batch {
+		keys[i] = targets[idx].device.Key
+	}
+
+	body := make(map[string]any, len(params)+1)
+	for key, value := range params {
+		body[key] = value
+	}
+	body["device_keys"] = keys
+
+	results := make(map[int]error, len(batch))
+
+	rs, status, err := n.postPush(body)
+	if batchRejected(status, rs) {
+		n.batchUnsupported.Store(true)
+		log.Printf("bark server does not support batch pushes, falling back to per-device requests: status %d\n", status)
+		return results
+	}
+
+	if err == nil {
+		err = checkCode(rs.Code, rs.Message)
+	}
+	if err != nil {
+		// Pushing the devices one by one would fail alike
+		for _, idx := range batch {
+			results[idx] = err
+		}
+		return results
+	}
+
+	var items []batchResult
+	if json.Unmarshal(rs.Data, &items) != nil || len(items) == 0 {
+		// Whether the devices were pushed is unknown, but the server may support batches later on
+		log.Println("bark server answered a batch push without per-device results, falling back to per-device requests")
+		return results
+	}
+
+	byKey := make(map[string]batchResult, len(items))
+	for _, item := range items {
+		byKey[item.DeviceKey] = item
+	}
+
+	for _, idx := range batch {
+		item, ok := byKey[targets[idx].device.Key]
+		if !ok {
+			continue
+		}
+
+		results[idx] = checkCode(item.Code, item.Message)
+	}
+
+	return results
+}
+
+// batchRejected reports whether the reply to a batch push means that the server does not support batches:
+// the push API is not found, or the server ignored device_keys and found no device key.
+//
+// Parameters:
+//   - status: The HTTP status code of the reply.
+//   - rs: The parsed reply, nil if it could not be parsed.
+//
+// Returns:
+//   - bool: true if batches are not supported.
+func batchRejected(status int, rs *pushResp) bool {
+	if status == http.StatusNotFound {
+		return true
+	}
+
+	return rs != nil && (rs.Code == http.StatusNotFound || rs.Code == http.StatusBadRequest && rs.Message == missingDeviceKeyMessage)
+}
+
+// postPush posts a request body to the push API.
+//
+// Parameters:
+//   - body: The request body.
+//
+// Returns:
+//   - *pushResp: The parsed response, whatever its code.
+//   - int: The HTTP status code of the response, 0 if the request could not be sent.
+//   - error: An error if the request cannot be sent or the response cannot be parsed.
+func (n *notify) postPush(body map[string]any) (*pushResp, int, error) {
+	request := &Request{
+		Method:  "POST",
+		URL:     util.SpliceStr(n.host, pushAPI),
+		Headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		Body:    body,
+	}
+
+	response, err := n.sendBarkAPIRequest(request)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send push: %w", err)
+	}
+
+	var rs pushResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		err = fmt.Errorf("failed to parse response with status code %d: %w", response.StatusCode, err)
+		if retry.TransientStatus(response.StatusCode) {
+			err = retry.Transient(err)
+		}
+
+		return nil, response.StatusCode, err
+	}
+
+	return &rs, response.StatusCode, nil
+}
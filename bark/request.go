@@ -0,0 +1,50 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package bark
+
+// Request represents a request to the Bark server.
+type Request struct {
+	Method      string
+	URL         string
+	Headers     map[string]string
+	QueryParams map[string]string
+	Body        any
+}
+
+// Response represents a response from the Bark server.
+type Response struct {
+	StatusCode int
+	Body       []byte
+	Headers    map[string][]string
+}
+
+// sendBarkAPIRequest sends a request to the Bark server.
+//
+// Bark reports errors with both the HTTP status and the code in the response body,
+// so the response is returned for any status and checked by the caller.
+//
+// Parameters:
+//   - request: The Request containing the request details.
+//
+// Returns:
+//   - *Response: The response from the Bark server.
+//   - error: An error if the request cannot be executed, nil otherwise.
+func (n *notify) sendBarkAPIRequest(request *Request) (*Response, error) {
+	req := n.request.R().
+		SetHeaders(request.Headers).
+		SetQueryParams(request.QueryParams).
+		SetBody(request.Body)
+
+	resp, err := req.Execute(request.Method, request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode(),
+		Body:       resp.Body(),
+		Headers:    resp.Header(),
+	}, nil
+}
@@ -21,6 +21,13 @@ type Cache interface {
 	GetString(key string) (string, error)
 }
 
+// Deleter is implemented by caches that can remove a value before it expires.
+// It is used to invalidate the tokens rejected by a provider, if the Cache supports it.
+type Deleter interface {
+	// Delete removes a value from the cache.
+	Delete(key string) error
+}
+
 // cache implements the Cache interface using sync.Map as the underlying storage.
 type cache struct {
 	buckets sync.Map
@@ -127,6 +134,52 @@ func (c *cache) GetString(key string) (string, error) {
 	return v.value, nil
 }
 
+// Delete removes the cache entry associated with the key.
+// Deleting a key that does not exist is not an error.
+//
+// Parameters:
+//   - key: The unique identifier of the cache entry to remove.
+//
+// Returns:
+//   - error: Always returns nil in this implementation.
+//
+// Example:
+//
+//	c := cache.New()
+//	c.SetString("token", "abc", 7200)
+//	c.Delete("token") // The next GetString("token") returns ErrKeyNotFound
+func (c *cache) Delete(key string) error {
+	c.buckets.Delete(key)
+	return nil
+}
+
+// TokenExpiration returns the expiration, in seconds, of a cached token valid for expiresIn seconds.
+// The token expires margin seconds early so that it is not used while it expires, but it is kept
+// at least half of its lifetime, and at least 1 second, since an expiration of 0 never expires.
+//
+// Parameters:
+//   - expiresIn: The lifetime of the token given by the provider, in seconds.
+//   - margin: The number of seconds before the end of its lifetime the token should expire.
+//
+// Returns:
+//   - int: The expiration to pass to SetString.
+//
+// Example:
+//
+//	c.SetString("token", rs.AccessToken, cache.TokenExpiration(rs.ExpiresIn, 100))
+func TokenExpiration(expiresIn, margin int) int {
+	expiration := expiresIn - margin
+	if expiration < expiresIn/2 {
+		expiration = expiresIn / 2
+	}
+
+	if expiration < 1 {
+		return 1
+	}
+
+	return expiration
+}
+
 // New creates and returns a new instance of Cache.
 //
 // Returns:
@@ -129,4 +129,56 @@ func TestCache(t *testing.T) {
 			t.Errorf("GetString returned %v after delay, want %v", got, value)
 		}
 	})
+
+	// Test deleting a key
+	t.Run("Delete", func(t *testing.T) {
+		key := "delete_key"
+
+		// Set the value
+		err := c.SetString(key, "delete_value", 5)
+		if err != nil {
+			t.Errorf("SetString failed: %v", err)
+		}
+
+		// Delete the value
+		d, ok := c.(Deleter)
+		if !ok {
+			t.Fatal("cache does not implement Deleter")
+		}
+		err = d.Delete(key)
+		if err != nil {
+			t.Errorf("Delete failed: %v", err)
+		}
+
+		// Try to get the deleted value
+		_, err = c.GetString(key)
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound, got %v", err)
+		}
+
+		// Deleting a missing key is not an error
+		if err = d.Delete(key); err != nil {
+			t.Errorf("Delete of missing key failed: %v", err)
+		}
+	})
+}
+
+func TestTokenExpiration(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		want      int
+	}{
+		{expiresIn: 7200, want: 7100},
+		{expiresIn: 150, want: 75},
+		{expiresIn: 100, want: 50},
+		{expiresIn: 1, want: 1},
+		{expiresIn: 0, want: 1},
+		{expiresIn: -5, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := TokenExpiration(tt.expiresIn, 100); got != tt.want {
+			t.Errorf("TokenExpiration(%d, 100) = %d, want %d", tt.expiresIn, got, tt.want)
+		}
+	}
 }
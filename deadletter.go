@@ -0,0 +1,519 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DeadLetterNotFound is returned when a dead letter does not exist in the store
+var DeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message that could not be delivered, once the retries of its channel and the fallbacks
+// of its failover chain are exhausted.
+type DeadLetter struct {
+	// ID identifies the dead letter: the message ID and the channel it was sent to,
+	// followed by the SendChannelName and SendTo of its target if it has any.
+	ID string
+
+	// Message is the message as submitted to the Manager, before being applied to its target.
+	Message Message
+
+	// Target is the target the message was sent to. Its failover chain, if any, is tried again on replay.
+	Target Target
+
+	// Failures are the failed attempts of the message, on its channel and its fallbacks, oldest first.
+	Failures []Failure
+
+	// FirstFailedAt is the time of the first failed attempt.
+	FirstFailedAt time.Time
+
+	// DeadAt is the time the message was given up on.
+	DeadAt time.Time
+}
+
+// Failure is a failed attempt to deliver a message.
+type Failure struct {
+	// Channel is the channel the attempt was made on.
+	Channel Channel
+
+	// State is the state reported for the attempt: retrying, failed or dropped.
+	State string
+
+	// Err is the error of the attempt.
+	Err string
+
+	// Attempts is the number of attempts made on the channel so far.
+	Attempts int
+
+	// At is the time the failure was reported.
+	At time.Time
+}
+
+// DeadLetterStore stores the dead letters of a Manager.
+// Implementations must be safe for concurrent use.
+type DeadLetterStore interface {
+	// Put stores a dead letter, replacing the dead letter with the same ID if any.
+	//
+	// Parameters:
+	// 	- letter: The dead letter to be stored.
+	//
+	// Returns:
+	// 	- error: An error if the dead letter cannot be stored.
+	Put(letter DeadLetter) error
+
+	// Get returns a dead letter.
+	//
+	// Parameters:
+	// 	- id: The ID of the dead letter.
+	//
+	// Returns:
+	// 	- DeadLetter: The dead letter.
+	// 	- error: DeadLetterNotFound if there is no dead letter with this ID.
+	Get(id string) (DeadLetter, error)
+
+	// List returns all the dead letters, oldest first.
+	//
+	// Returns:
+	// 	- []DeadLetter: The dead letters.
+	// 	- error: An error if the dead letters cannot be read.
+	List() ([]DeadLetter, error)
+
+	// Delete removes a dead letter. Deleting a dead letter that does not exist is not an error.
+	//
+	// Parameters:
+	// 	- id: The ID of the dead letter.
+	//
+	// Returns:
+	// 	- error: An error if the dead letter cannot be removed.
+	Delete(id string) error
+}
+
+// memoryDeadLetterStore is a DeadLetterStore keeping the dead letters in memory.
+type memoryDeadLetterStore struct {
+	mu      sync.RWMutex
+	letters map[string]DeadLetter
+}
+
+// NewMemoryDeadLetterStore creates a DeadLetterStore keeping the dead letters in memory.
+// They are lost when the process exits.
+//
+// Returns:
+//   - DeadLetterStore: The store
+func NewMemoryDeadLetterStore() DeadLetterStore {
+	return &memoryDeadLetterStore{letters: make(map[string]DeadLetter)}
+}
+
+// Put stores a dead letter in memory.
+func (s *memoryDeadLetterStore) Put(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters[letter.ID] = letter
+
+	return nil
+}
+
+// Get returns a dead letter kept in memory.
+func (s *memoryDeadLetterStore) Get(id string) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("%w: %s", DeadLetterNotFound, id)
+	}
+
+	return letter, nil
+}
+
+// List returns the dead letters kept in memory, oldest first.
+func (s *memoryDeadLetterStore) List() ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+
+	slices.SortFunc(letters, func(a, b DeadLetter) int {
+		return a.DeadAt.Compare(b.DeadAt)
+	})
+
+	return letters, nil
+}
+
+// Delete removes a dead letter from memory.
+func (s *memoryDeadLetterStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.letters, id)
+
+	return nil
+}
+
+// fileDeadLetterStore is a DeadLetterStore persisting the dead letters to a JSON Lines file.
+// The dead letters are also kept in memory, the file being read once when the store is created.
+type fileDeadLetterStore struct {
+	memoryDeadLetterStore
+
+	path string
+
+	// fileMu serializes the writes to the file
+	fileMu sync.Mutex
+}
+
+// NewFileDeadLetterStore creates a DeadLetterStore persisting the dead letters to a JSON Lines file,
+// one dead letter per line, so that they survive restarts.
+//
+// New dead letters are appended to the file, and the file is rewritten when a dead letter is deleted.
+// If the file already exists, its dead letters are loaded, a line replacing the previous lines
+// with the same ID.
+//
+// Parameters:
+//   - path: The path of the file, created if it does not exist
+//
+// Returns:
+//   - DeadLetterStore: The store
+//   - error: An error if the file cannot be read or contains an invalid line
+//
+// Example:
+//
+//	store, err := notify.NewFileDeadLetterStore("/var/lib/myapp/dead-letters.jsonl")
+//	if err != nil {
+//	    log.Fatalf("Failed to open dead letters: %v", err)
+//	}
+func NewFileDeadLetterStore(path string) (DeadLetterStore, error) {
+	s := &fileDeadLetterStore{
+		memoryDeadLetterStore: memoryDeadLetterStore{letters: make(map[string]DeadLetter)},
+		path:                  path,
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var letter DeadLetter
+		if err = json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("invalid dead letter at %s:%d: %w", path, line, err)
+		}
+
+		s.letters[letter.ID] = letter
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+
+	return s, nil
+}
+
+// Put appends a dead letter to the file and keeps it in memory.
+func (s *fileDeadLetterStore) Put(letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+
+	return s.memoryDeadLetterStore.Put(letter)
+}
+
+// Delete rewrites the file without a dead letter, then removes it from memory.
+// If the file cannot be rewritten, the dead letter is kept in memory as well as in the file.
+func (s *fileDeadLetterStore) Delete(id string) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	if _, err := s.memoryDeadLetterStore.Get(id); err != nil {
+		return nil
+	}
+
+	all, _ := s.memoryDeadLetterStore.List()
+	letters := slices.DeleteFunc(all, func(letter DeadLetter) bool { return letter.ID == id })
+
+	// Write the remaining dead letters to a temporary file renamed over the file,
+	// so that the file is never left half written
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, letter := range letters {
+		if err = enc.Encode(letter); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+
+	return s.memoryDeadLetterStore.Delete(id)
+}
+
+// OptDeadLetterStore sets the store of the dead letters of the Manager
+//
+// A message becomes a dead letter when its channel gives up on it, after its retries, and it has no failover
+// fallback left to try. Only the failures reported to the Manager are known: the results of senders implementing
+// ResultPublisher, such as the built-in channels, the results of SendSync, and the messages a sender refuses
+// to queue.
+// Messages whose context is done before they are delivered are not dead-lettered.
+//
+// Parameters:
+//   - store: The store of the dead letters, e.g. NewMemoryDeadLetterStore() or NewFileDeadLetterStore(path)
+//
+// Returns:
+//   - Option: A function that sets the dead letter store
+func OptDeadLetterStore(store DeadLetterStore) Option {
+	return func(o *option) {
+		o.deadLetters = store
+	}
+}
+
+// DeadLetters returns the messages that could not be delivered, oldest first
+//
+// Returns:
+//   - []DeadLetter: The dead letters
+//   - error: An error if the Manager has no dead letter store, or the dead letters cannot be read
+func (m *Manager) DeadLetters() ([]DeadLetter, error) {
+	if m.deadLetters == nil {
+		return nil, errors.New("notify dead letter store is not configured")
+	}
+
+	return m.deadLetters.List()
+}
+
+// Replay resends dead letters to their target, typically once the outage that made them fail is fixed
+//
+// Each dead letter is sent synchronously with the same message ID, failing over as usual, and removed from
+// the store once it is delivered or queued by a channel that does not report its deliveries.
+// If it fails again, its dead letter is replaced with the failures of the replay appended.
+//
+// Parameters:
+//   - ctx: The context bounding the replay
+//   - ids: The IDs of the dead letters to resend, all of them if empty
+//
+// Returns:
+//   - []SendResult: The result of every dead letter found, in the order of ids
+//   - error: DeadLetterNotFound for the unknown IDs and the errors of the failed deliveries, joined together
+//
+// Example:
+//
+//	letters, _ := manager.DeadLetters()
+//	for _, letter := range letters {
+//	    if letter.Target.Channel == notify.LarkChan {
+//	        results, err := manager.Replay(ctx, letter.ID)
+//	        log.Printf("replayed %s: %+v %v", letter.ID, results, err)
+//	    }
+//	}
+func (m *Manager) Replay(ctx context.Context, ids ...string) ([]SendResult, error) {
+	if m.deadLetters == nil {
+		return nil, errors.New("notify dead letter store is not configured")
+	}
+
+	var letters []DeadLetter
+	var errs []error
+	if len(ids) == 0 {
+		var err error
+		if letters, err = m.deadLetters.List(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range ids {
+		letter, err := m.deadLetters.Get(id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		letters = append(letters, letter)
+	}
+
+	results := make([]SendResult, 0, len(letters))
+	for _, letter := range letters {
+		c := m.newChain(ctx, letter.Target, letter.Message, true)
+		c.failures = slices.Clone(letter.Failures)
+
+		result := m.sendChain(c)
+		results = append(results, result)
+
+		if result.State == StateFailed {
+			errs = append(errs, fmt.Errorf("%s: %w", letter.ID, result.Err))
+			continue
+		}
+
+		if err := m.deadLetters.Delete(letter.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// deadLetterID returns the ID of the dead letter of a chain.
+func (c *chain) deadLetterID() string {
+	id := fmt.Sprintf("%s:%s", c.message.ID, c.primary)
+	if c.target.SendChannelName != "" || c.target.SendTo != "" {
+		id = fmt.Sprintf("%s:%s:%s", id, c.target.SendChannelName, c.target.SendTo)
+	}
+
+	return id
+}
+
+// track registers the delivery of a chain so that its failures are recorded, if the Manager has
+// a dead letter store.
+func (m *Manager) track(c *chain, key watchKey) {
+	if m.deadLetters == nil {
+		return
+	}
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	m.inflight[key] = c
+	c.deliveries = append(c.deliveries, key)
+}
+
+// untrack stops recording the failures of a chain whose message was delivered or is no longer wanted.
+func (m *Manager) untrack(c *chain) {
+	if m.deadLetters == nil {
+		return
+	}
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	m.forget(c)
+}
+
+// forget removes the deliveries of a chain from the tracked ones. It must be called under watchMu.
+func (m *Manager) forget(c *chain) {
+	for _, key := range c.deliveries {
+		delete(m.inflight, key)
+	}
+
+	c.deliveries = nil
+}
+
+// recordResult records a failed attempt in the history of the chain it belongs to,
+// and stops tracking the chain once its message is delivered.
+//
+// Parameters:
+//   - r: The result reported by a channel
+func (m *Manager) recordResult(r SendResult) {
+	if m.deadLetters == nil {
+		return
+	}
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	_, c, ok := lookupDelivery(m.inflight, r)
+	if !ok {
+		return
+	}
+
+	switch r.State {
+	case StateSent:
+		m.forget(c)
+	case StateRetrying, StateFailed, StateDropped:
+		failure := Failure{Channel: r.Channel, State: r.State, Attempts: r.Attempts, At: time.Now()}
+		if r.Err != nil {
+			failure.Err = r.Err.Error()
+		}
+
+		c.failures = append(c.failures, failure)
+	}
+}
+
+// deadLetter stores the message of a chain whose targets all failed, unless its context is done.
+// The chain is no longer tracked afterwards.
+//
+// Parameters:
+//   - c: The chain of the message
+func (m *Manager) deadLetter(c *chain) {
+	if m.deadLetters == nil {
+		return
+	}
+
+	m.watchMu.Lock()
+	m.forget(c)
+	failures := c.failures
+	m.watchMu.Unlock()
+
+	// The message is no longer wanted
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	letter := DeadLetter{
+		ID:       c.deadLetterID(),
+		Message:  c.message,
+		Target:   c.target,
+		Failures: failures,
+		DeadAt:   time.Now(),
+	}
+	if len(failures) > 0 {
+		letter.FirstFailedAt = failures[0].At
+	}
+
+	if err := m.deadLetters.Put(letter); err != nil {
+		log.Printf("failed to store dead letter %s: %v\n", letter.ID, err)
+	}
+}
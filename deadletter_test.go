@@ -0,0 +1,255 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDeadLetterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+
+	store, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore() error = %v", err)
+	}
+
+	now := time.Now().UTC()
+	letters := []DeadLetter{
+		{ID: "1:lark", Message: Message{ID: "1", Title: "Disk full", Tags: []string{"db"}}, Target: Target{Channel: LarkChan}, DeadAt: now},
+		{ID: "2:email", Message: Message{ID: "2", Title: "Slow"}, Target: Target{Channel: EmailChan, SendTo: "oncall"}, DeadAt: now.Add(time.Second)},
+		{
+			ID:       "1:lark",
+			Message:  Message{ID: "1", Title: "Disk full", Tags: []string{"db"}},
+			Target:   Target{Channel: LarkChan},
+			Failures: []Failure{{Channel: LarkChan, State: StateFailed, Err: "gateway down", Attempts: 3, At: now}},
+			DeadAt:   now.Add(2 * time.Second),
+		},
+	}
+	for _, letter := range letters {
+		if err = store.Put(letter); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	if err = store.Delete("2:email"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err = store.Delete("unknown"); err != nil {
+		t.Errorf("Delete() of an unknown dead letter error = %v", err)
+	}
+
+	// Reopen the file to check what was persisted
+	store, err = NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore() error = %v", err)
+	}
+
+	got, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "1:lark" || len(got[0].Failures) != 1 || got[0].Failures[0].Err != "gateway down" ||
+		got[0].Message.Tags[0] != "db" || !got[0].DeadAt.Equal(letters[2].DeadAt) {
+		t.Errorf("List() = %+v, want the replaced dead letter 1:lark", got)
+	}
+
+	if _, err = store.Get("2:email"); !errors.Is(err, DeadLetterNotFound) {
+		t.Errorf("Get() of a deleted dead letter error = %v, want DeadLetterNotFound", err)
+	}
+
+	// A dead letter is kept in memory if the file cannot be rewritten without it
+	if err = os.Mkdir(path+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("1:lark"); err == nil {
+		t.Error("Delete() without a writable temporary file error = nil")
+	}
+	if _, err = store.Get("1:lark"); err != nil {
+		t.Errorf("Get() after a failed Delete() error = %v", err)
+	}
+
+	if err = os.WriteFile(path, []byte("{not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileDeadLetterStore(path); err == nil {
+		t.Error("NewFileDeadLetterStore() of an invalid file error = nil")
+	}
+}
+
+func TestManager_DeadLetter(t *testing.T) {
+	m, err := New(OptDeadLetterStore(NewMemoryDeadLetterStore()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	primary := &fakeFlakySender{retries: 2}
+	if err = m.Register(primaryChan, primary); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// Messages no longer wanted are not dead-lettered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = m.SendContext(ctx, WarnLevel, "oncall", "Slow", "p99 is 3s", primaryChan); err != nil {
+		t.Fatalf("SendContext() error = %v", err)
+	}
+
+	msgID, err := m.Error("oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	m.Close()
+
+	letters, err := m.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters() error = %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("DeadLetters() = %+v, want 1 dead letter", letters)
+	}
+
+	letter := letters[0]
+	if letter.ID != msgID+":"+string(primaryChan) || letter.Message.ID != msgID || letter.Message.Title != "Disk full" ||
+		letter.Message.Level != ErrorLevel || letter.Target.Channel != primaryChan {
+		t.Errorf("dead letter = %+v, want message %s sent to %s", letter, msgID, primaryChan)
+	}
+
+	wantStates := []string{StateRetrying, StateRetrying, StateFailed}
+	if len(letter.Failures) != len(wantStates) {
+		t.Fatalf("dead letter failures = %+v, want %v", letter.Failures, wantStates)
+	}
+	for i, state := range wantStates {
+		if f := letter.Failures[i]; f.State != state || f.Channel != primaryChan || f.Attempts != i+1 {
+			t.Errorf("failure %d = %+v, want %s attempt %d on %s", i, f, state, i+1, primaryChan)
+		}
+	}
+
+	if letter.Failures[2].Err != "gateway down" || letter.FirstFailedAt.IsZero() || letter.DeadAt.Before(letter.FirstFailedAt) {
+		t.Errorf("dead letter = %+v, want the gateway error and ordered timestamps", letter)
+	}
+}
+
+func TestManager_DeadLetter_Targets(t *testing.T) {
+	m, err := New(
+		OptDeadLetterStore(NewMemoryDeadLetterStore()),
+		OptRules(Rule{Targets: []Target{{Channel: primaryChan, SendTo: "alice"}, {Channel: primaryChan, SendTo: "bob"}}}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	primary := &fakeFlakySender{retries: 1, deliveryIDs: true}
+	if err = m.Register(primaryChan, primary); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	msgID, err := m.Dispatch(context.Background(), Message{Level: ErrorLevel, Title: "Disk full"})
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	m.Close()
+
+	letters, err := m.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters() error = %v", err)
+	}
+
+	// Each target of the message is dead-lettered with its own failures
+	ids := map[string]bool{}
+	for _, letter := range letters {
+		ids[letter.ID] = true
+		if len(letter.Failures) != 2 {
+			t.Errorf("dead letter %s failures = %+v, want 2 failures", letter.ID, letter.Failures)
+		}
+	}
+
+	prefix := msgID + ":" + string(primaryChan) + ":"
+	if len(letters) != 2 || !ids[prefix+":alice"] || !ids[prefix+":bob"] {
+		t.Errorf("DeadLetters() = %+v, want the dead letters of alice and bob", letters)
+	}
+}
+
+func TestManager_Replay(t *testing.T) {
+	store, err := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore() error = %v", err)
+	}
+
+	m, err := New(
+		OptDeadLetterStore(store),
+		OptFailover(Failover{Channel: primaryChan, Fallbacks: []Target{{Channel: backupChan, SendTo: "backup-oncall"}}}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	primary := &fakeSyncSender{fakeSender: fakeSender{err: errors.New("primary is down")}}
+	backup := &fakeSyncSender{fakeSender: fakeSender{err: errors.New("backup is down")}}
+	for channel, s := range map[Channel]Sender{primaryChan: primary, backupChan: backup} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	msgID, err := m.Error("oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	var letters []DeadLetter
+	waitFor(t, func() bool {
+		letters, _ = m.DeadLetters()
+		return len(letters) == 1
+	})
+
+	// The primary refuses to queue the message, then the backup refuses it too
+	failures := letters[0].Failures
+	if len(failures) != 2 || failures[0].Channel != primaryChan || failures[0].State != StateDropped ||
+		failures[0].Err != "primary is down" || failures[1].Channel != backupChan ||
+		failures[1].State != StateDropped || failures[1].Err != "backup is down" {
+		t.Errorf("dead letter failures = %+v, want dropped by primary then by backup", failures)
+	}
+
+	if _, err = m.Replay(context.Background(), "unknown"); !errors.Is(err, DeadLetterNotFound) {
+		t.Errorf("Replay() of an unknown dead letter error = %v, want DeadLetterNotFound", err)
+	}
+
+	// Replaying during the outage keeps the dead letter, with the new failures appended
+	if _, err = m.Replay(context.Background(), letters[0].ID); err == nil {
+		t.Error("Replay() during the outage error = nil")
+	}
+	if letters, _ = m.DeadLetters(); len(letters) != 1 || len(letters[0].Failures) != 4 {
+		t.Fatalf("DeadLetters() after a failed replay = %+v, want 1 dead letter with 4 failures", letters)
+	}
+
+	backup.mu.Lock()
+	backup.err = nil
+	backup.mu.Unlock()
+
+	results, err := m.Replay(context.Background())
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(results) != 1 || results[0].State != StateSent || results[0].Channel != backupChan || results[0].MsgID != msgID {
+		t.Errorf("Replay() = %+v, want message %s sent by backup", results, msgID)
+	}
+
+	if letters, _ = m.DeadLetters(); len(letters) != 0 {
+		t.Errorf("DeadLetters() after replay = %+v, want none", letters)
+	}
+
+	if got := backup.received(); got[len(got)-1].ID != msgID || got[len(got)-1].SendTo != "backup-oncall" {
+		t.Errorf("backup received %+v, want message %s for backup-oncall", got, msgID)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/cache"
+	"github.com/sk-pkg/notify/util"
+	"log"
+	"strconv"
+)
+
+// getToken retrieves an access token for an enterprise internal app.
+// The token is cached until 100 seconds before it expires, or half of its lifetime for short-lived tokens.
+//
+// Parameters:
+//   - appKey: The AppKey of the app.
+//   - appSecret: The AppSecret of the app.
+//
+// Returns:
+//   - string: The access token if successful, an empty string otherwise.
+//   - error: An error if the token cannot be retrieved, nil otherwise.
+func (n *notify) getToken(appKey, appSecret string) (string, error) {
+	cacheKey := fmt.Sprintf(tokenCacheKey, appKey)
+
+	token, err := n.cache.GetString(cacheKey)
+	if err == nil && token != "" {
+		return token, nil
+	}
+
+	request := &Request{
+		Method: "GET",
+		URL:    util.SpliceStr(n.host, accessTokenAPI),
+		QueryParams: map[string]string{
+			"appkey":    appKey,
+			"appsecret": appSecret,
+		},
+	}
+
+	response, err := n.sendDingAPIRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to request DingTalk access token: %w", err)
+	}
+
+	var rs accessTokenResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return "", fmt.Errorf("failed to obtain DingTalk access token: %w", err)
+	}
+
+	if err = n.cache.SetString(cacheKey, rs.AccessToken, cache.TokenExpiration(rs.ExpiresIn, 100)); err != nil {
+		log.Printf("failed to cache token: %s", err)
+	}
+
+	return rs.AccessToken, nil
+}
+
+// buildWorkNoticeMsg serializes a Message into the "msg" field of a work notification.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - map[string]any: The msg object of the work notification API.
+//   - error: An error if the message type is not supported or its payload is missing.
+func buildWorkNoticeMsg(m Message) (map[string]any, error) {
+	msgType := m.MsgType
+	if msgType == "" {
+		msgType = "text"
+	}
+
+	switch msgType {
+	case "text":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr(m.Title, "\n", m.Content)
+		}
+		return map[string]any{"msgtype": "text", "text": map[string]string{"content": content}}, nil
+	case "markdown":
+		return map[string]any{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": m.Title, "text": m.Content},
+		}, nil
+	case "link":
+		if m.Link == nil || m.Link.MessageURL == "" {
+			return nil, errors.New("link payload with MessageURL is required for link message")
+		}
+		return map[string]any{
+			"msgtype": "link",
+			"link": map[string]string{
+				"title":      m.Title,
+				"text":       m.Content,
+				"messageUrl": m.Link.MessageURL,
+				"picUrl":     m.Link.PicURL,
+			},
+		}, nil
+	case "actionCard":
+		if m.ActionCard == nil || (m.ActionCard.SingleURL == "" && len(m.ActionCard.Buttons) == 0) {
+			return nil, errors.New("actionCard payload with SingleURL or Buttons is required for actionCard message")
+		}
+
+		// Work notifications use snake_case field names for action cards
+		card := map[string]any{"title": m.Title, "markdown": m.Content}
+		if m.ActionCard.BtnOrientation != "" {
+			card["btn_orientation"] = m.ActionCard.BtnOrientation
+		}
+		if m.ActionCard.SingleURL != "" {
+			card["single_title"] = m.ActionCard.SingleTitle
+			card["single_url"] = m.ActionCard.SingleURL
+		} else {
+			buttons := make([]map[string]string, 0, len(m.ActionCard.Buttons))
+			for _, b := range m.ActionCard.Buttons {
+				buttons = append(buttons, map[string]string{"title": b.Title, "action_url": b.ActionURL})
+			}
+			card["btn_json_list"] = buttons
+		}
+		return map[string]any{"msgtype": "action_card", "action_card": card}, nil
+	default:
+		return nil, fmt.Errorf("invalid work notification message type: %s", msgType)
+	}
+}
+
+// sendWorkNotice sends a work notification via an enterprise internal app.
+//
+// Parameters:
+//   - token: The access token for the app.
+//   - agentID: The agent ID of the app.
+//   - m: The Message struct containing the message details. Message.SendTo must be set.
+//
+// Returns:
+//   - string: The task ID of the work notification.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendWorkNotice(token string, agentID int64, m Message) (string, error) {
+	msg, err := buildWorkNoticeMsg(m)
+	if err != nil {
+		return "", err
+	}
+
+	request := &Request{
+		Method:      "POST",
+		URL:         util.SpliceStr(n.host, workNoticeAPI),
+		Headers:     map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		QueryParams: map[string]string{"access_token": token},
+		Body: map[string]any{
+			"agent_id":    agentID,
+			"userid_list": m.SendTo,
+			"msg":         msg,
+		},
+	}
+
+	response, err := n.sendDingAPIRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to send work notification: %w", err)
+	}
+
+	// Check response status
+	var rs messageResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return "", fmt.Errorf("failed to send work notification: %w", err)
+	}
+
+	return strconv.FormatInt(rs.TaskID, 10), nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	testAppKey    = "ding_app_key"
+	testAppSecret = "ding_app_secret"
+	testAppToken  = "ding_app_token"
+	testAgentID   = 123456
+)
+
+// newTestAppServer starts a fake DingTalk server serving the token and work notification APIs.
+// The returned counter reports how many times a token was requested and the returned
+// function reports the body of the last work notification.
+func newTestAppServer(t *testing.T) (*httptest.Server, *int32, func() map[string]any) {
+	t.Helper()
+
+	var (
+		tokenCalls int32
+		last       atomic.Value
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(accessTokenAPI, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCalls, 1)
+
+		if r.URL.Query().Get("appkey") != testAppKey || r.URL.Query().Get("appsecret") != testAppSecret {
+			_ = json.NewEncoder(w).Encode(accessTokenResp{ErrCode: 40089, ErrMsg: "invalid appkey or appsecret"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(accessTokenResp{AccessToken: testAppToken, ExpiresIn: 7200})
+	})
+	mux.HandleFunc(workNoticeAPI, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != testAppToken {
+			_ = json.NewEncoder(w).Encode(messageResp{ErrCode: 40014, ErrMsg: "invalid access_token"})
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		var params map[string]any
+		_ = json.Unmarshal(body, &params)
+		last.Store(params)
+
+		_ = json.NewEncoder(w).Encode(messageResp{ErrMsg: "ok", TaskID: 1})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, &tokenCalls, func() map[string]any {
+		v, _ := last.Load().(map[string]any)
+		return v
+	}
+}
+
+func newTestAppNotify(t *testing.T, host string) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "app",
+		Apps: map[string]App{
+			"app": {AgentID: testAgentID, AppKey: testAppKey, AppSecret: testAppSecret},
+			"bad": {AgentID: testAgentID, AppKey: "other_app_key", AppSecret: testAppSecret},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	n := i.(*notify)
+	n.host = host
+
+	return n
+}
+
+func TestNotify_Token(t *testing.T) {
+	srv, tokenCalls, _ := newTestAppServer(t)
+	n := newTestAppNotify(t, srv.URL)
+
+	for i := 0; i < 3; i++ {
+		token, err := n.Token("app")
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+
+		if token != testAppToken {
+			t.Errorf("Token() = %v, want %v", token, testAppToken)
+		}
+	}
+
+	if got := atomic.LoadInt32(tokenCalls); got != 1 {
+		t.Errorf("token API called %d times, want 1", got)
+	}
+
+	if _, err := n.Token("missing"); err == nil {
+		t.Error("Token() expected error for unknown app")
+	}
+}
+
+func TestNotify_SendWorkNotice(t *testing.T) {
+	srv, _, last := newTestAppServer(t)
+	n := newTestAppNotify(t, srv.URL)
+
+	taskID, err := n.Send(Message{SendTo: "user1,user2", MsgType: "markdown", Title: "Alert", Content: "**down**"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if taskID != "1" {
+		t.Errorf("Send() task ID = %q, want 1", taskID)
+	}
+
+	got, _ := json.Marshal(last())
+	want := `{"agent_id":123456,"msg":{"markdown":{"text":"**down**","title":"Alert"},"msgtype":"markdown"},"userid_list":"user1,user2"}`
+	if string(got) != want {
+		t.Errorf("work notification = %s, want %s", got, want)
+	}
+
+	if _, err = n.sendMsg(Message{Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error without sendTo")
+	}
+
+	if _, err = n.sendMsg(Message{SendChannelName: "bad", SendTo: "user1", Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error for invalid app secret")
+	}
+}
+
+func TestBuildWorkNoticeMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "default text",
+			message: Message{Title: "Title", Content: "Content"},
+			want:    `{"msgtype":"text","text":{"content":"Title\nContent"}}`,
+		},
+		{
+			name: "multi button actionCard",
+			message: Message{
+				MsgType:    "actionCard",
+				Title:      "Incident",
+				Content:    "DB down",
+				ActionCard: &ActionCard{Buttons: []ActionCardButton{{Title: "Ack", ActionURL: "https://example.com/ack"}}},
+			},
+			want: `{"action_card":{"btn_json_list":[{"action_url":"https://example.com/ack","title":"Ack"}],"markdown":"DB down","title":"Incident"},"msgtype":"action_card"}`,
+		},
+		{
+			name:    "feedCard is not supported",
+			message: Message{MsgType: "feedCard", FeedCard: &FeedCard{Links: []FeedCardLink{{Title: "News"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := buildWorkNoticeMsg(tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildWorkNoticeMsg() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			got, _ := json.Marshal(msg)
+			if string(got) != tt.want {
+				t.Errorf("buildWorkNoticeMsg() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,58 +2,634 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
+// Package ding provides functionality for sending messages via the DingTalk platform.
+// It supports sending messages through custom group robot webhooks and
+// work notifications of enterprise internal apps.
 package ding
 
-import "github.com/sk-pkg/notify/msgid"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/cache"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"log"
+	"runtime"
+)
+
+// Constants used throughout the package
+const (
+	// dingHost is the base URL for DingTalk API calls.
+	dingHost = "https://oapi.dingtalk.com"
+
+	// robotSendAPI is the URL for sending messages through custom robots.
+	robotSendAPI = "/robot/send"
+
+	// accessTokenAPI is the URL for retrieving the access token of an enterprise internal app.
+	accessTokenAPI = "/gettoken"
+
+	// workNoticeAPI is the URL for sending work notifications through enterprise internal apps.
+	workNoticeAPI = "/topapi/message/corpconversation/asyncsend_v2"
+
+	// tokenCacheKey is the key used to store the app access token in the cache.
+	// %s will be replaced with the app key.
+	tokenCacheKey = "ding:token:%s"
+)
+
+// Config represents the configuration for the DingTalk notifier.
+type Config struct {
+	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
+	Enabled bool
+
+	// DefaultSendChannelName is the default channel name for sending messages when not specified in the message.
+	// This must be set to a valid channel name from either Robots or Apps.
+	DefaultSendChannelName string
+
+	// ChannelSize defines the buffer size for the message channel.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	ChannelSize int
+
+	// PoolSize defines the number of goroutines in the worker pool.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	PoolSize int
+
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
+	// IdleSize is kept for compatibility and is currently unused.
+	IdleSize int
+
+	// Robots is a map of robot names to their corresponding custom robot configurations.
+	// The key will be used as the send channel name.
+	Robots map[string]Robot
+
+	// Apps is a map of enterprise internal app configurations, keyed by a unique identifier for each app.
+	// Use this to send work notifications to individual employees.
+	// The key will be used as the send channel name.
+	Apps map[string]App
+
+	// Retry is the policy applied to failed deliveries: transport errors, 408, 429 and 5xx statuses,
+	// and the error codes meaning that DingTalk is busy or the robot sends too fast.
+	// The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+}
+
+// Robot represents the configuration for a DingTalk custom group robot.
+type Robot struct {
+	// AccessToken is the access_token query parameter of the robot webhook URL.
+	AccessToken string
+
+	// Secret is the signing secret of the robot.
+	// It is only required if the robot has the "sign" security setting enabled.
+	Secret string
+}
+
+// App represents the configuration for a DingTalk enterprise internal app.
+type App struct {
+	// AgentID is the agent ID of the app.
+	AgentID int64
+
+	// Token is a function that returns the access token for the app.
+	// If not provided, a default function using AppKey and AppSecret will be used.
+	Token func() (string, error)
+
+	// AppKey is the unique identifier for the app.
+	// This is required if Token is not provided.
+	AppKey string
+
+	// AppSecret is the secret key for the app.
+	// This is required if Token is not provided.
+	AppSecret string
+}
+
+// Notify is the interface that wraps the basic methods for the notifier.
+type Notify interface {
+	// StartProcessor initiates the message processing routine.
+	// It should be called once before submitting any messages.
+	StartProcessor()
+
+	// SubmitMessage adds a new message to the processing queue.
+	// The message will be processed asynchronously by the processor started with StartProcessor.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message.
+	SubmitMessage(message Message) (msgID string, err error)
+
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
+	// Token retrieves the access token for a specific enterprise internal app.
+	//
+	// Parameters:
+	// 	- appName: The name of the app.
+	//
+	// Returns:
+	// 	- token: The access token for the app.
+	// 	- err: An error that occurred while retrieving the token.
+	Token(appName string) (token string, err error)
+
+	// Send sends a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The task ID of a work notification, empty for robot messages.
+	// 	- err: An error if the message cannot be sent.
+	Send(message Message) (providerMsgID string, err error)
+
+	// SendContext sends a message immediately, bypassing the processing queue, and stops retrying
+	// once ctx is done.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The task ID of a work notification, empty for robot messages.
+	// 	- attempts: The number of attempts made.
+	// 	- err: An error if the message cannot be sent.
+	SendContext(ctx context.Context, message Message) (providerMsgID string, attempts int, err error)
+
+	// Subscribe registers a handler called with the result of every delivery attempt, including the
+	// retries and the messages dropped before delivery, of the queued messages and of SendContext.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
+	// Close stops the notifier, ensuring all pending messages are processed
+	// before shutting down. After calling Close, the notifier should not be used anymore.
+	Close()
+}
+
+// notify implements the Notify interface.
+type notify struct {
+	// defaultSendChannelName is the default channel name for sending messages when not specified in the message.
+	defaultSendChannelName string
+
+	// host is the base URL for DingTalk API calls.
+	host string
+
+	// msgID is ID instance used for generating unique message IDs.
+	msgID *msgid.ID
+
+	// request is a resty client used for making HTTP requests to the DingTalk API.
+	request *resty.Client
+
+	// robots is a map of robot names to their corresponding configurations.
+	robots map[string]Robot
+
+	// apps is a map of enterprise internal app names to their corresponding configurations.
+	apps map[string]*app
+
+	// cache is a cache instance used for caching tokens.
+	cache cache.Cache
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
+}
+
+// app represents an initialized enterprise internal app.
+type app struct {
+	// agentID is the agent ID of the app.
+	agentID int64
+
+	// token is a function that returns the access token for the app.
+	token func() (string, error)
+}
+
+// Message represents a message to be sent via the notifier.
+type Message struct {
+	// ID is a unique identifier for the message. It can be used for tracking or deduplication.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets. It is reported in its SendResults.
+	DeliveryID string
+
+	// SendChannelName specifies the robot or app through which the message should be sent.
+	// If empty, the DefaultSendChannelName from the Config will be used.
+	SendChannelName string
+
+	// SendTo is a comma-separated list of DingTalk user IDs.
+	// It is required for work notifications sent through an app and ignored by robots.
+	SendTo string
+
+	// MsgType specifies the type of message.
+	// Options: text, markdown, link, actionCard, feedCard. If empty, text will be used.
+	// Work notifications do not support feedCard.
+	MsgType string
+
+	// Title is the title of the message.
+	// It is prepended to the content of text messages and used as the title of
+	// markdown, link and actionCard messages.
+	Title string
+
+	// Content contains the main body of the message.
+	// It is used as the text of text, markdown, link and actionCard messages.
+	Content string
+
+	// At specifies the users to mention. It only takes effect for text and markdown messages.
+	At *At
+
+	// Link contains the payload of a link message. Required if MsgType is link.
+	Link *Link
+
+	// ActionCard contains the payload of an actionCard message. Required if MsgType is actionCard.
+	ActionCard *ActionCard
+
+	// FeedCard contains the payload of a feedCard message. Required if MsgType is feedCard.
+	FeedCard *FeedCard
+}
+
+// At represents the mentions of a text or markdown message.
+// For markdown messages, DingTalk only highlights mentions whose "@mobile" or "@userId"
+// also appears in the message text.
+type At struct {
+	AtMobiles []string `json:"atMobiles,omitempty"`
+	AtUserIds []string `json:"atUserIds,omitempty"`
+	IsAtAll   bool     `json:"isAtAll,omitempty"`
+}
+
+// Link represents the payload of a link message.
+type Link struct {
+	// MessageURL is the URL opened when the message is clicked.
+	MessageURL string
+
+	// PicURL is the URL of the picture displayed in the message.
+	PicURL string
+}
+
+// ActionCard represents the payload of an actionCard message.
+// If SingleTitle and SingleURL are set, a single-button card is sent;
+// otherwise Buttons are used to build a multi-button card.
+type ActionCard struct {
+	// SingleTitle is the title of the single button.
+	SingleTitle string
+
+	// SingleURL is the URL opened by the single button.
+	SingleURL string
+
+	// BtnOrientation is the layout of the buttons: "0" for vertical, "1" for horizontal.
+	BtnOrientation string
+
+	// Buttons are the buttons of a multi-button card.
+	Buttons []ActionCardButton
+}
 
-type (
-	Config struct {
-		Enabled bool
+// ActionCardButton represents a button of a multi-button actionCard message.
+type ActionCardButton struct {
+	Title     string `json:"title"`
+	ActionURL string `json:"actionURL"`
+}
+
+// FeedCard represents the payload of a feedCard message.
+type FeedCard struct {
+	Links []FeedCardLink `json:"links"`
+}
+
+// FeedCardLink represents an entry of a feedCard message.
+type FeedCardLink struct {
+	Title      string `json:"title"`
+	MessageURL string `json:"messageURL"`
+	PicURL     string `json:"picURL,omitempty"`
+}
+
+// messageResp represents the response from the DingTalk robot API.
+type messageResp struct {
+	ErrCode int    `json:"errcode"` // Response code, 0 indicates success
+	ErrMsg  string `json:"errmsg"`  // Error message if the request failed
+	TaskID  int64  `json:"task_id"` // Task ID of an asynchronous work notification
+}
+
+// accessTokenResp represents the response from the DingTalk access token API.
+type accessTokenResp struct {
+	ErrCode     int    `json:"errcode"`      // Response code, 0 indicates success
+	ErrMsg      string `json:"errmsg"`       // Error message if the request failed
+	AccessToken string `json:"access_token"` // The access token for the app
+	ExpiresIn   int    `json:"expires_in"`   // Token expiration time in seconds
+}
 
-		ChannelSize int
-		PoolSize    int
-		IdleSize    int
+// validateConfig checks the provided configuration for validity.
+//
+// Parameters:
+//   - config: A pointer to the Config struct to be validated.
+//
+// Returns:
+//   - error: An error if the configuration is invalid, nil otherwise.
+func validateConfig(config *Config) error {
+	if len(config.Robots) == 0 && len(config.Apps) == 0 {
+		return errors.New("there are no available sending channels for ding talk, please configure Robots or Apps")
 	}
 
-	Notify interface {
-		StartProcessor()
-		SubmitMessage(message Message) (msgID string)
-		Close()
+	if config.DefaultSendChannelName == "" {
+		return errors.New("DefaultSendChannelName is required")
 	}
 
-	notify struct {
-		msgID    *msgid.ID
-		messages chan Message
+	for name, robot := range config.Robots {
+		if robot.AccessToken == "" {
+			return fmt.Errorf("ding talk robot config error: %s", name)
+		}
 	}
 
-	Message struct {
-		ID      string
-		Title   string
-		Content string
+	for name, a := range config.Apps {
+		if a.AgentID == 0 || (a.Token == nil && (a.AppKey == "" || a.AppSecret == "")) {
+			return fmt.Errorf("ding talk app config error: %s", name)
+		}
 	}
-)
 
-func (n *notify) Close() {
-	return
+	_, isRobot := config.Robots[config.DefaultSendChannelName]
+	_, isApp := config.Apps[config.DefaultSendChannelName]
+	if !isRobot && !isApp {
+		return fmt.Errorf("default send channel %s is not found in ding talk", config.DefaultSendChannelName)
+	}
+
+	// Set default values for ChannelSize and PoolSize if not provided
+	// Default to GOMAXPROCS * 10
+	if config.ChannelSize == 0 {
+		config.ChannelSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid ding talk retry policy: %w", err)
+	}
+
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid ding talk outbox: %w", err)
+	}
+
+	return nil
 }
 
+// StartProcessor starts the message processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
 func (n *notify) StartProcessor() {
+	n.queue.Start()
+}
 
+// SubmitMessage submits a message to the notifier's message queue.
+//
+// Parameters:
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
+func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
 }
 
-func (n *notify) SubmitMessage(message Message) (msgID string) {
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
 	if message.ID == "" {
 		message.ID = n.msgID.New()
 	}
 
-	n.messages <- message
+	key := outbox.Key(message.ID, message.SendChannelName, message.SendTo)
 
-	return message.ID
+	return message.ID, n.queue.Submit(ctx, key, message)
 }
 
+// New creates a new Notify instance with the provided configuration.
+//
+// Parameters:
+//   - config: The Config struct containing the notifier configuration.
+//
+// Returns:
+//   - Notify: A new Notify instance.
+//   - error: An error if the configuration is invalid or if the goroutine pool cannot be created.
 func New(config Config) (Notify, error) {
-	return &notify{
-		messages: make(chan Message, config.ChannelSize),
-		msgID:    msgid.NewMessageID(),
-	}, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	n := &notify{
+		defaultSendChannelName: config.DefaultSendChannelName,
+		host:                   dingHost,
+		msgID:                  msgid.NewMessageID(),
+		request:                resty.New(),
+		robots:                 config.Robots,
+		apps:                   make(map[string]*app),
+		cache:                  cache.New(),
+		retry:                  config.Retry,
+	}
+
+	// Initialize enterprise internal apps
+	for name, a := range config.Apps {
+		// If Token is not provided,
+		// a.AppKey and a.AppSecret will be used to generate the token.
+		if a.Token == nil {
+			appKey, appSecret := a.AppKey, a.AppSecret
+			a.Token = func() (string, error) {
+				return n.getToken(appKey, appSecret)
+			}
+		}
+
+		n.apps[name] = &app{
+			agentID: a.AgentID,
+			token:   a.Token,
+		}
+	}
+
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "ding talk",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send: func(_ context.Context, m Message) (string, error) {
+			return n.sendMsg(m)
+		},
+		Report: n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
+	return n, nil
+}
+
+// Token retrieves the access token for a specific enterprise internal app.
+//
+// Parameters:
+//   - appName: The name of the app.
+//
+// Returns:
+//   - token: The access token for the app.
+//   - err: An error that occurred while retrieving the token.
+func (n *notify) Token(appName string) (token string, err error) {
+	a, ok := n.apps[appName]
+	if !ok {
+		return "", fmt.Errorf("ding talk app %s not found", appName)
+	}
+
+	return a.token()
+}
+
+// Send sends a message immediately.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The task ID of a work notification, empty for robot messages.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) Send(message Message) (string, error) {
+	providerMsgID, _, err := n.SendContext(context.Background(), message)
+
+	return providerMsgID, err
+}
+
+// SendContext sends a message immediately, and stops retrying once ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The task ID of a work notification, empty for robot messages.
+//   - int: The number of attempts made.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) SendContext(ctx context.Context, message Message) (string, int, error) {
+	if message.ID == "" {
+		message.ID = n.msgID.New()
+	}
+
+	if err := ctx.Err(); err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return "", 0, err
+	}
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	for attempt := 1; ; attempt++ {
+		providerMsgID, err := n.sendMsg(message)
+		result := queue.Result{State: StateSent, ProviderMsgID: providerMsgID, Err: err, Attempts: attempt}
+
+		delay, ok := n.retry.Delay(attempt, err)
+		if !ok {
+			if err != nil {
+				result.State = StateFailed
+			}
+			n.report(message, result)
+
+			return providerMsgID, attempt, err
+		}
+
+		result.State = StateRetrying
+		n.report(message, result)
+
+		if waitErr := retry.Wait(ctx, delay); waitErr != nil {
+			result.State, result.Err = StateFailed, fmt.Errorf("%w, retry aborted: %w", err, waitErr)
+			n.report(message, result)
+
+			return providerMsgID, attempt, result.Err
+		}
+	}
+}
+
+// sendMsg sends a message using the appropriate channel (robot or enterprise internal app).
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - string: The task ID of a work notification, empty for robot messages.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendMsg(m Message) (string, error) {
+	channel := m.SendChannelName
+	if channel == "" {
+		channel = n.defaultSendChannelName
+	}
+
+	// Check if the channel is a robot
+	if robot, ok := n.robots[channel]; ok {
+		params, err := buildRobotParams(m)
+		if err != nil {
+			return "", err
+		}
+
+		return "", n.sendRobotMessage(robot, params)
+	}
+
+	// Check if the channel is an enterprise internal app
+	if a, ok := n.apps[channel]; ok {
+		if m.SendTo == "" {
+			return "", fmt.Errorf("sendTo is required for ding talk app %s", channel)
+		}
+
+		t, err := a.token()
+		if err != nil {
+			return "", fmt.Errorf("failed to get token for ding talk app %s: %w", channel, err)
+		}
+
+		return n.sendWorkNotice(t, a.agentID, m)
+	}
+
+	return "", fmt.Errorf("channel %s is not found in ding talk", channel)
+}
+
+// Close stops the notifier, waits for all messages to be processed, and releases resources.
+func (n *notify) Close() {
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
+
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
+
+	log.Println("DingTalk notify closed")
 }
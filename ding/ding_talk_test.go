@@ -0,0 +1,324 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessToken = "test_access_token"
+	testSecret      = "SECtest_secret"
+)
+
+// robotRequest records a request received by the fake robot server.
+type robotRequest struct {
+	Query url.Values
+	Body  map[string]any
+}
+
+// newTestServer starts a fake DingTalk server that records every request and
+// answers with the given errcode.
+func newTestServer(t *testing.T, errCode int) (*httptest.Server, func() []robotRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []robotRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var params map[string]any
+		_ = json.Unmarshal(body, &params)
+
+		mu.Lock()
+		requests = append(requests, robotRequest{Query: r.URL.Query(), Body: params})
+		mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(messageResp{ErrCode: errCode, ErrMsg: "test"})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []robotRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]robotRequest(nil), requests...)
+	}
+}
+
+func newTestNotify(t *testing.T, host string) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "plain",
+		Robots: map[string]Robot{
+			"plain":  {AccessToken: testAccessToken},
+			"signed": {AccessToken: testAccessToken, Secret: testSecret},
+		},
+		Retry: retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	n := i.(*notify)
+	n.host = host
+
+	return n
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name: "Valid config with robot",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "robot",
+				Robots:                 map[string]Robot{"robot": {AccessToken: testAccessToken}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid config - no robots",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "robot",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - missing access token",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "robot",
+				Robots:                 map[string]Robot{"robot": {Secret: testSecret}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid config with app",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "app",
+				Apps:                   map[string]App{"app": {AgentID: 1, AppKey: "key", AppSecret: "secret"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid config - app without credentials",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "app",
+				Apps:                   map[string]App{"app": {AgentID: 1, AppKey: "key"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - unknown default channel",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "other",
+				Robots:                 map[string]Robot{"robot": {AccessToken: testAccessToken}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotify_SubmitMessage(t *testing.T) {
+	srv, requests := newTestServer(t, 0)
+	n := newTestNotify(t, srv.URL)
+
+	n.StartProcessor()
+
+	msgID, err := n.SubmitMessage(Message{Title: "Title", Content: "Content"})
+	if err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	if msgID == "" {
+		t.Error("SubmitMessage() returned an empty message ID")
+	}
+
+	// Close waits for the queued message to be delivered
+	n.Close()
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(got))
+	}
+
+	text, _ := got[0].Body["text"].(map[string]any)
+	if text["content"] != "Title\nContent" {
+		t.Errorf("text content = %v, want %q", text["content"], "Title\nContent")
+	}
+
+	if got[0].Query.Get("access_token") != testAccessToken {
+		t.Errorf("access_token = %v, want %v", got[0].Query.Get("access_token"), testAccessToken)
+	}
+}
+
+func TestNotify_SendMsg_UnknownChannel(t *testing.T) {
+	n := newTestNotify(t, "http://127.0.0.1:0")
+
+	_, err := n.sendMsg(Message{SendChannelName: "missing", Content: "hello"})
+	if err == nil {
+		t.Error("sendMsg() expected error for unknown channel")
+	}
+}
+
+func TestNotify_Close(t *testing.T) {
+	srv, _ := newTestServer(t, 0)
+	n := newTestNotify(t, srv.URL)
+	n.StartProcessor()
+
+	done := make(chan struct{})
+	go func() {
+		n.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return")
+	}
+}
+
+func TestNotify_SubmitMessage_Retry(t *testing.T) {
+	// The robot sends too fast on the first request only
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errCode := 0
+		if calls.Add(1) == 1 {
+			errCode = 130101
+		}
+
+		_ = json.NewEncoder(w).Encode(messageResp{ErrCode: errCode, ErrMsg: "test"})
+	}))
+	t.Cleanup(srv.Close)
+
+	n := newTestNotify(t, srv.URL)
+
+	// The queued deliveries are reported to the subscribers
+	var states []string
+	n.Subscribe(func(r SendResult) { states = append(states, r.MsgID+":"+r.DeliveryID+":"+r.State) })
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{ID: "msg-1", DeliveryID: "1", Content: "hello"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	// Close waits for the scheduled retry
+	n.Close()
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+	if want := "[msg-1:1:retrying msg-1:1:sent]"; fmt.Sprint(states) != want {
+		t.Errorf("published %v, want %s", states, want)
+	}
+}
+
+func TestNotify_Send_Retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		errCode   int
+		wantCalls int
+	}{
+		{name: "System busy", errCode: -1, wantCalls: 3},
+		{name: "Permanent error", errCode: 310000, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newTestServer(t, tt.errCode)
+			n := newTestNotify(t, srv.URL)
+
+			_, attempts, err := n.SendContext(context.Background(), Message{Content: "hello"})
+			if err == nil || attempts != tt.wantCalls {
+				t.Errorf("SendContext() = %d attempts, %v, want %d attempts and an error", attempts, err, tt.wantCalls)
+			}
+
+			if got := len(requests()); got != tt.wantCalls {
+				t.Errorf("server received %d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNotify_SendContext_Cancelled(t *testing.T) {
+	srv, requests := newTestServer(t, -1)
+	n := newTestNotify(t, srv.URL)
+	n.retry = retry.Policy{MaxAttempts: 3, BaseBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// The retry is not waited for once the context is done
+	_, attempts, err := n.SendContext(ctx, Message{Content: "hello"})
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+		t.Errorf("SendContext() = %d attempts, %v, want 1 attempt and the context error", attempts, err)
+	}
+
+	if got := len(requests()); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		DefaultSendChannelName: "plain",
+		Robots:                 map[string]Robot{"plain": {AccessToken: testAccessToken}},
+		Apps:                   map[string]App{"app": {AgentID: 1, Token: func() (string, error) { return "token", nil }}},
+		Outbox:                 outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	// A message is queued once per robot or app user
+	message := Message{ID: "msg-1", Content: "disk full"}
+	for _, target := range []Message{{SendChannelName: "plain"}, {SendChannelName: "app", SendTo: "alice"}, {SendChannelName: "app", SendTo: "bob"}} {
+		message.SendChannelName, message.SendTo = target.SendChannelName, target.SendTo
+		if _, err = i.SubmitMessage(message); err != nil {
+			t.Fatalf("SubmitMessage() to %s %s error = %v", target.SendChannelName, target.SendTo, err)
+		}
+	}
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+}
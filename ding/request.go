@@ -0,0 +1,104 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"fmt"
+	"github.com/sk-pkg/notify/retry"
+	"slices"
+)
+
+// transientCodes are the error codes returned when DingTalk is busy or rate limiting the robot.
+var transientCodes = []int{
+	-1,     // System busy
+	130101, // Robot sending too fast
+}
+
+// Request represents a request to the DingTalk API.
+type Request struct {
+	Method      string
+	URL         string
+	Headers     map[string]string
+	QueryParams map[string]string
+	Body        any
+}
+
+// Response represents a response from the DingTalk API.
+type Response struct {
+	StatusCode int
+	Body       []byte
+	Headers    map[string][]string
+}
+
+// sendDingAPIRequest sends a request to the DingTalk API.
+//
+// DingTalk reports business errors in the response body with an HTTP 200 status,
+// so only transport failures and non-2xx statuses are treated as errors here.
+// Transient statuses (408, 429 and 5xx) are marked as retryable for the retry policy.
+//
+// Parameters:
+//   - request: The Request containing the request details.
+//
+// Returns:
+//   - *Response: The response from the DingTalk API.
+//   - error: An error if the request fails, nil otherwise.
+func (n *notify) sendDingAPIRequest(request *Request) (*Response, error) {
+	resp, err := n.executeRequest(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, resp.Body)
+		if retry.TransientStatus(resp.StatusCode) {
+			err = retry.Transient(err)
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// checkCode returns an error for a non-zero error code, marked as transient if the code
+// means that DingTalk is busy or rate limiting the robot.
+//
+// Parameters:
+//   - code: The error code of the response.
+//   - msg: The error message of the response.
+//
+// Returns:
+//   - error: nil if code is 0.
+func checkCode(code int, msg string) error {
+	if code == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%d %s", code, msg)
+	if slices.Contains(transientCodes, code) {
+		return retry.Transient(err)
+	}
+
+	return err
+}
+
+// executeRequest executes a single API request.
+func (n *notify) executeRequest(request *Request) (*Response, error) {
+	req := n.request.R().
+		SetHeaders(request.Headers).
+		SetQueryParams(request.QueryParams).
+		SetBody(request.Body)
+
+	resp, err := req.Execute(request.Method, request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode(),
+		Body:       resp.Body(),
+		Headers:    resp.Header(),
+	}, nil
+}
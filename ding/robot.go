@@ -0,0 +1,147 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/util"
+	"strconv"
+	"time"
+)
+
+// sign generates the signature required by robots with the "sign" security setting.
+//
+// The string to sign is "timestamp\nsecret", hashed with HMAC-SHA256 using the secret
+// as key and encoded with standard base64. URL encoding is left to the HTTP client.
+//
+// Parameters:
+//   - timestamp: The current time in milliseconds.
+//   - secret: The signing secret of the robot.
+//
+// Returns:
+//   - string: The base64 encoded signature.
+func sign(timestamp int64, secret string) string {
+	stringToSign := util.SpliceStr(strconv.FormatInt(timestamp, 10), "\n", secret)
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(stringToSign))
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// buildRobotParams serializes a Message into the format of the robot API.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - map[string]any: The request body for the robot API.
+//   - error: An error if the message type is invalid or its payload is missing.
+func buildRobotParams(m Message) (map[string]any, error) {
+	msgType := m.MsgType
+	if msgType == "" {
+		msgType = "text"
+	}
+
+	params := map[string]any{"msgtype": msgType}
+
+	switch msgType {
+	case "text":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr(m.Title, "\n", m.Content)
+		}
+		params["text"] = map[string]string{"content": content}
+	case "markdown":
+		params["markdown"] = map[string]string{"title": m.Title, "text": m.Content}
+	case "link":
+		if m.Link == nil || m.Link.MessageURL == "" {
+			return nil, errors.New("link payload with MessageURL is required for link message")
+		}
+		params["link"] = map[string]string{
+			"title":      m.Title,
+			"text":       m.Content,
+			"messageUrl": m.Link.MessageURL,
+			"picUrl":     m.Link.PicURL,
+		}
+	case "actionCard":
+		if m.ActionCard == nil || (m.ActionCard.SingleURL == "" && len(m.ActionCard.Buttons) == 0) {
+			return nil, errors.New("actionCard payload with SingleURL or Buttons is required for actionCard message")
+		}
+		card := map[string]any{"title": m.Title, "text": m.Content}
+		if m.ActionCard.BtnOrientation != "" {
+			card["btnOrientation"] = m.ActionCard.BtnOrientation
+		}
+		if m.ActionCard.SingleURL != "" {
+			card["singleTitle"] = m.ActionCard.SingleTitle
+			card["singleURL"] = m.ActionCard.SingleURL
+		} else {
+			card["btns"] = m.ActionCard.Buttons
+		}
+		params["actionCard"] = card
+	case "feedCard":
+		if m.FeedCard == nil || len(m.FeedCard.Links) == 0 {
+			return nil, errors.New("feedCard payload with Links is required for feedCard message")
+		}
+		params["feedCard"] = m.FeedCard
+	default:
+		return nil, fmt.Errorf("invalid message type: %s", msgType)
+	}
+
+	// Mentions are only supported by text and markdown messages
+	if m.At != nil && (msgType == "text" || msgType == "markdown") {
+		params["at"] = m.At
+	}
+
+	return params, nil
+}
+
+// sendRobotMessage sends a message via a custom robot webhook.
+//
+// Parameters:
+//   - robot: The Robot configuration.
+//   - params: The request body in the format of the robot API.
+//
+// Returns:
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendRobotMessage(robot Robot, params map[string]any) error {
+	query := map[string]string{"access_token": robot.AccessToken}
+
+	if robot.Secret != "" {
+		timestamp := time.Now().UnixMilli()
+		query["timestamp"] = strconv.FormatInt(timestamp, 10)
+		query["sign"] = sign(timestamp, robot.Secret)
+	}
+
+	request := &Request{
+		Method:      "POST",
+		URL:         util.SpliceStr(n.host, robotSendAPI),
+		Headers:     map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		QueryParams: query,
+		Body:        params,
+	}
+
+	response, err := n.sendDingAPIRequest(request)
+	if err != nil {
+		return fmt.Errorf("failed to send robot message: %w", err)
+	}
+
+	// Check response status
+	var rs messageResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return fmt.Errorf("failed to send robot message: %w", err)
+	}
+
+	return nil
+}
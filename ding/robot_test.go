@@ -0,0 +1,170 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ding
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	// Expected value computed with the reference algorithm from the DingTalk documentation
+	got := sign(1700000000000, "SECtest")
+	want := "aZLLrriXgn05YbwaGR7knYsLeJADjr9NwLaNNKpxh4g="
+
+	if got != want {
+		t.Errorf("sign() = %v, want %v", got, want)
+	}
+}
+
+func TestNotify_SendRobotMessage(t *testing.T) {
+	t.Run("signed robot", func(t *testing.T) {
+		srv, requests := newTestServer(t, 0)
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(Message{SendChannelName: "signed", Content: "hello"})
+		if err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		timestamp, err := strconv.ParseInt(got.Query.Get("timestamp"), 10, 64)
+		if err != nil {
+			t.Fatalf("invalid timestamp %q", got.Query.Get("timestamp"))
+		}
+
+		if got.Query.Get("sign") != sign(timestamp, testSecret) {
+			t.Errorf("sign = %v, want %v", got.Query.Get("sign"), sign(timestamp, testSecret))
+		}
+	})
+
+	t.Run("unsigned robot", func(t *testing.T) {
+		srv, requests := newTestServer(t, 0)
+		n := newTestNotify(t, srv.URL)
+
+		if _, err := n.sendMsg(Message{Content: "hello"}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		if got := requests()[0]; got.Query.Has("sign") {
+			t.Errorf("unexpected sign for robot without secret: %v", got.Query.Get("sign"))
+		}
+	})
+
+	t.Run("error code", func(t *testing.T) {
+		srv, _ := newTestServer(t, 310000)
+		n := newTestNotify(t, srv.URL)
+
+		if _, err := n.sendMsg(Message{Content: "hello"}); err == nil {
+			t.Error("sendMsg() expected error for non-zero errcode")
+		}
+	})
+}
+
+func TestBuildRobotParams(t *testing.T) {
+	at := &At{AtMobiles: []string{"13800000000"}, AtUserIds: []string{"user1"}, IsAtAll: true}
+
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "default text",
+			message: Message{Title: "Title", Content: "Content", At: at},
+			want:    `{"at":{"atMobiles":["13800000000"],"atUserIds":["user1"],"isAtAll":true},"msgtype":"text","text":{"content":"Title\nContent"}}`,
+		},
+		{
+			name:    "markdown",
+			message: Message{MsgType: "markdown", Title: "Alert", Content: "## CPU @13800000000", At: &At{AtMobiles: []string{"13800000000"}}},
+			want:    `{"at":{"atMobiles":["13800000000"]},"markdown":{"text":"## CPU @13800000000","title":"Alert"},"msgtype":"markdown"}`,
+		},
+		{
+			name: "link ignores at",
+			message: Message{
+				MsgType: "link",
+				Title:   "Release",
+				Content: "v1.2.3",
+				At:      at,
+				Link:    &Link{MessageURL: "https://example.com", PicURL: "https://example.com/a.png"},
+			},
+			want: `{"link":{"messageUrl":"https://example.com","picUrl":"https://example.com/a.png","text":"v1.2.3","title":"Release"},"msgtype":"link"}`,
+		},
+		{
+			name: "single button actionCard",
+			message: Message{
+				MsgType:    "actionCard",
+				Title:      "Incident",
+				Content:    "DB down",
+				ActionCard: &ActionCard{SingleTitle: "Open", SingleURL: "https://example.com"},
+			},
+			want: `{"actionCard":{"singleTitle":"Open","singleURL":"https://example.com","text":"DB down","title":"Incident"},"msgtype":"actionCard"}`,
+		},
+		{
+			name: "multi button actionCard",
+			message: Message{
+				MsgType: "actionCard",
+				Title:   "Incident",
+				Content: "DB down",
+				ActionCard: &ActionCard{
+					BtnOrientation: "1",
+					Buttons: []ActionCardButton{
+						{Title: "Ack", ActionURL: "https://example.com/ack"},
+						{Title: "Silence", ActionURL: "https://example.com/silence"},
+					},
+				},
+			},
+			want: `{"actionCard":{"btnOrientation":"1","btns":[{"title":"Ack","actionURL":"https://example.com/ack"},{"title":"Silence","actionURL":"https://example.com/silence"}],"text":"DB down","title":"Incident"},"msgtype":"actionCard"}`,
+		},
+		{
+			name: "feedCard",
+			message: Message{
+				MsgType:  "feedCard",
+				FeedCard: &FeedCard{Links: []FeedCardLink{{Title: "News", MessageURL: "https://example.com", PicURL: "https://example.com/a.png"}}},
+			},
+			want: `{"feedCard":{"links":[{"title":"News","messageURL":"https://example.com","picURL":"https://example.com/a.png"}]},"msgtype":"feedCard"}`,
+		},
+		{
+			name:    "link without payload",
+			message: Message{MsgType: "link"},
+			wantErr: true,
+		},
+		{
+			name:    "actionCard without buttons",
+			message: Message{MsgType: "actionCard", ActionCard: &ActionCard{}},
+			wantErr: true,
+		},
+		{
+			name:    "feedCard without links",
+			message: Message{MsgType: "feedCard"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid type",
+			message: Message{MsgType: "image"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := buildRobotParams(tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRobotParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			got, _ := json.Marshal(params)
+			if string(got) != tt.want {
+				t.Errorf("buildRobotParams() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,58 +2,583 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
+// Package email provides functionality for sending notifications by email through an SMTP server.
 package email
 
-import "github.com/sk-pkg/notify/msgid"
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"html/template"
+	"log"
+	"net/mail"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Security modes supported for the SMTP connection.
+const (
+	// SecurityNone sends mail over a plain TCP connection.
+	SecurityNone = "none"
+
+	// SecurityStartTLS upgrades a plain TCP connection with the STARTTLS command. It is the default.
+	SecurityStartTLS = "starttls"
+
+	// SecurityTLS connects with implicit TLS, usually on port 465.
+	SecurityTLS = "tls"
+)
+
+// Config represents the configuration for the email notifier.
+type Config struct {
+	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
+	Enabled bool
+
+	// ChannelSize defines the buffer size for the message channel.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	ChannelSize int
+
+	// PoolSize defines the number of goroutines in the worker pool.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	PoolSize int
+
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
+	// IdleSize is kept for compatibility and is currently unused.
+	IdleSize int
+
+	// Host is the host name of the SMTP server.
+	Host string
+
+	// Port is the port of the SMTP server.
+	// If set to 0, it defaults to 465 for SecurityTLS, 587 for SecurityStartTLS and 25 for SecurityNone.
+	Port int
+
+	// Username is the user name used to authenticate with the SMTP server.
+	// If empty, no authentication is performed.
+	Username string
+
+	// Password is the password used to authenticate with the SMTP server.
+	Password string
+
+	// Security selects how the connection to the SMTP server is secured.
+	//
+	// Options:
+	// 	- tls: Implicit TLS.
+	// 	- starttls: Plain connection upgraded with STARTTLS. This is the default.
+	// 	- none: Plain connection without encryption.
+	Security string
+
+	// TLSConfig is the TLS configuration used for tls and starttls connections.
+	// If nil, a configuration verifying the certificate against Host is used.
+	TLSConfig *tls.Config
+
+	// Timeout is the timeout for connecting to the SMTP server and for each delivery.
+	// If set to 0, it defaults to 30 seconds.
+	Timeout time.Duration
+
+	// From is the sender address, e.g. "Alerts <alerts@example.com>".
+	From string
+
+	// DefaultTo is the list of recipients used when a message does not specify any.
+	DefaultTo []string
+
+	// Templates maps message levels to custom HTML templates executed with TemplateData.
+	// Levels without a template use the built-in one.
+	Templates map[string]*template.Template
+
+	// Retry is the policy applied to failed deliveries: connection errors and the transient
+	// negative replies (4xx codes) of the SMTP server. The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+}
+
+// Notify is the interface that wraps the basic methods for the notifier.
+type Notify interface {
+	// StartProcessor initiates the message processing routine.
+	// It should be called once before submitting any messages.
+	StartProcessor()
+
+	// SubmitMessage adds a new message to the processing queue.
+	// The message will be processed asynchronously by the processor started with StartProcessor.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message.
+	SubmitMessage(message Message) (msgID string, err error)
+
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
+	// RegisterTemplate registers a custom HTML template for a message level.
+	//
+	// Parameters:
+	// 	- level: The level of the message (e.g., "success", "error", "warn", "info").
+	// 	- tmpl: The template executed with TemplateData. A nil template restores the built-in one.
+	RegisterTemplate(level string, tmpl *template.Template)
+
+	// Send sends a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The Message-ID header of the email, e.g. "<id@example.com>".
+	// 	- err: An error if the message cannot be sent.
+	Send(message Message) (providerMsgID string, err error)
+
+	// SendContext sends a message immediately, bypassing the processing queue, and stops retrying
+	// once ctx is done.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The Message-ID header of the email, e.g. "<id@example.com>".
+	// 	- attempts: The number of attempts made.
+	// 	- err: An error if the message cannot be sent.
+	SendContext(ctx context.Context, message Message) (providerMsgID string, attempts int, err error)
+
+	// Subscribe registers a handler called with the result of every delivery attempt, including the
+	// retries and the messages dropped before delivery, of the queued messages and of SendContext.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
+	// Close stops the notifier, ensuring all pending messages are processed
+	// before shutting down. After calling Close, the notifier should not be used anymore.
+	Close()
+}
+
+// notify implements the Notify interface.
+type notify struct {
+	// msgID is ID instance used for generating unique message IDs.
+	msgID *msgid.ID
 
-type (
-	Config struct {
-		Enabled bool
+	// smtp is the transport used for delivering messages.
+	smtp *smtpClient
 
-		ChannelSize int
-		PoolSize    int
-		IdleSize    int
+	// from is the parsed sender address.
+	from *mail.Address
+
+	// defaultTo is the list of recipients used when a message does not specify any.
+	defaultTo []*mail.Address
+
+	// templates maps message levels to custom HTML templates.
+	templates map[string]*template.Template
+
+	// tmplMu protects templates.
+	tmplMu sync.RWMutex
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
+}
+
+// Message represents a message to be sent via the notifier.
+type Message struct {
+	// ID is a unique identifier for the message. It can be used for tracking or deduplication.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets. It is reported in its SendResults.
+	DeliveryID string
+
+	// SendTo is a comma-separated list of recipient addresses, e.g. "a@example.com, Bob <b@example.com>".
+	// If empty, the DefaultTo recipients from the Config will be used.
+	SendTo string
+
+	// Cc is a list of carbon copy recipient addresses.
+	Cc []string
+
+	// Bcc is a list of blind carbon copy recipient addresses. They are not included in the headers.
+	Bcc []string
+
+	// ReplyTo is a comma-separated list of addresses replies should be sent to.
+	ReplyTo string
+
+	// Headers contains additional headers, e.g. "X-Priority". Values are RFC 2047 encoded if needed.
+	Headers map[string]string
+
+	// MsgLevel indicates the importance or category of the message (e.g., "info", "warn", "error", "success").
+	// If set and HTML is empty, the HTML body is rendered from the template of the level.
+	MsgLevel string
+
+	// Title is the subject of the email. Non-ASCII titles are RFC 2047 encoded.
+	Title string
+
+	// Content is the plain text body of the email.
+	Content string
+
+	// HTML is the HTML body of the email.
+	// If both Content and HTML are set, they are sent as multipart/alternative.
+	HTML string
+
+	// Attachments are files attached to the email.
+	Attachments []Attachment
+
+	// InlineImages are images embedded in the HTML body and referenced as "cid:<ContentID>".
+	InlineImages []Attachment
+}
+
+// validateConfig checks the provided configuration for validity.
+//
+// Parameters:
+//   - config: A pointer to the Config struct to be validated.
+//
+// Returns:
+//   - error: An error if the configuration is invalid, nil otherwise.
+func validateConfig(config *Config) error {
+	if config.Host == "" {
+		return errors.New("email Host is required")
 	}
 
-	Notify interface {
-		StartProcessor()
-		SubmitMessage(message Message) (msgID string)
-		Close()
+	if config.From == "" {
+		return errors.New("email From is required")
 	}
 
-	notify struct {
-		msgID    *msgid.ID
-		messages chan Message
+	if config.Security == "" {
+		config.Security = SecurityStartTLS
 	}
 
-	Message struct {
-		ID      string
-		Title   string
-		Content string
+	if config.Port == 0 {
+		switch config.Security {
+		case SecurityTLS:
+			config.Port = 465
+		case SecurityStartTLS:
+			config.Port = 587
+		default:
+			config.Port = 25
+		}
 	}
-)
 
-func (n *notify) Close() {
-	return
+	switch config.Security {
+	case SecurityNone, SecurityStartTLS, SecurityTLS:
+	default:
+		return fmt.Errorf("invalid email security mode: %s", config.Security)
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	// Set default values for ChannelSize and PoolSize if not provided
+	// Default to GOMAXPROCS * 10
+	if config.ChannelSize == 0 {
+		config.ChannelSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid email retry policy: %w", err)
+	}
+
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid email outbox: %w", err)
+	}
+
+	return nil
 }
 
+// StartProcessor starts the message processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
 func (n *notify) StartProcessor() {
+	n.queue.Start()
+}
 
+// SubmitMessage submits a message to the notifier's message queue.
+//
+// Parameters:
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
+func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
 }
 
-func (n *notify) SubmitMessage(message Message) (msgID string) {
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
+	message, err = n.prepareMessage(message)
+	if err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return message.ID, err
+	}
+
+	// The queue reports the messages it drops
+	key := outbox.Key(message.ID, message.SendTo)
+
+	return message.ID, n.queue.Submit(ctx, key, message)
+}
+
+// Send sends a message immediately.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The Message-ID header of the email.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) Send(message Message) (string, error) {
+	providerMsgID, _, err := n.SendContext(context.Background(), message)
+
+	return providerMsgID, err
+}
+
+// SendContext sends a message immediately, and stops retrying once ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The Message-ID header of the email.
+//   - int: The number of attempts made.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) SendContext(ctx context.Context, message Message) (string, int, error) {
+	message, err := n.prepareMessage(message)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return "", 0, err
+	}
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	for attempt := 1; ; attempt++ {
+		providerMsgID, err := n.sendMsg(message)
+		result := queue.Result{State: StateSent, ProviderMsgID: providerMsgID, Err: err, Attempts: attempt}
+
+		delay, ok := n.retry.Delay(attempt, err)
+		if !ok {
+			if err != nil {
+				result.State = StateFailed
+			}
+			n.report(message, result)
+
+			return providerMsgID, attempt, err
+		}
+
+		result.State = StateRetrying
+		n.report(message, result)
+
+		if waitErr := retry.Wait(ctx, delay); waitErr != nil {
+			result.State, result.Err = StateFailed, fmt.Errorf("%w, retry aborted: %w", err, waitErr)
+			n.report(message, result)
+
+			return providerMsgID, attempt, result.Err
+		}
+	}
+}
+
+// prepareMessage assigns an ID to a message and renders its HTML body from the template of its level.
+//
+// Parameters:
+//   - message: The Message struct to be prepared.
+//
+// Returns:
+//   - Message: The prepared message.
+//   - error: An error if the template cannot be rendered.
+func (n *notify) prepareMessage(message Message) (Message, error) {
 	if message.ID == "" {
 		message.ID = n.msgID.New()
 	}
 
-	n.messages <- message
+	// Render the HTML body from the template of the message level
+	if message.MsgLevel != "" && message.HTML == "" {
+		html, err := n.renderHTML(message.MsgLevel, message.Title, message.Content)
+		if err != nil {
+			return message, fmt.Errorf("failed to render email template: %w", err)
+		}
 
-	return message.ID
+		message.HTML = html
+	}
+
+	return message, nil
 }
 
+// New creates a new Notify instance with the provided configuration.
+//
+// Parameters:
+//   - config: The Config struct containing the notifier configuration.
+//
+// Returns:
+//   - Notify: A new Notify instance.
+//   - error: An error if the configuration is invalid or if the goroutine pool cannot be created.
 func New(config Config) (Notify, error) {
-	return &notify{
-		messages: make(chan Message, config.ChannelSize),
-		msgID:    msgid.NewMessageID(),
-	}, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email From address: %w", err)
+	}
+
+	defaultTo, err := parseAddresses(config.DefaultTo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email DefaultTo address: %w", err)
+	}
+
+	n := &notify{
+		msgID:     msgid.NewMessageID(),
+		smtp:      newSMTPClient(config),
+		from:      from,
+		defaultTo: defaultTo,
+		templates: make(map[string]*template.Template, len(config.Templates)),
+		retry:     config.Retry,
+	}
+
+	for level, tmpl := range config.Templates {
+		n.RegisterTemplate(level, tmpl)
+	}
+
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "email",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send: func(_ context.Context, m Message) (string, error) {
+			return n.sendMsg(m)
+		},
+		Report: n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
+	return n, nil
+}
+
+// sendMsg builds the email for a message and delivers it through the SMTP server.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - string: The Message-ID header of the email, empty if the message has no ID.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendMsg(m Message) (string, error) {
+	to := n.defaultTo
+	if m.SendTo != "" {
+		var err error
+		to, err = mail.ParseAddressList(m.SendTo)
+		if err != nil {
+			return "", fmt.Errorf("invalid email recipients %q: %w", m.SendTo, err)
+		}
+	}
+
+	if len(to) == 0 {
+		return "", errors.New("no recipients for email message")
+	}
+
+	cc, err := parseAddresses(m.Cc)
+	if err != nil {
+		return "", fmt.Errorf("invalid email Cc: %w", err)
+	}
+
+	bcc, err := parseAddresses(m.Bcc)
+	if err != nil {
+		return "", fmt.Errorf("invalid email Bcc: %w", err)
+	}
+
+	data, err := buildMessage(n.from, to, cc, m)
+	if err != nil {
+		return "", fmt.Errorf("failed to build email message: %w", err)
+	}
+
+	recipients := make([]string, 0, len(to)+len(cc)+len(bcc))
+	for _, list := range [][]*mail.Address{to, cc, bcc} {
+		for _, addr := range list {
+			recipients = append(recipients, addr.Address)
+		}
+	}
+
+	if err = n.smtp.send(n.from.Address, recipients, data); err != nil {
+		return "", classifyError(err)
+	}
+
+	return messageIDOf(m.ID, n.from), nil
+}
+
+// parseAddresses parses a list of addresses.
+func parseAddresses(addresses []string) ([]*mail.Address, error) {
+	parsed := make([]*mail.Address, 0, len(addresses))
+	for _, address := range addresses {
+		addr, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, addr)
+	}
+
+	return parsed, nil
+}
+
+// Close stops the notifier, waits for all messages to be processed, and releases resources.
+func (n *notify) Close() {
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
+
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
+
+	log.Println("Email notify closed")
 }
@@ -0,0 +1,237 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"mime"
+	"net/mail"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestNotify(t *testing.T, srv *testSMTPServer) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:   true,
+		Host:      "127.0.0.1",
+		Port:      srv.port(),
+		Security:  SecurityNone,
+		Timeout:   5 * time.Second,
+		From:      "Alerts <alerts@example.com>",
+		DefaultTo: []string{"oncall@example.com"},
+		Retry:     retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return i.(*notify)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:    "Valid config",
+			config:  Config{Enabled: true, Host: "smtp.example.com", From: "alerts@example.com"},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid config - missing host",
+			config:  Config{Enabled: true, From: "alerts@example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - invalid from",
+			config:  Config{Enabled: true, Host: "smtp.example.com", From: "not an address"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - invalid security",
+			config:  Config{Enabled: true, Host: "smtp.example.com", From: "alerts@example.com", Security: "ssl"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_DefaultPort(t *testing.T) {
+	tests := map[string]int{SecurityTLS: 465, SecurityStartTLS: 587, SecurityNone: 25, "": 587}
+
+	for security, want := range tests {
+		config := Config{Host: "smtp.example.com", From: "alerts@example.com", Security: security}
+		if err := validateConfig(&config); err != nil {
+			t.Fatalf("validateConfig() error = %v", err)
+		}
+
+		if config.Port != want {
+			t.Errorf("security %q: Port = %d, want %d", security, config.Port, want)
+		}
+	}
+}
+
+func TestNotify_SubmitMessage(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	n := newTestNotify(t, srv)
+
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{SendTo: "a@example.com, Bob <b@example.com>", Title: "Disk full", Content: "db-1 is at 99%"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	if _, err := n.SubmitMessage(Message{Title: "数据库告警", Content: "default recipients"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	// Close waits for the queued messages to be delivered
+	n.Close()
+
+	got := srv.received()
+	if len(got) != 2 {
+		t.Fatalf("server received %d mails, want 2", len(got))
+	}
+
+	byRecipients := map[string]receivedMail{}
+	for _, m := range got {
+		byRecipients[strings.Join(m.To, ",")] = m
+	}
+
+	explicit, ok := byRecipients["a@example.com,b@example.com"]
+	if !ok {
+		t.Fatalf("no mail delivered to explicit recipients, got %v", got)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(explicit.Data))
+	if err != nil {
+		t.Fatalf("failed to parse delivered mail: %v", err)
+	}
+
+	if msg.Header.Get("Subject") != "Disk full" {
+		t.Errorf("Subject = %q, want %q", msg.Header.Get("Subject"), "Disk full")
+	}
+
+	fallback, ok := byRecipients["oncall@example.com"]
+	if !ok {
+		t.Fatalf("no mail delivered to default recipients, got %v", got)
+	}
+
+	msg, err = mail.ReadMessage(strings.NewReader(fallback.Data))
+	if err != nil {
+		t.Fatalf("failed to parse delivered mail: %v", err)
+	}
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "数据库告警" {
+		t.Errorf("Subject = %q, want %q", subject, "数据库告警")
+	}
+}
+
+func TestNotify_SendMsg_InvalidRecipients(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	n := newTestNotify(t, srv)
+
+	if _, err := n.sendMsg(Message{SendTo: "not an address", Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error for invalid recipients")
+	}
+}
+
+func TestNotify_Send(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	n := newTestNotify(t, srv)
+
+	msgID, err := n.Send(Message{ID: "id-1", Title: "Disk full", Content: "db-1 is at 99%"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if msgID != "<id-1@example.com>" {
+		t.Errorf("Send() = %q, want <id-1@example.com>", msgID)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("server received %d mails, want 1", len(got))
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got[0].Data))
+	if err != nil {
+		t.Fatalf("failed to parse delivered mail: %v", err)
+	}
+	if msg.Header.Get("Message-Id") != msgID {
+		t.Errorf("Message-ID = %q, want %q", msg.Header.Get("Message-Id"), msgID)
+	}
+}
+
+func TestNotify_SubmitMessage_Retry(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	n := newTestNotify(t, srv)
+
+	// The queued deliveries are reported to the subscribers
+	var states []string
+	n.Subscribe(func(r SendResult) { states = append(states, r.MsgID+":"+r.DeliveryID+":"+r.State) })
+	n.StartProcessor()
+
+	for _, to := range []string{"greylist@example.com", "reject@example.com"} {
+		if _, err := n.SubmitMessage(Message{ID: to, SendTo: to, Content: "hello"}); err != nil {
+			t.Fatalf("SubmitMessage() error = %v", err)
+		}
+	}
+
+	// Close waits for the scheduled retry of the greylisted message
+	n.Close()
+
+	got := srv.received()
+	if len(got) != 1 || got[0].To[0] != "greylist@example.com" {
+		t.Errorf("server received %+v, want the greylisted mail only", got)
+	}
+	sort.Strings(states)
+	if want := "[greylist@example.com::retrying greylist@example.com::sent reject@example.com::failed]"; fmt.Sprint(states) != want {
+		t.Errorf("published %v, want %s", states, want)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		Host:      "127.0.0.1",
+		From:      "alerts@example.com",
+		DefaultTo: []string{"oncall@example.com"},
+		Outbox:    outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	// A message is queued once per list of recipients
+	message := Message{ID: "msg-1", Title: "Disk full", Content: "db-1"}
+	for _, to := range []string{"", "a@example.com", "b@example.com"} {
+		message.SendTo = to
+		if _, err = i.SubmitMessage(message); err != nil {
+			t.Fatalf("SubmitMessage() to %q error = %v", to, err)
+		}
+	}
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// base64LineLength is the maximum length of a base64 encoded line as required by RFC 2045.
+const base64LineLength = 76
+
+// Attachment represents a file attached to an email or an image embedded in its HTML body.
+type Attachment struct {
+	// Filename is the name of the file shown to the recipients.
+	// If empty, the base name of Path is used.
+	Filename string
+
+	// ContentType is the MIME type of the file.
+	// If empty, it is detected from the file extension, falling back to application/octet-stream.
+	ContentType string
+
+	// Data is the content of the file. If empty, the file is read from Path when the message is sent.
+	Data []byte
+
+	// Path is the path of a local file to attach. It is only used if Data is empty.
+	Path string
+
+	// ContentID identifies an inline image, which is referenced from the HTML body as "cid:<ContentID>".
+	// It is required for inline images and ignored for attachments.
+	ContentID string
+}
+
+// part is a MIME entity with its headers and encoded body.
+type part struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// buildMessage renders a Message into an RFC 5322 email.
+//
+// The body is structured as follows, omitting the containers that are not needed:
+//
+//	multipart/mixed
+//	├── multipart/related
+//	│   ├── multipart/alternative
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── inline images
+//	└── attachments
+//
+// Parameters:
+//   - from: The sender address.
+//   - to: The recipient addresses.
+//   - cc: The carbon copy addresses.
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - []byte: The complete message including headers.
+//   - error: An error if a header is invalid or an attachment cannot be read.
+func buildMessage(from *mail.Address, to, cc []*mail.Address, m Message) ([]byte, error) {
+	body, err := buildBody(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "From", from.String())
+	writeHeader(&buf, "To", joinAddresses(to))
+	if len(cc) > 0 {
+		writeHeader(&buf, "Cc", joinAddresses(cc))
+	}
+	if m.ReplyTo != "" {
+		replyTo, err := mail.ParseAddressList(m.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Reply-To address %q: %w", m.ReplyTo, err)
+		}
+		writeHeader(&buf, "Reply-To", joinAddresses(replyTo))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Title))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	if m.ID != "" {
+		writeHeader(&buf, "Message-ID", messageIDOf(m.ID, from))
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	// Custom headers are written in a stable order
+	keys := make([]string, 0, len(m.Headers))
+	for key := range m.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := m.Headers[key]
+		if strings.ContainsAny(key, "\r\n: ") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid email header %q", key)
+		}
+		writeHeader(&buf, textproto.CanonicalMIMEHeaderKey(key), mime.QEncoding.Encode("utf-8", value))
+	}
+
+	writeMIMEHeader(&buf, body.header)
+	buf.WriteString("\r\n")
+	buf.Write(body.body)
+
+	return buf.Bytes(), nil
+}
+
+// buildBody builds the MIME body of a message.
+func buildBody(m Message) (part, error) {
+	var alternatives []part
+	if m.Content != "" || m.HTML == "" {
+		p, err := textPart("text/plain", m.Content)
+		if err != nil {
+			return part{}, err
+		}
+		alternatives = append(alternatives, p)
+	}
+	if m.HTML != "" {
+		p, err := textPart("text/html", m.HTML)
+		if err != nil {
+			return part{}, err
+		}
+		alternatives = append(alternatives, p)
+	}
+
+	body := alternatives[0]
+	if len(alternatives) > 1 {
+		body = multipartOf("alternative", alternatives)
+	}
+
+	if len(m.InlineImages) > 0 {
+		related := []part{body}
+		for _, img := range m.InlineImages {
+			if img.ContentID == "" {
+				return part{}, errors.New("ContentID is required for inline images")
+			}
+
+			p, err := filePart(img, "inline")
+			if err != nil {
+				return part{}, err
+			}
+			p.header.Set("Content-ID", fmt.Sprintf("<%s>", img.ContentID))
+			related = append(related, p)
+		}
+		body = multipartOf("related", related)
+	}
+
+	if len(m.Attachments) > 0 {
+		mixed := []part{body}
+		for _, att := range m.Attachments {
+			p, err := filePart(att, "attachment")
+			if err != nil {
+				return part{}, err
+			}
+			mixed = append(mixed, p)
+		}
+		body = multipartOf("mixed", mixed)
+	}
+
+	return body, nil
+}
+
+// textPart creates a quoted-printable encoded text part.
+func textPart(contentType, text string) (part, error) {
+	var buf bytes.Buffer
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return part{}, err
+	}
+	if err := qp.Close(); err != nil {
+		return part{}, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "utf-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	return part{header: header, body: buf.Bytes()}, nil
+}
+
+// filePart creates a base64 encoded part for an attachment or inline image.
+func filePart(att Attachment, disposition string) (part, error) {
+	data := att.Data
+	if len(data) == 0 && att.Path != "" {
+		var err error
+		data, err = os.ReadFile(att.Path)
+		if err != nil {
+			return part{}, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	}
+
+	filename := att.Filename
+	if filename == "" && att.Path != "" {
+		filename = filepath.Base(att.Path)
+	}
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	if filename != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return part{}, fmt.Errorf("invalid attachment content type %q: %w", contentType, err)
+		}
+		params["name"] = filename
+		header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	} else {
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Disposition", disposition)
+	}
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	return part{header: header, body: encodeBase64Lines(data)}, nil
+}
+
+// multipartOf wraps parts into a multipart entity of the given subtype.
+func multipartOf(subtype string, parts []part) part {
+	var buf bytes.Buffer
+
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		// Writing to a bytes.Buffer never fails
+		pw, _ := w.CreatePart(p.header)
+		_, _ = pw.Write(p.body)
+	}
+	_ = w.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": w.Boundary()}))
+
+	return part{header: header, body: buf.Bytes()}
+}
+
+// encodeBase64Lines encodes data with base64, wrapping lines at 76 characters.
+func encodeBase64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var buf bytes.Buffer
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength])
+		buf.WriteString("\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// writeMIMEHeader writes the headers of a MIME entity in a stable order.
+func writeMIMEHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range header[key] {
+			writeHeader(buf, key, value)
+		}
+	}
+}
+
+// writeHeader writes a single header line.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
+
+// joinAddresses formats a list of addresses for an address header.
+func joinAddresses(addresses []*mail.Address) string {
+	s := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		s = append(s, addr.String())
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// messageIDOf returns the Message-ID header of a message, or empty if the message has no ID.
+func messageIDOf(id string, from *mail.Address) string {
+	if id == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("<%s@%s>", id, domainOf(from.Address))
+}
+
+// domainOf returns the domain part of an email address.
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+
+	return "localhost"
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mimeNode is a parsed MIME entity used to assert the structure of built messages.
+type mimeNode struct {
+	mediaType string
+	header    map[string][]string
+	body      string
+	children  []mimeNode
+}
+
+// parseMIME parses a MIME entity recursively, decoding base64 and quoted-printable bodies.
+func parseMIME(t *testing.T, header map[string][]string, body io.Reader) mimeNode {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(firstOf(header["Content-Type"]))
+	if err != nil {
+		t.Fatalf("invalid Content-Type %q: %v", header["Content-Type"], err)
+	}
+
+	node := mimeNode{mediaType: mediaType, header: header}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			node.children = append(node.children, parseMIME(t, p.Header, p))
+		}
+		return node
+	}
+
+	data, _ := io.ReadAll(body)
+	switch firstOf(header["Content-Transfer-Encoding"]) {
+	case "base64":
+		decoded, err := io.ReadAll(base64Decoder(data))
+		if err != nil {
+			t.Fatalf("invalid base64 body: %v", err)
+		}
+		data = decoded
+	case "quoted-printable":
+		decoded, err := io.ReadAll(quotedPrintableDecoder(data))
+		if err != nil {
+			t.Fatalf("invalid quoted-printable body: %v", err)
+		}
+		data = decoded
+	}
+	node.body = string(data)
+
+	return node
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func readBuiltMessage(t *testing.T, data []byte) (*mail.Message, mimeNode) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+
+	return msg, parseMIME(t, msg.Header, msg.Body)
+}
+
+func TestBuildMessage_PlainText(t *testing.T) {
+	from := &mail.Address{Name: "Alerts", Address: "alerts@example.com"}
+	to := []*mail.Address{{Address: "a@example.com"}}
+
+	data, err := buildMessage(from, to, nil, Message{ID: "id-1", Title: "数据库告警", Content: "db-1 is down"})
+	if err != nil {
+		t.Fatalf("buildMessage() error = %v", err)
+	}
+
+	msg, root := readBuiltMessage(t, data)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "数据库告警" {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, "数据库告警")
+	}
+
+	if !strings.HasPrefix(msg.Header.Get("Subject"), "=?utf-8?") {
+		t.Errorf("Subject %q is not RFC 2047 encoded", msg.Header.Get("Subject"))
+	}
+
+	if msg.Header.Get("Message-Id") != "<id-1@example.com>" {
+		t.Errorf("Message-ID = %q", msg.Header.Get("Message-Id"))
+	}
+
+	if root.mediaType != "text/plain" || root.body != "db-1 is down" {
+		t.Errorf("body = %s %q, want text/plain %q", root.mediaType, root.body, "db-1 is down")
+	}
+}
+
+func TestBuildMessage_Multipart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("host,cpu\ndb-1,99\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	from := &mail.Address{Address: "alerts@example.com"}
+	to := []*mail.Address{{Address: "a@example.com"}}
+	cc := []*mail.Address{{Name: "张三", Address: "zhangsan@example.com"}}
+
+	m := Message{
+		Title:   "Incident report",
+		ReplyTo: "sre@example.com",
+		Headers: map[string]string{"X-Priority": "1"},
+		Content: "plain body",
+		HTML:    `<p>html body</p><img src="cid:logo">`,
+		InlineImages: []Attachment{
+			{Filename: "logo.png", ContentID: "logo", Data: []byte("\x89PNG fake")},
+		},
+		Attachments: []Attachment{
+			{Path: path},
+			{Filename: "日志.txt", Data: []byte("log line")},
+		},
+	}
+
+	data, err := buildMessage(from, to, cc, m)
+	if err != nil {
+		t.Fatalf("buildMessage() error = %v", err)
+	}
+
+	msg, root := readBuiltMessage(t, data)
+
+	if msg.Header.Get("Reply-To") != "<sre@example.com>" {
+		t.Errorf("Reply-To = %q", msg.Header.Get("Reply-To"))
+	}
+
+	if msg.Header.Get("X-Priority") != "1" {
+		t.Errorf("X-Priority = %q", msg.Header.Get("X-Priority"))
+	}
+
+	ccList, err := msg.Header.AddressList("Cc")
+	if err != nil || len(ccList) != 1 || ccList[0].Name != "张三" {
+		t.Errorf("Cc = %v (%v)", ccList, err)
+	}
+
+	// multipart/mixed -> [multipart/related -> [multipart/alternative, image], attachment, attachment]
+	if root.mediaType != "multipart/mixed" || len(root.children) != 3 {
+		t.Fatalf("root = %s with %d parts, want multipart/mixed with 3 parts", root.mediaType, len(root.children))
+	}
+
+	related := root.children[0]
+	if related.mediaType != "multipart/related" || len(related.children) != 2 {
+		t.Fatalf("related = %s with %d parts", related.mediaType, len(related.children))
+	}
+
+	alternative := related.children[0]
+	if alternative.mediaType != "multipart/alternative" || len(alternative.children) != 2 {
+		t.Fatalf("alternative = %s with %d parts", alternative.mediaType, len(alternative.children))
+	}
+
+	if alternative.children[0].mediaType != "text/plain" || alternative.children[0].body != "plain body" {
+		t.Errorf("plain part = %s %q", alternative.children[0].mediaType, alternative.children[0].body)
+	}
+
+	if alternative.children[1].mediaType != "text/html" || alternative.children[1].body != m.HTML {
+		t.Errorf("html part = %s %q", alternative.children[1].mediaType, alternative.children[1].body)
+	}
+
+	image := related.children[1]
+	if image.mediaType != "image/png" || firstOf(image.header["Content-Id"]) != "<logo>" || image.body != "\x89PNG fake" {
+		t.Errorf("inline image = %s %v %q", image.mediaType, image.header["Content-Id"], image.body)
+	}
+
+	csv := root.children[1]
+	_, params, _ := mime.ParseMediaType(firstOf(csv.header["Content-Disposition"]))
+	if params["filename"] != "report.csv" || csv.body != "host,cpu\ndb-1,99\n" {
+		t.Errorf("path attachment = %v %q", csv.header["Content-Disposition"], csv.body)
+	}
+
+	txt := root.children[2]
+	disposition, params, _ := mime.ParseMediaType(firstOf(txt.header["Content-Disposition"]))
+	if disposition != "attachment" || params["filename"] != "日志.txt" || txt.body != "log line" {
+		t.Errorf("data attachment = %v %q", txt.header["Content-Disposition"], txt.body)
+	}
+}
+
+func TestBuildMessage_Errors(t *testing.T) {
+	from := &mail.Address{Address: "alerts@example.com"}
+	to := []*mail.Address{{Address: "a@example.com"}}
+
+	tests := []struct {
+		name    string
+		message Message
+	}{
+		{name: "header injection", message: Message{Headers: map[string]string{"X-Test": "a\r\nBcc: evil@example.com"}}},
+		{name: "invalid header key", message: Message{Headers: map[string]string{"X Test": "a"}}},
+		{name: "inline image without content id", message: Message{HTML: "<p/>", InlineImages: []Attachment{{Data: []byte("x")}}}},
+		{name: "missing attachment file", message: Message{Attachments: []Attachment{{Path: "/nonexistent/file"}}}},
+		{name: "invalid reply-to", message: Message{ReplyTo: "not an address"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildMessage(from, to, nil, tt.message); err == nil {
+				t.Error("buildMessage() expected error")
+			}
+		})
+	}
+}
+
+func TestEncodeBase64Lines(t *testing.T) {
+	encoded := encodeBase64Lines(bytes.Repeat([]byte("a"), 200))
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(encoded), "\r\n"), "\r\n") {
+		if len(line) > base64LineLength {
+			t.Errorf("line length %d exceeds %d", len(line), base64LineLength)
+		}
+	}
+}
+
+func base64Decoder(data []byte) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.ReplaceAll(data, []byte("\r\n"), nil)))
+}
+
+func quotedPrintableDecoder(data []byte) io.Reader {
+	return quotedprintable.NewReader(bytes.NewReader(data))
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/retry"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// smtpClient delivers messages to an SMTP server.
+// A new connection is opened for every delivery, so it is safe for concurrent use.
+type smtpClient struct {
+	host      string
+	addr      string
+	username  string
+	password  string
+	security  string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// newSMTPClient creates a smtpClient from a validated Config.
+func newSMTPClient(config Config) *smtpClient {
+	tlsConfig := config.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: config.Host}
+	}
+
+	return &smtpClient{
+		host:      config.Host,
+		addr:      net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		username:  config.Username,
+		password:  config.Password,
+		security:  config.Security,
+		tlsConfig: tlsConfig,
+		timeout:   config.Timeout,
+	}
+}
+
+// dial connects to the SMTP server and secures the connection according to the security mode.
+func (c *smtpClient) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if c.security == SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server %s: %w", c.addr, err)
+	}
+
+	// Bound the whole SMTP conversation by the timeout
+	_ = conn.SetDeadline(time.Now().Add(c.timeout))
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create smtp client: %w", err)
+	}
+
+	if c.security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, fmt.Errorf("smtp server %s does not support STARTTLS", c.addr)
+		}
+
+		if err = client.StartTLS(c.tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// send delivers a message to the recipients.
+//
+// Parameters:
+//   - from: The envelope sender address.
+//   - to: The envelope recipient addresses.
+//   - data: The complete message including headers.
+//
+// Returns:
+//   - error: An error if the message cannot be delivered, nil otherwise.
+func (c *smtpClient) send(from string, to []string, data []byte) error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if c.username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err = client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+				return fmt.Errorf("smtp authentication failed: %w", err)
+			}
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %w", err)
+	}
+
+	for _, addr := range to {
+		if err = client.Rcpt(addr); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s failed: %w", addr, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %w", err)
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return fmt.Errorf("failed to write email data: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("smtp server rejected the message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// classifyError marks the transient negative replies of the SMTP server (4xx codes, such as a
+// greylisted sender or a full mailbox) as retryable. Permanent replies (5xx codes) are left as they are.
+//
+// Parameters:
+//   - err: The error returned by send.
+//
+// Returns:
+//   - error: The error, marked as transient if the server may accept the message later.
+func classifyError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 400 && reply.Code < 500 {
+		return retry.Transient(err)
+	}
+
+	return err
+}
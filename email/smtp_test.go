@@ -0,0 +1,250 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"github.com/sk-pkg/notify/retry"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedMail is a message accepted by the fake SMTP server.
+type receivedMail struct {
+	From string
+	To   []string
+	Auth string
+	Data string
+}
+
+// testSMTPServer is a minimal in-process SMTP server used to test deliveries.
+// It accepts any sender and recipient, except recipients containing "reject",
+// and recipients containing "greylist" the first time they are seen.
+type testSMTPServer struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	mails      []receivedMail
+	greylisted map[string]bool
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testSMTPServer{listener: l, greylisted: make(map[string]bool)}
+	go s.serve()
+	t.Cleanup(func() { _ = l.Close() })
+
+	return s
+}
+
+// port returns the port the server is listening on.
+func (s *testSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// received returns the messages accepted so far.
+func (s *testSMTPServer) received() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]receivedMail(nil), s.mails...)
+}
+
+func (s *testSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP test")
+
+	var current receivedMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			_ = tp.PrintfLine("250-localhost")
+			_ = tp.PrintfLine("250-8BITMIME")
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line[len("AUTH PLAIN"):]))
+			current.Auth = string(decoded)
+			_ = tp.PrintfLine("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			current.From = trimPath(line[len("MAIL FROM:"):])
+			_ = tp.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt := trimPath(line[len("RCPT TO:"):])
+			if strings.Contains(rcpt, "reject") {
+				_ = tp.PrintfLine("550 5.1.1 mailbox unavailable")
+				continue
+			}
+			if strings.Contains(rcpt, "greylist") && s.greylist(rcpt) {
+				_ = tp.PrintfLine("451 4.7.1 greylisted, try again later")
+				continue
+			}
+			current.To = append(current.To, rcpt)
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "DATA":
+			_ = tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(tp.Reader.R)
+			if err != nil {
+				return
+			}
+			current.Data = data
+
+			s.mu.Lock()
+			s.mails = append(s.mails, current)
+			s.mu.Unlock()
+
+			current = receivedMail{Auth: current.Auth}
+			_ = tp.PrintfLine("250 OK queued")
+		case cmd == "RSET", cmd == "NOOP":
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// greylist reports whether a recipient is seen for the first time.
+func (s *testSMTPServer) greylist(rcpt string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := !s.greylisted[rcpt]
+	s.greylisted[rcpt] = true
+
+	return first
+}
+
+// trimPath extracts the address from a MAIL FROM or RCPT TO argument.
+func trimPath(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if i := strings.Index(arg, ">"); i >= 0 {
+		arg = arg[:i]
+	}
+
+	return strings.TrimPrefix(arg, "<")
+}
+
+// readData reads the DATA section up to the terminating dot line.
+func readData(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if line == ".\r\n" {
+			return b.String(), nil
+		}
+
+		b.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+func TestSMTPClient_Send(t *testing.T) {
+	srv := newTestSMTPServer(t)
+
+	c := newSMTPClient(Config{
+		Host:     "127.0.0.1",
+		Port:     srv.port(),
+		Username: "user",
+		Password: "pass",
+		Security: SecurityNone,
+		Timeout:  5 * time.Second,
+	})
+
+	err := c.send("from@example.com", []string{"a@example.com", "b@example.com"}, []byte("Subject: hi\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("server received %d mails, want 1", len(got))
+	}
+
+	if got[0].From != "from@example.com" || strings.Join(got[0].To, ",") != "a@example.com,b@example.com" {
+		t.Errorf("envelope = %s -> %v", got[0].From, got[0].To)
+	}
+
+	if got[0].Auth != "\x00user\x00pass" {
+		t.Errorf("auth = %q, want PLAIN credentials", got[0].Auth)
+	}
+
+	if err = c.send("from@example.com", []string{"reject@example.com"}, []byte("\r\n")); err == nil {
+		t.Error("send() expected error for rejected recipient")
+	}
+}
+
+func TestSMTPClient_StartTLSUnsupported(t *testing.T) {
+	srv := newTestSMTPServer(t)
+
+	c := newSMTPClient(Config{Host: "127.0.0.1", Port: srv.port(), Security: SecurityStartTLS, Timeout: 5 * time.Second})
+
+	if err := c.send("from@example.com", []string{"a@example.com"}, []byte("\r\n")); err == nil {
+		t.Error("send() expected error when the server does not offer STARTTLS")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	srv := newTestSMTPServer(t)
+
+	c := newSMTPClient(Config{Host: "127.0.0.1", Port: srv.port(), Security: SecurityNone, Timeout: 5 * time.Second})
+
+	tests := []struct {
+		name string
+		to   string
+		want bool
+	}{
+		{name: "Transient reply", to: "greylist@example.com", want: true},
+		{name: "Permanent reply", to: "reject@example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(c.send("from@example.com", []string{tt.to}, []byte("\r\n")))
+			if err == nil {
+				t.Fatal("send() expected error")
+			}
+
+			if got := retry.IsRetryable(err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateData represents the data used to render the HTML body of a leveled message.
+// Custom templates registered with RegisterTemplate are executed with this data.
+type TemplateData struct {
+	Title      string // The title of the message
+	Content    string // The plain text content of the message
+	Time       string // The time the message was rendered
+	Level      string // The level of the message (e.g., success, error, warn, info)
+	LevelColor string // The banner color of the level, as a CSS color
+}
+
+// defaultHTMLTmpl is the built-in HTML template for leveled messages.
+// It renders a level colored banner with the title, followed by the content and the time.
+// Styles are inlined since most email clients ignore style sheets.
+const defaultHTMLTmpl = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{.Title}}</title>
+</head>
+<body style="margin:0;padding:0;background-color:#f5f6f7;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="background-color:#f5f6f7;">
+<tr>
+<td align="center" style="padding:24px 12px;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="max-width:600px;background-color:#ffffff;border-radius:8px;overflow:hidden;font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',Helvetica,Arial,sans-serif;">
+<tr>
+<td style="background-color:{{.LevelColor}};padding:16px 24px;color:#ffffff;font-size:18px;font-weight:600;line-height:1.4;">{{.Title}}</td>
+</tr>
+<tr>
+<td style="padding:24px;color:#1f2329;font-size:14px;line-height:1.6;white-space:pre-wrap;word-break:break-word;">{{.Content}}</td>
+</tr>
+<tr>
+<td style="padding:0 24px 16px;">
+<div style="border-top:1px solid #dee0e3;padding-top:12px;color:#8f959e;font-size:12px;">{{.Time}}</div>
+</td>
+</tr>
+</table>
+</td>
+</tr>
+</table>
+</body>
+</html>
+`
+
+// levelColorMap maps notification levels to their corresponding banner colors.
+var levelColorMap = map[string]string{
+	"success": "#2ea121",
+	"error":   "#d83931",
+	"warn":    "#de7802",
+}
+
+// defaultLevelColor is the banner color used for info and unknown levels.
+const defaultLevelColor = "#245bdb"
+
+var (
+	once        sync.Once
+	defaultTmpl *template.Template
+	defaultErr  error
+)
+
+// getDefaultHTMLTmpl returns the parsed built-in HTML template.
+// It ensures that the template is only parsed once using sync.Once.
+//
+// Returns:
+//   - *template.Template: The parsed template
+//   - error: Any error encountered during template parsing
+func getDefaultHTMLTmpl() (*template.Template, error) {
+	once.Do(func() {
+		defaultTmpl, defaultErr = template.New("defaultHTML").Parse(defaultHTMLTmpl)
+	})
+	return defaultTmpl, defaultErr
+}
+
+// RegisterTemplate registers a custom HTML template for a message level.
+// It replaces the built-in template for messages of that level.
+//
+// Parameters:
+//   - level: The level of the message (e.g., "success", "error", "warn", "info").
+//   - tmpl: The template executed with TemplateData. A nil template restores the built-in one.
+func (n *notify) RegisterTemplate(level string, tmpl *template.Template) {
+	n.tmplMu.Lock()
+	defer n.tmplMu.Unlock()
+
+	if tmpl == nil {
+		delete(n.templates, level)
+		return
+	}
+
+	n.templates[level] = tmpl
+}
+
+// renderHTML renders the HTML body of a leveled message.
+// The template registered for the level is used, falling back to the built-in template.
+//
+// Parameters:
+//   - level: The level of the message.
+//   - title: The title of the message.
+//   - content: The plain text content of the message.
+//
+// Returns:
+//   - string: The rendered HTML body.
+//   - error: Any error encountered while executing the template.
+func (n *notify) renderHTML(level, title, content string) (string, error) {
+	n.tmplMu.RLock()
+	t, ok := n.templates[level]
+	n.tmplMu.RUnlock()
+
+	if !ok {
+		var err error
+		t, err = getDefaultHTMLTmpl()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Determine the color based on the level
+	levelColor, ok := levelColorMap[level]
+	if !ok {
+		levelColor = defaultLevelColor
+	}
+
+	data := TemplateData{
+		Title:      title,
+		Content:    content,
+		Time:       time.Now().Format("2006-01-02 15:04:05"),
+		Level:      level,
+		LevelColor: levelColor,
+	}
+
+	var result strings.Builder
+	if err := t.Execute(&result, data); err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestNotify_RenderHTML(t *testing.T) {
+	i, err := New(Config{Enabled: true, Host: "smtp.example.com", From: "alerts@example.com"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n := i.(*notify)
+
+	tests := []struct {
+		level string
+		color string
+	}{
+		{level: "success", color: "#2ea121"},
+		{level: "error", color: "#d83931"},
+		{level: "warn", color: "#de7802"},
+		{level: "info", color: defaultLevelColor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			html, err := n.renderHTML(tt.level, "DB <down>", "db-1 is down")
+			if err != nil {
+				t.Fatalf("renderHTML() error = %v", err)
+			}
+
+			for _, want := range []string{"background-color:" + tt.color, "DB &lt;down&gt;", "db-1 is down"} {
+				if !strings.Contains(html, want) {
+					t.Errorf("rendered HTML does not contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotify_RegisterTemplate(t *testing.T) {
+	custom := template.Must(template.New("error").Parse(`<h1 style="color:{{.LevelColor}}">{{.Level}}: {{.Title}}</h1>`))
+
+	i, err := New(Config{
+		Enabled:   true,
+		Host:      "smtp.example.com",
+		From:      "alerts@example.com",
+		Templates: map[string]*template.Template{"error": custom},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n := i.(*notify)
+
+	html, err := n.renderHTML("error", "Disk full", "")
+	if err != nil {
+		t.Fatalf("renderHTML() error = %v", err)
+	}
+
+	if html != `<h1 style="color:#d83931">error: Disk full</h1>` {
+		t.Errorf("renderHTML() = %q", html)
+	}
+
+	// Other levels keep the built-in template
+	html, _ = n.renderHTML("warn", "Disk full", "")
+	if !strings.Contains(html, "<!DOCTYPE html>") {
+		t.Error("warn level should use the built-in template")
+	}
+
+	// A nil template restores the built-in one
+	n.RegisterTemplate("error", nil)
+	html, _ = n.renderHTML("error", "Disk full", "")
+	if !strings.Contains(html, "<!DOCTYPE html>") {
+		t.Error("nil template should restore the built-in template")
+	}
+}
+
+func TestNotify_SubmitMessage_Level(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	n := newTestNotify(t, srv)
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{MsgLevel: "error", Title: "Disk full", Content: "db-1 is at 99%"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	n.Close()
+
+	mails := srv.received()
+	if len(mails) != 1 {
+		t.Fatalf("received %d mails, want 1", len(mails))
+	}
+
+	_, root := readBuiltMessage(t, []byte(mails[0].Data))
+	if root.mediaType != "multipart/alternative" || len(root.children) != 2 {
+		t.Fatalf("body = %s with %d parts, want multipart/alternative with 2 parts", root.mediaType, len(root.children))
+	}
+
+	if root.children[0].body != "db-1 is at 99%" {
+		t.Errorf("plain part = %q", root.children[0].body)
+	}
+
+	if !strings.Contains(root.children[1].body, "background-color:#d83931") {
+		t.Errorf("html part does not contain the error banner")
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Failover is a chain of fallback targets a channel fails over to when it cannot deliver a message.
+//
+// The failover is triggered by the failures reported by the channel: the results of senders implementing
+// ResultPublisher, such as the built-in channels, the results of SendSync, and the messages a sender refuses
+// to queue. A message queued by a sender that does not implement ResultPublisher is considered delivered.
+type Failover struct {
+	// Channel is the primary channel.
+	Channel Channel
+
+	// Fallbacks are tried in order until one of them delivers the message.
+	// Their channels must differ from each other and from the primary channel.
+	Fallbacks []Target
+
+	// MaxRetries is the number of retries of a channel after which the message fails over
+	// to the next target, the delivery on the channel being cancelled. If 0, the message fails over
+	// once the channel gives up.
+	MaxRetries int
+}
+
+// chain is a message being delivered through a failover chain.
+type chain struct {
+	// ctx is the context the message was sent with.
+	ctx context.Context
+
+	// message is the message before being applied to a target.
+	message Message
+
+	// primary is the channel the message was sent to.
+	primary Channel
+
+	// target is the target the message was sent to.
+	target Target
+
+	// fallbacks are the targets not tried yet.
+	fallbacks []Target
+
+	// maxRetries is the MaxRetries of the failover.
+	maxRetries int
+
+	// sync reports whether the chain is driven by SendSync, which fails over by itself.
+	sync bool
+
+	// failures are the failed attempts of the message, recorded under watchMu for its dead letter.
+	failures []Failure
+
+	// deliveries are the keys of the deliveries of the chain tracked in inflight, under watchMu.
+	deliveries []watchKey
+}
+
+// watchKey identifies the delivery of a message to a target.
+type watchKey struct {
+	msgID      string
+	channel    Channel
+	deliveryID string
+}
+
+// watch is the delivery of a chain's message on a channel reporting its results.
+type watch struct {
+	chain *chain
+
+	// cancel cancels the delivery.
+	cancel context.CancelFunc
+}
+
+// newFailovers validates the failover chains and indexes them by primary channel.
+//
+// Parameters:
+//   - failovers: The failover chains
+//
+// Returns:
+//   - map[Channel]Failover: The failover chains by primary channel
+//   - error: An error if a chain is invalid
+func newFailovers(failovers []Failover) (map[Channel]Failover, error) {
+	byChannel := make(map[Channel]Failover, len(failovers))
+
+	for _, f := range failovers {
+		if f.Channel == "" || len(f.Fallbacks) == 0 {
+			return nil, fmt.Errorf("notify failover of channel %q has no fallbacks", f.Channel)
+		}
+
+		if _, ok := byChannel[f.Channel]; ok {
+			return nil, fmt.Errorf("notify failover of channel %s is defined twice", f.Channel)
+		}
+
+		seen := map[Channel]bool{f.Channel: true}
+		for _, t := range f.Fallbacks {
+			if t.Channel == "" || seen[t.Channel] {
+				return nil, fmt.Errorf("notify failover of channel %s has an empty or repeated fallback channel %q", f.Channel, t.Channel)
+			}
+
+			seen[t.Channel] = true
+		}
+
+		byChannel[f.Channel] = f
+	}
+
+	return byChannel, nil
+}
+
+// newChain creates the chain of a message sent to a target, with the fallbacks of its channel if it has any.
+//
+// Parameters:
+//   - ctx: The context of the message
+//   - target: The target of the message
+//   - message: The message to be sent
+//   - sync: Whether the chain is driven by SendSync
+//
+// Returns:
+//   - *chain: The chain of the message
+func (m *Manager) newChain(ctx context.Context, target Target, message Message, sync bool) *chain {
+	c := &chain{ctx: ctx, message: message, primary: target.Channel, target: target, sync: sync}
+	if f, ok := m.failovers[target.Channel]; ok {
+		c.fallbacks = f.Fallbacks
+		c.maxRetries = f.MaxRetries
+	}
+
+	return c
+}
+
+// submitTarget submits a message to a target, failing over to the fallbacks of its channel if it has any.
+//
+// Parameters:
+//   - ctx: The context of the message
+//   - target: The target of the message
+//   - message: The message to be sent
+func (m *Manager) submitTarget(ctx context.Context, target Target, message Message) {
+	c := m.newChain(ctx, target, message, false)
+
+	if !m.submitChain(c, target) {
+		m.goFailover(c)
+	}
+}
+
+// submitChain submits the message of a chain to one of its targets.
+// Deliveries on senders implementing ResultPublisher are watched, so that their failure triggers the failover.
+//
+// Parameters:
+//   - c: The chain of the message
+//   - target: The target of the message
+//
+// Returns:
+//   - bool: false if the message could not be submitted and the chain must fail over
+func (m *Manager) submitChain(c *chain, target Target) bool {
+	channel := target.Channel
+	message, key := m.deliveryOf(c, target)
+
+	sender, ok := m.sender(channel)
+	if !ok {
+		log.Printf("notify channel %s is not enabled or registered\n", channel)
+		m.publish(SendResult{
+			Channel:      channel,
+			MsgID:        message.ID,
+			DeliveryID:   message.DeliveryID,
+			State:        StateDropped,
+			Err:          fmt.Errorf("notify channel %s is not enabled or registered", channel),
+			FailoverFrom: c.failoverFrom(channel),
+		})
+
+		return false
+	}
+
+	// Publishers report their own dropped messages, which triggers the failover
+	if _, ok := sender.(ResultPublisher); ok {
+		ctx := c.ctx
+		followed := len(c.fallbacks) > 0 || channel != c.primary || m.deadLetters != nil
+		if followed {
+			ctx = m.watch(c, key)
+		}
+
+		// The refused message fails over once: here, or from the result published by the sender
+		if err := submitContext(ctx, sender, message); err != nil {
+			log.Println(err)
+			return followed && !m.unwatch(key)
+		}
+
+		return true
+	}
+
+	if err := submitContext(c.ctx, sender, message); err != nil {
+		log.Println(err)
+		m.publish(SendResult{
+			Channel:      channel,
+			MsgID:        message.ID,
+			DeliveryID:   message.DeliveryID,
+			State:        StateDropped,
+			Err:          err,
+			FailoverFrom: c.failoverFrom(channel),
+		})
+
+		return false
+	}
+
+	// The sender does not report its deliveries, so the message is considered delivered
+	m.untrack(c)
+
+	return true
+}
+
+// failover submits the message of a chain to its next fallbacks, until one of them accepts it.
+// If they all fail, the message is dead-lettered.
+//
+// Parameters:
+//   - c: The chain of the message
+func (m *Manager) failover(c *chain) {
+	for len(c.fallbacks) > 0 {
+		// The message is no longer wanted
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		if m.submitChain(c, m.nextFallback(c)) {
+			return
+		}
+	}
+
+	m.deadLetter(c)
+}
+
+// goFailover runs failover in a goroutine tracked by failoverWG.
+func (m *Manager) goFailover(c *chain) {
+	m.failoverWG.Add(1)
+	go func() {
+		defer m.failoverWG.Done()
+
+		m.failover(c)
+	}()
+}
+
+// sendChain sends the message of a chain created by newChain for SendSync and waits for the outcome,
+// failing over to the fallbacks of its channel until one of them delivers it.
+// If they all fail, the message is dead-lettered.
+//
+// Parameters:
+//   - c: The chain of the message
+//
+// Returns:
+//   - SendResult: The result of the last channel tried, with the primary channel in FailoverFrom if it is not
+//     the primary channel
+func (m *Manager) sendChain(c *chain) SendResult {
+	target := c.target
+	_, failover := m.failovers[c.primary]
+
+	for {
+		message, key := m.deliveryOf(c, target)
+
+		// Watch the deliveries reporting their retries, to cancel them once they retry for too long
+		deliveryCtx := c.ctx
+		if s, ok := m.sender(target.Channel); ok && failover {
+			if _, ok := s.(ResultPublisher); ok {
+				deliveryCtx = m.watch(c, key)
+			}
+		}
+
+		result := m.sendSync(deliveryCtx, target.Channel, message, c.failoverFrom(target.Channel))
+		if result.State != StateFailed {
+			m.untrack(c)
+			return result
+		}
+
+		if len(c.fallbacks) == 0 || c.ctx.Err() != nil {
+			m.deadLetter(c)
+			return result
+		}
+
+		target = m.nextFallback(c)
+	}
+}
+
+// nextFallback removes the next fallback of a chain and returns it.
+// The fallbacks are modified under watchMu, as handleResult reads them.
+func (m *Manager) nextFallback(c *chain) Target {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	target := c.fallbacks[0]
+	c.fallbacks = c.fallbacks[1:]
+
+	return target
+}
+
+// failoverFrom returns the primary channel of the chain if channel is one of its fallbacks.
+func (c *chain) failoverFrom(channel Channel) Channel {
+	if channel == c.primary {
+		return ""
+	}
+
+	return c.primary
+}
+
+// deliveryOf returns the message of a chain as delivered to a target, with a new DeliveryID,
+// and the key of the delivery. The delivery is tracked so that its failures are recorded.
+//
+// Parameters:
+//   - c: The chain of the message
+//   - target: The target of the delivery
+//
+// Returns:
+//   - Message: The message applied to the target
+//   - watchKey: The key of the delivery
+func (m *Manager) deliveryOf(c *chain, target Target) (Message, watchKey) {
+	message := target.apply(c.message)
+	message.DeliveryID = strconv.FormatUint(m.nextDeliveryID.Add(1), 10)
+	key := watchKey{msgID: message.ID, channel: target.Channel, deliveryID: message.DeliveryID}
+
+	m.track(c, key)
+
+	return message, key
+}
+
+// lookupDelivery returns the entry of the delivery a result reports on, and its key.
+// A result without a DeliveryID, from a sender that does not report it, matches the delivery
+// of its message on its channel if there is only one.
+//
+// Parameters:
+//   - entries: The entries by delivery
+//   - r: The result reported by a channel
+//
+// Returns:
+//   - watchKey: The key of the delivery
+//   - V: The entry of the delivery
+//   - bool: false if no delivery matches the result
+func lookupDelivery[V any](entries map[watchKey]V, r SendResult) (watchKey, V, bool) {
+	key := watchKey{msgID: r.MsgID, channel: r.Channel, deliveryID: r.DeliveryID}
+	if v, ok := entries[key]; ok || r.DeliveryID != "" {
+		return key, v, ok
+	}
+
+	var (
+		found   watchKey
+		entry   V
+		matches int
+	)
+	for k, v := range entries {
+		if k.msgID == r.MsgID && k.channel == r.Channel {
+			found, entry = k, v
+			matches++
+		}
+	}
+
+	if matches != 1 {
+		var zero V
+		return key, zero, false
+	}
+
+	return found, entry, true
+}
+
+// unwatch stops watching a delivery and cancels it.
+//
+// Parameters:
+//   - key: The key of the delivery
+//
+// Returns:
+//   - bool: false if the delivery was not watched anymore, its outcome being already handled
+func (m *Manager) unwatch(key watchKey) bool {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	w, ok := m.watches[key]
+	if ok {
+		delete(m.watches, key)
+		w.cancel()
+	}
+
+	return ok
+}
+
+// watch registers the delivery of the message of a chain, whose results are handled by handleResult.
+//
+// Parameters:
+//   - c: The chain of the message
+//   - key: The key of the delivery, returned by deliveryOf
+//
+// Returns:
+//   - context.Context: The context of the delivery, cancelled when the chain fails over
+func (m *Manager) watch(c *chain, key watchKey) context.Context {
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	m.watches[key] = watch{chain: c, cancel: cancel}
+
+	return ctx
+}
+
+// handleResult records the failover of a result reported by a channel, and reports whether the delivery failed
+// or retried for too long.
+//
+// Parameters:
+//   - r: The result reported by the channel, whose FailoverFrom is set if it belongs to a fallback
+//
+// Returns:
+//   - *chain: The chain to fail over with goFailover, nil if there is none or SendSync fails it over
+func (m *Manager) handleResult(r *SendResult) *chain {
+	m.watchMu.Lock()
+	key, w, ok := lookupDelivery(m.watches, *r)
+	if !ok {
+		m.watchMu.Unlock()
+		return nil
+	}
+
+	c := w.chain
+	r.FailoverFrom = c.failoverFrom(r.Channel)
+
+	switch r.State {
+	case StateFailed, StateDropped:
+	case StateRetrying:
+		if c.maxRetries == 0 || r.Attempts < c.maxRetries || len(c.fallbacks) == 0 {
+			m.watchMu.Unlock()
+			return nil
+		}
+	case StateSent:
+		delete(m.watches, key)
+		m.watchMu.Unlock()
+		w.cancel()
+
+		return nil
+	default:
+		m.watchMu.Unlock()
+		return nil
+	}
+
+	delete(m.watches, key)
+	m.watchMu.Unlock()
+	w.cancel()
+
+	// SendSync fails over when the cancelled delivery returns
+	if c.sync {
+		return nil
+	}
+
+	return c
+}
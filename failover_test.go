@@ -0,0 +1,344 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	primaryChan  Channel = "primary"
+	backupChan   Channel = "backup"
+	lastKeptChan Channel = "last"
+)
+
+// fakeFlakySender reports the failure of every message in the background, after being rate limited retries
+// times. If untilCancelled is set, it keeps retrying until the context of the message is done.
+// If deliveryIDs is set, the results carry the DeliveryID of the messages.
+type fakeFlakySender struct {
+	fakeSender
+	retries        int
+	untilCancelled bool
+	deliveryIDs    bool
+	handler        func(SendResult)
+	wg             sync.WaitGroup
+}
+
+func (s *fakeFlakySender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+func (s *fakeFlakySender) SubmitContext(ctx context.Context, message Message) error {
+	if err := s.fakeSender.Submit(message); err != nil {
+		return err
+	}
+
+	var deliveryID string
+	if s.deliveryIDs {
+		deliveryID = message.DeliveryID
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for i := 1; i <= s.retries; i++ {
+			s.handler(SendResult{MsgID: message.ID, DeliveryID: deliveryID, State: StateRetrying, Attempts: i})
+		}
+
+		err := errors.New("gateway down")
+		if s.untilCancelled {
+			<-ctx.Done()
+			err = ctx.Err()
+		}
+
+		s.handler(SendResult{MsgID: message.ID, DeliveryID: deliveryID, State: StateFailed, Err: err, Attempts: s.retries + 1})
+	}()
+
+	return nil
+}
+
+func (s *fakeFlakySender) Subscribe(handler func(SendResult)) func() {
+	s.handler = handler
+
+	return func() {}
+}
+
+func (s *fakeFlakySender) Close() {
+	s.wg.Wait()
+	s.fakeSender.Close()
+}
+
+// waitFor waits until cond is true, failing the test after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met after 1s")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newFailoverManager creates a Manager with a failover chain from primary to backup, then last.
+func newFailoverManager(t *testing.T, maxRetries int, senders map[Channel]Sender) *Manager {
+	t.Helper()
+
+	m, err := New(OptFailover(Failover{
+		Channel:    primaryChan,
+		Fallbacks:  []Target{{Channel: backupChan, SendTo: "backup-oncall"}, {Channel: lastKeptChan, SendTo: "last-oncall"}},
+		MaxRetries: maxRetries,
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for channel, s := range senders {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	return m
+}
+
+func TestNewFailovers(t *testing.T) {
+	tests := []struct {
+		name      string
+		failovers []Failover
+		wantErr   bool
+	}{
+		{
+			name:      "Valid failovers",
+			failovers: []Failover{{Channel: LarkChan, Fallbacks: []Target{{Channel: TelegramChan}, {Channel: EmailChan}}}},
+			wantErr:   false,
+		},
+		{
+			name:      "Invalid failovers - no fallbacks",
+			failovers: []Failover{{Channel: LarkChan}},
+			wantErr:   true,
+		},
+		{
+			name:      "Invalid failovers - fallback to the primary channel",
+			failovers: []Failover{{Channel: LarkChan, Fallbacks: []Target{{Channel: LarkChan, SendChannelName: "bot"}}}},
+			wantErr:   true,
+		},
+		{
+			name: "Invalid failovers - channel defined twice",
+			failovers: []Failover{
+				{Channel: LarkChan, Fallbacks: []Target{{Channel: EmailChan}}},
+				{Channel: LarkChan, Fallbacks: []Target{{Channel: TelegramChan}}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newFailovers(tt.failovers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newFailovers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_Failover(t *testing.T) {
+	primary := &fakeFlakySender{}
+	backup := &fakeSyncSender{fakeSender: fakeSender{err: errors.New("backup is down")}}
+	last := &fakeSender{}
+	m := newFailoverManager(t, 0, map[Channel]Sender{primaryChan: primary, backupChan: backup, lastKeptChan: last})
+
+	var mu sync.Mutex
+	var results []SendResult
+	m.Subscribe(func(r SendResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		results = append(results, r)
+	})
+
+	msgID, err := m.Error("oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return len(last.received()) == 1 })
+	m.Close()
+
+	got := last.received()[0]
+	if got.ID != msgID || got.SendTo != "last-oncall" || got.Title != "Disk full" {
+		t.Errorf("last received %+v, want message %s for last-oncall", got, msgID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []struct {
+		channel, from Channel
+		state         string
+	}{
+		{primaryChan, "", StateFailed},
+		{backupChan, primaryChan, StateDropped},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("published %+v, want %d results", results, len(want))
+	}
+
+	for i, w := range want {
+		r := results[i]
+		if r.Channel != w.channel || r.FailoverFrom != w.from || r.State != w.state || r.MsgID != msgID {
+			t.Errorf("results[%d] = %+v, want %+v", i, r, w)
+		}
+	}
+}
+
+func TestManager_Failover_SlowSubscriber(t *testing.T) {
+	primary := &fakeFlakySender{retries: 2 * resultQueueSize}
+	backup := &fakeSyncSender{}
+	m := newFailoverManager(t, 0, map[Channel]Sender{primaryChan: primary, backupChan: backup})
+
+	// The subscriber is stuck while the primary reports more results than the queue holds
+	release := make(chan struct{})
+	m.Subscribe(func(SendResult) { <-release })
+
+	if _, err := m.Error("oncall", "Disk full", "95%", primaryChan); err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	// The failure of the primary is handled whatever the subscriber
+	waitFor(t, func() bool { return len(backup.received()) == 1 })
+
+	close(release)
+	m.Close()
+}
+
+func TestManager_Close_Failover(t *testing.T) {
+	primary := &fakeFlakySender{}
+	backup := &fakeSender{}
+	m := newFailoverManager(t, 0, map[Channel]Sender{primaryChan: primary, backupChan: backup})
+
+	msgID, err := m.Error("oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	// The failure reported while the primary is closed still fails over to the open backup
+	m.Close()
+
+	if got := backup.received(); len(got) != 1 || got[0].ID != msgID {
+		t.Errorf("backup received %+v, want message %s", got, msgID)
+	}
+}
+
+func TestManager_Failover_SharedChannel(t *testing.T) {
+	primary := &fakeFlakySender{deliveryIDs: true}
+	backup := &fakeSyncSender{}
+
+	m, err := New(
+		OptRules(Rule{Targets: []Target{{Channel: primaryChan, SendTo: "alice"}, {Channel: primaryChan, SendTo: "bob"}}}),
+		OptFailover(Failover{Channel: primaryChan, Fallbacks: []Target{{Channel: backupChan}}}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for channel, s := range map[Channel]Sender{primaryChan: primary, backupChan: backup} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	msgID, err := m.Dispatch(context.Background(), Message{Level: ErrorLevel, Title: "Disk full"})
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	// Both deliveries on the primary fail over on their own
+	waitFor(t, func() bool { return len(backup.received()) == 2 })
+	m.Close()
+
+	recipients := map[string]bool{}
+	for _, message := range primary.received() {
+		recipients[message.SendTo] = true
+	}
+	if !recipients["alice"] || !recipients["bob"] {
+		t.Errorf("primary received %+v, want the messages of alice and bob", primary.received())
+	}
+
+	for _, message := range backup.received() {
+		if message.ID != msgID {
+			t.Errorf("backup received %+v, want message %s", message, msgID)
+		}
+	}
+}
+
+func TestManager_Failover_SyncSender(t *testing.T) {
+	primary := &fakeSyncSender{sendErr: errors.New("primary is down")}
+	backup := &fakeSender{}
+	m := newFailoverManager(t, 0, map[Channel]Sender{primaryChan: primary, backupChan: backup})
+
+	msgID, err := m.Error("oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+	m.Close()
+
+	// The message is queued rather than sent synchronously, and the sender does not report its failure
+	if got := primary.received(); len(got) != 1 || got[0].ID != msgID {
+		t.Errorf("primary queued %+v, want message %s", got, msgID)
+	}
+	if got := backup.received(); len(got) != 0 {
+		t.Errorf("backup received %+v, want no failover", got)
+	}
+}
+
+func TestManager_Failover_RateLimited(t *testing.T) {
+	primary := &fakeFlakySender{retries: 3, untilCancelled: true}
+	backup := &fakeSyncSender{}
+	m := newFailoverManager(t, 2, map[Channel]Sender{primaryChan: primary, backupChan: backup})
+
+	msgID, err := m.Warn("oncall", "Slow", "p99 is 3s", primaryChan)
+	if err != nil {
+		t.Fatalf("Warn() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return len(backup.received()) == 1 })
+
+	// Close waits for the primary to report its cancelled delivery
+	m.Close()
+
+	if got := backup.received()[0]; got.ID != msgID || got.SendTo != "backup-oncall" {
+		t.Errorf("backup received %+v, want message %s for backup-oncall", got, msgID)
+	}
+}
+
+func TestManager_SendSync_Failover(t *testing.T) {
+	primary := &fakeSyncSender{fakeSender: fakeSender{err: errors.New("primary is down")}}
+	backup := &fakeSyncSender{}
+	m := newFailoverManager(t, 0, map[Channel]Sender{primaryChan: primary, backupChan: backup})
+	defer m.Close()
+
+	results, err := m.SendSync(context.Background(), ErrorLevel, "oncall", "Disk full", "95%", primaryChan)
+	if err != nil {
+		t.Fatalf("SendSync() error = %v", err)
+	}
+
+	r := results[0]
+	if r.Channel != backupChan || r.FailoverFrom != primaryChan || r.State != StateSent {
+		t.Errorf("SendSync() = %+v, want sent by backup after failing over from primary", r)
+	}
+
+	if got := backup.received(); len(got) != 1 || got[0].ID != r.MsgID || got[0].SendTo != "backup-oncall" {
+		t.Errorf("backup received %+v, want message %s for backup-oncall", got, r.MsgID)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package queue provides the queue the notification channels deliver their submitted messages through.
+// The messages are persisted to an outbox, buffered in a channel and delivered by a pool of workers,
+// which retry the failed deliveries according to a retry policy without blocking while waiting.
+// The channels only provide the function sending a message.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when a message is submitted to a closed queue.
+var ErrClosed = errors.New("notify is closed")
+
+// States of a Result.
+const (
+	// StateSent indicates that the message was sent successfully.
+	StateSent = "sent"
+
+	// StateFailed indicates that the message was given up on after a failed attempt.
+	StateFailed = "failed"
+
+	// StateRetrying indicates that the attempt failed and the message will be sent again.
+	StateRetrying = "retrying"
+
+	// StateDropped indicates that the message was discarded before it could be sent.
+	StateDropped = "dropped"
+)
+
+// Config represents the configuration of a queue.
+type Config[M any] struct {
+	// Name is the name of the channel, e.g. "ding talk", used in errors and logs.
+	Name string
+
+	// Size is the buffer size of the queue.
+	Size int
+
+	// PoolSize is the number of goroutines delivering the messages.
+	PoolSize int
+
+	// Retry is the policy applied to failed deliveries.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+
+	// Send makes an attempt to send a message. ctx is the context the message was submitted with.
+	Send func(ctx context.Context, m M) (providerMsgID string, err error)
+
+	// Retried returns the message to be sent again after a failed attempt, e.g. restricted to the recipients
+	// that did not receive it, or false if it is not worth retrying. If nil, the message is retried as is.
+	Retried func(m M, err error) (M, bool)
+
+	// Report is called with the result of every attempt, and with the messages dropped before being sent.
+	// It is optional.
+	Report func(m M, result Result)
+}
+
+// Result represents the result of an attempt to deliver a queued message.
+type Result struct {
+	// State is the state of the message: sent, failed, retrying or dropped.
+	State string
+
+	// ProviderMsgID is the message ID returned by Send.
+	ProviderMsgID string
+
+	// Err is the error of the attempt, or the reason the message was dropped.
+	Err error
+
+	// Attempts is the number of times the message was sent, 0 if it was dropped before being sent.
+	Attempts int
+}
+
+// Queue delivers the messages submitted to a channel.
+type Queue[M any] struct {
+	// name is the name of the channel, used in errors and logs.
+	name string
+
+	// items is a channel for buffering the submitted messages before processing.
+	items chan item[M]
+
+	// closed reports whether Close was called, after which items is closed.
+	closed bool
+
+	// closeMu protects closed, and is held while submitting to items so that it is not closed meanwhile.
+	closeMu sync.RWMutex
+
+	// pool is a goroutine pool used for concurrent message processing.
+	pool *ants.PoolWithFunc
+
+	// wg is used to wait for the processor, the deliveries and the scheduled retries on Close.
+	wg sync.WaitGroup
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// journal persists the queued messages, nil if disabled.
+	journal *outbox.Journal[M]
+
+	// send, retried and report are the functions of the Config.
+	send    func(ctx context.Context, m M) (string, error)
+	retried func(m M, err error) (M, bool)
+	report  func(m M, result Result)
+}
+
+// item is a queued message.
+type item[M any] struct {
+	// ctx is the context the message was submitted with.
+	ctx context.Context
+
+	// key is the key of the message in the outbox.
+	key string
+
+	// message is the message to be sent.
+	message M
+
+	// attempts is the number of times the message has been sent.
+	attempts int
+}
+
+// New creates a queue and opens its outbox. The queue delivers nothing until Start is called.
+//
+// Parameters:
+//   - config: The configuration of the queue.
+//
+// Returns:
+//   - *Queue[M]: The queue.
+//   - error: An error if the goroutine pool or the outbox cannot be created.
+func New[M any](config Config[M]) (*Queue[M], error) {
+	q := &Queue[M]{
+		name:    config.Name,
+		items:   make(chan item[M], config.Size),
+		retry:   config.Retry,
+		send:    config.Send,
+		retried: config.Retried,
+		report:  config.Report,
+	}
+
+	pool, err := ants.NewPoolWithFunc(config.PoolSize, func(i interface{}) {
+		q.deliver(i.(item[M]))
+		q.wg.Done()
+	}, ants.WithPreAlloc(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s goroutine pool: %v", config.Name, err)
+	}
+
+	q.pool = pool
+
+	q.journal, err = outbox.OpenJournal[M](config.Name, config.Outbox)
+	if err != nil {
+		pool.Release()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Start starts the processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously reads messages
+// from the queue and submits them to the goroutine pool.
+func (q *Queue[M]) Start() {
+	// The processor itself is tracked by wg so that Close waits for the queue to drain
+	q.wg.Add(1)
+
+	go func() {
+		defer q.wg.Done()
+
+		for _, e := range q.journal.Recover() {
+			q.invoke(item[M]{ctx: context.Background(), key: e.Key, message: e.Message})
+		}
+
+		for i := range q.items {
+			q.invoke(i)
+		}
+	}()
+}
+
+// Submit writes a message to the outbox and queues it.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered, and a message
+//     whose context is done before it is sent is dropped.
+//   - key: The key of the message in the outbox, built with outbox.Key from its ID and its target.
+//   - m: The message.
+//
+// Returns:
+//   - error: ErrClosed after Close, outbox.ErrDuplicate if the message is already queued for the same target,
+//     an error if the message cannot be written to the outbox, or the error of ctx if it is done
+//     before the message is queued.
+func (q *Queue[M]) Submit(ctx context.Context, key string, m M) error {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+
+	if q.closed {
+		q.reportOf(m, Result{State: StateDropped, Err: ErrClosed})
+		return ErrClosed
+	}
+
+	// A message already in the outbox for the same target is not queued twice
+	if err := q.journal.Append(key, m); err != nil {
+		q.reportOf(m, Result{State: StateDropped, Err: err})
+		return err
+	}
+
+	// Queue the message, unless ctx is done while the queue is full
+	select {
+	case q.items <- item[M]{ctx: ctx, key: key, message: m}:
+		return nil
+	case <-ctx.Done():
+		q.journal.Done(key)
+		q.reportOf(m, Result{State: StateDropped, Err: ctx.Err()})
+
+		return ctx.Err()
+	}
+}
+
+// invoke submits a message to the goroutine pool.
+func (q *Queue[M]) invoke(i item[M]) {
+	q.wg.Add(1)
+	err := q.pool.Invoke(i)
+	if err != nil {
+		q.wg.Done()
+		log.Printf("failed to submit %s task to pool: %v\n", q.name, err)
+		q.reportOf(i.message, Result{State: StateDropped, Err: err, Attempts: i.attempts})
+	}
+}
+
+// deliver makes an attempt to send a queued message, and schedules its retry if the attempt failed
+// and the retry policy allows another one. Otherwise the message is done, and is removed from the outbox.
+// A message whose context is done is dropped without being sent.
+//
+// Parameters:
+//   - i: The queued message.
+func (q *Queue[M]) deliver(i item[M]) {
+	if err := i.ctx.Err(); err != nil {
+		q.reportOf(i.message, Result{State: StateDropped, Err: err, Attempts: i.attempts})
+		q.journal.Done(i.key)
+
+		return
+	}
+
+	i.attempts++
+
+	result := Result{Attempts: i.attempts}
+	result.ProviderMsgID, result.Err = q.send(i.ctx, i.message)
+	if result.Err == nil {
+		result.State = StateSent
+		q.reportOf(i.message, result)
+		q.journal.Done(i.key)
+
+		return
+	}
+
+	// A cancelled delivery is not retried
+	if delay, ok := q.retry.Delay(i.attempts, result.Err); ok && i.ctx.Err() == nil {
+		if retried, ok := q.retriedOf(i.message, result.Err); ok {
+			log.Printf("failed to send %s message, retrying in %s: %v\n", q.name, delay, result.Err)
+			result.State = StateRetrying
+			q.reportOf(i.message, result)
+
+			i.message = retried
+			q.retryLater(i, delay)
+
+			return
+		}
+	}
+
+	log.Printf("failed to send %s message: %v\n", q.name, result.Err)
+	result.State = StateFailed
+	q.reportOf(i.message, result)
+	q.journal.Done(i.key)
+}
+
+// retryLater submits a message to the goroutine pool again once the retry delay has elapsed,
+// so that no worker is blocked while waiting. Close waits for the scheduled retries.
+//
+// Parameters:
+//   - i: The message to be retried.
+//   - delay: The wait before the retry.
+func (q *Queue[M]) retryLater(i item[M], delay time.Duration) {
+	q.wg.Add(1)
+	time.AfterFunc(delay, func() {
+		if err := q.pool.Invoke(i); err != nil {
+			q.wg.Done()
+			log.Printf("failed to submit %s retry to pool: %v\n", q.name, err)
+			q.reportOf(i.message, Result{State: StateDropped, Err: err, Attempts: i.attempts})
+		}
+	})
+}
+
+// retriedOf returns the message to be sent again after a failed attempt.
+func (q *Queue[M]) retriedOf(m M, err error) (M, bool) {
+	if q.retried == nil {
+		return m, true
+	}
+
+	return q.retried(m, err)
+}
+
+// reportOf passes a result to the Report function, if any.
+func (q *Queue[M]) reportOf(m M, result Result) {
+	if q.report != nil {
+		q.report(m, result)
+	}
+}
+
+// Close stops accepting messages, waits for the queued messages and the scheduled retries to be processed,
+// and releases the goroutine pool. The outbox keeps the messages that could not be delivered for the next start.
+func (q *Queue[M]) Close() {
+	// Close the queue to stop accepting new messages
+	q.closeMu.Lock()
+	q.closed = true
+	close(q.items)
+	q.closeMu.Unlock()
+
+	// Wait for all messages to be processed
+	q.wg.Wait()
+
+	// Release the goroutine pool
+	q.pool.Release()
+
+	// Close the outbox, keeping the messages that could not be delivered for the next start
+	q.journal.Close()
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testMessage struct {
+	ID     string
+	SendTo string
+}
+
+// recorder records the messages sent and the results reported by a queue.
+type recorder struct {
+	mu      sync.Mutex
+	sent    []testMessage
+	results []Result
+}
+
+func (r *recorder) record(m testMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, m)
+}
+
+func (r *recorder) report(_ testMessage, result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result.Err = nil
+	r.results = append(r.results, result)
+}
+
+// newTestQueue creates a queue of a single worker sending the messages with send, and recording them.
+func newTestQueue(t *testing.T, config Config[testMessage], send func(m testMessage) error) (*Queue[testMessage], *recorder) {
+	t.Helper()
+
+	r := &recorder{}
+	config.Name = "test"
+	config.PoolSize = 1
+	config.Retry = retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	config.Send = func(ctx context.Context, m testMessage) (string, error) {
+		r.record(m)
+		if send == nil {
+			return "provider-" + m.ID, nil
+		}
+
+		return "", send(m)
+	}
+	config.Report = r.report
+
+	if err := config.Outbox.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	q, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return q, r
+}
+
+func TestQueue(t *testing.T) {
+	q, r := newTestQueue(t, Config[testMessage]{Size: 2}, nil)
+	q.Start()
+
+	for _, m := range []testMessage{{ID: "msg-1"}, {ID: "msg-2"}} {
+		if err := q.Submit(context.Background(), m.ID, m); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	// Close waits for the queued messages to be delivered
+	q.Close()
+
+	if len(r.sent) != 2 {
+		t.Errorf("sent %+v, want both messages", r.sent)
+	}
+
+	want := Result{State: StateSent, Attempts: 1}
+	for _, result := range r.results {
+		if !strings.HasPrefix(result.ProviderMsgID, "provider-") || result.State != want.State || result.Attempts != want.Attempts {
+			t.Errorf("reported %+v, want sent after 1 attempt", result)
+		}
+	}
+
+	// A message submitted after Close is refused
+	if err := q.Submit(context.Background(), "msg-3", testMessage{ID: "msg-3"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit() after Close error = %v, want ErrClosed", err)
+	}
+	if got := r.results[len(r.results)-1]; got.State != StateDropped {
+		t.Errorf("reported %+v after Close, want dropped", got)
+	}
+}
+
+func TestQueue_Retry(t *testing.T) {
+	var busy atomic.Bool
+	var calls atomic.Int32
+	q, r := newTestQueue(t, Config[testMessage]{
+		Size: 2,
+		// Only the failed recipient is retried
+		Retried: func(m testMessage, err error) (testMessage, bool) {
+			m.SendTo = "bob"
+			return m, true
+		},
+	}, func(m testMessage) error {
+		// The only worker must not be held while the message waits for its retry
+		if !busy.CompareAndSwap(false, true) {
+			t.Error("worker blocked by a retry")
+		}
+		defer busy.Store(false)
+
+		if m.ID == "msg-1" && calls.Add(1) == 1 {
+			return retry.Transient(errors.New("bob is busy"))
+		}
+
+		return nil
+	})
+	q.Start()
+
+	for _, m := range []testMessage{{ID: "msg-1", SendTo: "alice,bob"}, {ID: "msg-2", SendTo: "carol"}} {
+		if err := q.Submit(context.Background(), m.ID, m); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	// Close waits for the scheduled retry
+	q.Close()
+
+	var retried []testMessage
+	for _, m := range r.sent {
+		if m.ID == "msg-1" {
+			retried = append(retried, m)
+		}
+	}
+	if want := []testMessage{{ID: "msg-1", SendTo: "alice,bob"}, {ID: "msg-1", SendTo: "bob"}}; !reflect.DeepEqual(retried, want) {
+		t.Errorf("sent %+v, want %+v", retried, want)
+	}
+
+	var states []string
+	for _, result := range r.results {
+		states = append(states, result.State)
+	}
+	if len(states) != 3 || !strings.Contains(strings.Join(states, ","), StateRetrying) {
+		t.Errorf("reported %v, want a retry and two sent messages", states)
+	}
+}
+
+func TestQueue_Retry_GivenUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		retried   func(m testMessage, err error) (testMessage, bool)
+		err       error
+		wantCalls int
+	}{
+		{name: "Retryable error", err: retry.Transient(errors.New("busy")), wantCalls: 3},
+		{name: "Permanent error", err: errors.New("invalid"), wantCalls: 1},
+		{
+			name:      "No recipient worth retrying",
+			retried:   func(m testMessage, err error) (testMessage, bool) { return m, false },
+			err:       retry.Transient(errors.New("busy")),
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, r := newTestQueue(t, Config[testMessage]{Size: 1, Retried: tt.retried}, func(testMessage) error { return tt.err })
+			q.Start()
+
+			if err := q.Submit(context.Background(), "msg-1", testMessage{ID: "msg-1"}); err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+			q.Close()
+
+			if len(r.sent) != tt.wantCalls {
+				t.Errorf("sent %d times, want %d", len(r.sent), tt.wantCalls)
+			}
+			if got := r.results[len(r.results)-1]; got.State != StateFailed || got.Attempts != tt.wantCalls {
+				t.Errorf("reported %+v, want failed after %d attempts", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestQueue_Context(t *testing.T) {
+	q, r := newTestQueue(t, Config[testMessage]{Size: 1}, nil)
+
+	// A message whose context is done before it is sent is dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := q.Submit(ctx, "msg-1", testMessage{ID: "msg-1"}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	cancel()
+
+	// The queue is full, so the message cannot be queued before its context is done
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := q.Submit(ctx, "msg-2", testMessage{ID: "msg-2"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Submit() to a full queue error = %v, want context.DeadlineExceeded", err)
+	}
+
+	q.Start()
+	q.Close()
+
+	if len(r.sent) != 0 {
+		t.Errorf("sent %+v, want none", r.sent)
+	}
+
+	want := []Result{{State: StateDropped}, {State: StateDropped}}
+	if !reflect.DeepEqual(r.results, want) {
+		t.Errorf("reported %+v, want %+v", r.results, want)
+	}
+}
+
+func TestQueue_Outbox(t *testing.T) {
+	config := Config[testMessage]{Size: 2, Outbox: outbox.Config{Dir: t.TempDir()}}
+
+	// The first run queues the message to two recipients, but stops before delivering it
+	q, _ := newTestQueue(t, config, nil)
+	for _, to := range []string{"alice", "bob"} {
+		if err := q.Submit(context.Background(), outbox.Key("msg-1", to), testMessage{ID: "msg-1", SendTo: to}); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	if err := q.Submit(context.Background(), outbox.Key("msg-1", "bob"), testMessage{ID: "msg-1", SendTo: "bob"}); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("Submit() again error = %v, want outbox.ErrDuplicate", err)
+	}
+	q.Close()
+
+	// The next runs deliver it once to each recipient
+	var sent []testMessage
+	for range 2 {
+		q, r := newTestQueue(t, config, nil)
+		q.Start()
+		q.Close()
+
+		sent = append(sent, r.sent...)
+	}
+
+	if want := []testMessage{{ID: "msg-1", SendTo: "alice"}, {ID: "msg-1", SendTo: "bob"}}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %+v after restarts, want %+v", sent, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import "sync"
+
+// Results passes the results published by a channel to its subscribers, in order, from a single goroutine.
+//
+// The results waiting for the subscribers are buffered up to the size given to NewResults. Once the buffer is full,
+// Publish blocks until the subscribers catch up, so that a slow subscriber slows down the deliveries instead of
+// letting the results pile up in memory. No result is discarded, so that the subscribers such as the
+// notify.Manager, whose failover depends on them, do not miss any. A subscriber must therefore not wait
+// for the deliveries of the channel publishing the results.
+type Results[R any] struct {
+	// pending buffers the results published and not passed to the subscribers yet.
+	pending chan R
+
+	// closed reports whether Close was called, after which pending is closed.
+	closed bool
+
+	// closeMu protects closed, and is held while publishing to pending so that it is not closed meanwhile.
+	closeMu sync.RWMutex
+
+	// subscribers is a map of subscription IDs to the handlers of the results.
+	subscribers map[int]func(R)
+
+	// nextSubscriberID is the ID of the next subscription.
+	nextSubscriberID int
+
+	// subMu protects subscribers and nextSubscriberID.
+	subMu sync.RWMutex
+
+	// wg is used to wait for the dispatcher to pass on the remaining results on Close.
+	wg sync.WaitGroup
+}
+
+// NewResults creates a Results and starts the goroutine passing the results to the subscribers.
+//
+// Parameters:
+//   - size: The number of results buffered before Publish blocks.
+//
+// Returns:
+//   - *Results[R]: The Results, to be closed with Close.
+func NewResults[R any](size int) *Results[R] {
+	r := &Results[R]{
+		pending:     make(chan R, size),
+		subscribers: make(map[int]func(R)),
+	}
+
+	r.wg.Add(1)
+	go r.dispatch()
+
+	return r
+}
+
+// Subscribe registers a handler called with every published result.
+//
+// Parameters:
+//   - handler: The function called with each result.
+//
+// Returns:
+//   - func(): A function removing the handler.
+func (r *Results[R]) Subscribe(handler func(R)) func() {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	id := r.nextSubscriberID
+	r.nextSubscriberID++
+	r.subscribers[id] = handler
+
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+
+		delete(r.subscribers, id)
+	}
+}
+
+// Publish queues a result for the subscribers. It blocks while the buffer is full.
+// The results published without subscribers, or after Close, are discarded.
+//
+// Parameters:
+//   - result: The result to be published.
+func (r *Results[R]) Publish(result R) {
+	r.subMu.RLock()
+	subscribed := len(r.subscribers) > 0
+	r.subMu.RUnlock()
+
+	if !subscribed {
+		return
+	}
+
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+
+	if r.closed {
+		return
+	}
+
+	r.pending <- result
+}
+
+// Close stops accepting results, and waits for the remaining ones to be passed to the subscribers.
+func (r *Results[R]) Close() {
+	r.closeMu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.pending)
+	}
+	r.closeMu.Unlock()
+
+	r.wg.Wait()
+}
+
+// dispatch passes every published result to the subscribers, in order, until Close is called.
+func (r *Results[R]) dispatch() {
+	defer r.wg.Done()
+
+	for result := range r.pending {
+		r.subMu.RLock()
+		handlers := make([]func(R), 0, len(r.subscribers))
+		for _, handler := range r.subscribers {
+			handlers = append(handlers, handler)
+		}
+		r.subMu.RUnlock()
+
+		for _, handler := range handlers {
+			handler(result)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResults(t *testing.T) {
+	r := NewResults[int](2)
+
+	var got []int
+	unsubscribe := r.Subscribe(func(result int) { got = append(got, result) })
+
+	r.Publish(1)
+	r.Publish(2)
+	r.Close()
+	unsubscribe()
+
+	// Results published after Close are discarded
+	r.Publish(3)
+
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("handler got %v, want [1 2]", got)
+	}
+}
+
+func TestResults_Unsubscribe(t *testing.T) {
+	r := NewResults[int](2)
+
+	called := false
+	unsubscribe := r.Subscribe(func(int) { called = true })
+	unsubscribe()
+
+	r.Publish(1)
+	r.Close()
+
+	if called {
+		t.Error("handler called after unsubscribe")
+	}
+}
+
+func TestResults_SlowSubscriber(t *testing.T) {
+	const size = 2
+	r := NewResults[int](size)
+
+	// The handler is blocked while far more results than the buffer are published
+	release := make(chan struct{})
+	var got []int
+	r.Subscribe(func(result int) {
+		<-release
+		got = append(got, result)
+	})
+
+	var published atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			r.Publish(i)
+			published.Add(1)
+		}
+	}()
+
+	// Publish blocks once the buffer is full, besides the result held by the handler
+	time.Sleep(50 * time.Millisecond)
+	if n := published.Load(); n > size+1 {
+		t.Errorf("published %d results to a blocked handler, want at most %d", n, size+1)
+	}
+
+	// No result is discarded once the handler catches up
+	close(release)
+	<-done
+	r.Close()
+
+	if len(got) != 1000 || got[0] != 0 || got[999] != 999 {
+		t.Errorf("handler got %d results, want the 1000 results in order", len(got))
+	}
+}
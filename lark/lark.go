@@ -7,17 +7,19 @@
 package lark
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
-	"github.com/panjf2000/ants/v2"
 	"github.com/sk-pkg/notify/cache"
+	"github.com/sk-pkg/notify/internal/queue"
 	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
 	"github.com/sk-pkg/notify/util"
 	"log"
 	"runtime"
-	"sync"
 )
 
 // Constants used throughout the package
@@ -39,6 +41,9 @@ const (
 	tokenCacheKey = "lark:token:%s"
 )
 
+// ErrClosed is returned when a message is submitted after Close.
+var ErrClosed = queue.ErrClosed
+
 // Config represents the configuration for the Lark notifier.
 type Config struct {
 	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
@@ -56,6 +61,11 @@ type Config struct {
 	// If set to 0, it defaults to 10 * GOMAXPROCS.
 	PoolSize int
 
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
 	// BotWebhooks is a map of bot names to their corresponding webhook URLs.
 	// Use this to configure message sending via bot webhooks.
 	// The key will be used as the send channel name.
@@ -65,6 +75,15 @@ type Config struct {
 	// Use this to configure message sending via Lark Apps.
 	// The key will be used as the send channel name.
 	Larks map[string]Lark
+
+	// Retry is the policy applied to failed deliveries: transport errors, 408, 429 and 5xx statuses,
+	// and the response codes meaning that Lark is busy. A rate limited message waits at least until
+	// the limit resets. The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
 }
 
 // Lark represents the configuration for a Lark App.
@@ -149,6 +168,19 @@ type Notify interface {
 	// 	- err: An error that occurred while submitting the message.
 	SubmitMessage(message Message) (msgID string, err error)
 
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped,
+	// and a cancellation during the delivery aborts the requests and rate limit waits.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
 	// Token retrieves the access token for a specific Lark App.
 	//
 	// Parameters:
@@ -159,6 +191,36 @@ type Notify interface {
 	// 	- err: An error that occurred while retrieving the token.
 	Token(appName string) (token string, err error)
 
+	// Send sends a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- SendResult: The result of the delivery, including the message ID assigned by Lark.
+	Send(message Message) SendResult
+
+	// SendContext is like Send, but ctx bounds the token fetch, the requests and the rate limit waits.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- SendResult: The result of the delivery, including the message ID assigned by Lark.
+	SendContext(ctx context.Context, message Message) SendResult
+
+	// Subscribe registers a handler called with the result of every delivery, including the
+	// retries of rate limited messages and the messages dropped before delivery.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
 	// Close stops the notifier, ensuring all pending messages are processed
 	// before shutting down. It waits for all goroutines to finish and releases any resources.
 	// After calling Close, the notifier should not be used anymore.
@@ -170,18 +232,52 @@ type SendResult struct {
 	// MsgID is a unique identifier for the submitted message.
 	MsgID string
 
+	// DeliveryID is the DeliveryID of the message.
+	DeliveryID string
+
+	// Channel is the name of the bot webhook or Lark App the message was sent through.
+	Channel string
+
+	// ProviderMsgID is the message ID assigned by Lark.
+	// It is only available for Lark App messages, since bot webhooks do not return one.
+	ProviderMsgID string
+
 	// State is the state of the message.
 	// Possible values:
-	// 	- success: The message was sent successfully.
+	// 	- sent: The message was sent successfully.
 	// 	- failed: An error occurred while sending the message.
 	// 	- pending: The message is still being processed.
+	// 	- retrying: The message was rate limited and will be sent again.
+	// 	- dropped: The message was discarded before it could be sent.
 	State string
 
 	// Err is an error that occurred while sending the message.
 	// If State is "failed", this field contains the error.
 	Err error
+
+	// Attempts is the number of times the message was sent, 0 if it was rejected before sending.
+	// For a retrying result, it is the number of the attempt that was rate limited.
+	Attempts int
 }
 
+// States of a SendResult.
+const (
+	// StateSent indicates that the message was sent successfully.
+	StateSent = "sent"
+
+	// StateFailed indicates that an error occurred while sending the message.
+	StateFailed = "failed"
+
+	// StatePending indicates that the message is still being processed.
+	StatePending = "pending"
+
+	// StateRetrying indicates that the message was rate limited and will be sent again.
+	StateRetrying = "retrying"
+
+	// StateDropped indicates that the message was discarded before it could be sent.
+	StateDropped = "dropped"
+)
+
 // notify implements the Notify interface.
 type notify struct {
 	// defaultSendChannelName is the default channel name for sending messages when not specified in the message.
@@ -190,9 +286,6 @@ type notify struct {
 	// msgID is ID instance used for generating unique message IDs.
 	msgID *msgid.ID
 
-	// messages is a channel for buffering incoming messages before processing.
-	messages chan Message
-
 	// request is a resty client used for making HTTP requests to the Lark API.
 	request *resty.Client
 
@@ -204,17 +297,17 @@ type notify struct {
 	// The key will be used as the send channel name.
 	botWebhooks map[string]string
 
-	// pool is a goroutine pool used for concurrent message processing.
-	pool *ants.PoolWithFunc
-
-	// wg is used to wait for all goroutines to finish before closing the notifier.
-	wg sync.WaitGroup
-
 	// cache is a cache instance used for caching tokens.
 	cache cache.Cache
 
-	// sendResult is a channel for sending the result of each message send operation.
-	sendResult chan SendResult
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
 }
 
 // Token retrieves the access token for a specific Lark App.
@@ -231,13 +324,13 @@ func (n *notify) Token(appName string) (token string, err error) {
 		return "", fmt.Errorf("lark app %s not found", appName)
 	}
 
-	return a.token()
+	return a.token(context.Background())
 }
 
 // app represents the configuration for a Lark App.
 type app struct {
 	// token is a function that returns the access token for the Lark App.
-	token func() (string, error)
+	token func(ctx context.Context) (string, error)
 
 	// msgAPI is the URL for sending messages through Lark Apps.
 	msgAPI string
@@ -255,6 +348,10 @@ type Message struct {
 	// SendTo Lark(Feishu) user ID
 	SendTo string
 
+	// DeliveryID optionally identifies the delivery of the message, and is reported in its SendResult.
+	// It is set by the notify.Manager to tell apart the deliveries of a message sent to several targets.
+	DeliveryID string
+
 	// MsgType specifies the type of message
 	// It must be set to a valid value.
 	//
@@ -288,6 +385,9 @@ type appTokenResp struct {
 type messageResp struct {
 	Code int    `json:"code"` // Response code, 0 indicates success
 	Msg  string `json:"msg"`  // Error message if the request failed
+	Data struct {
+		MessageID string `json:"message_id"` // The ID of the sent message, only returned for Lark App messages
+	} `json:"data"`
 }
 
 // validateConfig checks the provided configuration for validity.
@@ -345,32 +445,164 @@ func validateConfig(config *Config) error {
 		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
 	}
 
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid lark retry policy: %w", err)
+	}
+
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid lark outbox: %w", err)
+	}
+
 	return nil
 }
 
 // StartProcessor starts the message processing goroutine.
-// It continuously reads messages from the channel and submits them to the goroutine pool.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
 func (n *notify) StartProcessor() {
-	go func() {
-		for m := range n.messages {
-			n.wg.Add(1)
-			err := n.pool.Invoke(m)
-			if err != nil {
-				log.Printf("failed to submit lark task to pool: %v\n", err)
-			}
-		}
-	}()
+	n.queue.Start()
 }
 
-// SubmitMessage submits a message to the notifier's message channel.
+// SubmitMessage submits a message to the notifier's message queue.
 //
 // Parameters:
 //   - message: The Message struct to be submitted.
 //
 // Returns:
 //   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
-//   - error: Any error encountered during the process.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
 func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
+}
+
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
+	message, err = n.prepareMessage(message)
+	if err != nil {
+		n.publish(SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, Channel: n.channelOf(message), State: StateDropped, Err: err})
+		return message.ID, err
+	}
+
+	// The queue reports the messages it drops
+	key := outbox.Key(message.ID, message.SendChannelName, message.SendTo)
+
+	return message.ID, n.queue.Submit(ctx, key, message)
+}
+
+// Send sends a message immediately and reports the result.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - SendResult: The result of the delivery.
+func (n *notify) Send(message Message) SendResult {
+	return n.SendContext(context.Background(), message)
+}
+
+// SendContext sends a message immediately and reports the result, aborting the delivery when ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - SendResult: The result of the delivery.
+func (n *notify) SendContext(ctx context.Context, message Message) SendResult {
+	message, err := n.prepareMessage(message)
+	if err != nil {
+		result := SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, Channel: n.channelOf(message), State: StateFailed, Err: err}
+		n.publish(result)
+
+		return result
+	}
+
+	if err = ctx.Err(); err != nil {
+		result := SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, Channel: n.channelOf(message), State: StateDropped, Err: err}
+		n.publish(result)
+
+		return result
+	}
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	for attempt := 1; ; attempt++ {
+		result := SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, Channel: n.channelOf(message), Attempts: attempt}
+		result.ProviderMsgID, result.Err = n.sendMsg(ctx, message)
+
+		delay, retried := n.retry.Delay(attempt, result.Err)
+		switch {
+		case result.Err == nil:
+			result.State = StateSent
+		case retried && ctx.Err() == nil:
+			result.State = StateRetrying
+		default:
+			result.State = StateFailed
+		}
+
+		n.publish(result)
+		if result.State != StateRetrying {
+			return result
+		}
+
+		if err = retry.Wait(ctx, delay); err != nil {
+			result.State = StateFailed
+			result.Err = fmt.Errorf("%w, retry aborted: %w", result.Err, err)
+			n.publish(result)
+
+			return result
+		}
+	}
+}
+
+// report publishes the result of an attempt to deliver a queued message, or of a message dropped by the queue.
+//
+// Parameters:
+//   - m: The prepared Message struct.
+//   - r: The result reported by the queue.
+func (n *notify) report(m Message, r queue.Result) {
+	n.publish(SendResult{
+		MsgID:         m.ID,
+		DeliveryID:    m.DeliveryID,
+		Channel:       n.channelOf(m),
+		ProviderMsgID: r.ProviderMsgID,
+		State:         r.State,
+		Err:           r.Err,
+		Attempts:      r.Attempts,
+	})
+}
+
+// channelOf returns the name of the channel a message is sent through.
+func (n *notify) channelOf(m Message) string {
+	if m.SendChannelName != "" {
+		return m.SendChannelName
+	}
+
+	return n.defaultSendChannelName
+}
+
+// prepareMessage assigns an ID to a message and, if needed, turns its level and title into a card.
+//
+// Parameters:
+//   - message: The Message struct to be prepared.
+//
+// Returns:
+//   - Message: The prepared message.
+//   - error: An error if the card message cannot be generated.
+func (n *notify) prepareMessage(message Message) (Message, error) {
 	// Generate a new message ID if not provided
 	if message.ID == "" {
 		message.ID = n.msgID.New()
@@ -382,7 +614,7 @@ func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
 		if ok {
 			cardContent, err := n.generateTextCardMsgWithLevel(message.MsgLevel, message.Title, content)
 			if err != nil {
-				return message.ID, fmt.Errorf("failed to generate card message: %w", err)
+				return message, fmt.Errorf("failed to generate card message: %w", err)
 			}
 
 			message.MsgType = "interactive"
@@ -390,10 +622,7 @@ func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
 		}
 	}
 
-	// Submit the message to the channel
-	n.messages <- message
-
-	return message.ID, nil
+	return message, nil
 }
 
 // shouldGenerateCardMsg checks if a card message should be generated based on the message properties.
@@ -418,31 +647,14 @@ func New(config Config) (Notify, error) {
 
 	n := &notify{
 		msgID:                  msgid.NewMessageID(),
-		messages:               make(chan Message, config.ChannelSize),
 		request:                resty.New(),
 		apps:                   make(map[string]*app),
 		botWebhooks:            config.BotWebhooks,
 		defaultSendChannelName: config.DefaultSendChannelName,
-		sendResult:             make(chan SendResult, config.ChannelSize),
+		retry:                  config.Retry,
 		cache:                  cache.New(),
 	}
 
-	// Create a new goroutine pool
-	pool, err := ants.NewPoolWithFunc(config.PoolSize, func(i interface{}) {
-		msg := i.(Message)
-		err := n.sendMsg(msg)
-		if err != nil {
-			log.Printf("failed to send lark message: %v\n", err)
-		}
-
-		n.wg.Done()
-	}, ants.WithPreAlloc(true))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create lark goroutine pool: %v", err)
-	}
-
-	n.pool = pool
-
 	// Initialize Lark Apps
 	for name, lark := range config.Larks {
 		var msgAPI, appTokenAPI string
@@ -457,6 +669,9 @@ func New(config Config) (Notify, error) {
 			return nil, fmt.Errorf("invalid lark app type: %s", lark.AppType)
 		}
 
+		// A custom Token does not take a context
+		token := func(context.Context) (string, error) { return lark.Token() }
+
 		// If Token is not provided,
 		// lark.AppID and lark.AppSecret will be used to generate the token.
 		if lark.Token == nil {
@@ -464,25 +679,42 @@ func New(config Config) (Notify, error) {
 				return nil, fmt.Errorf("lark config error: %s", name)
 			}
 
-			lark.Token = func() (string, error) {
-				return n.getToken(lark.AppID, lark.AppSecret, appTokenAPI)
+			token = func(ctx context.Context) (string, error) {
+				return n.getToken(ctx, lark.AppID, lark.AppSecret, appTokenAPI)
 			}
 		}
 
 		a := &app{
-			token:  lark.Token,
+			token:  token,
 			msgAPI: msgAPI,
 		}
 
 		n.apps[name] = a
 	}
 
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "lark",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send:     n.sendMsg,
+		Report:   n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
 	return n, nil
 }
 
 // getToken retrieves an access token for a Lark App.
 //
 // Parameters:
+//   - ctx: The context of the token request.
 //   - appID: The App ID for the Lark App.
 //   - appSecret: The App Secret for the Lark App.
 //   - appTokenAPI: The API endpoint for obtaining the Lark App Token.
@@ -490,7 +722,7 @@ func New(config Config) (Notify, error) {
 // Returns:
 //   - string: The access token if successful, an empty string otherwise.
 //   - error: An error if the token cannot be retrieved, nil otherwise.
-func (n *notify) getToken(appID, appSecret, appTokenAPI string) (string, error) {
+func (n *notify) getToken(ctx context.Context, appID, appSecret, appTokenAPI string) (string, error) {
 	cacheKey := fmt.Sprintf(tokenCacheKey, appID)
 
 	token, err := n.cache.GetString(cacheKey)
@@ -510,7 +742,7 @@ func (n *notify) getToken(appID, appSecret, appTokenAPI string) (string, error)
 		},
 	}
 
-	response, err := n.sendLarkAPIRequest(request, 3)
+	response, err := n.sendLarkAPIRequest(ctx, request)
 	if err != nil {
 		return "", fmt.Errorf("failed to request Lark App Token: %w", err)
 	}
@@ -520,11 +752,11 @@ func (n *notify) getToken(appID, appSecret, appTokenAPI string) (string, error)
 		return "", fmt.Errorf("failed to parse token response: %w", err)
 	}
 
-	if rs.Code != 0 {
-		return "", fmt.Errorf("failed to obtain Lark App Token: %s", rs.Msg)
+	if err = checkCode(rs.Code, rs.Msg); err != nil {
+		return "", fmt.Errorf("failed to obtain Lark App Token: %w", err)
 	}
 
-	if err = n.cache.SetString(cacheKey, rs.AppAccessToken, rs.Expire-100); err != nil {
+	if err = n.cache.SetString(cacheKey, rs.AppAccessToken, cache.TokenExpiration(rs.Expire, 100)); err != nil {
 		log.Printf("failed to cache token: %s", err)
 	}
 
@@ -534,48 +766,44 @@ func (n *notify) getToken(appID, appSecret, appTokenAPI string) (string, error)
 // sendMsg sends a message using the appropriate channel (BotWebhook or Lark App).
 //
 // Parameters:
+//   - ctx: The context of the delivery.
 //   - m: The Message struct containing the message details.
 //
 // Returns:
+//   - string: The message ID assigned by Lark, empty for bot webhooks.
 //   - error: An error if the message cannot be sent, nil otherwise.
-func (n *notify) sendMsg(m Message) error {
-	channel := m.SendChannelName
-	if channel == "" {
-		channel = n.defaultSendChannelName
-	}
+func (n *notify) sendMsg(ctx context.Context, m Message) (string, error) {
+	channel := n.channelOf(m)
 
 	// Check if the channel is a BotWebhook
 	if webhook, ok := n.botWebhooks[channel]; ok {
-		return n.sendBotWebhookMessage(webhook, m)
+		return "", n.sendBotWebhookMessage(ctx, webhook, m)
 	}
 
 	// Check if the channel is a Lark App
 	if a, ok := n.apps[channel]; ok {
 		if m.SendTo == "" {
-			return fmt.Errorf("sendTo is required for lark app %s", channel)
+			return "", fmt.Errorf("sendTo is required for lark app %s", channel)
 		}
 
-		t, err := a.token()
+		t, err := a.token(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to get token for lark app %s: %w", channel, err)
+			return "", fmt.Errorf("failed to get token for lark app %s: %w", channel, err)
 		}
 
-		return n.sendLarkAppMessage(t, a.msgAPI, m)
+		return n.sendLarkAppMessage(ctx, t, a.msgAPI, m)
 	}
 
-	return fmt.Errorf("channel %s is not found in lark", channel)
+	return "", fmt.Errorf("channel %s is not found in lark", channel)
 }
 
 // Close stops the notifier, waits for all messages to be processed, and releases resources.
 func (n *notify) Close() {
-	// Close the message channel to stop accepting new messages
-	close(n.messages)
-
-	// Wait for all messages to be processed
-	n.wg.Wait()
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
 
-	// Release the goroutine pool
-	n.pool.Release()
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
 
 	log.Println("Lark notify closed")
 }
@@ -5,8 +5,8 @@
 package lark
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 )
 
@@ -66,13 +66,15 @@ func (n *notify) SendTemplateCardMessage(appName, templateID, sendTo string, con
 // sendLarkAppMessage sends a message via a Lark App.
 //
 // Parameters:
+//   - ctx: The context of the request.
 //   - token: The access token for the Lark App.
 //   - msgAPI: The API endpoint for sending Message.
 //   - m: The Message struct containing the message details. Message.MsgType must be set.
 //
 // Returns:
+//   - string: The message ID assigned by Lark.
 //   - error: An error if the message cannot be sent, nil otherwise.
-func (n *notify) sendLarkAppMessage(token, msgAPI string, m Message) error {
+func (n *notify) sendLarkAppMessage(ctx context.Context, token, msgAPI string, m Message) (string, error) {
 	var marshal []byte
 	var err error
 
@@ -82,35 +84,35 @@ func (n *notify) sendLarkAppMessage(token, msgAPI string, m Message) error {
 	case "text":
 		marshal, _ = json.Marshal(map[string]any{"text": m.Content.(string)})
 		if err != nil {
-			return fmt.Errorf("failed to marshal text content: %v", err)
+			return "", fmt.Errorf("failed to marshal text content: %v", err)
 		}
 	case "image":
 		marshal, err = json.Marshal(map[string]any{"image_key": m.Content.(string)})
 		if err != nil {
-			return fmt.Errorf("failed to marshal image content: %v", err)
+			return "", fmt.Errorf("failed to marshal image content: %v", err)
 		}
 	case "audio", "file", "media", "sticker":
 		marshal, err = json.Marshal(map[string]any{"file_key": m.Content.(string)})
 		if err != nil {
-			return fmt.Errorf("failed to marshal file content: %v", err)
+			return "", fmt.Errorf("failed to marshal file content: %v", err)
 		}
 	case "share_chat":
 		marshal, err = json.Marshal(map[string]any{"chat_id": m.Content.(string)})
 		if err != nil {
-			return fmt.Errorf("failed to marshal share chat content: %v", err)
+			return "", fmt.Errorf("failed to marshal share chat content: %v", err)
 		}
 	case "share_user":
 		marshal, err = json.Marshal(map[string]any{"user_id": m.Content.(string)})
 		if err != nil {
-			return fmt.Errorf("failed to marshal share user content: %v", err)
+			return "", fmt.Errorf("failed to marshal share user content: %v", err)
 		}
 	case "post", "interactive", "system":
 		marshal, err = json.Marshal(m.Content)
 		if err != nil {
-			return fmt.Errorf("failed to marshal %s content: %v", m.MsgType, err)
+			return "", fmt.Errorf("failed to marshal %s content: %v", m.MsgType, err)
 		}
 	default:
-		return fmt.Errorf("invalid message type: %s", m.MsgType)
+		return "", fmt.Errorf("invalid message type: %s", m.MsgType)
 	}
 
 	params["content"] = string(marshal)
@@ -126,20 +128,20 @@ func (n *notify) sendLarkAppMessage(token, msgAPI string, m Message) error {
 		Body:        params,
 	}
 
-	response, err := n.sendLarkAPIRequest(request, 3)
+	response, err := n.sendLarkAPIRequest(ctx, request)
 	if err != nil {
-		return fmt.Errorf("failed to send app message: %w", err)
+		return "", fmt.Errorf("failed to send app message: %w", err)
 	}
 
 	// Check response status
 	var rs messageResp
 	if err = json.Unmarshal(response.Body, &rs); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
+		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if rs.Code != 0 {
-		return errors.New(rs.Msg)
+	if err = checkCode(rs.Code, rs.Msg); err != nil {
+		return "", fmt.Errorf("failed to send app message: %w", err)
 	}
 
-	return nil
+	return rs.Data.MessageID, nil
 }
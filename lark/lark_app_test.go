@@ -4,7 +4,11 @@
 
 package lark
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestNotify_SendAppMessage(t *testing.T) {
 	mockNotify := newTestNotify()
@@ -49,7 +53,7 @@ func TestNotify_SendAppMessage(t *testing.T) {
 				if msg.Content != tt.content {
 					t.Errorf("Submitted message Content = %v, want %v", msg.Content, tt.content)
 				}
-			default:
+			case <-time.After(time.Second):
 				t.Error("No message was submitted to the channel")
 			}
 		})
@@ -70,12 +74,12 @@ func TestNotify_SendAppMessage_TextMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -95,12 +99,12 @@ func TestNotify_SendAppMessage_ImageMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -185,12 +189,12 @@ func TestNotify_SendAppMessage_PostMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -241,12 +245,12 @@ func TestNotify_SendAppMessage_InteractiveMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -266,12 +270,12 @@ func TestNotify_SendAppMessage_ShareChatMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -291,12 +295,12 @@ func TestNotify_SendAppMessage_ShareUserMessage(t *testing.T) {
 		t.Error("app not found")
 	}
 
-	tk, err := a.token()
+	tk, err := a.token(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = n.sendLarkAppMessage(tk, a.msgAPI, m)
+	_, err = n.sendLarkAppMessage(context.Background(), tk, a.msgAPI, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -5,6 +5,7 @@
 package lark
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -31,12 +32,13 @@ func (n *notify) SendBotMessage(botName, msgType string, content any) (msgID str
 // sendBotWebhookMessage sends a message via a BotWebhook.
 //
 // Parameters:
+//   - ctx: The context of the request.
 //   - webhook: The webhook URL.
 //   - m: The Message struct containing the message details.
 //
 // Returns:
 //   - error: An error if the message cannot be sent, nil otherwise.
-func (n *notify) sendBotWebhookMessage(webhook string, m Message) error {
+func (n *notify) sendBotWebhookMessage(ctx context.Context, webhook string, m Message) error {
 	params := make(map[string]any)
 	params["msg_type"] = m.MsgType
 
@@ -64,7 +66,7 @@ func (n *notify) sendBotWebhookMessage(webhook string, m Message) error {
 		Body:    params,
 	}
 
-	response, err := n.sendLarkAPIRequest(request, 3)
+	response, err := n.sendLarkAPIRequest(ctx, request)
 	if err != nil {
 		return fmt.Errorf("failed to send bot message: %w", err)
 	}
@@ -75,8 +77,8 @@ func (n *notify) sendBotWebhookMessage(webhook string, m Message) error {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if rs.Code != 0 {
-		return fmt.Errorf("failed to send bot message: %s", rs.Msg)
+	if err = checkCode(rs.Code, rs.Msg); err != nil {
+		return fmt.Errorf("failed to send bot message: %w", err)
 	}
 
 	return nil
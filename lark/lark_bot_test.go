@@ -4,7 +4,11 @@
 
 package lark
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestNotify_SendBotMessage(t *testing.T) {
 	// Create a mock Notify instance
@@ -43,7 +47,7 @@ func TestNotify_SendBotMessage(t *testing.T) {
 				if msg.Content != tt.content {
 					t.Errorf("Submitted message Content = %v, want %v", msg.Content, tt.content)
 				}
-			default:
+			case <-time.After(time.Second):
 				t.Error("No message was submitted to the channel")
 			}
 		})
@@ -63,7 +67,7 @@ func TestNotify_SendBotMessage_TextMessage(t *testing.T) {
 		t.Error("webhook not found")
 	}
 
-	err := n.sendBotWebhookMessage(webhook, m)
+	err := n.sendBotWebhookMessage(context.Background(), webhook, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -82,7 +86,7 @@ func TestNotify_SendBotMessage_ImageMessage(t *testing.T) {
 		t.Error("webhook not found")
 	}
 
-	err := n.sendBotWebhookMessage(webhook, m)
+	err := n.sendBotWebhookMessage(context.Background(), webhook, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -113,7 +117,7 @@ func TestNotify_SendBotMessage_PostMessage(t *testing.T) {
 		t.Error("webhook not found")
 	}
 
-	err := n.sendBotWebhookMessage(webhook, m)
+	err := n.sendBotWebhookMessage(context.Background(), webhook, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -132,7 +136,7 @@ func TestNotify_SendBotMessage_ShareChatMessage(t *testing.T) {
 		t.Error("webhook not found")
 	}
 
-	err := n.sendBotWebhookMessage(webhook, m)
+	err := n.sendBotWebhookMessage(context.Background(), webhook, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -181,7 +185,7 @@ func TestNotify_SendBotMessage_InteractiveMessage(t *testing.T) {
 		t.Error("webhook not found")
 	}
 
-	err := n.sendBotWebhookMessage(webhook, m)
+	err := n.sendBotWebhookMessage(context.Background(), webhook, m)
 	if err != nil {
 		t.Error(err)
 	}
@@ -5,10 +5,13 @@
 package lark
 
 import (
+	"context"
 	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/internal/queue"
 	"github.com/sk-pkg/notify/msgid"
 	"github.com/sk-pkg/notify/util"
 	"testing"
+	"time"
 )
 
 const (
@@ -128,7 +131,15 @@ func newTestNotifyInterface() (Notify, error) {
 	return New(cfg)
 }
 
-func newTestNotify() *notify {
+// testNotify is a notify whose queue captures the submitted messages instead of sending them.
+type testNotify struct {
+	*notify
+
+	// messages receives the messages delivered by the queue.
+	messages chan Message
+}
+
+func newTestNotify() *testNotify {
 	botWebhooks := map[string]string{
 		"test_bot_1": botTestWebhook1,
 		"test_bot_2": botTestWebhook2,
@@ -136,7 +147,6 @@ func newTestNotify() *notify {
 
 	n := &notify{
 		msgID:                  msgid.NewMessageID(),
-		messages:               make(chan Message, 10),
 		request:                resty.New(),
 		botWebhooks:            botWebhooks,
 		defaultSendChannelName: "test_bot_1",
@@ -145,29 +155,46 @@ func newTestNotify() *notify {
 	apps := map[string]*app{
 		"lark_1": {
 			msgAPI: util.SpliceStr(larkHost, messageAPI),
-			token: func() (string, error) {
-				return n.getToken(larkTestAppID, larkTestAppSecret, util.SpliceStr(larkHost, appAccessTokenAPI))
+			token: func(ctx context.Context) (string, error) {
+				return n.getToken(ctx, larkTestAppID, larkTestAppSecret, util.SpliceStr(larkHost, appAccessTokenAPI))
 			},
 		},
 		"lark_2": {
 			msgAPI: util.SpliceStr(larkHost, messageAPI),
-			token:  getLarkToken,
+			token:  func(context.Context) (string, error) { return getLarkToken() },
 		},
 		"feishu_1": {
 			msgAPI: util.SpliceStr(feishuHost, messageAPI),
-			token: func() (string, error) {
-				return n.getToken(feishuTestAppID, feishuTestAppSecret, util.SpliceStr(feishuHost, appAccessTokenAPI))
+			token: func(ctx context.Context) (string, error) {
+				return n.getToken(ctx, feishuTestAppID, feishuTestAppSecret, util.SpliceStr(feishuHost, appAccessTokenAPI))
 			},
 		},
 		"feishu_2": {
 			msgAPI: util.SpliceStr(feishuHost, messageAPI),
-			token:  getFeishuToken,
+			token:  func(context.Context) (string, error) { return getFeishuToken() },
 		},
 	}
 
 	n.apps = apps
 
-	return n
+	messages := make(chan Message, 10)
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "lark",
+		Size:     10,
+		PoolSize: 1,
+		Send: func(_ context.Context, m Message) (string, error) {
+			messages <- m
+			return "", nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	n.queue = q
+	n.queue.Start()
+
+	return &testNotify{notify: n, messages: messages}
 }
 
 func TestNotify_SubmitMessage(t *testing.T) {
@@ -209,7 +236,7 @@ func TestNotify_SubmitMessage(t *testing.T) {
 				if msg.ID != gotID {
 					t.Errorf("Submitted message ID = %v, want %v", msg.ID, gotID)
 				}
-			default:
+			case <-time.After(time.Second):
 				t.Error("No message was submitted to the channel")
 			}
 		})
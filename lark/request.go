@@ -5,11 +5,22 @@
 package lark
 
 import (
+	"context"
 	"fmt"
+	"github.com/sk-pkg/notify/retry"
+	"net/http"
+	"slices"
 	"strconv"
 	"time"
 )
 
+// transientCodes are the response codes returned when Lark is busy or rate limiting the app.
+var transientCodes = []int{
+	99991400, // Request trigger frequency limit
+	230020,   // Message sending trigger frequency limit
+	1000,     // Internal server error
+}
+
 // Request represents a request to the Lark API.
 type Request struct {
 	Method      string
@@ -26,22 +37,21 @@ type Response struct {
 	Headers    map[string][]string
 }
 
-// sendLarkAPIRequest sends a request to the Lark API with retry logic for rate limiting.
+// sendLarkAPIRequest sends a request to the Lark API.
 //
-// This function implements a retry mechanism to handle Lark API's rate limiting:
-//   - It attempts to send the request up to maxRetries times (default 3).
-//   - If a 429 (Too Many Requests) status is received, it waits for the duration
-//     specified in the 'x-ogw-ratelimit-reset' header before retrying.
-//   - If the 'x-ogw-ratelimit-reset' header is missing or invalid, it defaults to a 60-second wait.
-//   - The function gives up after maxRetries attempts, returning an error.
+// The request is attempted once, and the errors worth retrying are marked for the retry policy:
+//   - If a 429 (Too Many Requests) status is received, the error asks to wait for the duration
+//     specified in the 'x-ogw-ratelimit-reset' header, 60 seconds if it is missing or invalid.
+//   - Other transient statuses (408 and 5xx) are marked as transient.
+//   - Transport errors are left as they are, and are retryable by default.
 //
 // Parameters:
+//   - ctx: The context of the request.
 //   - request: The Request containing the request details.
-//   - maxRetries: The maximum number of retry attempts (default 3).
 //
 // Returns:
 //   - *Response: The response from the Lark API.
-//   - error: An error if the request fails after all retries, nil otherwise.
+//   - error: An error if the request fails, nil otherwise.
 //
 // Example:
 //
@@ -52,42 +62,56 @@ type Response struct {
 //	    Body:    params,
 //	}
 //
-//	response, err := sendLarkAPIRequest(client, request, 3)
+//	response, err := sendLarkAPIRequest(ctx, request)
 //	if err != nil {
 //	    log.Printf("API request failed: %v", err)
 //	}
-func (n *notify) sendLarkAPIRequest(request *Request, maxRetries int) (*Response, error) {
-	if maxRetries <= 0 {
-		maxRetries = 3 // Default to 3 retries if not specified
+func (n *notify) sendLarkAPIRequest(ctx context.Context, request *Request) (*Response, error) {
+	resp, err := n.executeRequest(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	// Handle rate limiting (HTTP 429 status)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resetTime := n.extractResetTime(http.Header(resp.Headers).Values("x-ogw-ratelimit-reset"))
+		return nil, retry.After(fmt.Errorf("rate limited, reset in %d seconds", resetTime), time.Duration(resetTime)*time.Second)
+	}
+
+	if retry.TransientStatus(resp.StatusCode) {
+		return nil, retry.Transient(fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, resp.Body))
+	}
+
+	// Return response for all other cases
+	return resp, nil
+}
+
+// checkCode returns an error for a non-zero response code, marked as transient if the code
+// means that Lark is busy or rate limiting the app.
+//
+// Parameters:
+//   - code: The response code.
+//   - msg: The error message of the response.
+//
+// Returns:
+//   - error: nil if code is 0.
+func checkCode(code int, msg string) error {
+	if code == 0 {
+		return nil
 	}
 
-	for retry := 0; retry <= maxRetries; retry++ {
-		resp, err := n.executeRequest(request)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute request: %w", err)
-		}
-
-		// Handle rate limiting (HTTP 429 status)
-		if resp.StatusCode == 429 {
-			if retry < maxRetries {
-				resetTime := n.extractResetTime(resp.Headers["x-ogw-ratelimit-reset"])
-				time.Sleep(time.Duration(resetTime) * time.Second)
-				continue
-			} else {
-				return nil, fmt.Errorf("rate limit exceeded after %d retries", maxRetries)
-			}
-		}
-
-		// Return response for all other cases
-		return resp, nil
+	err := fmt.Errorf("%d %s", code, msg)
+	if slices.Contains(transientCodes, code) {
+		return retry.Transient(err)
 	}
 
-	return nil, fmt.Errorf("failed to send request after %d retries", maxRetries)
+	return err
 }
 
 // executeRequest executes a single API request.
-func (n *notify) executeRequest(request *Request) (*Response, error) {
+func (n *notify) executeRequest(ctx context.Context, request *Request) (*Response, error) {
 	req := n.request.R().
+		SetContext(ctx).
 		SetHeaders(request.Headers).
 		SetQueryParams(request.QueryParams).
 		SetBody(request.Body)
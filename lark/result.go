@@ -0,0 +1,28 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package lark
+
+// Subscribe registers a handler called with the result of every delivery.
+// The handlers are called in order from a single goroutine. Once Config.ResultBufferSize results are
+// waiting for a slow handler, the deliveries wait for it to catch up, so the handler must not wait for them.
+//
+// Parameters:
+//   - handler: The function called with each SendResult.
+//
+// Returns:
+//   - func(): A function removing the handler.
+func (n *notify) Subscribe(handler func(SendResult)) func() {
+	return n.results.Subscribe(handler)
+}
+
+// publish queues a result for the subscribers.
+// It never discards a result: it blocks while Config.ResultBufferSize results are waiting for the subscribers,
+// so that the subscribers such as the notify.Manager, whose failover depends on them, do not miss any.
+//
+// Parameters:
+//   - result: The SendResult to be published.
+func (n *notify) publish(result SendResult) {
+	n.results.Publish(result)
+}
@@ -0,0 +1,370 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSendTestNotify creates a notifier whose bot webhook and Lark App point to a fake server.
+func newSendTestNotify(t *testing.T, code int) *notify {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": "bot"})
+	})
+
+	// The limited webhook answers the first request with 429 Too Many Requests
+	var limited atomic.Bool
+	mux.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
+		if limited.CompareAndSwap(false, true) {
+			w.Header().Set("x-ogw-ratelimit-reset", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": "bot"})
+	})
+	mux.HandleFunc(messageAPI, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"code": code,
+			"msg":  "app",
+			"data": map[string]string{"message_id": "om_123"},
+		})
+	})
+
+	mux.HandleFunc("/throttled", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ogw-ratelimit-reset", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	n := &notify{
+		msgID:   msgid.NewMessageID(),
+		request: resty.New(),
+		botWebhooks: map[string]string{
+			"bot":       srv.URL + "/hook",
+			"limited":   srv.URL + "/limited",
+			"throttled": srv.URL + "/throttled",
+		},
+		defaultSendChannelName: "bot",
+		apps: map[string]*app{
+			"app": {
+				msgAPI: srv.URL + messageAPI,
+				token:  func(context.Context) (string, error) { return "t-test", nil },
+			},
+			"broken": {
+				msgAPI: srv.URL + messageAPI,
+				token:  func(context.Context) (string, error) { return "", errors.New("invalid app secret") },
+			},
+		},
+		retry: retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	q, err := queue.New(queue.Config[Message]{Name: "lark", Size: 2, PoolSize: 1, Retry: n.retry, Send: n.sendMsg, Report: n.report})
+	if err != nil {
+		t.Fatalf("queue.New() error = %v", err)
+	}
+	n.queue = q
+	n.results = queue.NewResults[SendResult](10)
+
+	return n
+}
+
+// subscribe collects the results published by n until the returned function is called.
+func subscribe(n *notify) func() []SendResult {
+	var results []SendResult
+	unsubscribe := n.Subscribe(func(r SendResult) {
+		r.Err = nil
+		results = append(results, r)
+	})
+
+	return func() []SendResult {
+		// Closing the results waits for the dispatcher to pass on the queued results
+		n.results.Close()
+		unsubscribe()
+
+		return results
+	}
+}
+
+func TestNotify_Send(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		message Message
+		want    SendResult
+	}{
+		{
+			name:    "Bot webhook",
+			message: Message{ID: "id-1", MsgType: "text", Content: "hello"},
+			want:    SendResult{MsgID: "id-1", Channel: "bot", State: StateSent, Attempts: 1},
+		},
+		{
+			name:    "Lark App",
+			message: Message{ID: "id-2", SendChannelName: "app", SendTo: "u_1", MsgType: "text", Content: "hello"},
+			want:    SendResult{MsgID: "id-2", Channel: "app", ProviderMsgID: "om_123", State: StateSent, Attempts: 1},
+		},
+		{
+			name:    "Rejected by Lark",
+			code:    9499,
+			message: Message{ID: "id-3", MsgType: "text", Content: "hello"},
+			want:    SendResult{MsgID: "id-3", Channel: "bot", State: StateFailed, Attempts: 1},
+		},
+		{
+			name:    "Token error",
+			message: Message{ID: "id-4", SendChannelName: "broken", SendTo: "u_1", MsgType: "text", Content: "hello"},
+			want:    SendResult{MsgID: "id-4", Channel: "broken", State: StateFailed, Attempts: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newSendTestNotify(t, tt.code)
+
+			got := n.Send(tt.message)
+			if (got.Err != nil) != (tt.want.State == StateFailed) {
+				t.Errorf("Send() error = %v, want state %s", got.Err, tt.want.State)
+			}
+
+			got.Err = nil
+			if got != tt.want {
+				t.Errorf("Send() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotify_Send_GeneratesID(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+
+	got := n.Send(Message{MsgType: "text", Content: "hello"})
+	if got.Err != nil || got.MsgID == "" {
+		t.Errorf("Send() = %+v, want a generated message ID", got)
+	}
+}
+
+func TestNotify_Subscribe(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+	results := subscribe(n)
+
+	n.Send(Message{ID: "id-1", SendChannelName: "limited", MsgType: "text", Content: "hello"})
+	n.Send(Message{ID: "id-2", SendChannelName: "missing", MsgType: "text", Content: "hello"})
+
+	want := []SendResult{
+		{MsgID: "id-1", Channel: "limited", State: StateRetrying, Attempts: 1},
+		{MsgID: "id-1", Channel: "limited", State: StateSent, Attempts: 2},
+		{MsgID: "id-2", Channel: "missing", State: StateFailed, Attempts: 1},
+	}
+
+	got := results()
+	if len(got) != len(want) {
+		t.Fatalf("published %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNotify_Subscribe_Dropped(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+	results := subscribe(n)
+
+	// The message is queued, but its context is done before it is sent
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := n.SubmitMessageContext(ctx, Message{ID: "id-1", MsgType: "text", Content: "hello"}); err != nil {
+		t.Fatalf("SubmitMessageContext() error = %v", err)
+	}
+	cancel()
+
+	n.StartProcessor()
+	n.queue.Close()
+
+	got := results()
+	if len(got) != 1 || got[0].MsgID != "id-1" || got[0].Channel != "bot" || got[0].State != StateDropped {
+		t.Errorf("published %+v, want a dropped result for id-1", got)
+	}
+}
+
+func TestNotify_Subscribe_Unsubscribe(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+
+	called := false
+	unsubscribe := n.Subscribe(func(SendResult) { called = true })
+	unsubscribe()
+
+	n.Send(Message{MsgType: "text", Content: "hello"})
+	n.results.Close()
+
+	if called {
+		t.Error("handler called after unsubscribe")
+	}
+}
+
+func TestNotify_SendContext(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got := n.SendContext(ctx, Message{ID: "id-1", MsgType: "text", Content: "hello"})
+	if got.State != StateDropped || !errors.Is(got.Err, context.Canceled) || got.Attempts != 0 {
+		t.Errorf("SendContext() = %+v, want dropped with context.Canceled", got)
+	}
+}
+
+func TestNotify_SendContext_RateLimitWait(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	got := n.SendContext(ctx, Message{SendChannelName: "throttled", MsgType: "text", Content: "hello"})
+	if got.State != StateFailed || !errors.Is(got.Err, context.DeadlineExceeded) {
+		t.Errorf("SendContext() = %+v, want failed with context.DeadlineExceeded", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SendContext() returned after %s, want the rate limit wait to be aborted", elapsed)
+	}
+}
+
+func TestNotify_SubmitMessage_Closed(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+	results := subscribe(n)
+	n.Close()
+
+	// A message submitted after Close is refused instead of sent on the closed channel
+	if _, err := n.SubmitMessage(Message{ID: "id-1", MsgType: "text", Content: "hello"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("SubmitMessage() after Close error = %v, want ErrClosed", err)
+	}
+
+	got := results()
+	if len(got) != 0 {
+		t.Errorf("published %+v after the results were closed, want none", got)
+	}
+}
+
+func TestNotify_SubmitMessage_Retry(t *testing.T) {
+	n := newSendTestNotify(t, 0)
+
+	var mu sync.Mutex
+	var got []SendResult
+	n.Subscribe(func(r SendResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		r.Err = nil
+		got = append(got, r)
+	})
+
+	n.StartProcessor()
+	for _, m := range []Message{
+		{ID: "id-1", SendChannelName: "limited", MsgType: "text", Content: "hello"},
+		{ID: "id-2", MsgType: "text", Content: "hello"},
+	} {
+		if _, err := n.SubmitMessage(m); err != nil {
+			t.Fatalf("SubmitMessage() error = %v", err)
+		}
+	}
+
+	// Close waits for the scheduled retry
+	n.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := map[string][]SendResult{
+		"id-1": {
+			{MsgID: "id-1", Channel: "limited", State: StateRetrying, Attempts: 1},
+			{MsgID: "id-1", Channel: "limited", State: StateSent, Attempts: 2},
+		},
+		"id-2": {{MsgID: "id-2", Channel: "bot", State: StateSent, Attempts: 1}},
+	}
+
+	byID := make(map[string][]SendResult)
+	for _, r := range got {
+		byID[r.MsgID] = append(byID[r.MsgID], r)
+	}
+
+	if !reflect.DeepEqual(byID, want) {
+		t.Errorf("published %+v, want %+v", byID, want)
+	}
+}
+
+func TestNotify_Send_RetryPolicy(t *testing.T) {
+	n := newSendTestNotify(t, 99991400)
+
+	got := n.Send(Message{ID: "id-1", MsgType: "text", Content: "hello"})
+	if got.State != StateFailed || got.Attempts != 3 {
+		t.Errorf("Send() = %+v, want failed after 3 attempts", got)
+	}
+
+	n.retry.MaxAttempts = 1
+	got = n.Send(Message{ID: "id-2", MsgType: "text", Content: "hello"})
+	if got.State != StateFailed || got.Attempts != 1 {
+		t.Errorf("Send() = %+v, want failed after 1 attempt", got)
+	}
+
+	// Codes not meaning that Lark is busy are not retried
+	n = newSendTestNotify(t, 9499)
+	if got = n.Send(Message{ID: "id-3", MsgType: "text", Content: "hello"}); got.Attempts != 1 {
+		t.Errorf("Send() = %+v, want failed after 1 attempt", got)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		DefaultSendChannelName: "bot",
+		BotWebhooks:            map[string]string{"bot": "http://127.0.0.1:0", "backup": "http://127.0.0.1:0"},
+		Outbox:                 outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// A message is queued once per bot or app user
+	message := Message{ID: "msg-1", MsgLevel: "error", Title: "Disk full", Content: "db-1 /var is 95% full"}
+	for _, target := range []Message{{SendChannelName: "bot"}, {SendChannelName: "backup"}, {SendChannelName: "backup", SendTo: "ou_1"}} {
+		message.SendChannelName, message.SendTo = target.SendChannelName, target.SendTo
+		if msgID, err := i.SubmitMessage(message); err != nil || msgID != "msg-1" {
+			t.Fatalf("SubmitMessage() = %s, %v, want msg-1", msgID, err)
+		}
+	}
+
+	var dropped []SendResult
+	i.Subscribe(func(r SendResult) { dropped = append(dropped, r) })
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+
+	// Close waits for the results to be passed to the subscribers
+	i.Close()
+
+	if len(dropped) != 1 || dropped[0].State != StateDropped || !errors.Is(dropped[0].Err, outbox.ErrDuplicate) {
+		t.Errorf("published %+v, want the duplicate dropped", dropped)
+	}
+}
@@ -8,7 +8,9 @@
 package notify
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/sk-pkg/notify/bark"
 	"github.com/sk-pkg/notify/ding"
 	"github.com/sk-pkg/notify/email"
@@ -17,6 +19,9 @@ import (
 	"github.com/sk-pkg/notify/telegram"
 	"github.com/sk-pkg/notify/wechat"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Constants for supported notification channels and message levels
@@ -37,6 +42,9 @@ const (
 var (
 	// InvalidParams is returned when invalid parameters are provided
 	InvalidParams = errors.New("invalid params")
+
+	// ChannelRegistered is returned when registering a channel that already has a sender
+	ChannelRegistered = errors.New("channel already registered")
 )
 
 // Option is a function type for configuring the Manager
@@ -46,6 +54,9 @@ type Option func(*option)
 type option struct {
 	defaultChannel Channel
 	defaultLevel   Level
+	rules          []Rule
+	failovers      []Failover
+	deadLetters    DeadLetterStore
 
 	larkConfig     lark.Config
 	dingTalkConfig ding.Config
@@ -75,7 +86,53 @@ type Manager struct {
 	Bark     bark.Notify
 	Email    email.Notify
 
-	channelStatus map[Channel]bool
+	// senders maps each enabled or registered channel to its sender
+	senders map[Channel]Sender
+	mu      sync.RWMutex
+
+	// router chooses the targets of the messages sent without channels, nil without rules
+	router *Router
+
+	// failovers maps channels to their failover chains
+	failovers map[Channel]Failover
+
+	// watches tracks the deliveries of failover chains on channels reporting their results
+	watches map[watchKey]watch
+	watchMu sync.Mutex
+
+	// nextDeliveryID generates the DeliveryID of the messages delivered to each target
+	nextDeliveryID atomic.Uint64
+
+	// failoverWG is used to wait for the failovers in progress on Close
+	failoverWG sync.WaitGroup
+
+	// deadLetters stores the messages that could not be delivered, nil if dead-lettering is disabled
+	deadLetters DeadLetterStore
+
+	// inflight tracks the chains of the deliveries in progress, to record their failures, under watchMu
+	inflight map[watchKey]*chain
+
+	// subscribers maps subscription IDs to the handlers of the send results
+	subscribers      map[int]func(SendResult)
+	nextSubscriberID int
+
+	// results queues the results for the subscribers, consumed by dispatchResults once a handler is subscribed.
+	// resultsClosed is set by Close, and subMu protects the subscribers and the sends on results.
+	results       chan published
+	resultsClosed bool
+	dispatchOnce  sync.Once
+	dispatchWG    sync.WaitGroup
+	subMu         sync.RWMutex
+}
+
+// resultQueueSize is the number of results waiting for the subscribers of a Manager,
+// beyond which the results are discarded for the subscribers that cannot keep up.
+const resultQueueSize = 1024
+
+// published is a result queued for the handlers subscribed when it was published.
+type published struct {
+	result   SendResult
+	handlers []func(SendResult)
 }
 
 // OptLarkConfig sets the Lark configuration for the Manager
@@ -182,6 +239,38 @@ func OptDefaultLevel(level Level) Option {
 	}
 }
 
+// OptRules sets the routing rules of the Manager
+//
+// The rules choose the channels and recipients of the messages sent without channels,
+// including those sent with Dispatch. They are evaluated in order, see Router.Route.
+//
+// Parameters:
+//   - rules: The routing rules
+//
+// Returns:
+//   - Option: A function that sets the routing rules
+func OptRules(rules ...Rule) Option {
+	return func(o *option) {
+		o.rules = rules
+	}
+}
+
+// OptFailover sets the failover chains of the Manager
+//
+// When a channel with a failover chain fails to deliver a message, the message is sent to the fallbacks
+// of the chain in order, with the same message ID, until one of them delivers it.
+//
+// Parameters:
+//   - failovers: The failover chains, one per primary channel
+//
+// Returns:
+//   - Option: A function that sets the failover chains
+func OptFailover(failovers ...Failover) Option {
+	return func(o *option) {
+		o.failovers = failovers
+	}
+}
+
 // New creates a new Manager instance with the provided options
 //
 // Parameters:
@@ -212,14 +301,26 @@ func New(options ...Option) (*Manager, error) {
 
 	// Create a new Manager instance
 	m := &Manager{
-		channelStatus: map[Channel]bool{
-			LarkChan:     opt.larkConfig.Enabled,
-			DingTalkChan: opt.dingTalkConfig.Enabled,
-			WechatChan:   opt.wechatConfig.Enabled,
-			TelegramChan: opt.telegramConfig.Enabled,
-			BarkChan:     opt.barkConfig.Enabled,
-			EmailChan:    opt.emailConfig.Enabled,
-		},
+		senders:     make(map[Channel]Sender),
+		subscribers: make(map[int]func(SendResult)),
+		results:     make(chan published, resultQueueSize),
+		watches:     make(map[watchKey]watch),
+		deadLetters: opt.deadLetters,
+		inflight:    make(map[watchKey]*chain),
+	}
+
+	// Validate the failover chains before starting any channel
+	m.failovers, err = newFailovers(opt.failovers)
+	if err != nil {
+		return m, err
+	}
+
+	// Compile the routing rules before starting any channel
+	if len(opt.rules) > 0 {
+		m.router, err = NewRouter(opt.rules...)
+		if err != nil {
+			return m, err
+		}
 	}
 
 	// Initialize enabled channels
@@ -229,6 +330,7 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.Lark.StartProcessor()
+		m.senders[LarkChan] = larkSender{n: m.Lark}
 	}
 
 	if opt.dingTalkConfig.Enabled {
@@ -237,6 +339,7 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.DingTalk.StartProcessor()
+		m.senders[DingTalkChan] = dingSender{n: m.DingTalk}
 	}
 
 	if opt.wechatConfig.Enabled {
@@ -245,6 +348,7 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.Wechat.StartProcessor()
+		m.senders[WechatChan] = wechatSender{n: m.Wechat}
 	}
 
 	if opt.telegramConfig.Enabled {
@@ -253,6 +357,7 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.Telegram.StartProcessor()
+		m.senders[TelegramChan] = telegramSender{n: m.Telegram}
 	}
 
 	if opt.barkConfig.Enabled {
@@ -261,6 +366,7 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.Bark.StartProcessor()
+		m.senders[BarkChan] = barkSender{n: m.Bark}
 	}
 
 	if opt.emailConfig.Enabled {
@@ -269,6 +375,12 @@ func New(options ...Option) (*Manager, error) {
 			return m, err
 		}
 		m.Email.StartProcessor()
+		m.senders[EmailChan] = emailSender{n: m.Email}
+	}
+
+	// Forward the results of the built-in channels to the subscribers
+	for channel, s := range m.senders {
+		m.forwardResults(channel, s)
 	}
 
 	// Set default channel and level
@@ -282,6 +394,7 @@ func New(options ...Option) (*Manager, error) {
 // submit is an internal method to submit a message to specified channels
 //
 // Parameters:
+//   - ctx: The context of the message, passed to the senders implementing ContextSender
 //   - level: The severity level of the message
 //   - sendTo: The recipient of the message
 //   - title: The title of the message
@@ -291,75 +404,102 @@ func New(options ...Option) (*Manager, error) {
 // Returns:
 //   - string: The message ID
 //   - error: An error if any occurred during submission
-func (m *Manager) submit(level Level, sendTo, title, content string, channels ...Channel) (string, error) {
-	// Validate input parameters
-	if title == "" && content == "" {
-		return "", InvalidParams
+func (m *Manager) submit(ctx context.Context, level Level, sendTo, title, content string, channels ...Channel) (string, error) {
+	return m.dispatch(ctx, Message{Level: level, SendTo: sendTo, Title: title, Content: content}, channels)
+}
+
+// dispatch submits a message to the given channels, or to the targets chosen by the routing rules
+//
+// Parameters:
+//   - ctx: The context of the message, passed to the senders implementing ContextSender
+//   - message: The message to be sent
+//   - channels: The channels to send the message through, routed if empty
+//
+// Returns:
+//   - string: The message ID
+//   - error: An error if any occurred during submission
+func (m *Manager) dispatch(ctx context.Context, message Message, channels []Channel) (string, error) {
+	message, targets, err := m.newMessage(message, channels)
+	if err != nil {
+		return "", err
 	}
 
-	// Use default channel if none specified
-	channelCount := len(channels)
-	if channelCount == 0 {
-		channels = append(channels, m.defaultChannel)
+	// Submit message to each target
+	for _, target := range targets {
+		m.submitTarget(ctx, target, message)
+	}
+
+	return message.ID, nil
+}
+
+// newMessage validates a message, applies the defaults, generates its ID and resolves its targets
+//
+// Parameters:
+//   - message: The message to be sent
+//   - channels: The channels to send the message through
+//
+// Returns:
+//   - Message: The message to be sent
+//   - []Target: The channels given, or if none, the targets chosen by the routing rules,
+//     or if no rule matches, the default channel
+//   - error: InvalidParams if the message has neither title nor content
+func (m *Manager) newMessage(message Message, channels []Channel) (Message, []Target, error) {
+	// Validate input parameters
+	if message.Title == "" && message.Content == "" {
+		return Message{}, nil, InvalidParams
 	}
 
 	// Use default level if none specified
-	if level == "" {
-		level = m.defaultLevel
+	if message.Level == "" {
+		message.Level = m.defaultLevel
 	}
 
-	// Generate a new message ID
-	msgID := m.messageID.New()
+	if message.ID == "" {
+		message.ID = m.messageID.New()
+	}
 
-	var err error
-	// Submit message to each specified channel
+	targets := make([]Target, 0, len(channels))
 	for _, channel := range channels {
-		switch channel {
-		case LarkChan:
-			_, err = m.Lark.SubmitMessage(lark.Message{
-				ID:       msgID,
-				SendTo:   sendTo,
-				MsgLevel: string(level),
-				Title:    title,
-				Content:  content,
-			})
-			if err != nil {
-				log.Println(err)
-			}
-		case DingTalkChan:
-			m.DingTalk.SubmitMessage(ding.Message{
-				ID:      msgID,
-				Title:   title,
-				Content: content,
-			})
-		case WechatChan:
-			m.Wechat.SubmitMessage(wechat.Message{
-				ID:      msgID,
-				Title:   title,
-				Content: content,
-			})
-		case EmailChan:
-			m.Email.SubmitMessage(email.Message{
-				ID:      msgID,
-				Title:   title,
-				Content: content,
-			})
-		case TelegramChan:
-			m.Telegram.SubmitMessage(telegram.Message{
-				ID:      msgID,
-				Title:   title,
-				Content: content,
-			})
-		case BarkChan:
-			m.Bark.SubmitMessage(bark.Message{
-				ID:      msgID,
-				Title:   title,
-				Content: content,
-			})
-		}
+		targets = append(targets, Target{Channel: channel})
+	}
+
+	// Route the message if no channel is specified
+	if len(targets) == 0 && m.router != nil {
+		targets = m.router.Route(message)
+	}
+
+	// Use default channel if no rule matches
+	if len(targets) == 0 {
+		targets = []Target{{Channel: m.defaultChannel}}
 	}
 
-	return msgID, nil
+	return message, targets, nil
+}
+
+// Dispatch submits a message to the channels and recipients chosen by the routing rules
+//
+// The message is matched against the rules configured with OptRules, on its level, tags, title and source.
+// If no rule matches, it is sent to the default channel.
+//
+// Parameters:
+//   - ctx: The context of the message, passed to the senders implementing ContextSender
+//   - message: The message to be sent. Its ID is generated and its level defaulted if empty
+//
+// Returns:
+//   - string: The message ID
+//   - error: InvalidParams if the message has neither title nor content
+//
+// Example:
+//
+//	msgID, err := manager.Dispatch(ctx, notify.Message{
+//	    Level:   notify.ErrorLevel,
+//	    Title:   "Refund failed",
+//	    Content: "order 42: card declined",
+//	    Tags:    []string{"refunds"},
+//	    Source:  "payments",
+//	})
+func (m *Manager) Dispatch(ctx context.Context, message Message) (string, error) {
+	return m.dispatch(ctx, message, nil)
 }
 
 // Send submits a message with a specified level to the given channels
@@ -382,7 +522,146 @@ func (m *Manager) submit(level Level, sendTo, title, content string, channels ..
 //	    log.Printf("Failed to send message: %v", err)
 //	}
 func (m *Manager) Send(level Level, sendTo, title, content string, channels ...Channel) (string, error) {
-	return m.submit(level, sendTo, title, content, channels...)
+	return m.submit(context.Background(), level, sendTo, title, content, channels...)
+}
+
+// SendContext submits a message with a specified level to the given channels, bound to ctx
+//
+// Channels whose sender implements ContextSender, such as the built-in channels, drop the message if ctx is
+// done before it is delivered, and stop retrying it once ctx is cancelled. The other channels ignore ctx.
+//
+// Parameters:
+//   - ctx: The context of the message, typically the context of the request the notification belongs to
+//   - level: The severity level of the message
+//   - sendTo: The recipient of the message
+//   - title: The title of the message
+//   - content: The content of the message
+//   - channels: A variadic list of channels to send the message through
+//
+// Returns:
+//   - string: The message ID
+//   - error: An error if any occurred during sending
+//
+// Example:
+//
+//	msgID, err := manager.SendContext(r.Context(), WarnLevel, "user123", "Slow checkout", "took 12s", LarkChan)
+//	if err != nil {
+//	    log.Printf("Failed to send message: %v", err)
+//	}
+func (m *Manager) SendContext(ctx context.Context, level Level, sendTo, title, content string, channels ...Channel) (string, error) {
+	return m.submit(ctx, level, sendTo, title, content, channels...)
+}
+
+// SendSync sends a message with a specified level to the given channels and waits for every channel
+// to finish delivering it
+//
+// Channels are sent to concurrently. Channels whose sender does not implement SyncSender are submitted
+// as usual and reported as pending. If ctx is done before a channel finishes, its result is reported as
+// pending with the context error, and the delivery continues in the background.
+//
+// Parameters:
+//   - ctx: The context bounding the wait for the results
+//   - level: The severity level of the message
+//   - sendTo: The recipient of the message
+//   - title: The title of the message
+//   - content: The content of the message
+//   - channels: A variadic list of channels to send the message through
+//
+// Returns:
+//   - []SendResult: The result of every channel, in the order of channels
+//   - error: InvalidParams if the parameters are invalid, or the errors of all failed channels joined together
+//
+// Example:
+//
+//	results, err := manager.SendSync(ctx, ErrorLevel, "user123", "Disk full", "db-1 /var is 95% full", LarkChan, EmailChan)
+//	for _, r := range results {
+//	    log.Printf("%s: %s %s in %s (%v)", r.Channel, r.State, r.ProviderMsgID, r.Latency, r.Err)
+//	}
+func (m *Manager) SendSync(ctx context.Context, level Level, sendTo, title, content string, channels ...Channel) ([]SendResult, error) {
+	message := Message{Level: level, SendTo: sendTo, Title: title, Content: content}
+	message, targets, err := m.newMessage(message, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SendResult, len(targets))
+	done := make([]chan SendResult, len(targets))
+	for i, target := range targets {
+		done[i] = make(chan SendResult, 1)
+		c := m.newChain(ctx, target, message, true)
+		go func(c *chain, done chan<- SendResult) {
+			done <- m.sendChain(c)
+		}(c, done[i])
+	}
+
+	var errs []error
+	for i, target := range targets {
+		channel := target.Channel
+		select {
+		case results[i] = <-done[i]:
+		case <-ctx.Done():
+			results[i] = SendResult{Channel: channel, MsgID: message.ID, State: StatePending, Err: ctx.Err()}
+		}
+
+		if results[i].Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, results[i].Err))
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// sendSync delivers a message through a channel and measures the delivery
+//
+// Parameters:
+//   - ctx: The context of the delivery
+//   - channel: The channel to send the message through
+//   - message: The message to be sent
+//   - failoverFrom: The primary channel if channel is one of its failover fallbacks, empty otherwise
+//
+// Returns:
+//   - SendResult: The result of the delivery
+func (m *Manager) sendSync(ctx context.Context, channel Channel, message Message, failoverFrom Channel) SendResult {
+	sender, ok := m.sender(channel)
+	if !ok {
+		return SendResult{
+			Channel:      channel,
+			MsgID:        message.ID,
+			State:        StateFailed,
+			Err:          fmt.Errorf("notify channel %s is not enabled or registered", channel),
+			FailoverFrom: failoverFrom,
+		}
+	}
+
+	start := time.Now()
+
+	var result SendResult
+	if s, ok := sender.(SyncSender); ok {
+		result = s.SendSync(ctx, message)
+		result.State = StateSent
+		if result.Err != nil {
+			result.State = StateFailed
+		}
+	} else {
+		result.Err = submitContext(ctx, sender, message)
+		result.State = StatePending
+		if result.Err != nil {
+			result.State = StateFailed
+		}
+	}
+
+	result.Channel = channel
+	result.MsgID = message.ID
+	result.DeliveryID = message.DeliveryID
+	result.Latency = time.Since(start)
+	result.FailoverFrom = failoverFrom
+
+	// Publishers report their own results, and pending results are not an outcome
+	if _, ok := sender.(ResultPublisher); !ok && result.State != StatePending {
+		m.publish(result)
+	}
+
+	return result
 }
 
 // Info sends an info level message to the specified channels
@@ -404,7 +683,7 @@ func (m *Manager) Send(level Level, sendTo, title, content string, channels ...C
 //	    log.Printf("Failed to send info message: %v", err)
 //	}
 func (m *Manager) Info(sendTo, title, content string, channels ...Channel) (string, error) {
-	return m.submit(InfoLevel, sendTo, title, content, channels...)
+	return m.submit(context.Background(), InfoLevel, sendTo, title, content, channels...)
 }
 
 // Success sends a success level message to the specified channels
@@ -426,7 +705,7 @@ func (m *Manager) Info(sendTo, title, content string, channels ...Channel) (stri
 //	    log.Printf("Failed to send success message: %v", err)
 //	}
 func (m *Manager) Success(sendTo, title, content string, channels ...Channel) (string, error) {
-	return m.submit(SuccessLevel, sendTo, title, content, channels...)
+	return m.submit(context.Background(), SuccessLevel, sendTo, title, content, channels...)
 }
 
 // Error sends an error level message to the specified channels
@@ -448,7 +727,7 @@ func (m *Manager) Success(sendTo, title, content string, channels ...Channel) (s
 //	    log.Printf("Failed to send error message: %v", err)
 //	}
 func (m *Manager) Error(sendTo, title, content string, channels ...Channel) (string, error) {
-	return m.submit(ErrorLevel, sendTo, title, content, channels...)
+	return m.submit(context.Background(), ErrorLevel, sendTo, title, content, channels...)
 }
 
 // Warn sends a warning level message to the specified channels
@@ -470,31 +749,204 @@ func (m *Manager) Error(sendTo, title, content string, channels ...Channel) (str
 //	    log.Printf("Failed to send warning message: %v", err)
 //	}
 func (m *Manager) Warn(sendTo, title, content string, channels ...Channel) (string, error) {
-	return m.submit(WarnLevel, sendTo, title, content, channels...)
+	return m.submit(context.Background(), WarnLevel, sendTo, title, content, channels...)
+}
+
+// Register adds a custom notification channel to the Manager.
+// Messages sent to the channel get the same message ID and level as the other channels they are sent to.
+//
+// Parameters:
+//   - channel: The name of the channel, used with Send, Info, Success, Error and Warn
+//   - s: The Sender delivering the messages of the channel
+//
+// Returns:
+//   - error: InvalidParams if the channel or sender is empty, ChannelRegistered if the channel already has a sender
+//
+// Example:
+//
+//	const SMSChan notify.Channel = "sms"
+//
+//	err := manager.Register(SMSChan, smsSender)
+//	if err != nil {
+//	    log.Fatalf("Failed to register SMS channel: %v", err)
+//	}
+//	msgID, err := manager.Error("+8613800000000", "Disk full", "db-1 /var is 95% full", SMSChan)
+func (m *Manager) Register(channel Channel, s Sender) error {
+	if channel == "" || s == nil {
+		return InvalidParams
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.senders[channel]; ok {
+		return fmt.Errorf("%w: %s", ChannelRegistered, channel)
+	}
+
+	m.senders[channel] = s
+	m.forwardResults(channel, s)
+
+	return nil
 }
 
-// Close gracefully shuts down all enabled notification channels
+// Subscribe registers a handler called with the result of the deliveries of every channel.
+//
+// Channels whose sender implements ResultPublisher, such as the built-in channels, report every delivery attempt,
+// including retries and messages dropped before delivery. For the other channels, the results of
+// SendSync and the messages that could not be submitted are reported.
+// Handlers are called one at a time from a dedicated goroutine, in the order the results were published,
+// after the Manager has handled them for failover and dead-lettering. If the handlers cannot keep up,
+// the results beyond the queue of 1024 results are logged and discarded for them, but never for the Manager.
+//
+// Parameters:
+//   - handler: The function called with each SendResult
+//
+// Returns:
+//   - func(): A function removing the handler
+//
+// Example:
+//
+//	unsubscribe := manager.Subscribe(func(r notify.SendResult) {
+//	    deliveries.WithLabelValues(string(r.Channel), r.State).Inc()
+//	})
+//	defer unsubscribe()
+func (m *Manager) Subscribe(handler func(SendResult)) func() {
+	m.dispatchOnce.Do(func() {
+		m.dispatchWG.Add(1)
+		go m.dispatchResults()
+	})
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	id := m.nextSubscriberID
+	m.nextSubscriberID++
+	m.subscribers[id] = handler
+
+	return func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+
+		delete(m.subscribers, id)
+	}
+}
+
+// publish records a result for the dead letters and queues it for the subscribers.
+// It never blocks: if the subscribers cannot keep up and the queue is full, the result is discarded
+// for them and logged.
+func (m *Manager) publish(result SendResult) {
+	m.recordResult(result)
+
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+
+	if m.resultsClosed || len(m.subscribers) == 0 {
+		return
+	}
+
+	handlers := make([]func(SendResult), 0, len(m.subscribers))
+	for _, handler := range m.subscribers {
+		handlers = append(handlers, handler)
+	}
+
+	select {
+	case m.results <- published{result: result, handlers: handlers}:
+	default:
+		log.Printf("notify send result of message %s discarded, subscribers are too slow\n", result.MsgID)
+	}
+}
+
+// dispatchResults passes the queued results to their handlers until results is closed.
+func (m *Manager) dispatchResults() {
+	defer m.dispatchWG.Done()
+
+	for p := range m.results {
+		for _, handler := range p.handlers {
+			handler(p.result)
+		}
+	}
+}
+
+// forwardResults subscribes to the results of a sender implementing ResultPublisher,
+// and publishes them with the channel they were sent through.
+func (m *Manager) forwardResults(channel Channel, s Sender) {
+	p, ok := s.(ResultPublisher)
+	if !ok {
+		return
+	}
+
+	p.Subscribe(func(r SendResult) {
+		r.Channel = channel
+		c := m.handleResult(&r)
+		m.publish(r)
+
+		// Fail over once the failure is recorded, so that it precedes the failures of the fallbacks
+		if c != nil {
+			m.goFailover(c)
+		}
+	})
+}
+
+// sender returns the Sender of a channel.
+func (m *Manager) sender(channel Channel) (Sender, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.senders[channel]
+
+	return s, ok
+}
+
+// isFallback reports whether a channel is the fallback of a failover chain.
+func (m *Manager) isFallback(channel Channel) bool {
+	for _, f := range m.failovers {
+		for _, t := range f.Fallbacks {
+			if t.Channel == channel {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Close gracefully shuts down all enabled and registered notification channels
 //
 // This method should be called when the Manager is no longer needed to ensure
 // proper cleanup of resources.
 func (m *Manager) Close() {
-	// Iterate through all channels and close enabled ones
-	for channel, status := range m.channelStatus {
-		if status {
-			switch channel {
-			case LarkChan:
-				m.Lark.Close()
-			case DingTalkChan:
-				m.DingTalk.Close()
-			case WechatChan:
-				m.Wechat.Close()
-			case EmailChan:
-				m.Email.Close()
-			case TelegramChan:
-				m.Telegram.Close()
-			case BarkChan:
-				m.Bark.Close()
-			}
+	// The lock is not held while waiting for the failovers, which look up the senders
+	m.mu.RLock()
+	primaries := make([]Sender, 0, len(m.senders))
+	fallbacks := make([]Sender, 0, len(m.senders))
+	for channel, s := range m.senders {
+		if m.isFallback(channel) {
+			fallbacks = append(fallbacks, s)
+		} else {
+			primaries = append(primaries, s)
 		}
 	}
+	m.mu.RUnlock()
+
+	// Close the channels that are not fallbacks first, as the failures of the messages
+	// they still have to deliver may fail over to the fallbacks
+	for _, s := range primaries {
+		s.Close()
+	}
+	m.failoverWG.Wait()
+
+	// The messages failing over from a closed fallback are refused by the next ones, and dead-lettered
+	for _, s := range fallbacks {
+		s.Close()
+	}
+	m.failoverWG.Wait()
+
+	// Pass the remaining results to the subscribers
+	m.subMu.Lock()
+	if !m.resultsClosed {
+		m.resultsClosed = true
+		close(m.results)
+	}
+	m.subMu.Unlock()
+	m.dispatchWG.Wait()
 }
@@ -0,0 +1,374 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errSenderClosed is returned by a fakeSender submitted a message after Close.
+var errSenderClosed = errors.New("sender is closed")
+
+// fakeSender records the messages submitted to it.
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+	closed   bool
+	err      error
+}
+
+func (s *fakeSender) Submit(message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSenderClosed
+	}
+
+	s.messages = append(s.messages, message)
+
+	return s.err
+}
+
+func (s *fakeSender) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+}
+
+func (s *fakeSender) received() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+const (
+	smsChan   Channel = "sms"
+	pagerChan Channel = "pager"
+)
+
+func TestManager_Register(t *testing.T) {
+	m, err := New(OptDefaultChannel(smsChan), OptDefaultLevel(WarnLevel))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sms := &fakeSender{}
+	if err = m.Register(smsChan, sms); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if err = m.Register(smsChan, &fakeSender{}); !errors.Is(err, ChannelRegistered) {
+		t.Errorf("Register() error = %v, want ChannelRegistered", err)
+	}
+	if err = m.Register("", sms); !errors.Is(err, InvalidParams) {
+		t.Errorf("Register() error = %v, want InvalidParams", err)
+	}
+	if err = m.Register(pagerChan, nil); !errors.Is(err, InvalidParams) {
+		t.Errorf("Register() error = %v, want InvalidParams", err)
+	}
+
+	pager := &fakeSender{err: errors.New("pager is down")}
+	if err = m.Register(pagerChan, pager); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	msgID, err := m.Error("+8613800000000", "Disk full", "db-1 /var is 95% full", smsChan, pagerChan)
+	if err != nil {
+		t.Fatalf("Error() error = %v", err)
+	}
+
+	want := Message{ID: msgID, Level: ErrorLevel, SendTo: "+8613800000000", Title: "Disk full", Content: "db-1 /var is 95% full"}
+	for name, s := range map[string]*fakeSender{"sms": sms, "pager": pager} {
+		got := s.received()
+		if len(got) == 1 && got[0].DeliveryID != "" {
+			// The DeliveryID is generated by the Manager for each target
+			got[0].DeliveryID = ""
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("%s received %+v, want %+v", name, got, want)
+		}
+	}
+
+	// Default channel and level
+	msgID, err = m.Send("", "+8613800000000", "", "ping")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := sms.received(); len(got) != 2 || got[1].ID != msgID || got[1].Level != WarnLevel {
+		t.Errorf("sms received %+v, want default level %s", got, WarnLevel)
+	}
+
+	// Unknown channels are skipped
+	if _, err = m.Info("", "Title", "Content", "unknown", smsChan); err != nil {
+		t.Errorf("Info() error = %v", err)
+	}
+	if got := sms.received(); len(got) != 3 {
+		t.Errorf("sms received %d messages, want 3", len(got))
+	}
+
+	m.Close()
+	if !sms.closed || !pager.closed {
+		t.Errorf("Close() did not close registered senders")
+	}
+}
+
+// fakeSyncSender delivers messages synchronously, blocking until release is closed if it is set.
+// If sendErr is set, it queues messages but fails to deliver them.
+type fakeSyncSender struct {
+	fakeSender
+	release chan struct{}
+	sendErr error
+}
+
+func (s *fakeSyncSender) SendSync(ctx context.Context, message Message) SendResult {
+	if s.release != nil {
+		<-s.release
+	}
+
+	if s.sendErr != nil {
+		return SendResult{Err: s.sendErr, Attempts: 1}
+	}
+
+	if err := s.Submit(message); err != nil {
+		return SendResult{Err: err, Attempts: 1}
+	}
+
+	return SendResult{ProviderMsgID: "provider-" + message.ID, Attempts: 1}
+}
+
+func TestManager_SendSync(t *testing.T) {
+	m, err := New(OptDefaultChannel(smsChan))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	sms := &fakeSyncSender{}
+	pager := &fakeSyncSender{fakeSender: fakeSender{err: errors.New("pager is down")}}
+	queue := &fakeSender{}
+	for channel, s := range map[Channel]Sender{smsChan: sms, pagerChan: pager, "queue": queue} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	results, err := m.SendSync(context.Background(), ErrorLevel, "oncall", "Disk full", "95%", smsChan, pagerChan, "queue", "unknown")
+	if err == nil {
+		t.Error("SendSync() error = nil, want errors of pager and unknown")
+	}
+
+	want := []struct {
+		channel       Channel
+		state         string
+		providerMsgID bool
+		failed        bool
+		attempts      int
+	}{
+		{smsChan, StateSent, true, false, 1},
+		{pagerChan, StateFailed, false, true, 1},
+		{"queue", StatePending, false, false, 0},
+		{"unknown", StateFailed, false, true, 0},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("SendSync() returned %d results, want %d", len(results), len(want))
+	}
+
+	msgID := results[0].MsgID
+	for i, w := range want {
+		r := results[i]
+		if r.Channel != w.channel || r.State != w.state || (r.Err != nil) != w.failed || r.Attempts != w.attempts {
+			t.Errorf("results[%d] = %+v, want %+v", i, r, w)
+		}
+		if (r.ProviderMsgID != "") != w.providerMsgID || r.MsgID != msgID {
+			t.Errorf("results[%d] IDs = %q, %q, want message ID %q", i, r.MsgID, r.ProviderMsgID, msgID)
+		}
+	}
+
+	if got := queue.received(); len(got) != 1 || got[0].ID != msgID || got[0].Level != ErrorLevel {
+		t.Errorf("queue received %+v, want the message submitted", got)
+	}
+
+	if _, err = m.SendSync(context.Background(), InfoLevel, "", "", ""); !errors.Is(err, InvalidParams) {
+		t.Errorf("SendSync() error = %v, want InvalidParams", err)
+	}
+}
+
+func TestManager_SendSync_ContextDone(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	slow := &fakeSyncSender{release: make(chan struct{})}
+	if err = m.Register(smsChan, slow); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	results, err := m.SendSync(ctx, InfoLevel, "", "Title", "Content", smsChan)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendSync() error = %v, want context.DeadlineExceeded", err)
+	}
+	if len(results) != 1 || results[0].State != StatePending {
+		t.Errorf("SendSync() = %+v, want pending result", results)
+	}
+
+	close(slow.release)
+	m.Close()
+}
+
+// fakePublisher is a sender reporting a retry and the delivery of every message submitted to it.
+type fakePublisher struct {
+	fakeSender
+	handler func(SendResult)
+}
+
+func (s *fakePublisher) Submit(message Message) error {
+	s.handler(SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, State: StateRetrying, Attempts: 1})
+	s.handler(SendResult{MsgID: message.ID, DeliveryID: message.DeliveryID, State: StateSent, Attempts: 2})
+
+	return s.fakeSender.Submit(message)
+}
+
+func (s *fakePublisher) Subscribe(handler func(SendResult)) func() {
+	s.handler = handler
+
+	return func() {}
+}
+
+func TestManager_Subscribe(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sms := &fakeSyncSender{}
+	pager := &fakePublisher{}
+	for channel, s := range map[Channel]Sender{smsChan: sms, pagerChan: pager} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var got []SendResult
+	unsubscribe := m.Subscribe(func(r SendResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		r.Err = nil
+		r.Latency = 0
+		r.DeliveryID = ""
+		got = append(got, r)
+	})
+
+	msgID, err := m.Warn("", "Title", "Content", pagerChan, "unknown")
+	if err != nil {
+		t.Fatalf("Warn() error = %v", err)
+	}
+
+	syncResults, err := m.SendSync(context.Background(), InfoLevel, "", "Title", "Content", smsChan)
+	if err != nil {
+		t.Fatalf("SendSync() error = %v", err)
+	}
+
+	unsubscribe()
+	if _, err = m.Warn("", "Title", "Content", pagerChan); err != nil {
+		t.Fatalf("Warn() error = %v", err)
+	}
+
+	// Close passes the queued results to the handlers
+	m.Close()
+
+	want := []SendResult{
+		{Channel: pagerChan, MsgID: msgID, State: StateRetrying, Attempts: 1},
+		{Channel: pagerChan, MsgID: msgID, State: StateSent, Attempts: 2},
+		{Channel: "unknown", MsgID: msgID, State: StateDropped},
+		{Channel: smsChan, MsgID: syncResults[0].MsgID, ProviderMsgID: syncResults[0].ProviderMsgID, State: StateSent, Attempts: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("published %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// fakeContextSender records the contexts the messages are submitted with.
+type fakeContextSender struct {
+	fakeSender
+	contexts []context.Context
+}
+
+func (s *fakeContextSender) SubmitContext(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	s.contexts = append(s.contexts, ctx)
+	s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Submit(message)
+}
+
+func TestManager_SendContext(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sms := &fakeContextSender{}
+	pager := &fakeSender{}
+	for channel, s := range map[Channel]Sender{smsChan: sms, pagerChan: pager} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	var dropped []SendResult
+	m.Subscribe(func(r SendResult) { dropped = append(dropped, r) })
+
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "request"))
+
+	if _, err = m.SendContext(ctx, InfoLevel, "", "Title", "Content", smsChan, pagerChan); err != nil {
+		t.Fatalf("SendContext() error = %v", err)
+	}
+
+	cancel()
+	if _, err = m.SendContext(ctx, InfoLevel, "", "Title", "Content", smsChan, pagerChan); err != nil {
+		t.Fatalf("SendContext() error = %v", err)
+	}
+
+	// Close passes the queued results to the handlers
+	m.Close()
+
+	if len(sms.contexts) != 2 || sms.contexts[0].Value(key{}) != "request" {
+		t.Errorf("sms submitted with %v, want the request context", sms.contexts)
+	}
+	if got := sms.received(); len(got) != 1 {
+		t.Errorf("sms received %d messages, want 1", len(got))
+	}
+	if got := pager.received(); len(got) != 2 {
+		t.Errorf("pager received %d messages, want 2", len(got))
+	}
+	if len(dropped) != 1 || dropped[0].Channel != smsChan || !errors.Is(dropped[0].Err, context.Canceled) {
+		t.Errorf("published %+v, want sms dropped with context.Canceled", dropped)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrDuplicate is returned when a message is appended to a journal again while it is pending.
+var ErrDuplicate = errors.New("message is already in the outbox")
+
+// Journal persists the queued messages of a channel to an outbox, encoding them as JSON.
+// The messages are identified by a key, built with Key from their ID and their target, so that a message
+// sent to several targets is queued once per target.
+//
+// A nil Journal is a disabled outbox: it accepts every message and recovers none.
+type Journal[M any] struct {
+	outbox *Outbox
+
+	// name is the name of the channel, used in errors and logs.
+	name string
+}
+
+// Entry is a message recovered from a journal.
+type Entry[M any] struct {
+	// Key is the key the message was appended with.
+	Key string
+
+	// Message is the decoded message.
+	Message M
+}
+
+// OpenJournal opens the journal of a channel, recovering the messages still pending in its outbox.
+//
+// Parameters:
+//   - name: The name of the channel, e.g. "lark", used in errors and logs.
+//   - config: The configuration of the outbox.
+//
+// Returns:
+//   - *Journal[M]: The journal, nil if the outbox is disabled.
+//   - error: An error if the outbox cannot be opened.
+//
+// Example:
+//
+//	journal, err := outbox.OpenJournal[Message]("lark", config.Outbox)
+//	if err != nil {
+//	    log.Fatalf("Failed to open journal: %v", err)
+//	}
+//	defer journal.Close()
+func OpenJournal[M any](name string, config Config) (*Journal[M], error) {
+	if config.Dir == "" {
+		return nil, nil
+	}
+
+	o, err := Open(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s outbox: %w", name, err)
+	}
+
+	return &Journal[M]{outbox: o, name: name}, nil
+}
+
+// Key returns the key of a message delivered to a target.
+//
+// Parameters:
+//   - id: The ID of the message.
+//   - target: The fields identifying the target of the message, e.g. its bot and recipient.
+//
+// Returns:
+//   - string: The key of the message.
+func Key(id string, target ...string) string {
+	return strings.Join(append([]string{id}, target...), "|")
+}
+
+// Append writes a message to the journal before it is queued.
+//
+// Parameters:
+//   - key: The key of the message, returned by Key.
+//   - m: The message.
+//
+// Returns:
+//   - error: ErrDuplicate if a message with the same key is pending, in which case it must not be queued again,
+//     or an error if the message cannot be written.
+func (j *Journal[M]) Append(key string, m M) error {
+	if j == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", j.name, err)
+	}
+
+	added, err := j.outbox.Append(key, data)
+	if err != nil {
+		return fmt.Errorf("failed to persist %s message: %w", j.name, err)
+	}
+	if !added {
+		return fmt.Errorf("%s message %s: %w", j.name, key, ErrDuplicate)
+	}
+
+	return nil
+}
+
+// Recover returns the messages left in the journal by the previous run, in the order they were written.
+// The messages that cannot be decoded are logged and marked done.
+//
+// Returns:
+//   - []Entry[M]: The recovered messages.
+func (j *Journal[M]) Recover() []Entry[M] {
+	if j == nil {
+		return nil
+	}
+
+	records := j.outbox.Recover()
+	entries := make([]Entry[M], 0, len(records))
+	for _, r := range records {
+		var m M
+		if err := json.Unmarshal(r.Data, &m); err != nil {
+			log.Printf("failed to decode %s message %s from outbox: %v\n", j.name, r.ID, err)
+			j.Done(r.ID)
+			continue
+		}
+
+		entries = append(entries, Entry[M]{Key: r.ID, Message: m})
+	}
+
+	return entries
+}
+
+// Done removes a message delivered or given up on from the journal. Failures are logged, as the message
+// is only delivered again after a restart.
+//
+// Parameters:
+//   - key: The key of the message.
+func (j *Journal[M]) Done(key string) {
+	if j == nil {
+		return
+	}
+
+	if err := j.outbox.Done(key); err != nil {
+		log.Printf("failed to mark %s message %s done in outbox: %v\n", j.name, key, err)
+	}
+}
+
+// Close closes the journal, keeping the messages that could not be delivered for the next start.
+func (j *Journal[M]) Close() {
+	if j == nil {
+		return
+	}
+
+	if err := j.outbox.Close(); err != nil {
+		log.Printf("failed to close %s outbox: %v\n", j.name, err)
+	}
+}
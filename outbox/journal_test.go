@@ -0,0 +1,92 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package outbox
+
+import (
+	"errors"
+	"testing"
+)
+
+type testMessage struct {
+	ID     string
+	SendTo string
+}
+
+func TestKey(t *testing.T) {
+	if got := Key("msg-1", "bot", "oncall"); got != "msg-1|bot|oncall" {
+		t.Errorf("Key() = %s, want msg-1|bot|oncall", got)
+	}
+	if Key("msg-1", "", "alice") == Key("msg-1", "alice", "") {
+		t.Error("Key() of different targets are equal")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	// A disabled journal accepts every message and recovers none
+	disabled, err := OpenJournal[testMessage]("test", Config{})
+	if err != nil || disabled != nil {
+		t.Fatalf("OpenJournal() of a disabled outbox = %v, %v, want nil, nil", disabled, err)
+	}
+	if err = disabled.Append("a", testMessage{}); err != nil {
+		t.Errorf("Append() to a disabled journal error = %v", err)
+	}
+	if got := disabled.Recover(); got != nil {
+		t.Errorf("Recover() of a disabled journal = %v, want none", got)
+	}
+	disabled.Done("a")
+	disabled.Close()
+
+	if _, err = OpenJournal[testMessage]("test", Config{Dir: t.TempDir(), Sync: "sometimes"}); err == nil {
+		t.Error("OpenJournal() of an invalid config error = nil")
+	}
+
+	config := Config{Dir: t.TempDir()}
+	j, err := OpenJournal[testMessage]("test", config)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+
+	// A message is queued once per target
+	alice, bob := Key("msg-1", "alice"), Key("msg-1", "bob")
+	for _, key := range []string{alice, bob} {
+		if err = j.Append(key, testMessage{ID: "msg-1", SendTo: key}); err != nil {
+			t.Fatalf("Append(%s) error = %v", key, err)
+		}
+	}
+	if err = j.Append(alice, testMessage{ID: "msg-1"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Append() again error = %v, want ErrDuplicate", err)
+	}
+
+	j.Done(alice)
+
+	// A record that is not a message is given up on when recovered
+	if _, err = j.outbox.Append("invalid", []byte(`"not a message"`)); err != nil {
+		t.Fatalf("Append() of an invalid record error = %v", err)
+	}
+	j.Close()
+
+	j, err = OpenJournal[testMessage]("test", config)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+
+	got := j.Recover()
+	if len(got) != 1 || got[0].Key != bob || got[0].Message != (testMessage{ID: "msg-1", SendTo: bob}) {
+		t.Fatalf("Recover() = %+v, want the message of bob", got)
+	}
+
+	j.Done(bob)
+	j.Close()
+
+	j, err = OpenJournal[testMessage]("test", config)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	defer j.Close()
+
+	if got = j.Recover(); len(got) != 0 || j.outbox.Len() != 0 {
+		t.Errorf("Recover() after done = %+v, want none", got)
+	}
+}
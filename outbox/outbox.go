@@ -0,0 +1,704 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package outbox provides the write-ahead log the notification channels persist their queued messages to,
+// so that the messages survive a crash or a restart.
+//
+// Records are appended to segment files, one JSON object per line. A message is written when it is queued,
+// and marked done once it is delivered or given up on. When a segment is full, a new one is started, and the
+// old segments are compacted if at most half of their messages are still pending: these are copied to the new
+// segment and the old segments are removed. When the outbox is opened, the messages still pending are recovered,
+// to be delivered again, and all the old segments are compacted.
+//
+// Delivery is exactly-once-ish: a message is not written again while it is pending, but a message delivered
+// right before a crash, whose done mark was not written yet, is delivered again. Once done, a message can be
+// written again with the same ID, e.g. when it is replayed.
+//
+// A record torn by a failed write is truncated, or left behind by starting a new segment if the segment file
+// cannot be truncated. Torn records found when the outbox is opened are logged and skipped.
+//
+// The channels use an outbox through a Journal, which encodes their messages and identifies them by
+// their ID and their target.
+package outbox
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sync policies of an outbox.
+const (
+	// SyncAlways flushes every record to disk before Append and Done return. It is the default.
+	SyncAlways SyncPolicy = "always"
+
+	// SyncInterval flushes the records to disk every SyncInterval. A crash may lose the messages
+	// queued during the last interval.
+	SyncInterval SyncPolicy = "interval"
+
+	// SyncNever leaves flushing the records to the operating system. The messages survive a restart
+	// of the process, but not necessarily a crash of the machine.
+	SyncNever SyncPolicy = "never"
+)
+
+// Defaults of a Config.
+const (
+	// DefaultSyncInterval is the default interval of SyncInterval.
+	DefaultSyncInterval = time.Second
+
+	// DefaultSegmentSize is the default size of a segment file, in bytes.
+	DefaultSegmentSize = 16 << 20
+)
+
+// segmentExt is the extension of the segment files.
+const segmentExt = ".log"
+
+// ErrClosed is returned when writing to a closed outbox.
+var ErrClosed = errors.New("outbox is closed")
+
+// SyncPolicy defines when the records of an outbox are flushed to disk.
+type SyncPolicy string
+
+// Config represents the configuration of an outbox.
+type Config struct {
+	// Dir is the directory of the segment files, created if it does not exist.
+	// It must not be shared with another outbox. The outbox is disabled if Dir is empty.
+	Dir string
+
+	// Sync is the sync policy: SyncAlways, SyncInterval or SyncNever. Defaults to SyncAlways.
+	Sync SyncPolicy
+
+	// SyncInterval is the interval of SyncInterval. Defaults to 1 second.
+	SyncInterval time.Duration
+
+	// SegmentSize is the size, in bytes, after which a new segment file is started and the old ones
+	// are compacted. Defaults to 16 MiB.
+	SegmentSize int64
+}
+
+// Validate applies the defaults to the unset fields of the configuration and checks the others.
+//
+// Returns:
+//   - error: An error if a field is invalid.
+func (c *Config) Validate() error {
+	switch c.Sync {
+	case "":
+		c.Sync = SyncAlways
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return fmt.Errorf("invalid outbox sync policy: %s", c.Sync)
+	}
+
+	if c.SyncInterval < 0 || c.SegmentSize < 0 {
+		return errors.New("outbox sync interval and segment size cannot be negative")
+	}
+
+	if c.SyncInterval == 0 {
+		c.SyncInterval = DefaultSyncInterval
+	}
+
+	if c.SegmentSize == 0 {
+		c.SegmentSize = DefaultSegmentSize
+	}
+
+	return nil
+}
+
+// Record is a message persisted to an outbox.
+type Record struct {
+	// ID is the ID of the message.
+	ID string
+
+	// Data is the JSON encoding of the message.
+	Data json.RawMessage
+}
+
+// entry is a line of a segment file: a message, or the done mark of a message if Done is set.
+type entry struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+	Done bool            `json:"done,omitempty"`
+}
+
+// segment is a segment file.
+type segment struct {
+	seq uint64
+
+	// records is the number of messages written to the segment.
+	records int
+
+	// pending is the number of messages of the segment not done yet.
+	pending int
+}
+
+// pendingRecord is a message not done yet, with the segment it was written to.
+type pendingRecord struct {
+	Record
+	seq uint64
+
+	// order is the rank of the message among the messages written, to keep them in order when they are copied.
+	order uint64
+}
+
+// Outbox is a write-ahead log of the messages of a channel.
+// It is safe for concurrent use.
+type Outbox struct {
+	config Config
+
+	mu sync.Mutex
+
+	// segments are the segment files, oldest first. The last one is written to.
+	segments []*segment
+
+	// file is the segment file written to.
+	file *os.File
+
+	// size is the size of the segment file written to.
+	size int64
+
+	// pending maps the IDs of the messages not done yet to their record.
+	pending map[string]*pendingRecord
+
+	// recovered are the messages pending when the outbox was opened, until Recover returns them.
+	recovered []Record
+
+	// written is the number of messages written, which ranks them.
+	written uint64
+
+	// dirty reports whether records were written since the last sync, with SyncInterval.
+	dirty bool
+
+	// torn reports whether the segment file ends with a torn record that could not be discarded.
+	torn bool
+
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open opens the outbox of a directory, recovering the messages still pending in its segment files.
+// The segment files are compacted into a new one.
+//
+// Parameters:
+//   - config: The configuration of the outbox.
+//
+// Returns:
+//   - *Outbox: The outbox.
+//   - error: An error if the configuration is invalid, or the segment files cannot be read or written.
+//
+// Example:
+//
+//	o, err := outbox.Open(outbox.Config{Dir: "/var/lib/myapp/outbox/lark"})
+//	if err != nil {
+//	    log.Fatalf("Failed to open outbox: %v", err)
+//	}
+//	defer o.Close()
+func Open(config Config) (*Outbox, error) {
+	if config.Dir == "" {
+		return nil, errors.New("outbox dir is required")
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox dir: %w", err)
+	}
+
+	o := &Outbox{
+		config:  config,
+		pending: make(map[string]*pendingRecord),
+		stop:    make(chan struct{}),
+	}
+
+	seqs, err := o.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seq := range seqs {
+		if err = o.load(seq); err != nil {
+			return nil, err
+		}
+	}
+
+	// Order the recovered messages as they were written
+	records := make([]*pendingRecord, 0, len(o.pending))
+	for _, r := range o.pending {
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b *pendingRecord) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	for _, r := range records {
+		o.recovered = append(o.recovered, r.Record)
+	}
+
+	var next uint64 = 1
+	if len(seqs) > 0 {
+		next = seqs[len(seqs)-1] + 1
+	}
+
+	if err = o.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	// Compact all the old segments, which also drops the records torn by a crash or a failed write
+	o.compact(true)
+
+	if o.config.Sync == SyncInterval {
+		o.wg.Add(1)
+		go o.syncLoop()
+	}
+
+	return o, nil
+}
+
+// Append writes a message to the outbox. It must be called before the message is acknowledged.
+//
+// Parameters:
+//   - id: The ID of the message.
+//   - data: The JSON encoding of the message.
+//
+// Returns:
+//   - bool: false if a message with the same ID is pending, in which case it is not written.
+//     A message marked done can be written again.
+//   - error: An error if the message cannot be written.
+func (o *Outbox) Append(id string, data []byte) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return false, ErrClosed
+	}
+
+	if _, ok := o.pending[id]; ok {
+		return false, nil
+	}
+
+	if err := o.write(entry{ID: id, Data: data}); err != nil {
+		return false, err
+	}
+
+	seg := o.segments[len(o.segments)-1]
+	seg.records++
+	seg.pending++
+	o.written++
+	o.pending[id] = &pendingRecord{Record: Record{ID: id, Data: data}, seq: seg.seq, order: o.written}
+
+	if o.size >= o.config.SegmentSize {
+		o.roll()
+	}
+
+	return true, nil
+}
+
+// Done marks a message as done, once it is delivered or given up on, so that it is not recovered.
+// Marking a message that is not pending is not an error.
+//
+// Parameters:
+//   - id: The ID of the message.
+//
+// Returns:
+//   - error: An error if the done mark cannot be written.
+func (o *Outbox) Done(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return ErrClosed
+	}
+
+	r, ok := o.pending[id]
+	if !ok {
+		return nil
+	}
+
+	if err := o.write(entry{ID: id, Done: true}); err != nil {
+		return err
+	}
+
+	delete(o.pending, id)
+	if seg := o.segment(r.seq); seg != nil {
+		seg.pending--
+	}
+
+	return nil
+}
+
+// Recover returns the messages that were pending when the outbox was opened, in the order they were written.
+// They are returned once, and stay pending until they are marked done.
+//
+// Returns:
+//   - []Record: The recovered messages.
+func (o *Outbox) Recover() []Record {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	records := o.recovered
+	o.recovered = nil
+
+	return records
+}
+
+// Len returns the number of pending messages.
+//
+// Returns:
+//   - int: The number of messages written and not done yet.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pending)
+}
+
+// Close flushes the outbox to disk and closes its segment file. The pending messages are recovered
+// when the outbox is opened again.
+//
+// Returns:
+//   - error: An error if the segment file cannot be flushed or closed.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mu.Unlock()
+
+	close(o.stop)
+	o.wg.Wait()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := o.file.Sync()
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to close outbox: %w", err)
+	}
+
+	return nil
+}
+
+// write appends an entry to the segment file, and flushes it according to the sync policy.
+// A record partially written is discarded, so that the next records do not follow a torn line.
+// It must be called with mu held.
+func (o *Outbox) write(e entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox record: %w", err)
+	}
+
+	// The previous record was torn and could not be discarded, so start on a new line
+	if o.torn {
+		line = append([]byte{'\n'}, line...)
+	}
+
+	n, err := o.file.Write(append(line, '\n'))
+	if err != nil {
+		o.discard(n)
+		return fmt.Errorf("failed to write outbox record: %w", err)
+	}
+
+	o.size += int64(n)
+	o.torn = false
+
+	switch o.config.Sync {
+	case SyncAlways:
+		if err = o.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync outbox: %w", err)
+		}
+	case SyncInterval:
+		o.dirty = true
+	}
+
+	return nil
+}
+
+// discard removes the n bytes of a record partially written to the segment file, by truncating it back
+// to its size before the record. If it cannot be truncated, a new segment file is started instead, leaving
+// the torn record at the end of the old one, to be skipped when the outbox is opened.
+// It must be called with mu held.
+func (o *Outbox) discard(n int) {
+	if n == 0 {
+		return
+	}
+
+	err := o.file.Truncate(o.size)
+	if err == nil {
+		return
+	}
+
+	log.Printf("failed to truncate torn outbox record: %v\n", err)
+
+	o.size += int64(n)
+	o.torn = true
+
+	old := o.file
+	if err = o.openSegment(o.segments[len(o.segments)-1].seq + 1); err != nil {
+		log.Printf("failed to start outbox segment: %v\n", err)
+		return
+	}
+
+	o.torn = false
+	if err = old.Sync(); err != nil {
+		log.Printf("failed to sync outbox segment: %v\n", err)
+	}
+	_ = old.Close()
+}
+
+// roll starts a new segment file and compacts the old ones. Failures are logged, as the records
+// can still be written to the full segment file.
+// It must be called with mu held.
+func (o *Outbox) roll() {
+	next := o.segments[len(o.segments)-1].seq + 1
+
+	old := o.file
+	if err := o.openSegment(next); err != nil {
+		log.Printf("failed to start outbox segment: %v\n", err)
+		return
+	}
+
+	if err := old.Sync(); err != nil {
+		log.Printf("failed to sync outbox segment: %v\n", err)
+	}
+	_ = old.Close()
+
+	o.compact(false)
+}
+
+// compact removes the old segments, copying their pending messages to the segment written to.
+// The old segments are removed together, so that no done mark is lost while the message it marks is kept.
+// It must be called with mu held.
+//
+// Parameters:
+//   - all: Whether the old segments are compacted whatever their pending messages. Otherwise, they are
+//     compacted only if at most half of their messages are pending, as copying them would gain little space.
+func (o *Outbox) compact(all bool) {
+	active := o.segments[len(o.segments)-1]
+	removed := o.segments[:len(o.segments)-1]
+
+	if len(removed) == 0 {
+		return
+	}
+
+	var records, pending int
+	for _, seg := range removed {
+		records += seg.records
+		pending += seg.pending
+	}
+
+	if !all && pending*2 > records {
+		return
+	}
+
+	last := removed[len(removed)-1].seq
+
+	// Copy the pending messages of the removed segments, in the order they were written
+	var copies []*pendingRecord
+	for _, r := range o.pending {
+		if r.seq <= last {
+			copies = append(copies, r)
+		}
+	}
+	slices.SortFunc(copies, func(a, b *pendingRecord) int {
+		return cmp.Compare(a.order, b.order)
+	})
+
+	for _, r := range copies {
+		if err := o.write(entry{ID: r.ID, Data: r.Data}); err != nil {
+			log.Printf("failed to compact outbox: %v\n", err)
+			return
+		}
+
+		r.seq = active.seq
+		active.records++
+		active.pending++
+	}
+
+	// The copies must be on disk before the segments holding them are removed
+	if err := o.file.Sync(); err != nil {
+		log.Printf("failed to compact outbox: %v\n", err)
+		return
+	}
+
+	for _, seg := range removed {
+		if err := os.Remove(o.segmentPath(seg.seq)); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove outbox segment: %v\n", err)
+		}
+	}
+
+	o.segments = []*segment{active}
+
+	syncDir(o.config.Dir)
+}
+
+// syncLoop flushes the records to disk every SyncInterval, until the outbox is closed.
+func (o *Outbox) syncLoop() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(o.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			if o.dirty {
+				if err := o.file.Sync(); err != nil {
+					log.Printf("failed to sync outbox: %v\n", err)
+				}
+				o.dirty = false
+			}
+			o.mu.Unlock()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// openSegment creates a segment file and makes it the one written to.
+func (o *Outbox) openSegment(seq uint64) error {
+	f, err := os.OpenFile(o.segmentPath(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox segment: %w", err)
+	}
+
+	o.file = f
+	o.size = 0
+	o.segments = append(o.segments, &segment{seq: seq})
+
+	return nil
+}
+
+// load reads the records of a segment file. Torn records, left by a crash or a failed write, are logged
+// and skipped: a record is only acknowledged once written in full, so a torn one was never acknowledged.
+//
+// Parameters:
+//   - seq: The sequence number of the segment.
+//
+// Returns:
+//   - error: An error if the segment cannot be read.
+func (o *Outbox) load(seq uint64) error {
+	f, err := os.Open(o.segmentPath(seq))
+	if err != nil {
+		return fmt.Errorf("failed to open outbox segment: %w", err)
+	}
+	defer f.Close()
+
+	seg := &segment{seq: seq}
+	o.segments = append(o.segments, seg)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e entry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID == "" {
+			log.Printf("ignoring torn outbox record at %s:%d: %v\n", o.segmentPath(seq), line, err)
+			continue
+		}
+
+		if e.Done {
+			if r, ok := o.pending[e.ID]; ok {
+				delete(o.pending, e.ID)
+				if s := o.segment(r.seq); s != nil {
+					s.pending--
+				}
+			}
+
+			continue
+		}
+
+		// A message copied by a compaction interrupted before removing the old segment
+		if r, ok := o.pending[e.ID]; ok {
+			if s := o.segment(r.seq); s != nil {
+				s.pending--
+			}
+		}
+
+		seg.records++
+		seg.pending++
+		o.written++
+		o.pending[e.ID] = &pendingRecord{Record: Record{ID: e.ID, Data: e.Data}, seq: seq, order: o.written}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read outbox segment: %w", err)
+	}
+
+	return nil
+}
+
+// listSegments returns the sequence numbers of the segment files of the directory, in order.
+func (o *Outbox) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(o.config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox dir: %w", err)
+	}
+
+	var seqs []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	slices.Sort(seqs)
+
+	return seqs, nil
+}
+
+// segment returns the segment with a sequence number, nil if it was removed.
+func (o *Outbox) segment(seq uint64) *segment {
+	for _, seg := range o.segments {
+		if seg.seq == seq {
+			return seg
+		}
+	}
+
+	return nil
+}
+
+// segmentPath returns the path of the segment file with a sequence number.
+func (o *Outbox) segmentPath(seq uint64) string {
+	return filepath.Join(o.config.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// syncDir flushes the entries of a directory to disk, so that the segment files created and removed are durable.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	_ = d.Sync()
+}
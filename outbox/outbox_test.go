@@ -0,0 +1,282 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    Config
+		wantErr bool
+	}{
+		{
+			name:   "Valid config - defaults",
+			config: Config{Dir: "outbox"},
+			want:   Config{Dir: "outbox", Sync: SyncAlways, SyncInterval: time.Second, SegmentSize: 16 << 20},
+		},
+		{
+			name:   "Valid config - interval",
+			config: Config{Dir: "outbox", Sync: SyncInterval, SyncInterval: 100 * time.Millisecond, SegmentSize: 1024},
+			want:   Config{Dir: "outbox", Sync: SyncInterval, SyncInterval: 100 * time.Millisecond, SegmentSize: 1024},
+		},
+		{
+			name:    "Invalid config - unknown sync policy",
+			config:  Config{Dir: "outbox", Sync: "sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid config - negative segment size",
+			config:  Config{Dir: "outbox", SegmentSize: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.config != tt.want {
+				t.Errorf("Validate() = %+v, want %+v", tt.config, tt.want)
+			}
+		})
+	}
+}
+
+// open opens an outbox, failing the test on error.
+func open(t *testing.T, config Config) *Outbox {
+	t.Helper()
+
+	o, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	return o
+}
+
+// ids returns the IDs of records.
+func ids(records []Record) []string {
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+
+	return ids
+}
+
+// segmentFiles returns the number of segment files of a directory.
+func segmentFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(files)
+}
+
+func TestOutbox_Recover(t *testing.T) {
+	config := Config{Dir: filepath.Join(t.TempDir(), "outbox")}
+
+	o := open(t, config)
+	for _, id := range []string{"a", "b", "c"} {
+		if added, err := o.Append(id, []byte(`{"title":"`+id+`"}`)); !added || err != nil {
+			t.Fatalf("Append(%s) = %v, %v, want true, nil", id, added, err)
+		}
+	}
+
+	if err := o.Done("b"); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+
+	// Messages are written once per ID while they are pending
+	if added, err := o.Append("a", []byte(`{}`)); added || err != nil {
+		t.Errorf("Append(a) again = %v, %v, want false, nil", added, err)
+	}
+
+	// A message done can be written again, e.g. when it is replayed
+	if added, err := o.Append("b", []byte(`{"title":"b again"}`)); !added || err != nil {
+		t.Errorf("Append(b) once done = %v, %v, want true, nil", added, err)
+	}
+	if err := o.Done("b"); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+
+	if got := o.Recover(); len(got) != 0 {
+		t.Errorf("Recover() of a new outbox = %v, want none", ids(got))
+	}
+
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := o.Append("d", []byte(`{}`)); err != ErrClosed {
+		t.Errorf("Append() after Close() error = %v, want ErrClosed", err)
+	}
+
+	o = open(t, config)
+	got := o.Recover()
+	if fmt.Sprint(ids(got)) != "[a c]" || string(got[1].Data) != `{"title":"c"}` {
+		t.Fatalf("Recover() = %v, want a and c", got)
+	}
+
+	if again := o.Recover(); len(again) != 0 {
+		t.Errorf("Recover() twice = %v, want none", ids(again))
+	}
+
+	if added, _ := o.Append("c", []byte(`{}`)); added {
+		t.Error("Append() of a recovered message = true, want false")
+	}
+
+	for _, id := range []string{"a", "c"} {
+		if err := o.Done(id); err != nil {
+			t.Fatalf("Done(%s) error = %v", id, err)
+		}
+	}
+	o.Close()
+
+	o = open(t, config)
+	defer o.Close()
+
+	if got = o.Recover(); len(got) != 0 || o.Len() != 0 {
+		t.Errorf("Recover() after done = %v, want none", ids(got))
+	}
+
+	if n := segmentFiles(t, config.Dir); n != 1 {
+		t.Errorf("outbox has %d segment files after opening, want 1", n)
+	}
+}
+
+func TestOutbox_Compaction(t *testing.T) {
+	config := Config{Dir: t.TempDir(), SegmentSize: 256, Sync: SyncNever}
+
+	o := open(t, config)
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("msg-%03d", i)
+		if _, err := o.Append(id, []byte(`{"content":"disk full"}`)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+
+		// Keep every tenth message pending
+		if i%10 != 0 {
+			if err := o.Done(id); err != nil {
+				t.Fatalf("Done() error = %v", err)
+			}
+		}
+	}
+
+	if n := segmentFiles(t, config.Dir); n > 5 {
+		t.Errorf("outbox has %d segment files, want the old ones compacted", n)
+	}
+
+	if o.Len() != 10 {
+		t.Errorf("Len() = %d, want 10", o.Len())
+	}
+	o.Close()
+
+	o = open(t, config)
+	defer o.Close()
+
+	want := "[msg-000 msg-010 msg-020 msg-030 msg-040 msg-050 msg-060 msg-070 msg-080 msg-090]"
+	if got := fmt.Sprint(ids(o.Recover())); got != want {
+		t.Errorf("Recover() = %s, want %s", got, want)
+	}
+}
+
+func TestOutbox_TornRecord(t *testing.T) {
+	config := Config{Dir: t.TempDir()}
+
+	o := open(t, config)
+	o.Append("a", []byte(`{}`))
+	o.Append("b", []byte(`{}`))
+	o.Close()
+
+	// Simulate a crash in the middle of a write
+	files, _ := filepath.Glob(filepath.Join(config.Dir, "*"+segmentExt))
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"c","da`)
+	f.Close()
+
+	o = open(t, config)
+	if got := fmt.Sprint(ids(o.Recover())); got != "[a b]" {
+		t.Errorf("Recover() = %s, want [a b]", got)
+	}
+	o.Close()
+
+	// A record torn by a failed write is followed by others in the middle of the segment
+	files, _ = filepath.Glob(filepath.Join(config.Dir, "*"+segmentExt))
+	if err = os.WriteFile(files[0], []byte("{\"id\":\"a\",\"data\":{}}\n{\"id\":\"x\",\"da\n{\"id\":\"b\",\"data\":{}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o = open(t, config)
+	if got := fmt.Sprint(ids(o.Recover())); got != "[a b]" {
+		t.Errorf("Recover() of a segment torn in the middle = %s, want [a b]", got)
+	}
+	o.Close()
+}
+
+func TestOutbox_Discard(t *testing.T) {
+	config := Config{Dir: t.TempDir()}
+
+	o := open(t, config)
+	defer o.Close()
+
+	o.Append("a", []byte(`{}`))
+
+	// Simulate a write failing after writing part of a record
+	torn := `{"id":"x","da`
+	if _, err := o.file.WriteString(torn); err != nil {
+		t.Fatal(err)
+	}
+
+	o.mu.Lock()
+	o.discard(len(torn))
+	o.mu.Unlock()
+
+	if added, err := o.Append("b", []byte(`{}`)); !added || err != nil {
+		t.Fatalf("Append() after a torn write = %v, %v, want true, nil", added, err)
+	}
+
+	data, err := os.ReadFile(o.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"id\":\"a\",\"data\":{}}\n{\"id\":\"b\",\"data\":{}}\n"; string(data) != want {
+		t.Errorf("segment after a torn write = %q, want %q", data, want)
+	}
+}
+
+func TestOutbox_SyncInterval(t *testing.T) {
+	config := Config{Dir: t.TempDir(), Sync: SyncInterval, SyncInterval: 5 * time.Millisecond}
+
+	o := open(t, config)
+	o.Append("a", []byte(`{}`))
+	time.Sleep(20 * time.Millisecond)
+
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	o = open(t, config)
+	defer o.Close()
+
+	if got := fmt.Sprint(ids(o.Recover())); got != "[a]" {
+		t.Errorf("Recover() = %s, want [a]", got)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package retry provides the retry policy shared by the notification channels.
+// It decides whether a failed delivery is retried, and how long to wait before the next attempt,
+// using exponential backoff with jitter.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// Defaults of a Policy.
+const (
+	// DefaultMaxAttempts is the default number of attempts of a delivery.
+	DefaultMaxAttempts = 3
+
+	// DefaultBaseBackoff is the default wait before the first retry.
+	DefaultBaseBackoff = time.Second
+
+	// DefaultMaxBackoff is the default maximum wait between two attempts.
+	DefaultMaxBackoff = time.Minute
+
+	// DefaultJitter is the default fraction of the backoff randomly added or removed.
+	DefaultJitter = 0.2
+)
+
+// Policy describes how the failed deliveries of a channel are retried.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts of a delivery, including the first one.
+	// Defaults to 3 if 0, set it to 1 or a negative value to disable retries.
+	MaxAttempts int
+
+	// BaseBackoff is the wait before the first retry, doubled for each following retry.
+	// Defaults to 1 second.
+	BaseBackoff time.Duration
+
+	// MaxBackoff is the maximum wait between two attempts. Defaults to 1 minute.
+	// A longer wait requested by the provider, e.g. with a rate limit reset time, is still honored.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of the backoff randomly added or removed, between 0 and 1.
+	// Defaults to 0.2 if 0, set it to a negative value to disable jitter.
+	Jitter float64
+
+	// Retryable reports whether a failed delivery may succeed if retried. Defaults to IsRetryable.
+	Retryable func(err error) bool
+}
+
+// Validate applies the defaults to the unset fields of the policy and checks the others.
+//
+// Returns:
+//   - error: An error if a field is invalid.
+func (p *Policy) Validate() error {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+
+	if p.MaxAttempts < 0 {
+		p.MaxAttempts = 1
+	}
+
+	if p.BaseBackoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("retry backoff cannot be negative")
+	}
+
+	if p.BaseBackoff == 0 {
+		p.BaseBackoff = DefaultBaseBackoff
+	}
+
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if p.MaxBackoff < p.BaseBackoff {
+		return fmt.Errorf("retry max backoff %s is shorter than base backoff %s", p.MaxBackoff, p.BaseBackoff)
+	}
+
+	if p.Jitter > 1 {
+		return fmt.Errorf("retry jitter %v is greater than 1", p.Jitter)
+	}
+
+	if p.Jitter == 0 {
+		p.Jitter = DefaultJitter
+	}
+
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+
+	return nil
+}
+
+// Backoff returns the wait after a failed attempt: BaseBackoff doubled for each previous retry,
+// capped at MaxBackoff, with Jitter applied.
+//
+// Parameters:
+//   - attempt: The number of the failed attempt, starting at 1.
+//
+// Returns:
+//   - time.Duration: The wait before the next attempt.
+func (p Policy) Backoff(attempt int) time.Duration {
+	backoff := p.BaseBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	backoff = min(backoff, p.MaxBackoff)
+
+	if p.Jitter > 0 {
+		backoff += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(backoff))
+	}
+
+	return backoff
+}
+
+// Delay reports whether a failed attempt is retried, and how long to wait before the next attempt.
+// The wait is the backoff of the attempt, or the wait requested by the error if it is longer.
+//
+// Parameters:
+//   - attempt: The number of the failed attempt, starting at 1.
+//   - err: The error of the failed attempt.
+//
+// Returns:
+//   - time.Duration: The wait before the next attempt.
+//   - bool: false if the attempt was the last one or the error is not retryable.
+func (p Policy) Delay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !p.IsRetryable(err) {
+		return 0, false
+	}
+
+	delay := p.Backoff(attempt)
+	if after, ok := RetryAfter(err); ok && after > delay {
+		delay = after
+	}
+
+	return delay, true
+}
+
+// IsRetryable reports whether an error is retryable according to the Retryable classifier of the policy,
+// regardless of the number of attempts left.
+//
+// Parameters:
+//   - err: The error of the failed attempt.
+//
+// Returns:
+//   - bool: true if the error is retryable, false if it is nil.
+func (p Policy) IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if p.Retryable == nil {
+		return IsRetryable(err)
+	}
+
+	return p.Retryable(err)
+}
+
+// transientError marks an error as retryable.
+type transientError struct {
+	err   error
+	after time.Duration
+}
+
+// Error returns the message of the wrapped error.
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient marks an error as retryable, e.g. a 5xx status or a provider error code meaning that
+// the service is busy.
+//
+// Parameters:
+//   - err: The error to be marked.
+//
+// Returns:
+//   - error: The error wrapping err, nil if err is nil.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &transientError{err: err}
+}
+
+// After marks an error as retryable after a wait requested by the provider, e.g. the reset time
+// of a rate limit.
+//
+// Parameters:
+//   - err: The error to be marked.
+//   - after: The minimum wait before the next attempt.
+//
+// Returns:
+//   - error: The error wrapping err, nil if err is nil.
+func After(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	return &transientError{err: err, after: after}
+}
+
+// RetryAfter returns the wait requested by an error marked with After.
+//
+// Parameters:
+//   - err: The error of the failed attempt.
+//
+// Returns:
+//   - time.Duration: The wait requested.
+//   - bool: true if the error was marked with After.
+func RetryAfter(err error) (time.Duration, bool) {
+	var t *transientError
+	if errors.As(err, &t) && t.after > 0 {
+		return t.after, true
+	}
+
+	return 0, false
+}
+
+// IsRetryable is the default classifier of a Policy.
+// It reports whether an error was marked with Transient or After, or is a network error,
+// such as a timeout, a refused connection or a connection closed before the response.
+// Cancellations and context deadlines are never retried.
+//
+// Parameters:
+//   - err: The error of the failed attempt.
+//
+// Returns:
+//   - bool: true if the error is retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var t *transientError
+	if errors.As(err, &t) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// TransientStatus reports whether an HTTP status code is worth retrying:
+// 408 Request Timeout, 429 Too Many Requests and the 5xx server errors.
+//
+// Parameters:
+//   - code: The HTTP status code.
+//
+// Returns:
+//   - bool: true if the status is transient.
+func TransientStatus(code int) bool {
+	return code == 408 || code == 429 || code >= 500
+}
+
+// Wait waits for a delay, or until ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the wait.
+//   - delay: The wait.
+//
+// Returns:
+//   - error: The error of ctx if it is done before the delay elapsed, nil otherwise.
+func Wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		want    Policy
+		wantErr bool
+	}{
+		{
+			name:   "Valid policy - defaults",
+			policy: Policy{},
+			want:   Policy{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute, Jitter: 0.2},
+		},
+		{
+			name:   "Valid policy - disabled",
+			policy: Policy{MaxAttempts: -1, Jitter: -1},
+			want:   Policy{MaxAttempts: 1, BaseBackoff: time.Second, MaxBackoff: time.Minute},
+		},
+		{
+			name:    "Invalid policy - max backoff shorter than base",
+			policy:  Policy{BaseBackoff: time.Minute, MaxBackoff: time.Second},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid policy - jitter greater than 1",
+			policy:  Policy{Jitter: 1.5},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid policy - negative backoff",
+			policy:  Policy{BaseBackoff: -time.Second},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if tt.policy.Retryable == nil {
+				t.Error("Validate() did not set Retryable")
+			}
+
+			got := tt.policy
+			if got.MaxAttempts != tt.want.MaxAttempts || got.BaseBackoff != tt.want.BaseBackoff ||
+				got.MaxBackoff != tt.want.MaxBackoff || got.Jitter != tt.want.Jitter {
+				t.Errorf("Validate() = %+v, want %+v", tt.policy, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	p := Policy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w {
+			t.Errorf("Backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Backoff(3); got < 200*time.Millisecond || got > 600*time.Millisecond {
+			t.Fatalf("Backoff(3) = %s, want 400ms ± 50%%", got)
+		}
+	}
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	transient := Transient(errors.New("bad gateway"))
+
+	tests := []struct {
+		name      string
+		attempt   int
+		err       error
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{"Transient error", 1, transient, 100 * time.Millisecond, true},
+		{"Second retry", 2, transient, 200 * time.Millisecond, true},
+		{"Attempts exhausted", 3, transient, 0, false},
+		{"Permanent error", 1, errors.New("invalid receiver"), 0, false},
+		{"Wait requested", 1, After(errors.New("rate limited"), 5*time.Second), 5 * time.Second, true},
+		{"Shorter wait requested", 2, After(errors.New("rate limited"), time.Millisecond), 200 * time.Millisecond, true},
+		{"No error", 1, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := p.Delay(tt.attempt, tt.err)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("Delay() = %s, %v, want %s, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+
+	p.Retryable = func(err error) bool { return true }
+	if _, retry := p.Delay(1, errors.New("invalid receiver")); !retry {
+		t.Error("Delay() did not use the custom classifier")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Transient", fmt.Errorf("send: %w", Transient(errors.New("server error"))), true},
+		{"Network error", &url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"Connection closed", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"Cancelled", Transient(context.Canceled), false},
+		{"Deadline", fmt.Errorf("send: %w", context.DeadlineExceeded), false},
+		{"Permanent", errors.New("invalid receiver"), false},
+		{"Nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	if err := Wait(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := Wait(ctx, time.Minute); !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Errorf("Wait() error = %v after %s, want context.Canceled immediately", err, time.Since(start))
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Rule routes the messages it matches to a set of targets.
+// Every non-empty criterion must match for the rule to match, and a rule without criteria matches every message.
+type Rule struct {
+	// Name identifies the rule in errors.
+	Name string
+
+	// Levels matches messages with one of the levels.
+	Levels []Level
+
+	// Tags matches messages carrying all the tags.
+	Tags []string
+
+	// Title matches messages whose title matches the regular expression.
+	Title string
+
+	// Sources matches messages from one of the services.
+	Sources []string
+
+	// Targets are the channels and recipients the matched messages are sent to.
+	Targets []Target
+
+	// Continue makes the evaluation go on with the next rules after this rule matched.
+	// By default, the first matching rule decides the targets of a message.
+	Continue bool
+}
+
+// Target is a channel, and optionally a recipient, a message is routed to.
+type Target struct {
+	// Channel is the channel the message is sent through.
+	Channel Channel
+
+	// SendChannelName is the bot, app or account of the channel to send through, e.g. the name of
+	// a Lark bot webhook or app. If empty, the default of the channel is used.
+	SendChannelName string
+
+	// SendTo replaces the recipient of the message if not empty.
+	SendTo string
+}
+
+// apply returns the message as sent to the target.
+func (t Target) apply(message Message) Message {
+	if t.SendTo != "" {
+		message.SendTo = t.SendTo
+	}
+	if t.SendChannelName != "" {
+		message.SendChannelName = t.SendChannelName
+	}
+
+	return message
+}
+
+// Router evaluates routing rules against messages.
+type Router struct {
+	rules []rule
+}
+
+// rule is a Rule with its title expression compiled.
+type rule struct {
+	Rule
+	title *regexp.Regexp
+}
+
+// NewRouter creates a Router evaluating the rules in order.
+//
+// Parameters:
+//   - rules: The routing rules, evaluated in order
+//
+// Returns:
+//   - *Router: The router
+//   - error: An error if a rule has an invalid title expression or no targets
+func NewRouter(rules ...Rule) (*Router, error) {
+	r := &Router{rules: make([]rule, 0, len(rules))}
+
+	for i, ru := range rules {
+		name := ru.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if len(ru.Targets) == 0 {
+			return nil, fmt.Errorf("notify rule %s has no targets", name)
+		}
+
+		for _, t := range ru.Targets {
+			if t.Channel == "" {
+				return nil, fmt.Errorf("notify rule %s has a target without channel", name)
+			}
+		}
+
+		compiled := rule{Rule: ru}
+		if ru.Title != "" {
+			title, err := regexp.Compile(ru.Title)
+			if err != nil {
+				return nil, fmt.Errorf("notify rule %s has an invalid title expression: %w", name, err)
+			}
+
+			compiled.title = title
+		}
+
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// Route returns the targets of a message.
+// The targets of the first matching rule are returned, along with those of the following matching rules
+// as long as the rules matched have Continue set. Targets listed by several rules are returned once.
+//
+// Parameters:
+//   - message: The message to be routed
+//
+// Returns:
+//   - []Target: The targets of the message, empty if no rule matches
+func (r *Router) Route(message Message) []Target {
+	var targets []Target
+
+	for _, ru := range r.rules {
+		if !ru.matches(message) {
+			continue
+		}
+
+		for _, t := range ru.Targets {
+			if !slices.Contains(targets, t) {
+				targets = append(targets, t)
+			}
+		}
+
+		if !ru.Continue {
+			break
+		}
+	}
+
+	return targets
+}
+
+// matches reports whether a message meets every criterion of the rule.
+func (r rule) matches(message Message) bool {
+	if len(r.Levels) > 0 && !slices.Contains(r.Levels, message.Level) {
+		return false
+	}
+
+	if len(r.Sources) > 0 && !slices.Contains(r.Sources, message.Source) {
+		return false
+	}
+
+	for _, tag := range r.Tags {
+		if !slices.Contains(message.Tags, tag) {
+			return false
+		}
+	}
+
+	if r.title != nil && !r.title.MatchString(message.Title) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var (
+	paymentsChat = Target{Channel: LarkChan, SendChannelName: "ops_app", SendTo: "oc_payments"}
+	paymentsMail = Target{Channel: EmailChan, SendTo: "payments@example.com"}
+	infoBot      = Target{Channel: LarkChan, SendChannelName: "info_bot"}
+	auditLog     = Target{Channel: "audit"}
+)
+
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+
+	r, err := NewRouter(
+		Rule{Name: "audit", Tags: []string{"audit"}, Targets: []Target{auditLog}, Continue: true},
+		Rule{Name: "payments errors", Levels: []Level{ErrorLevel}, Sources: []string{"payments"}, Targets: []Target{paymentsChat, paymentsMail}},
+		Rule{Name: "outages", Title: `(?i)\b(down|outage)\b`, Targets: []Target{paymentsMail}},
+		Rule{Name: "info", Levels: []Level{InfoLevel, SuccessLevel}, Targets: []Target{infoBot}},
+	)
+	if err != nil {
+		t.Fatalf("NewRouter() error = %v", err)
+	}
+
+	return r
+}
+
+func TestNewRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr bool
+	}{
+		{
+			name:    "Valid rules",
+			rules:   []Rule{{Name: "all", Targets: []Target{infoBot}}},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid rules - no targets",
+			rules:   []Rule{{Name: "empty", Levels: []Level{InfoLevel}}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid rules - target without channel",
+			rules:   []Rule{{Targets: []Target{{SendTo: "oncall"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid rules - invalid title expression",
+			rules:   []Rule{{Title: "(", Targets: []Target{infoBot}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter(tt.rules...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRouter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouter_Route(t *testing.T) {
+	r := newTestRouter(t)
+
+	tests := []struct {
+		name    string
+		message Message
+		want    []Target
+	}{
+		{
+			name:    "First match",
+			message: Message{Level: ErrorLevel, Source: "payments", Title: "Payment provider down"},
+			want:    []Target{paymentsChat, paymentsMail},
+		},
+		{
+			name:    "Continue",
+			message: Message{Level: ErrorLevel, Source: "payments", Tags: []string{"refunds", "audit"}},
+			want:    []Target{auditLog, paymentsChat, paymentsMail},
+		},
+		{
+			name:    "Title expression",
+			message: Message{Level: WarnLevel, Source: "search", Title: "Search is DOWN"},
+			want:    []Target{paymentsMail},
+		},
+		{
+			name:    "Level",
+			message: Message{Level: InfoLevel, Source: "payments"},
+			want:    []Target{infoBot},
+		},
+		{
+			name:    "No match",
+			message: Message{Level: WarnLevel, Source: "search", Title: "Slow queries"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Route(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_Route_Deduplicates(t *testing.T) {
+	r, err := NewRouter(
+		Rule{Levels: []Level{ErrorLevel}, Targets: []Target{paymentsMail}, Continue: true},
+		Rule{Targets: []Target{paymentsMail, infoBot}},
+	)
+	if err != nil {
+		t.Fatalf("NewRouter() error = %v", err)
+	}
+
+	want := []Target{paymentsMail, infoBot}
+	if got := r.Route(Message{Level: ErrorLevel}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Route() = %+v, want %+v", got, want)
+	}
+}
+
+func TestManager_Dispatch(t *testing.T) {
+	m, err := New(
+		OptDefaultChannel(pagerChan),
+		OptRules(Rule{
+			Name:    "payments",
+			Sources: []string{"payments"},
+			Targets: []Target{{Channel: smsChan, SendChannelName: "gateway_2", SendTo: "+8613800000000"}, {Channel: pagerChan}},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	sms, pager := &fakeSender{}, &fakeSender{}
+	for channel, s := range map[Channel]Sender{smsChan: sms, pagerChan: pager} {
+		if err = m.Register(channel, s); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	msgID, err := m.Dispatch(context.Background(), Message{Level: ErrorLevel, SendTo: "oncall", Title: "Refund failed", Source: "payments"})
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	got := sms.received()
+	if len(got) != 1 || got[0].ID != msgID || got[0].SendTo != "+8613800000000" || got[0].SendChannelName != "gateway_2" {
+		t.Errorf("sms received %+v, want the routed message", got)
+	}
+
+	got = pager.received()
+	if len(got) != 1 || got[0].ID != msgID || got[0].SendTo != "oncall" {
+		t.Errorf("pager received %+v, want the message with its own recipient", got)
+	}
+
+	// Unmatched messages go to the default channel, explicit channels bypass the rules
+	if _, err = m.Dispatch(context.Background(), Message{Content: "ping", Source: "search"}); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if _, err = m.Send(InfoLevel, "", "", "ping", smsChan); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(sms.received()) != 2 || len(pager.received()) != 2 {
+		t.Errorf("sms received %d and pager %d messages, want 2 each", len(sms.received()), len(pager.received()))
+	}
+
+	if _, err = New(OptRules(Rule{Name: "invalid"})); err == nil {
+		t.Error("New() expected error for invalid rules")
+	}
+}
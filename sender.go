@@ -0,0 +1,505 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"github.com/sk-pkg/notify/bark"
+	"github.com/sk-pkg/notify/ding"
+	"github.com/sk-pkg/notify/email"
+	"github.com/sk-pkg/notify/lark"
+	"github.com/sk-pkg/notify/telegram"
+	"github.com/sk-pkg/notify/wechat"
+	"time"
+)
+
+// States of a SendResult, shared with lark.SendResult.
+const (
+	// StateSent indicates that the channel delivered the message.
+	StateSent = lark.StateSent
+
+	// StateFailed indicates that the channel failed to deliver the message.
+	StateFailed = lark.StateFailed
+
+	// StatePending indicates that the message was queued but its delivery is not known yet.
+	StatePending = lark.StatePending
+
+	// StateRetrying indicates that the channel failed to deliver the message and will try again.
+	StateRetrying = lark.StateRetrying
+
+	// StateDropped indicates that the message was discarded before the channel could deliver it.
+	StateDropped = lark.StateDropped
+)
+
+// Message represents a message routed by the Manager to a channel.
+type Message struct {
+	// ID is the unique identifier generated by the Manager. It is the same for every channel of a message.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets, as the targets share its ID.
+	// It is set by the Manager, and reported in the results of the senders implementing ResultPublisher.
+	DeliveryID string
+
+	// Level is the severity level of the message.
+	Level Level
+
+	// SendTo is the recipient of the message, interpreted by each channel.
+	SendTo string
+
+	// Title is the title of the message.
+	Title string
+
+	// Content is the content of the message.
+	Content string
+
+	// SendChannelName is the bot, app or account of the channel to send through, set by the routing rules.
+	// It is used by Lark, DingTalk, WeChat and Telegram, whose default is used if it is empty.
+	SendChannelName string
+
+	// Tags are labels of the message, matched by the routing rules.
+	Tags []string
+
+	// Source is the service the message comes from, matched by the routing rules.
+	Source string
+}
+
+// Sender is the interface implemented by notification channels.
+// The built-in channels are wrapped in a Sender, and custom channels can be added with Manager.Register.
+type Sender interface {
+	// Submit queues a message for delivery. It should not block on the delivery itself.
+	//
+	// Parameters:
+	// 	- message: The message to be delivered. Its ID must be kept so that it can be tracked across channels.
+	//
+	// Returns:
+	// 	- error: An error if the message cannot be queued.
+	Submit(message Message) error
+
+	// Close stops the sender, ensuring all pending messages are processed before shutting down.
+	Close()
+}
+
+// SyncSender is implemented by senders that can deliver a message synchronously.
+// Channels registered with a Sender that does not implement it are reported as pending by Manager.SendSync.
+type SyncSender interface {
+	Sender
+
+	// SendSync delivers a message and waits for the outcome.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery. The Manager stops waiting for the result when it is done.
+	// 	- message: The message to be delivered.
+	//
+	// Returns:
+	// 	- SendResult: The outcome of the delivery. Only ProviderMsgID, Err and Attempts need to be set,
+	// 	  the other fields are filled in by the Manager.
+	SendSync(ctx context.Context, message Message) SendResult
+}
+
+// ContextSender is implemented by senders that can bind a message to a context.
+// Messages are submitted to the other senders with Submit, ignoring the context.
+type ContextSender interface {
+	Sender
+
+	// SubmitContext queues a message for delivery, bound to ctx.
+	// The sender should drop the message if ctx is done before it is delivered.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The message to be delivered.
+	//
+	// Returns:
+	// 	- error: An error if the message cannot be queued, or the error of ctx.
+	SubmitContext(ctx context.Context, message Message) error
+}
+
+// submitContext submits a message to a sender, with ctx if it implements ContextSender.
+func submitContext(ctx context.Context, s Sender, message Message) error {
+	if cs, ok := s.(ContextSender); ok {
+		return cs.SubmitContext(ctx, message)
+	}
+
+	return s.Submit(message)
+}
+
+// ResultPublisher is implemented by senders that report the outcome of the messages they deliver
+// in the background. The Manager subscribes to them, and passes their results to Manager.Subscribe.
+// The results should carry the DeliveryID of their message: results without it are matched to the message
+// by its ID, which cannot tell apart the deliveries of a message to several targets on the same channel.
+type ResultPublisher interface {
+	// Subscribe registers a handler called with the result of every delivery attempt.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult. Channel and Latency do not need to be set.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+}
+
+// SendResult represents the result of delivering a message through a channel.
+// It mirrors lark.SendResult, adding the channel and the latency of the delivery.
+type SendResult struct {
+	// Channel is the channel the message was sent through.
+	Channel Channel
+
+	// MsgID is the message ID generated by the Manager.
+	MsgID string
+
+	// DeliveryID is the DeliveryID of the message, which tells apart its deliveries to several targets.
+	DeliveryID string
+
+	// ProviderMsgID is the message ID assigned by the channel's service, if it returns one.
+	ProviderMsgID string
+
+	// State is the state of the delivery: sent, failed, pending, retrying or dropped.
+	State string
+
+	// Err is the error that occurred while sending the message, if State is failed or the wait was cancelled.
+	Err error
+
+	// Attempts is the number of times the channel tried to deliver the message.
+	Attempts int
+
+	// Latency is the time it took the channel to deliver the message or fail.
+	// It is only measured by Manager.SendSync.
+	Latency time.Duration
+
+	// FailoverFrom is the channel the message was sent to, if Channel is one of its failover fallbacks.
+	FailoverFrom Channel
+}
+
+// larkSender adapts a lark.Notify to the Sender interface.
+type larkSender struct {
+	n lark.Notify
+}
+
+// Submit queues a message for delivery via Lark.
+func (s larkSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via Lark.
+func (s larkSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, larkMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via Lark and waits for the outcome.
+func (s larkSender) SendSync(ctx context.Context, message Message) SendResult {
+	r := s.n.SendContext(ctx, larkMessage(message))
+
+	return SendResult{ProviderMsgID: r.ProviderMsgID, Err: r.Err, Attempts: r.Attempts}
+}
+
+// Subscribe passes the results of the Lark notifier to handler.
+func (s larkSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r lark.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the Lark notifier.
+func (s larkSender) Close() {
+	s.n.Close()
+}
+
+// larkMessage converts a message to a lark.Message.
+func larkMessage(message Message) lark.Message {
+	return lark.Message{
+		ID:              message.ID,
+		DeliveryID:      message.DeliveryID,
+		SendChannelName: message.SendChannelName,
+		SendTo:          message.SendTo,
+		MsgLevel:        string(message.Level),
+		Title:           message.Title,
+		Content:         message.Content,
+	}
+}
+
+// dingSender adapts a ding.Notify to the Sender interface.
+type dingSender struct {
+	n ding.Notify
+}
+
+// Submit queues a message for delivery via DingTalk.
+func (s dingSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via DingTalk.
+func (s dingSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, dingMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via DingTalk and waits for the outcome.
+func (s dingSender) SendSync(ctx context.Context, message Message) SendResult {
+	providerMsgID, attempts, err := s.n.SendContext(ctx, dingMessage(message))
+
+	return SendResult{ProviderMsgID: providerMsgID, Err: err, Attempts: attempts}
+}
+
+// Subscribe passes the results of the DingTalk notifier to handler.
+func (s dingSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r ding.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the DingTalk notifier.
+func (s dingSender) Close() {
+	s.n.Close()
+}
+
+// dingMessage converts a message to a ding.Message.
+func dingMessage(message Message) ding.Message {
+	return ding.Message{
+		ID:              message.ID,
+		DeliveryID:      message.DeliveryID,
+		SendChannelName: message.SendChannelName,
+		SendTo:          message.SendTo,
+		Title:           message.Title,
+		Content:         message.Content,
+	}
+}
+
+// wechatSender adapts a wechat.Notify to the Sender interface.
+type wechatSender struct {
+	n wechat.Notify
+}
+
+// Submit queues a message for delivery via WeChat.
+func (s wechatSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via WeChat.
+func (s wechatSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, wechatMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via WeChat and waits for the outcome.
+func (s wechatSender) SendSync(ctx context.Context, message Message) SendResult {
+	providerMsgID, attempts, err := s.n.SendContext(ctx, wechatMessage(message))
+
+	return SendResult{ProviderMsgID: providerMsgID, Err: err, Attempts: attempts}
+}
+
+// Subscribe passes the results of the WeChat notifier to handler.
+func (s wechatSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r wechat.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the WeChat notifier.
+func (s wechatSender) Close() {
+	s.n.Close()
+}
+
+// wechatMessage converts a message to a wechat.Message.
+func wechatMessage(message Message) wechat.Message {
+	return wechat.Message{
+		ID:              message.ID,
+		DeliveryID:      message.DeliveryID,
+		SendChannelName: message.SendChannelName,
+		SendTo:          message.SendTo,
+		Title:           message.Title,
+		Content:         message.Content,
+	}
+}
+
+// emailSender adapts an email.Notify to the Sender interface.
+type emailSender struct {
+	n email.Notify
+}
+
+// Submit queues a message for delivery via Email.
+func (s emailSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via Email.
+func (s emailSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, emailMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via Email and waits for the outcome.
+func (s emailSender) SendSync(ctx context.Context, message Message) SendResult {
+	providerMsgID, attempts, err := s.n.SendContext(ctx, emailMessage(message))
+
+	return SendResult{ProviderMsgID: providerMsgID, Err: err, Attempts: attempts}
+}
+
+// Subscribe passes the results of the Email notifier to handler.
+func (s emailSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r email.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the Email notifier.
+func (s emailSender) Close() {
+	s.n.Close()
+}
+
+// emailMessage converts a message to an email.Message.
+func emailMessage(message Message) email.Message {
+	return email.Message{
+		ID:         message.ID,
+		DeliveryID: message.DeliveryID,
+		SendTo:     message.SendTo,
+		MsgLevel:   string(message.Level),
+		Title:      message.Title,
+		Content:    message.Content,
+	}
+}
+
+// telegramSender adapts a telegram.Notify to the Sender interface.
+type telegramSender struct {
+	n telegram.Notify
+}
+
+// Submit queues a message for delivery via Telegram.
+func (s telegramSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via Telegram.
+func (s telegramSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, telegramMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via Telegram and waits for the outcome.
+func (s telegramSender) SendSync(ctx context.Context, message Message) SendResult {
+	providerMsgID, attempts, err := s.n.SendContext(ctx, telegramMessage(message))
+
+	return SendResult{ProviderMsgID: providerMsgID, Err: err, Attempts: attempts}
+}
+
+// Subscribe passes the results of the Telegram notifier to handler.
+func (s telegramSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r telegram.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the Telegram notifier.
+func (s telegramSender) Close() {
+	s.n.Close()
+}
+
+// telegramMessage converts a message to a telegram.Message.
+func telegramMessage(message Message) telegram.Message {
+	return telegram.Message{
+		ID:              message.ID,
+		DeliveryID:      message.DeliveryID,
+		SendChannelName: message.SendChannelName,
+		SendTo:          message.SendTo,
+		MsgLevel:        string(message.Level),
+		Title:           message.Title,
+		Content:         message.Content,
+	}
+}
+
+// barkSender adapts a bark.Notify to the Sender interface.
+type barkSender struct {
+	n bark.Notify
+}
+
+// Submit queues a message for delivery via Bark.
+func (s barkSender) Submit(message Message) error {
+	return s.SubmitContext(context.Background(), message)
+}
+
+// SubmitContext queues a message bound to ctx for delivery via Bark.
+func (s barkSender) SubmitContext(ctx context.Context, message Message) error {
+	_, err := s.n.SubmitMessageContext(ctx, barkMessage(message))
+
+	return err
+}
+
+// SendSync delivers a message via Bark and waits for the outcome.
+// Bark does not assign message IDs, so ProviderMsgID is always empty.
+func (s barkSender) SendSync(ctx context.Context, message Message) SendResult {
+	_, attempts, err := s.n.SendContext(ctx, barkMessage(message))
+
+	return SendResult{Err: err, Attempts: attempts}
+}
+
+// Subscribe passes the results of the Bark notifier to handler.
+func (s barkSender) Subscribe(handler func(SendResult)) func() {
+	return s.n.Subscribe(func(r bark.SendResult) {
+		handler(SendResult{
+			MsgID:         r.MsgID,
+			DeliveryID:    r.DeliveryID,
+			ProviderMsgID: r.ProviderMsgID,
+			State:         r.State,
+			Err:           r.Err,
+			Attempts:      r.Attempts,
+		})
+	})
+}
+
+// Close stops the Bark notifier.
+func (s barkSender) Close() {
+	s.n.Close()
+}
+
+// barkMessage converts a message to a bark.Message.
+func barkMessage(message Message) bark.Message {
+	return bark.Message{
+		ID:         message.ID,
+		DeliveryID: message.DeliveryID,
+		SendTo:     message.SendTo,
+		MsgLevel:   string(message.Level),
+		Title:      message.Title,
+		Content:    message.Content,
+	}
+}
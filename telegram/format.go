@@ -0,0 +1,227 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"fmt"
+	"strings"
+)
+
+// markdownV2Escaper escapes the characters reserved by MarkdownV2.
+// https://core.telegram.org/bots/api#markdownv2-style
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`,
+	"_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`,
+	"=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// htmlEscaper escapes the characters reserved by the HTML parse mode.
+// https://core.telegram.org/bots/api#html-style
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// validParseMode reports whether a parse mode is supported.
+func validParseMode(parseMode string) bool {
+	switch parseMode {
+	case "", ParseModeMarkdownV2, ParseModeHTML:
+		return true
+	default:
+		return false
+	}
+}
+
+// EscapeMarkdownV2 escapes text so that it is displayed literally in a MarkdownV2 message.
+func EscapeMarkdownV2(text string) string {
+	return markdownV2Escaper.Replace(text)
+}
+
+// EscapeHTML escapes text so that it is displayed literally in an HTML message.
+func EscapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// formatText renders the title and content of a message for a parse mode.
+// Both are escaped, and the title is rendered in bold when a parse mode is used.
+//
+// Parameters:
+//   - parseMode: The parse mode of the message: MarkdownV2, HTML or empty for plain text.
+//   - title: The title of the message.
+//   - content: The content of the message.
+//
+// Returns:
+//   - string: The formatted text.
+//   - error: An error if the parse mode is not supported or the text is empty.
+func formatText(parseMode, title, content string) (string, error) {
+	if title == "" && content == "" {
+		return "", fmt.Errorf("telegram message text is empty")
+	}
+
+	switch parseMode {
+	case "":
+	case ParseModeMarkdownV2:
+		if title != "" {
+			title = "*" + EscapeMarkdownV2(title) + "*"
+		}
+		content = EscapeMarkdownV2(content)
+	case ParseModeHTML:
+		if title != "" {
+			title = "<b>" + EscapeHTML(title) + "</b>"
+		}
+		content = EscapeHTML(content)
+	default:
+		return "", fmt.Errorf("invalid telegram parse mode: %s", parseMode)
+	}
+
+	switch {
+	case title == "":
+		return content, nil
+	case content == "":
+		return title, nil
+	default:
+		return title + "\n" + content, nil
+	}
+}
+
+// escaperOf returns the function escaping text for a parse mode.
+func escaperOf(parseMode string) func(string) string {
+	switch parseMode {
+	case ParseModeMarkdownV2:
+		return EscapeMarkdownV2
+	case ParseModeHTML:
+		return EscapeHTML
+	default:
+		return func(s string) string { return s }
+	}
+}
+
+// textLength returns the length of text in UTF-16 code units, which is how Telegram counts characters.
+func textLength(text string) int {
+	length := 0
+	for _, r := range text {
+		// Characters outside the Basic Multilingual Plane take a surrogate pair
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+
+	return length
+}
+
+// splitText formats the title and content of a message into ordered chunks no longer than limit.
+//
+// The content is split at line breaks where possible, so that stack traces keep their lines, and
+// at character boundaries otherwise. Every chunk is escaped on its own and the title only appears
+// in the first chunk, so escape sequences and formatting entities are never cut in half.
+//
+// Parameters:
+//   - parseMode: The parse mode of the message: MarkdownV2, HTML or empty for plain text.
+//   - title: The title of the message.
+//   - content: The content of the message.
+//   - limit: The maximum length of a chunk, as counted by textLength.
+//
+// Returns:
+//   - []string: The formatted chunks, in order.
+//   - error: An error if the parse mode is not supported or the text is empty.
+func splitText(parseMode, title, content string, limit int) ([]string, error) {
+	text, err := formatText(parseMode, title, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if textLength(text) <= limit {
+		return []string{text}, nil
+	}
+
+	header, _ := formatText(parseMode, title, "")
+	if title == "" || textLength(header)+1 >= limit {
+		// A title that does not fit is sent as plain content
+		if title != "" {
+			content = title + "\n" + content
+		}
+		header = ""
+	}
+
+	// The first chunk has the least room, since it carries the title
+	budget := limit
+	if header != "" {
+		budget -= textLength(header) + 1
+	}
+
+	escape := escaperOf(parseMode)
+
+	var (
+		chunks  []string
+		current strings.Builder
+		length  int
+	)
+
+	flush := func() {
+		chunk := strings.TrimRight(current.String(), "\n")
+		if len(chunks) == 0 && header != "" {
+			chunk = header + "\n" + chunk
+		}
+		chunks = append(chunks, chunk)
+
+		current.Reset()
+		length = 0
+	}
+
+	for _, piece := range splitPieces(content, budget, escape) {
+		pieceLength := textLength(piece)
+		if length > 0 && length+pieceLength > budget {
+			flush()
+			budget = limit
+		}
+
+		current.WriteString(piece)
+		length += pieceLength
+	}
+
+	flush()
+
+	return chunks, nil
+}
+
+// splitPieces splits content into escaped lines, keeping their line breaks.
+// Lines longer than limit once escaped are further split at character boundaries.
+func splitPieces(content string, limit int, escape func(string) string) []string {
+	var pieces []string
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+
+		escaped := escape(line)
+		if textLength(escaped) <= limit {
+			pieces = append(pieces, escaped)
+			continue
+		}
+
+		var (
+			piece  strings.Builder
+			length int
+		)
+		for _, r := range line {
+			s := escape(string(r))
+			if length+textLength(s) > limit {
+				pieces = append(pieces, piece.String())
+				piece.Reset()
+				length = 0
+			}
+
+			piece.WriteString(s)
+			length += textLength(s)
+		}
+
+		if piece.Len() > 0 {
+			pieces = append(pieces, piece.String())
+		}
+	}
+
+	return pieces
+}
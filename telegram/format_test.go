@@ -0,0 +1,155 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatText(t *testing.T) {
+	tests := []struct {
+		name      string
+		parseMode string
+		title     string
+		content   string
+		want      string
+		wantErr   bool
+	}{
+		{name: "plain", title: "Title", content: "a_b*c", want: "Title\na_b*c"},
+		{name: "plain content only", content: "hello", want: "hello"},
+		{
+			name:      "markdownV2",
+			parseMode: ParseModeMarkdownV2,
+			title:     "[prod] db-1",
+			content:   "err: file_name.go:12 (x+y=z) #1 ~ | {a} > b! `c` \\",
+			want:      "*\\[prod\\] db\\-1*\nerr: file\\_name\\.go:12 \\(x\\+y\\=z\\) \\#1 \\~ \\| \\{a\\} \\> b\\! \\`c\\` \\\\",
+		},
+		{name: "markdownV2 title only", parseMode: ParseModeMarkdownV2, title: "a.b", want: "*a\\.b*"},
+		{
+			name:      "html",
+			parseMode: ParseModeHTML,
+			title:     "<script>",
+			content:   `a && b > c "quoted"`,
+			want:      "<b>&lt;script&gt;</b>\na &amp;&amp; b &gt; c \"quoted\"",
+		},
+		{name: "empty", wantErr: true},
+		{name: "invalid parse mode", parseMode: "Markdown", content: "x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatText(tt.parseMode, tt.title, tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("formatText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	if got := textLength("a中😀"); got != 4 {
+		t.Errorf("textLength() = %d, want 4", got)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	t.Run("short text is not split", func(t *testing.T) {
+		chunks, err := splitText("", "Title", "Content", 100)
+		if err != nil || len(chunks) != 1 || chunks[0] != "Title\nContent" {
+			t.Errorf("splitText() = %q, %v", chunks, err)
+		}
+	})
+
+	t.Run("split at line breaks", func(t *testing.T) {
+		content := "line 1\nline 2\nline 3\nline 4"
+
+		chunks, err := splitText("", "T", content, 16)
+		if err != nil {
+			t.Fatalf("splitText() error = %v", err)
+		}
+
+		want := []string{"T\nline 1\nline 2", "line 3\nline 4"}
+		if strings.Join(chunks, "|") != strings.Join(want, "|") {
+			t.Errorf("splitText() = %q, want %q", chunks, want)
+		}
+	})
+
+	t.Run("long line is split at character boundaries", func(t *testing.T) {
+		chunks, err := splitText("", "", strings.Repeat("中", 25), 10)
+		if err != nil {
+			t.Fatalf("splitText() error = %v", err)
+		}
+
+		if len(chunks) != 3 || strings.Join(chunks, "") != strings.Repeat("中", 25) {
+			t.Errorf("splitText() = %q", chunks)
+		}
+	})
+
+	for _, parseMode := range []string{ParseModeMarkdownV2, ParseModeHTML} {
+		t.Run(parseMode+" entities are kept whole", func(t *testing.T) {
+			var content strings.Builder
+			for i := 0; i < 300; i++ {
+				content.WriteString("panic: a.b_c <nil> & (x)!\n")
+			}
+
+			chunks, err := splitText(parseMode, "Stack <trace>", content.String(), 100)
+			if err != nil {
+				t.Fatalf("splitText() error = %v", err)
+			}
+
+			escape := escaperOf(parseMode)
+			title, _ := formatText(parseMode, "Stack <trace>", "")
+			if !strings.HasPrefix(chunks[0], title+"\n") {
+				t.Errorf("first chunk %q does not start with the title", chunks[0])
+			}
+
+			var body strings.Builder
+			for i, chunk := range chunks {
+				if textLength(chunk) > 100 {
+					t.Errorf("chunk %d has length %d, want at most 100", i, textLength(chunk))
+				}
+
+				if i == 0 {
+					chunk = strings.TrimPrefix(chunk, title+"\n")
+				}
+
+				// Every chunk consists of whole escaped lines
+				for _, line := range strings.Split(chunk, "\n") {
+					if line != escape("panic: a.b_c <nil> & (x)!") {
+						t.Fatalf("chunk %d has a broken line %q", i, line)
+					}
+				}
+
+				body.WriteString(chunk + "\n")
+			}
+
+			if body.String() != escape(content.String()) {
+				t.Error("chunks do not add up to the content")
+			}
+		})
+	}
+
+	t.Run("escape sequences are not cut", func(t *testing.T) {
+		chunks, err := splitText(ParseModeMarkdownV2, "", strings.Repeat(".", 15), 10)
+		if err != nil {
+			t.Fatalf("splitText() error = %v", err)
+		}
+
+		for _, chunk := range chunks {
+			if strings.HasSuffix(chunk, `\`) || strings.HasPrefix(chunk, ".") {
+				t.Errorf("chunk %q cuts an escape sequence", chunk)
+			}
+		}
+
+		if strings.Join(chunks, "") != strings.Repeat(`\.`, 15) {
+			t.Errorf("splitText() = %q", chunks)
+		}
+	})
+}
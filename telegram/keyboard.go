@@ -0,0 +1,71 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// maxCallbackDataLength is the maximum size of the callback data of a button, in bytes.
+const maxCallbackDataLength = 64
+
+// InlineButton represents a button of an inline keyboard attached to a message.
+// Exactly one of CallbackData and URL must be set.
+type InlineButton struct {
+	// Text is the label of the button.
+	Text string `json:"text"`
+
+	// CallbackData is sent back in a callback query when the button is pressed, 1-64 bytes.
+	// Queries are dispatched to the handlers registered with HandleCallback.
+	CallbackData string `json:"callback_data,omitempty"`
+
+	// URL is opened when the button is pressed.
+	URL string `json:"url,omitempty"`
+}
+
+// inlineKeyboardMarkup represents the reply_markup parameter of a message with an inline keyboard.
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineButton `json:"inline_keyboard"`
+}
+
+// buildReplyMarkup validates an inline keyboard and returns its reply_markup parameter.
+//
+// Parameters:
+//   - keyboard: The rows of buttons.
+//
+// Returns:
+//   - *inlineKeyboardMarkup: The reply_markup parameter, or nil if the keyboard is empty.
+//   - error: An error if a button is invalid.
+func buildReplyMarkup(keyboard [][]InlineButton) (*inlineKeyboardMarkup, error) {
+	if len(keyboard) == 0 {
+		return nil, nil
+	}
+
+	for i, row := range keyboard {
+		for j, button := range row {
+			if button.Text == "" {
+				return nil, fmt.Errorf("text is required for inline button %d of row %d", j+1, i+1)
+			}
+
+			if (button.CallbackData == "") == (button.URL == "") {
+				return nil, fmt.Errorf("exactly one of CallbackData and URL is required for inline button %q", button.Text)
+			}
+
+			if len(button.CallbackData) > maxCallbackDataLength {
+				return nil, fmt.Errorf("callback data of inline button %q exceeds %d bytes", button.Text, maxCallbackDataLength)
+			}
+		}
+	}
+
+	return &inlineKeyboardMarkup{InlineKeyboard: keyboard}, nil
+}
+
+// String encodes the reply_markup parameter as JSON, as required in multipart requests.
+// It is used by fmt when the parameter is converted into a form field.
+func (m *inlineKeyboardMarkup) String() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBuildReplyMarkup(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyboard [][]InlineButton
+		want     string
+		wantErr  bool
+	}{
+		{name: "empty"},
+		{
+			name: "callback and url buttons",
+			keyboard: [][]InlineButton{
+				{{Text: "Ack", CallbackData: "ack:1"}, {Text: "Silence 1h", CallbackData: "silence:1:1h"}},
+				{{Text: "Dashboard", URL: "https://example.com"}},
+			},
+			want: `{"inline_keyboard":[[{"text":"Ack","callback_data":"ack:1"},{"text":"Silence 1h","callback_data":"silence:1:1h"}],[{"text":"Dashboard","url":"https://example.com"}]]}`,
+		},
+		{name: "missing text", keyboard: [][]InlineButton{{{CallbackData: "ack"}}}, wantErr: true},
+		{name: "no action", keyboard: [][]InlineButton{{{Text: "Ack"}}}, wantErr: true},
+		{name: "both actions", keyboard: [][]InlineButton{{{Text: "Ack", CallbackData: "a", URL: "https://example.com"}}}, wantErr: true},
+		{name: "callback data too long", keyboard: [][]InlineButton{{{Text: "Ack", CallbackData: strings.Repeat("a", 65)}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markup, err := buildReplyMarkup(tt.keyboard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildReplyMarkup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.want == "" {
+				if markup != nil && !tt.wantErr {
+					t.Errorf("buildReplyMarkup() = %v, want nil", markup)
+				}
+				return
+			}
+
+			if got := markup.String(); got != tt.want {
+				t.Errorf("buildReplyMarkup() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotify_SendMsg_InlineKeyboard(t *testing.T) {
+	keyboard := [][]InlineButton{{{Text: "Ack", CallbackData: "ack:1"}}}
+	want := `{"inline_keyboard":[[{"callback_data":"ack:1","text":"Ack"}]]}`
+
+	t.Run("attached to the last part", func(t *testing.T) {
+		srv, requests := newTestServer(t, okHandler)
+		n := newTestNotify(t, srv.URL)
+
+		content := strings.Repeat("line\n", 1000)
+		if _, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: content, InlineKeyboard: keyboard}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()
+		if len(got) < 2 {
+			t.Fatalf("server received %d requests, want the text to be split", len(got))
+		}
+
+		for i, r := range got {
+			_, ok := r.Params["reply_markup"]
+			if last := i == len(got)-1; ok != last {
+				t.Errorf("part %d has reply_markup = %v", i, ok)
+			}
+		}
+
+		markup, _ := json.Marshal(got[len(got)-1].Params["reply_markup"])
+		if string(markup) != want {
+			t.Errorf("reply_markup = %s, want %s", markup, want)
+		}
+	})
+
+	t.Run("uploaded attachment", func(t *testing.T) {
+		srv, requests := newTestServer(t, mediaHandler)
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Photo: &Media{Data: []byte("x")}, InlineKeyboard: keyboard})
+		if err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		if got.Params["reply_markup"] != `{"inline_keyboard":[[{"text":"Ack","callback_data":"ack:1"}]]}` {
+			t.Errorf("reply_markup = %v", got.Params["reply_markup"])
+		}
+	})
+
+	t.Run("invalid keyboard", func(t *testing.T) {
+		srv, requests := newTestServer(t, okHandler)
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: "x", InlineKeyboard: [][]InlineButton{{{Text: "Ack"}}}})
+		if err == nil {
+			t.Error("sendMsg() expected error for invalid keyboard")
+		}
+
+		if len(requests()) != 0 {
+			t.Error("invalid message should not be sent")
+		}
+	})
+}
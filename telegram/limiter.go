@@ -0,0 +1,116 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket refilled at a constant rate.
+type bucket struct {
+	rate   float64   // Tokens added per second
+	burst  float64   // Maximum number of tokens
+	tokens float64   // Current number of tokens, negative when tokens are reserved in advance
+	last   time.Time // Time tokens were last updated
+}
+
+// reserve takes a token from the bucket and returns how long the caller must wait before using it.
+// Tokens are reserved even if the bucket is empty, so concurrent callers are served in order.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter throttles messages per bot and per chat with token buckets.
+type rateLimiter struct {
+	// globalRate is the number of messages per second allowed for a bot. Zero disables the limit.
+	globalRate float64
+
+	// chatRate is the number of messages per second allowed for a chat. Zero disables the limit.
+	chatRate float64
+
+	// mu protects buckets.
+	mu sync.Mutex
+
+	// buckets maps a bot token, or a bot token and chat ID, to its bucket.
+	buckets map[string]*bucket
+}
+
+// newRateLimiter creates a rate limiter. A non-positive rate disables the corresponding limit.
+func newRateLimiter(globalRate, chatRate float64) *rateLimiter {
+	return &rateLimiter{
+		globalRate: math.Max(globalRate, 0),
+		chatRate:   math.Max(chatRate, 0),
+		buckets:    make(map[string]*bucket),
+	}
+}
+
+// wait blocks until a message can be sent to a chat by a bot without exceeding either limit, or ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the message.
+//   - token: The token of the bot.
+//   - chatID: The target chat ID.
+//
+// Returns:
+//   - error: The error of ctx if it is done before the message can be sent, nil otherwise.
+func (l *rateLimiter) wait(ctx context.Context, token, chatID string) error {
+	delay := l.reserve(token, chatID, time.Now())
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve reserves a token from the bot and chat buckets and returns the longest of both waits.
+func (l *rateLimiter) reserve(token, chatID string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var delay time.Duration
+	if l.globalRate > 0 {
+		// The whole rate is available at once, matching Telegram's per second limit
+		delay = l.bucket(token, l.globalRate, math.Ceil(l.globalRate), now).reserve(now)
+	}
+
+	if l.chatRate > 0 {
+		// Messages to a chat are spaced evenly
+		if d := l.bucket(token+":"+chatID, l.chatRate, 1, now).reserve(now); d > delay {
+			delay = d
+		}
+	}
+
+	return delay
+}
+
+// bucket returns the bucket for a key, creating a full one if needed. l.mu must be held.
+func (l *rateLimiter) bucket(key string, rate, burst float64, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	return b
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	now := time.Now()
+
+	t.Run("chat rate spaces messages to a chat", func(t *testing.T) {
+		l := newRateLimiter(0, 1)
+
+		if d := l.reserve("bot", "1", now); d != 0 {
+			t.Errorf("first reserve = %v, want 0", d)
+		}
+
+		if d := l.reserve("bot", "1", now); d != time.Second {
+			t.Errorf("second reserve = %v, want 1s", d)
+		}
+
+		if d := l.reserve("bot", "1", now); d != 2*time.Second {
+			t.Errorf("third reserve = %v, want 2s", d)
+		}
+
+		// Other chats and bots have their own buckets
+		if d := l.reserve("bot", "2", now); d != 0 {
+			t.Errorf("reserve for another chat = %v, want 0", d)
+		}
+
+		if d := l.reserve("other", "1", now); d != 0 {
+			t.Errorf("reserve for another bot = %v, want 0", d)
+		}
+	})
+
+	t.Run("global rate allows a burst", func(t *testing.T) {
+		l := newRateLimiter(2, 0)
+
+		for i := 0; i < 2; i++ {
+			if d := l.reserve("bot", "1", now); d != 0 {
+				t.Errorf("reserve %d = %v, want 0", i, d)
+			}
+		}
+
+		if d := l.reserve("bot", "2", now); d != 500*time.Millisecond {
+			t.Errorf("reserve after burst = %v, want 500ms", d)
+		}
+	})
+
+	t.Run("bucket refills over time", func(t *testing.T) {
+		l := newRateLimiter(0, 1)
+
+		l.reserve("bot", "1", now)
+		if d := l.reserve("bot", "1", now.Add(time.Second)); d != 0 {
+			t.Errorf("reserve after refill = %v, want 0", d)
+		}
+	})
+
+	t.Run("longest wait wins", func(t *testing.T) {
+		l := newRateLimiter(30, 1)
+
+		l.reserve("bot", "1", now)
+		if d := l.reserve("bot", "1", now); d != time.Second {
+			t.Errorf("reserve = %v, want chat limit of 1s", d)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		l := newRateLimiter(-1, -1)
+
+		for i := 0; i < 100; i++ {
+			if d := l.reserve("bot", "1", now); d != 0 {
+				t.Fatalf("reserve %d = %v, want 0", i, d)
+			}
+		}
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Bot API methods for sending media.
+const (
+	// sendPhotoAPI is the Bot API method for sending photos.
+	sendPhotoAPI = "sendPhoto"
+
+	// sendDocumentAPI is the Bot API method for sending general files.
+	sendDocumentAPI = "sendDocument"
+)
+
+// Media represents a photo or document attached to a message.
+// Exactly one of Data, Path and URL must be set.
+type Media struct {
+	// Data is the raw file content, uploaded with a multipart request.
+	Data []byte
+
+	// Path is the path of a local file, uploaded with a multipart request.
+	Path string
+
+	// URL is an HTTP URL Telegram downloads the file from, or the file_id of a file already on the Telegram servers.
+	URL string
+
+	// Filename is the file name shown to the recipients.
+	// If empty, the base name of Path is used, falling back to "photo" or "document".
+	Filename string
+}
+
+// sentMessage represents the message returned by the Bot API methods sending messages.
+type sentMessage struct {
+	MessageID int64 `json:"message_id"` // Unique identifier of the message in its chat
+	Photo     []struct {
+		FileID string `json:"file_id"` // Identifier of the photo, for reuse
+	} `json:"photo"` // Available sizes of a photo, the largest last
+	Document *struct {
+		FileID string `json:"file_id"` // Identifier of the document, for reuse
+	} `json:"document"`
+}
+
+// attachment is a resolved photo or document ready to be sent.
+type attachment struct {
+	method string // The Bot API method used to send the attachment
+	field  string // The name of the parameter carrying the file
+	name   string // The file name of an upload
+	data   []byte // The content of an upload
+	ref    string // A URL or file_id, sent instead of uploading data
+}
+
+// resolveAttachment resolves the photo or document of a message, reading it from disk if needed.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - *attachment: The resolved attachment, or nil if the message has none.
+//   - error: An error if the media is invalid or the file cannot be read.
+func resolveAttachment(m Message) (*attachment, error) {
+	if m.Photo != nil && m.Document != nil {
+		return nil, errors.New("telegram message cannot carry both a photo and a document")
+	}
+
+	var (
+		media *Media
+		a     *attachment
+	)
+	switch {
+	case m.Photo != nil:
+		media, a = m.Photo, &attachment{method: sendPhotoAPI, field: "photo", name: "photo"}
+	case m.Document != nil:
+		media, a = m.Document, &attachment{method: sendDocumentAPI, field: "document", name: "document"}
+	default:
+		return nil, nil
+	}
+
+	sources := 0
+	for _, set := range []bool{len(media.Data) > 0, media.Path != "", media.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of Data, Path and URL is required for telegram %s", a.field)
+	}
+
+	switch {
+	case media.URL != "":
+		a.ref = media.URL
+	case media.Path != "":
+		data, err := os.ReadFile(media.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read telegram %s: %w", a.field, err)
+		}
+		a.data, a.name = data, filepath.Base(media.Path)
+	default:
+		a.data = media.Data
+	}
+
+	if media.Filename != "" {
+		a.name = media.Filename
+	}
+
+	return a, nil
+}
+
+// sendAttachment sends a photo or document to a chat.
+// It waits until the message can be sent without exceeding the rate limits of the bot and the chat.
+//
+// Parameters:
+//   - ctx: The context of the message.
+//   - bot: The Bot configuration.
+//   - chatID: The target chat ID or channel username.
+//   - a: The attachment to send.
+//   - caption: The formatted caption, or empty for none.
+//   - opts: The delivery options of the message.
+//
+// Returns:
+//   - messageID: The ID of the sent message.
+//   - fileID: The file_id of the sent file, which can be used to send it again without uploading it.
+//   - err: An error if the attachment cannot be sent, nil otherwise.
+func (n *notify) sendAttachment(ctx context.Context, bot Bot, chatID string, a *attachment, caption string, opts sendOptions) (messageID int64, fileID string, err error) {
+	if err = n.limiter.wait(ctx, bot.Token, chatID); err != nil {
+		return 0, "", err
+	}
+
+	// The parse mode only applies to the caption
+	if caption == "" {
+		opts.parseMode = ""
+	}
+
+	var sent sentMessage
+	if a.ref != "" {
+		params := opts.params()
+		params["chat_id"] = chatID
+		params[a.field] = a.ref
+		if caption != "" {
+			params["caption"] = caption
+		}
+
+		err = n.callAPI(ctx, bot, a.method, params, &sent)
+	} else {
+		fields := opts.formFields()
+		fields["chat_id"] = chatID
+		if caption != "" {
+			fields["caption"] = caption
+		}
+
+		file := &FormFile{Field: a.field, Name: a.name, Data: a.data}
+		err = n.uploadFile(ctx, bot, a.method, fields, file, &sent)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	switch {
+	case len(sent.Photo) > 0:
+		return sent.MessageID, sent.Photo[len(sent.Photo)-1].FileID, nil
+	case sent.Document != nil:
+		return sent.MessageID, sent.Document.FileID, nil
+	default:
+		return sent.MessageID, "", nil
+	}
+}
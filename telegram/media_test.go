@@ -0,0 +1,181 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mediaHandler answers media methods with a sent message carrying a file_id.
+func mediaHandler(method string, _ map[string]any) (int, string) {
+	switch method {
+	case sendPhotoAPI:
+		return http.StatusOK, `{"ok":true,"result":{"message_id":1,"photo":[{"file_id":"small"},{"file_id":"photo-id"}]}}`
+	case sendDocumentAPI:
+		return http.StatusOK, `{"ok":true,"result":{"message_id":1,"document":{"file_id":"doc-id"}}}`
+	default:
+		return okHandler(method, nil)
+	}
+}
+
+func TestResolveAttachment(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		wantErr bool
+	}{
+		{name: "no media", message: Message{}},
+		{name: "photo data", message: Message{Photo: &Media{Data: []byte("x")}}},
+		{name: "document url", message: Message{Document: &Media{URL: "https://example.com/a.pdf"}}},
+		{name: "photo and document", message: Message{Photo: &Media{URL: "a"}, Document: &Media{URL: "b"}}, wantErr: true},
+		{name: "no source", message: Message{Photo: &Media{Filename: "a.png"}}, wantErr: true},
+		{name: "two sources", message: Message{Photo: &Media{Data: []byte("x"), URL: "a"}}, wantErr: true},
+		{name: "missing file", message: Message{Document: &Media{Path: "/nonexistent/file"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveAttachment(tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveAttachment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotify_SendMsg_Photo(t *testing.T) {
+	srv, requests := newTestServer(t, mediaHandler)
+	n := newTestNotify(t, srv.URL)
+
+	_, err := n.sendMsg(context.Background(), Message{
+		SendChannelName: "html",
+		SendTo:          "-1001,-1002",
+		Title:           "CPU <high>",
+		Content:         "graph attached",
+		Photo:           &Media{Data: []byte("\x89PNG"), Filename: "cpu.png"},
+	})
+	if err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(got))
+	}
+
+	// The first chat receives an upload
+	upload := got[0]
+	if upload.Method != sendPhotoAPI || upload.Files["photo"] != (uploadedFile{Name: "cpu.png", Data: "\x89PNG"}) {
+		t.Errorf("upload = %s %v", upload.Method, upload.Files)
+	}
+
+	if upload.Params["caption"] != "<b>CPU &lt;high&gt;</b>\ngraph attached" || upload.Params["parse_mode"] != ParseModeHTML {
+		t.Errorf("upload params = %v", upload.Params)
+	}
+
+	// The second chat reuses the uploaded file
+	reuse := got[1]
+	if reuse.Method != sendPhotoAPI || reuse.Params["photo"] != "photo-id" || len(reuse.Files) != 0 {
+		t.Errorf("reuse = %s %v %v", reuse.Method, reuse.Params, reuse.Files)
+	}
+}
+
+func TestNotify_SendMsg_Document(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	if err := os.WriteFile(path, []byte("goroutine 1 [running]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("path", func(t *testing.T) {
+		srv, requests := newTestServer(t, mediaHandler)
+		n := newTestNotify(t, srv.URL)
+
+		if _, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Document: &Media{Path: path}}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		if got.Method != sendDocumentAPI || got.Files["document"] != (uploadedFile{Name: "trace.log", Data: "goroutine 1 [running]"}) {
+			t.Errorf("request = %s %v", got.Method, got.Files)
+		}
+
+		if _, ok := got.Params["caption"]; ok {
+			t.Errorf("unexpected caption %v", got.Params["caption"])
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		srv, requests := newTestServer(t, mediaHandler)
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: "report", Document: &Media{URL: "https://example.com/r.pdf"}})
+		if err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		if got.Params["document"] != "https://example.com/r.pdf" || got.Params["caption"] != "report" || len(got.Files) != 0 {
+			t.Errorf("request = %v %v", got.Params, got.Files)
+		}
+	})
+
+	t.Run("long caption is sent as text", func(t *testing.T) {
+		srv, requests := newTestServer(t, mediaHandler)
+		n := newTestNotify(t, srv.URL)
+
+		content := strings.Repeat("x", maxCaptionLength+1)
+		if _, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: content, Document: &Media{Path: path}}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()
+		if len(got) != 2 || got[0].Method != sendDocumentAPI || got[1].Method != sendMessageAPI {
+			t.Fatalf("requests = %v, want sendDocument then sendMessage", got)
+		}
+
+		if _, ok := got[0].Params["caption"]; ok || got[1].Params["text"] != content {
+			t.Errorf("caption = %v, text length = %d", got[0].Params["caption"], len(got[1].Params["text"].(string)))
+		}
+	})
+}
+
+func TestNotify_SendMsg_LongText(t *testing.T) {
+	srv, requests := newTestServer(t, okHandler)
+	n := newTestNotify(t, srv.URL)
+
+	var content strings.Builder
+	for i := 0; i < 400; i++ {
+		content.WriteString("    at com.example.Service.handle(Service.java:42)\n")
+	}
+
+	_, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", ParseMode: ParseModeMarkdownV2, Title: "Exception", Content: content.String()})
+	if err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	got := requests()
+	if len(got) < 2 {
+		t.Fatalf("server received %d requests, want the text to be split", len(got))
+	}
+
+	var text strings.Builder
+	for i, r := range got {
+		chunk := r.Params["text"].(string)
+		if textLength(chunk) > maxTextLength {
+			t.Errorf("chunk %d has length %d", i, textLength(chunk))
+		}
+		text.WriteString(chunk + "\n")
+	}
+
+	want := "*Exception*\n" + EscapeMarkdownV2(content.String())
+	if text.String() != want {
+		t.Error("chunks are not delivered in order")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/retry"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request represents a request to the Bot API.
+type Request struct {
+	Method   string
+	URL      string
+	Headers  map[string]string
+	Body     any
+	FormData map[string]string // Multipart form fields, sent instead of Body when File is set
+	File     *FormFile         // File uploaded as part of a multipart form
+}
+
+// FormFile represents a file uploaded in a multipart request.
+type FormFile struct {
+	Field string // Name of the form field
+	Name  string // File name
+	Data  []byte // File content
+}
+
+// Response represents a response from the Bot API.
+type Response struct {
+	StatusCode int
+	Body       []byte
+	Headers    map[string][]string
+}
+
+// apiResp represents the envelope of every Bot API response.
+type apiResp struct {
+	OK          bool            `json:"ok"`          // Whether the request was successful
+	Result      json.RawMessage `json:"result"`      // The result of the request if successful
+	ErrorCode   int             `json:"error_code"`  // Error code if the request failed
+	Description string          `json:"description"` // Human-readable description of the result
+	Parameters  *struct {
+		MigrateToChatID int64 `json:"migrate_to_chat_id"` // The new ID of a group migrated to a supergroup
+		RetryAfter      int   `json:"retry_after"`        // Seconds to wait before the request can be repeated
+	} `json:"parameters"`
+}
+
+// apiError is returned when the Bot API reports a failed request.
+type apiError struct {
+	method      string
+	code        int
+	description string
+	retryAfter  time.Duration // How long to wait before retrying a rate limited request
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("telegram %s failed: %d %s", e.method, e.code, e.description)
+}
+
+// callAPI calls a Bot API method with a JSON body and decodes its result.
+//
+// Telegram answers failed requests with a non-2xx status and an error description in the body,
+// so both are checked here and reported as an *apiError.
+//
+// The errors worth retrying later, rate limited requests, 5xx responses and transport errors,
+// are marked for the retry policy. A 429 (Too Many Requests) error carries the 'parameters.retry_after'
+// of the response, 1 second if it is missing, so that the policy does not retry it earlier.
+//
+// Parameters:
+//   - ctx: The context of the request. Canceling it aborts the request.
+//   - bot: The Bot configuration.
+//   - method: The Bot API method, e.g. sendMessage.
+//   - params: The parameters of the method.
+//   - result: A pointer the result is decoded into, or nil to discard it.
+//
+// Returns:
+//   - error: An error if the request fails or the Bot API reports an error, nil otherwise.
+func (n *notify) callAPI(ctx context.Context, bot Bot, method string, params map[string]any, result any) error {
+	request := &Request{
+		Method:  "POST",
+		URL:     n.methodURL(bot, method),
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    params,
+	}
+
+	return n.doRequest(ctx, bot, method, request, result)
+}
+
+// uploadFile calls a Bot API method with a multipart form uploading a file, and decodes its result.
+// Errors are handled as in callAPI.
+//
+// Parameters:
+//   - ctx: The context of the request.
+//   - bot: The Bot configuration.
+//   - method: The Bot API method, e.g. sendDocument.
+//   - fields: The parameters of the method other than the file.
+//   - file: The file to upload.
+//   - result: A pointer the result is decoded into, or nil to discard it.
+//
+// Returns:
+//   - error: An error if the request fails or the Bot API reports an error, nil otherwise.
+func (n *notify) uploadFile(ctx context.Context, bot Bot, method string, fields map[string]string, file *FormFile, result any) error {
+	request := &Request{
+		Method:   "POST",
+		URL:      n.methodURL(bot, method),
+		FormData: fields,
+		File:     file,
+	}
+
+	return n.doRequest(ctx, bot, method, request, result)
+}
+
+// doRequest executes a request and decodes its result. Failed requests are left to the retry policy.
+func (n *notify) doRequest(ctx context.Context, bot Bot, method string, request *Request, result any) error {
+	response, err := n.executeRequest(ctx, request)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", redactToken(err, bot.Token))
+	}
+
+	return classifyError(decodeResponse(method, response, result))
+}
+
+// classifyError marks the failures reported by the Bot API that are worth retrying later:
+// rate limited requests, after their retry_after, and server errors.
+func classifyError(err error) error {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch {
+	case apiErr.code == http.StatusTooManyRequests:
+		return retry.After(err, apiErr.retryAfter)
+	case retry.TransientStatus(apiErr.code):
+		return retry.Transient(err)
+	}
+
+	return err
+}
+
+// methodURL returns the URL of a Bot API method for a bot.
+func (n *notify) methodURL(bot Bot, method string) string {
+	return n.host + "/bot" + bot.Token + "/" + method
+}
+
+// decodeResponse decodes a Bot API response into result.
+func decodeResponse(method string, response *Response, result any) error {
+	var rs apiResp
+	if err := json.Unmarshal(response.Body, &rs); err != nil {
+		err = fmt.Errorf("failed to parse response with status code %d: %w", response.StatusCode, err)
+		if retry.TransientStatus(response.StatusCode) {
+			err = retry.Transient(err)
+		}
+
+		return err
+	}
+
+	if !rs.OK {
+		err := &apiError{method: method, code: rs.ErrorCode, description: rs.Description, retryAfter: time.Second}
+		if rs.Parameters != nil && rs.Parameters.RetryAfter > 0 {
+			err.retryAfter = time.Duration(rs.Parameters.RetryAfter) * time.Second
+		}
+
+		return err
+	}
+
+	if result != nil && len(rs.Result) > 0 {
+		if err := json.Unmarshal(rs.Result, result); err != nil {
+			return fmt.Errorf("failed to parse %s result: %w", method, err)
+		}
+	}
+
+	return nil
+}
+
+// executeRequest executes a single API request.
+func (n *notify) executeRequest(ctx context.Context, request *Request) (*Response, error) {
+	req := n.request.R().SetContext(ctx).SetHeaders(request.Headers)
+
+	if request.File != nil {
+		req.SetMultipartFormData(request.FormData).
+			SetFileReader(request.File.Field, request.File.Name, bytes.NewReader(request.File.Data))
+	} else {
+		req.SetBody(request.Body)
+	}
+
+	resp, err := req.Execute(request.Method, request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode(),
+		Body:       resp.Body(),
+		Headers:    resp.Header(),
+	}, nil
+}
+
+// redactToken removes the bot token from an error message, since transport errors include the request URL.
+// A redacted error stays retryable if the original one was.
+func redactToken(err error, token string) error {
+	if token == "" || !strings.Contains(err.Error(), token) {
+		return err
+	}
+
+	redacted := errors.New(strings.ReplaceAll(err.Error(), token, "<token>"))
+	if retry.IsRetryable(err) {
+		return retry.Transient(redacted)
+	}
+
+	return redacted
+}
@@ -2,58 +2,915 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
+// Package telegram provides functionality for sending messages via the Telegram Bot API.
+// It supports multiple named bots, each with its own default chats and parse mode.
 package telegram
 
-import "github.com/sk-pkg/notify/msgid"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants used throughout the package
+const (
+	// defaultAPIBaseURL is the base URL of the official Telegram Bot API server.
+	defaultAPIBaseURL = "https://api.telegram.org"
+
+	// sendMessageAPI is the Bot API method for sending text messages.
+	sendMessageAPI = "sendMessage"
+
+	// maxTextLength is the maximum length of a text message.
+	maxTextLength = 4096
+
+	// maxCaptionLength is the maximum length of a media caption.
+	maxCaptionLength = 1024
+
+	// defaultGlobalRate is the default number of messages per second sent by a bot.
+	defaultGlobalRate = 30
+
+	// defaultChatRate is the default number of messages per second sent to a chat.
+	defaultChatRate = 1
+)
+
+// Parse modes supported for formatting messages.
+const (
+	// ParseModeMarkdownV2 formats messages with Telegram's MarkdownV2 syntax.
+	ParseModeMarkdownV2 = "MarkdownV2"
+
+	// ParseModeHTML formats messages with Telegram's HTML subset.
+	ParseModeHTML = "HTML"
+)
+
+// Config represents the configuration for the Telegram notifier.
+type Config struct {
+	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
+	Enabled bool
+
+	// DefaultSendChannelName is the default bot name for sending messages when not specified in the message.
+	// This must be set to a valid bot name from Bots.
+	DefaultSendChannelName string
+
+	// ChannelSize defines the buffer size for the message channel.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	ChannelSize int
+
+	// PoolSize defines the number of goroutines in the worker pool.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	PoolSize int
+
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
+	// IdleSize is kept for compatibility and is currently unused.
+	IdleSize int
+
+	// APIBaseURL is the base URL of the Bot API server, e.g. a local Bot API server.
+	// If empty, it defaults to https://api.telegram.org.
+	APIBaseURL string
+
+	// GlobalRate is the number of messages per second each bot may send across all chats.
+	// If set to 0, it defaults to 30. A negative value disables the limit.
+	GlobalRate float64
+
+	// ChatRate is the number of messages per second each bot may send to a single chat.
+	// If set to 0, it defaults to 1. A negative value disables the limit.
+	ChatRate float64
+
+	// PollTimeout is the timeout of the long polling requests of bots with PollUpdates enabled.
+	// If set to 0, it defaults to 30 seconds.
+	PollTimeout time.Duration
+
+	// MaxRetries is kept for compatibility and is currently unused.
+	// Requests answered with 429 Too Many Requests are retried by the Retry policy.
+	MaxRetries int
+
+	// Retry is the policy applied to failed deliveries: transport errors, 5xx responses, and 429 responses,
+	// which are not retried before the retry_after of the response. Only the chats that did not receive
+	// the message are retried. The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+
+	// Bots is a map of bot names to their corresponding configurations.
+	// The key will be used as the send channel name.
+	Bots map[string]Bot
+
+	// Levels maps message levels (info, success, warn, error) to their delivery options.
+	// They are applied to messages with a matching MsgLevel, such as those sent by Manager.Info or Manager.Error.
+	Levels map[string]LevelOptions
+}
+
+// LevelOptions represents the delivery options of a message level.
+type LevelOptions struct {
+	// MessageThreadID is the forum topic messages of the level are sent to, unless they specify one.
+	MessageThreadID int64
+
+	// Silent sends messages of the level without notification sound.
+	Silent bool
+}
+
+// Bot represents the configuration for a Telegram bot.
+type Bot struct {
+	// Token is the bot token issued by @BotFather.
+	Token string
+
+	// ChatIDs is the list of chats messages are sent to when a message does not specify any.
+	// Each entry is a chat ID such as "-1001234567890" or a channel username such as "@alerts".
+	ChatIDs []string
+
+	// ParseMode is the default parse mode of the bot's messages: MarkdownV2, HTML or empty for plain text.
+	ParseMode string
+
+	// PollUpdates enables long polling of callback queries with getUpdates once StartProcessor is called.
+	// It cannot be used together with a webhook, or with another process polling the same bot.
+	PollUpdates bool
+}
+
+// Notify is the interface that wraps the basic methods for the notifier.
+type Notify interface {
+	// StartProcessor initiates the message processing routine.
+	// It should be called once before submitting any messages.
+	StartProcessor()
+
+	// SubmitMessage adds a new message to the processing queue.
+	// The message will be processed asynchronously by the processor started with StartProcessor.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message.
+	SubmitMessage(message Message) (msgID string, err error)
+
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
+	// HandleCallback registers a handler for callback queries whose data starts with prefix.
+	// Queries are received by the bots with PollUpdates enabled.
+	//
+	// Parameters:
+	// 	- prefix: The prefix of the callback data. The longest matching prefix wins, and "" matches every query.
+	// 	- handler: The handler called with the query, returning the answer shown to the user.
+	HandleCallback(prefix string, handler CallbackHandler)
+
+	// Send sends a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The IDs of the first message sent to each chat, as comma-separated chat_id:message_id pairs.
+	// 	- err: An error if the message cannot be sent to any of the chats.
+	Send(message Message) (providerMsgID string, err error)
+
+	// SendContext sends a message immediately, bypassing the processing queue, and stops retrying
+	// once ctx is done.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The IDs of the first message sent to each chat, as comma-separated chat_id:message_id pairs.
+	// 	- attempts: The number of attempts made.
+	// 	- err: An error if the message cannot be sent to any of the chats.
+	SendContext(ctx context.Context, message Message) (providerMsgID string, attempts int, err error)
+
+	// Subscribe registers a handler called with the result of every delivery attempt, including the
+	// retries and the messages dropped before delivery, of the queued messages and of SendContext.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
+	// Close stops the notifier, ensuring all pending messages are processed
+	// before shutting down. After calling Close, the notifier should not be used anymore.
+	Close()
+}
+
+// notify implements the Notify interface.
+type notify struct {
+	// defaultSendChannelName is the default bot name for sending messages when not specified in the message.
+	defaultSendChannelName string
+
+	// host is the base URL of the Bot API server.
+	host string
+
+	// msgID is ID instance used for generating unique message IDs.
+	msgID *msgid.ID
+
+	// request is a resty client used for making HTTP requests to the Bot API.
+	request *resty.Client
+
+	// bots is a map of bot names to their corresponding configurations.
+	bots map[string]Bot
+
+	// limiter throttles messages per bot and per chat.
+	limiter *rateLimiter
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
+
+	// levels maps message levels to their delivery options.
+	levels map[string]LevelOptions
+
+	// handlers maps callback data prefixes to their handlers.
+	handlers map[string]CallbackHandler
 
-type (
-	Config struct {
-		Enabled bool
+	// handlersMu protects handlers.
+	handlersMu sync.RWMutex
 
-		ChannelSize int
-		PoolSize    int
-		IdleSize    int
+	// pollTimeout is the timeout of a long polling request.
+	pollTimeout time.Duration
+
+	// pollCtx is canceled to stop polling updates.
+	pollCtx context.Context
+
+	// stopPolling cancels pollCtx.
+	stopPolling context.CancelFunc
+
+	// pollWG is used to wait for the polling goroutines to finish.
+	pollWG sync.WaitGroup
+}
+
+// Message represents a message to be sent via the notifier.
+type Message struct {
+	// ID is a unique identifier for the message. It can be used for tracking or deduplication.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets. It is reported in its SendResults.
+	DeliveryID string
+
+	// SendChannelName specifies the bot through which the message should be sent.
+	// If empty, the DefaultSendChannelName from the Config will be used.
+	SendChannelName string
+
+	// SendTo is a comma-separated list of chat IDs or channel usernames, e.g. "-1001234567890,@alerts".
+	// If empty, the ChatIDs of the bot will be used.
+	SendTo string
+
+	// ParseMode overrides the parse mode of the bot: MarkdownV2, HTML or empty to use the bot's parse mode.
+	ParseMode string
+
+	// Title is the title of the message. It is rendered in bold above the content
+	// when a parse mode is used, and escaped accordingly.
+	Title string
+
+	// Content contains the main body of the message. It is escaped according to the parse mode.
+	// Text longer than Telegram's limit of 4096 characters is sent as several messages, in order.
+	Content string
+
+	// Photo is a photo sent with the message. Title and Content are used as its caption
+	// if they fit in 1024 characters, and are sent as a separate text message otherwise.
+	Photo *Media
+
+	// Document is a file sent with the message, with the same caption rules as Photo.
+	Document *Media
+
+	// MsgLevel indicates the importance or category of the message (e.g., "info", "warn", "error", "success").
+	// The delivery options of the level in Config.Levels are applied to the message.
+	MsgLevel string
+
+	// MessageThreadID is the forum topic the message is sent to.
+	// If 0, the topic of the message level is used, if any.
+	MessageThreadID int64
+
+	// DisableNotification sends the message silently. Messages of a silent level are always sent silently.
+	DisableNotification bool
+
+	// ProtectContent protects the message from forwarding and saving.
+	ProtectContent bool
+
+	// ReplyToMessageID is the message the message replies to. If the message is split, only the first part replies.
+	ReplyToMessageID int64
+
+	// InlineKeyboard is a keyboard of buttons attached to the message, as rows of buttons.
+	// If the message is split, it is attached to the last part.
+	InlineKeyboard [][]InlineButton
+}
+
+// validateConfig checks the provided configuration for validity.
+//
+// Parameters:
+//   - config: A pointer to the Config struct to be validated.
+//
+// Returns:
+//   - error: An error if the configuration is invalid, nil otherwise.
+func validateConfig(config *Config) error {
+	if len(config.Bots) == 0 {
+		return errors.New("there are no available sending channels for telegram, please configure Bots")
 	}
 
-	Notify interface {
-		StartProcessor()
-		SubmitMessage(message Message) (msgID string)
-		Close()
+	if config.DefaultSendChannelName == "" {
+		return errors.New("DefaultSendChannelName is required")
 	}
 
-	notify struct {
-		msgID    *msgid.ID
-		messages chan Message
+	for name, bot := range config.Bots {
+		if bot.Token == "" {
+			return fmt.Errorf("telegram bot config error: %s", name)
+		}
+
+		if !validParseMode(bot.ParseMode) {
+			return fmt.Errorf("invalid parse mode %q for telegram bot %s", bot.ParseMode, name)
+		}
 	}
 
-	Message struct {
-		ID      string
-		Title   string
-		Content string
+	if _, ok := config.Bots[config.DefaultSendChannelName]; !ok {
+		return fmt.Errorf("default send channel %s is not found in telegram", config.DefaultSendChannelName)
 	}
-)
 
-func (n *notify) Close() {
-	return
+	if config.APIBaseURL == "" {
+		config.APIBaseURL = defaultAPIBaseURL
+	}
+	config.APIBaseURL = strings.TrimSuffix(config.APIBaseURL, "/")
+
+	if config.GlobalRate == 0 {
+		config.GlobalRate = defaultGlobalRate
+	}
+
+	if config.ChatRate == 0 {
+		config.ChatRate = defaultChatRate
+	}
+
+	if config.PollTimeout == 0 {
+		config.PollTimeout = defaultPollTimeout
+	}
+
+	// Set default values for ChannelSize and PoolSize if not provided
+	// Default to GOMAXPROCS * 10
+	if config.ChannelSize == 0 {
+		config.ChannelSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid telegram retry policy: %w", err)
+	}
+
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid telegram outbox: %w", err)
+	}
+
+	return nil
 }
 
+// StartProcessor starts the message processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
+// It also starts polling callback queries for the bots with PollUpdates enabled.
 func (n *notify) StartProcessor() {
+	n.startPolling()
+
+	n.queue.Start()
+}
 
+// SubmitMessage submits a message to the notifier's message queue.
+//
+// Parameters:
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
+func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
 }
 
-func (n *notify) SubmitMessage(message Message) (msgID string) {
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
 	if message.ID == "" {
 		message.ID = n.msgID.New()
 	}
 
-	n.messages <- message
+	key := outbox.Key(message.ID, message.SendChannelName, message.SendTo)
 
-	return message.ID
+	return message.ID, n.queue.Submit(ctx, key, message)
 }
 
+// New creates a new Notify instance with the provided configuration.
+//
+// Parameters:
+//   - config: The Config struct containing the notifier configuration.
+//
+// Returns:
+//   - Notify: A new Notify instance.
+//   - error: An error if the configuration is invalid or if the goroutine pool cannot be created.
 func New(config Config) (Notify, error) {
-	return &notify{
-		messages: make(chan Message, config.ChannelSize),
-		msgID:    msgid.NewMessageID(),
-	}, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	n := &notify{
+		defaultSendChannelName: config.DefaultSendChannelName,
+		host:                   config.APIBaseURL,
+		msgID:                  msgid.NewMessageID(),
+		request:                resty.New(),
+		bots:                   config.Bots,
+		limiter:                newRateLimiter(config.GlobalRate, config.ChatRate),
+		retry:                  config.Retry,
+		levels:                 config.Levels,
+		handlers:               make(map[string]CallbackHandler),
+		pollTimeout:            config.PollTimeout,
+	}
+
+	n.pollCtx, n.stopPolling = context.WithCancel(context.Background())
+
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "telegram",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send:     n.sendMsg,
+		// Only the chats that did not receive the message are retried
+		Retried: func(m Message, err error) (Message, bool) {
+			m, err = n.failedChats(m, err)
+			if err != nil {
+				log.Printf("failed to send telegram message: %v\n", err)
+			}
+
+			return m, true
+		},
+		Report: n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
+	return n, nil
+}
+
+// Send sends a message immediately.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The IDs of the first message sent to each chat, as comma-separated chat_id:message_id pairs.
+//   - error: The errors of all failed chats joined together, nil if every chat received the message.
+func (n *notify) Send(message Message) (string, error) {
+	providerMsgID, _, err := n.SendContext(context.Background(), message)
+
+	return providerMsgID, err
+}
+
+// SendContext sends a message immediately, and stops retrying once ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The IDs of the first message sent to each chat, as comma-separated chat_id:message_id pairs.
+//   - int: The number of attempts made.
+//   - error: The errors of all failed chats joined together, nil if every chat received the message.
+func (n *notify) SendContext(ctx context.Context, message Message) (string, int, error) {
+	if message.ID == "" {
+		message.ID = n.msgID.New()
+	}
+
+	if err := ctx.Err(); err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return "", 0, err
+	}
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	var (
+		sent []string
+		errs []error
+	)
+	for attempt := 1; ; attempt++ {
+		providerMsgID, err := n.sendMsg(ctx, message)
+		if providerMsgID != "" {
+			sent = append(sent, providerMsgID)
+		}
+
+		result := queue.Result{State: StateRetrying, ProviderMsgID: providerMsgID, Err: err, Attempts: attempt}
+
+		delay, ok := n.retry.Delay(attempt, err)
+		if !ok {
+			result.ProviderMsgID, result.Err = strings.Join(sent, ","), errors.Join(append(errs, err)...)
+			result.State = StateSent
+			if result.Err != nil {
+				result.State = StateFailed
+			}
+			n.report(message, result)
+
+			return result.ProviderMsgID, attempt, result.Err
+		}
+
+		n.report(message, result)
+
+		var permanent error
+		message, permanent = n.failedChats(message, err)
+		if permanent != nil {
+			errs = append(errs, permanent)
+		}
+
+		if waitErr := retry.Wait(ctx, delay); waitErr != nil {
+			err = fmt.Errorf("%w, retry aborted: %w", err, waitErr)
+			result.ProviderMsgID, result.Err = strings.Join(sent, ","), errors.Join(append(errs, err)...)
+			result.State = StateFailed
+			n.report(message, result)
+
+			return result.ProviderMsgID, attempt, result.Err
+		}
+	}
+}
+
+// failedChats restricts the recipients of a message to the chats whose delivery failed with a retryable error,
+// so that a retry does not send the message twice to the other chats.
+// The failed chats receive every part of the message again.
+//
+// Parameters:
+//   - m: The Message struct that was sent.
+//   - err: The retryable error returned by sendMsg.
+//
+// Returns:
+//   - Message: The message to be retried.
+//   - error: The errors of the chats that are not retried, nil if there are none.
+func (n *notify) failedChats(m Message, err error) (Message, error) {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return m, nil
+	}
+
+	var (
+		chatIDs, failed []string
+		permanent       []error
+	)
+	for _, e := range joined.Unwrap() {
+		var chatErr *chatError
+		if !errors.As(e, &chatErr) {
+			continue
+		}
+
+		failed = append(failed, chatErr.chatID)
+		if n.retry.IsRetryable(chatErr.err) {
+			chatIDs = append(chatIDs, chatErr.chatID)
+			continue
+		}
+
+		permanent = append(permanent, e)
+	}
+
+	// A custom classifier may retry the message as a whole without retrying any chat on its own
+	if len(chatIDs) == 0 {
+		chatIDs, permanent = failed, nil
+	}
+
+	m.SendTo = strings.Join(chatIDs, ",")
+
+	return m, errors.Join(permanent...)
+}
+
+// sendMsg sends a message to every target chat through the selected bot.
+// Delivery continues with the remaining chats if one of them fails.
+//
+// Parameters:
+//   - ctx: The context of the message. Canceling it aborts the requests and the rate limit waits.
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - string: The IDs of the first message sent to each chat, as comma-separated chat_id:message_id pairs.
+//   - error: The errors of all failed chats joined together, nil if every chat received the message.
+func (n *notify) sendMsg(ctx context.Context, m Message) (string, error) {
+	channel := m.SendChannelName
+	if channel == "" {
+		channel = n.defaultSendChannelName
+	}
+
+	bot, ok := n.bots[channel]
+	if !ok {
+		return "", fmt.Errorf("channel %s is not found in telegram", channel)
+	}
+
+	chatIDs := bot.ChatIDs
+	if m.SendTo != "" {
+		chatIDs = splitChatIDs(m.SendTo)
+	}
+
+	if len(chatIDs) == 0 {
+		return "", fmt.Errorf("no chat IDs for telegram bot %s", channel)
+	}
+
+	opts := sendOptions{
+		parseMode:           bot.ParseMode,
+		messageThreadID:     m.MessageThreadID,
+		disableNotification: m.DisableNotification,
+		protectContent:      m.ProtectContent,
+		replyToMessageID:    m.ReplyToMessageID,
+	}
+	if m.ParseMode != "" {
+		opts.parseMode = m.ParseMode
+	}
+
+	// Apply the delivery options of the message level
+	if level, ok := n.levels[m.MsgLevel]; ok {
+		if opts.messageThreadID == 0 {
+			opts.messageThreadID = level.MessageThreadID
+		}
+		opts.disableNotification = opts.disableNotification || level.Silent
+	}
+
+	a, err := resolveAttachment(m)
+	if err != nil {
+		return "", err
+	}
+
+	caption, chunks, err := buildTexts(opts.parseMode, m, a != nil)
+	if err != nil {
+		return "", err
+	}
+
+	opts.markup, err = buildReplyMarkup(m.InlineKeyboard)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		sent []string
+		errs []error
+	)
+	for _, chatID := range chatIDs {
+		messageID, err := n.sendToChat(ctx, bot, chatID, a, caption, chunks, opts)
+		if messageID != 0 {
+			sent = append(sent, chatID+":"+strconv.FormatInt(messageID, 10))
+		}
+		if err != nil {
+			errs = append(errs, &chatError{chatID: chatID, err: err})
+		}
+	}
+
+	return strings.Join(sent, ","), errors.Join(errs...)
+}
+
+// chatError is the error of a chat that did not receive a message.
+type chatError struct {
+	chatID string
+	err    error
+}
+
+func (e *chatError) Error() string {
+	return fmt.Sprintf("chat %s: %v", e.chatID, e.err)
+}
+
+// Unwrap returns the error of the chat.
+func (e *chatError) Unwrap() error {
+	return e.err
+}
+
+// sendToChat sends the attachment of a message followed by its text chunks to a chat.
+// Sending stops at the first failure so that the recipients never see chunks out of order.
+// Only the first part replies to ReplyToMessageID, and only the last part carries the inline keyboard.
+//
+// Parameters:
+//   - ctx: The context of the message.
+//   - bot: The Bot configuration.
+//   - chatID: The target chat ID or channel username.
+//   - a: The attachment to send, or nil for none.
+//   - caption: The formatted caption of the attachment.
+//   - chunks: The formatted text messages.
+//   - opts: The delivery options of the message.
+//
+// Returns:
+//   - int64: The ID of the first message sent, 0 if none was sent.
+//   - error: An error if any part of the message cannot be sent, nil otherwise.
+func (n *notify) sendToChat(ctx context.Context, bot Bot, chatID string, a *attachment, caption string, chunks []string, opts sendOptions) (int64, error) {
+	parts := len(chunks)
+	if a != nil {
+		parts++
+	}
+
+	part := 0
+	partOpts := func() sendOptions {
+		o := opts
+		if part > 0 {
+			o.replyToMessageID = 0
+		}
+		if part < parts-1 {
+			o.markup = nil
+		}
+		part++
+
+		return o
+	}
+
+	var first int64
+	if a != nil {
+		messageID, fileID, err := n.sendAttachment(ctx, bot, chatID, a, caption, partOpts())
+		if err != nil {
+			return 0, err
+		}
+		first = messageID
+
+		// Later chats reuse the uploaded file instead of uploading it again
+		if a.ref == "" && fileID != "" {
+			a.ref = fileID
+		}
+	}
+
+	for i, chunk := range chunks {
+		messageID, err := n.sendText(ctx, bot, chatID, chunk, partOpts())
+		if err != nil {
+			return first, fmt.Errorf("failed to send part %d of %d: %w", i+1, len(chunks), err)
+		}
+		if first == 0 {
+			first = messageID
+		}
+	}
+
+	return first, nil
+}
+
+// buildTexts formats the text of a message into the caption of its attachment if it fits,
+// and into text messages no longer than Telegram's limit otherwise.
+//
+// Parameters:
+//   - parseMode: The parse mode of the message.
+//   - m: The Message struct containing the message details.
+//   - hasAttachment: Whether the message carries a photo or document.
+//
+// Returns:
+//   - caption: The formatted caption of the attachment.
+//   - chunks: The formatted text messages, in order.
+//   - err: An error if the parse mode is not supported or a message without attachment has no text.
+func buildTexts(parseMode string, m Message, hasAttachment bool) (caption string, chunks []string, err error) {
+	if hasAttachment {
+		if m.Title == "" && m.Content == "" {
+			return "", nil, nil
+		}
+
+		text, err := formatText(parseMode, m.Title, m.Content)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if textLength(text) <= maxCaptionLength {
+			return text, nil, nil
+		}
+	}
+
+	chunks, err = splitText(parseMode, m.Title, m.Content, maxTextLength)
+
+	return "", chunks, err
+}
+
+// sendText sends a formatted text message to a chat.
+// It waits until the message can be sent without exceeding the rate limits of the bot and the chat.
+//
+// Parameters:
+//   - ctx: The context of the message.
+//   - bot: The Bot configuration.
+//   - chatID: The target chat ID or channel username.
+//   - text: The formatted text.
+//   - opts: The delivery options of the message.
+//
+// Returns:
+//   - int64: The ID of the sent message.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendText(ctx context.Context, bot Bot, chatID, text string, opts sendOptions) (int64, error) {
+	params := opts.params()
+	params["chat_id"] = chatID
+	params["text"] = text
+
+	if err := n.limiter.wait(ctx, bot.Token, chatID); err != nil {
+		return 0, err
+	}
+
+	var sent sentMessage
+	if err := n.callAPI(ctx, bot, sendMessageAPI, params, &sent); err != nil {
+		return 0, err
+	}
+
+	return sent.MessageID, nil
+}
+
+// sendOptions are the delivery options of a message, shared by all of its parts.
+type sendOptions struct {
+	parseMode           string
+	messageThreadID     int64
+	disableNotification bool
+	protectContent      bool
+	replyToMessageID    int64
+	markup              *inlineKeyboardMarkup
+}
+
+// params returns the options as Bot API parameters, omitting the unset ones.
+func (o sendOptions) params() map[string]any {
+	params := map[string]any{}
+	if o.parseMode != "" {
+		params["parse_mode"] = o.parseMode
+	}
+	if o.messageThreadID != 0 {
+		params["message_thread_id"] = o.messageThreadID
+	}
+	if o.disableNotification {
+		params["disable_notification"] = true
+	}
+	if o.protectContent {
+		params["protect_content"] = true
+	}
+	if o.replyToMessageID != 0 {
+		params["reply_to_message_id"] = o.replyToMessageID
+	}
+	if o.markup != nil {
+		params["reply_markup"] = o.markup
+	}
+
+	return params
+}
+
+// formFields returns the options as multipart form fields, omitting the unset ones.
+func (o sendOptions) formFields() map[string]string {
+	fields := make(map[string]string)
+	for key, value := range o.params() {
+		fields[key] = fmt.Sprint(value)
+	}
+
+	return fields
+}
+
+// splitChatIDs splits a comma-separated list of chat IDs.
+func splitChatIDs(sendTo string) []string {
+	var chatIDs []string
+	for _, id := range strings.Split(sendTo, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			chatIDs = append(chatIDs, id)
+		}
+	}
+
+	return chatIDs
+}
+
+// Close stops the notifier, waits for all messages to be processed, and releases resources.
+func (n *notify) Close() {
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
+
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
+
+	// Stop polling updates
+	n.stopPolling()
+	n.pollWG.Wait()
+
+	log.Println("Telegram notify closed")
 }
@@ -0,0 +1,651 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testToken = "123456:TEST-token"
+
+// apiRequest records a request received by the fake Bot API server.
+// Multipart form fields are recorded as Params, and uploaded files as Files.
+type apiRequest struct {
+	Method string
+	Params map[string]any
+	Files  map[string]uploadedFile
+}
+
+// uploadedFile records a file uploaded to the fake Bot API server.
+type uploadedFile struct {
+	Name string
+	Data string
+}
+
+// apiHandler answers a Bot API method call with a status code and a JSON body.
+type apiHandler func(method string, params map[string]any) (int, string)
+
+// okHandler answers every call successfully.
+func okHandler(string, map[string]any) (int, string) {
+	return http.StatusOK, `{"ok":true,"result":{"message_id":1}}`
+}
+
+// newTestServer starts a fake Bot API server that records every request and answers it with handler.
+func newTestServer(t *testing.T, handler apiHandler) (*httptest.Server, func() []apiRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []apiRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/bot" + testToken + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"ok":false,"error_code":404,"description":"Not Found"}`)
+			return
+		}
+		method := strings.TrimPrefix(r.URL.Path, prefix)
+
+		req := apiRequest{Method: method, Params: map[string]any{}, Files: map[string]uploadedFile{}}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			_ = r.ParseMultipartForm(1 << 20)
+			for key, values := range r.MultipartForm.Value {
+				req.Params[key] = values[0]
+			}
+			for key, headers := range r.MultipartForm.File {
+				f, _ := headers[0].Open()
+				data, _ := io.ReadAll(f)
+				_ = f.Close()
+				req.Files[key] = uploadedFile{Name: headers[0].Filename, Data: string(data)}
+			}
+		} else {
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &req.Params)
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		status, resp := handler(method, req.Params)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []apiRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]apiRequest(nil), requests...)
+	}
+}
+
+func newTestNotify(t *testing.T, host string) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "ops",
+		APIBaseURL:             host,
+		GlobalRate:             -1,
+		ChatRate:               -1,
+		Bots: map[string]Bot{
+			"ops":  {Token: testToken, ChatIDs: []string{"-1001", "-1002"}},
+			"html": {Token: testToken, ChatIDs: []string{"-1001"}, ParseMode: ParseModeHTML},
+		},
+		Retry: retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return i.(*notify)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name: "Valid config",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "ops",
+				Bots:                   map[string]Bot{"ops": {Token: testToken}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid config - no bots",
+			config:  Config{Enabled: true, DefaultSendChannelName: "ops"},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - missing token",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "ops",
+				Bots:                   map[string]Bot{"ops": {}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - invalid parse mode",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "ops",
+				Bots:                   map[string]Bot{"ops": {Token: testToken, ParseMode: "Markdown"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - unknown default channel",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "missing",
+				Bots:                   map[string]Bot{"ops": {Token: testToken}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_APIBaseURL(t *testing.T) {
+	config := Config{DefaultSendChannelName: "ops", Bots: map[string]Bot{"ops": {Token: testToken}}}
+	if err := validateConfig(&config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+
+	if config.APIBaseURL != defaultAPIBaseURL {
+		t.Errorf("APIBaseURL = %q, want %q", config.APIBaseURL, defaultAPIBaseURL)
+	}
+
+	config.APIBaseURL = "http://127.0.0.1:8081/"
+	if err := validateConfig(&config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+
+	if config.APIBaseURL != "http://127.0.0.1:8081" {
+		t.Errorf("APIBaseURL = %q, want trailing slash trimmed", config.APIBaseURL)
+	}
+}
+
+func TestNotify_SubmitMessage(t *testing.T) {
+	srv, requests := newTestServer(t, okHandler)
+	n := newTestNotify(t, srv.URL)
+
+	n.StartProcessor()
+
+	msgID, err := n.SubmitMessage(Message{Title: "Disk full", Content: "db-1 is at 99%"})
+	if err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	if msgID == "" {
+		t.Error("SubmitMessage() returned an empty message ID")
+	}
+
+	// Close waits for the queued message to be delivered
+	n.Close()
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(got))
+	}
+
+	chats := map[any]bool{}
+	for _, r := range got {
+		if r.Method != sendMessageAPI {
+			t.Errorf("method = %s, want %s", r.Method, sendMessageAPI)
+		}
+
+		if r.Params["text"] != "Disk full\ndb-1 is at 99%" {
+			t.Errorf("text = %q", r.Params["text"])
+		}
+
+		if _, ok := r.Params["parse_mode"]; ok {
+			t.Errorf("unexpected parse_mode for plain text: %v", r.Params["parse_mode"])
+		}
+
+		chats[r.Params["chat_id"]] = true
+	}
+
+	if !chats["-1001"] || !chats["-1002"] {
+		t.Errorf("chat IDs = %v, want -1001 and -1002", chats)
+	}
+}
+
+func TestNotify_SendMsg(t *testing.T) {
+	t.Run("sendTo overrides default chats", func(t *testing.T) {
+		srv, requests := newTestServer(t, okHandler)
+		n := newTestNotify(t, srv.URL)
+
+		if _, err := n.sendMsg(context.Background(), Message{SendTo: "@alerts, -1003", Content: "hello"}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()
+		if len(got) != 2 || got[0].Params["chat_id"] != "@alerts" || got[1].Params["chat_id"] != "-1003" {
+			t.Errorf("requests = %v, want @alerts and -1003", got)
+		}
+	})
+
+	t.Run("bot parse mode", func(t *testing.T) {
+		srv, requests := newTestServer(t, okHandler)
+		n := newTestNotify(t, srv.URL)
+
+		if _, err := n.sendMsg(context.Background(), Message{SendChannelName: "html", Title: "a<b", Content: "x & y"}); err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		if got.Params["parse_mode"] != ParseModeHTML || got.Params["text"] != "<b>a&lt;b</b>\nx &amp; y" {
+			t.Errorf("params = %v", got.Params)
+		}
+	})
+
+	t.Run("message parse mode overrides bot", func(t *testing.T) {
+		srv, requests := newTestServer(t, okHandler)
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(context.Background(), Message{SendChannelName: "html", ParseMode: ParseModeMarkdownV2, Title: "v1.2", Content: "done!"})
+		if err != nil {
+			t.Fatalf("sendMsg() error = %v", err)
+		}
+
+		got := requests()[0]
+		if got.Params["parse_mode"] != ParseModeMarkdownV2 || got.Params["text"] != "*v1\\.2*\ndone\\!" {
+			t.Errorf("params = %v", got.Params)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		srv, requests := newTestServer(t, func(_ string, params map[string]any) (int, string) {
+			if params["chat_id"] == "-1001" {
+				return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`
+			}
+			return okHandler("", params)
+		})
+		n := newTestNotify(t, srv.URL)
+
+		_, err := n.sendMsg(context.Background(), Message{Content: "hello"})
+		if err == nil || !strings.Contains(err.Error(), "chat not found") {
+			t.Errorf("sendMsg() error = %v, want chat not found", err)
+		}
+
+		if len(requests()) != 2 {
+			t.Errorf("server received %d requests, want delivery to continue after a failure", len(requests()))
+		}
+	})
+
+	t.Run("unknown channel", func(t *testing.T) {
+		n := newTestNotify(t, "http://127.0.0.1:0")
+
+		if _, err := n.sendMsg(context.Background(), Message{SendChannelName: "missing", Content: "hello"}); err == nil {
+			t.Error("sendMsg() expected error for unknown channel")
+		}
+	})
+}
+
+func TestNotify_Send(t *testing.T) {
+	var next int64
+	srv, _ := newTestServer(t, func(method string, params map[string]any) (int, string) {
+		if params["chat_id"] == "-1002" {
+			return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`
+		}
+
+		next++
+		return http.StatusOK, fmt.Sprintf(`{"ok":true,"result":{"message_id":%d}}`, next)
+	})
+	n := newTestNotify(t, srv.URL)
+
+	got, err := n.Send(Message{SendTo: "-1001,-1002,-1003", Content: "hello"})
+	if err == nil {
+		t.Error("Send() expected error for chat -1002")
+	}
+	if got != "-1001:1,-1003:2" {
+		t.Errorf("Send() = %q, want -1001:1,-1003:2", got)
+	}
+}
+
+func TestNotify_Send_RetriesFailedChats(t *testing.T) {
+	var mu sync.Mutex
+	failures := map[string]int{}
+
+	srv, requests := newTestServer(t, func(method string, params map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		chatID, _ := params["chat_id"].(string)
+		switch {
+		case chatID == "-1002" && failures[chatID] == 0:
+			failures[chatID]++
+			return http.StatusBadGateway, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`
+		case chatID == "-1003":
+			return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`
+		}
+
+		return okHandler(method, params)
+	})
+	n := newTestNotify(t, srv.URL)
+	n.retry = retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	got, err := n.Send(Message{SendTo: "-1001,-1002,-1003", Content: "hello"})
+	if err == nil || !strings.Contains(err.Error(), "chat -1003") || strings.Contains(err.Error(), "chat -1002") {
+		t.Errorf("Send() error = %v, want the error of chat -1003 only", err)
+	}
+	if got != "-1001:1,-1002:1" {
+		t.Errorf("Send() = %q, want -1001:1,-1002:1", got)
+	}
+
+	var chats []any
+	for _, r := range requests() {
+		chats = append(chats, r.Params["chat_id"])
+	}
+	if fmt.Sprint(chats) != "[-1001 -1002 -1003 -1002]" {
+		t.Errorf("requests sent to chats %v, want [-1001 -1002 -1003 -1002]", chats)
+	}
+}
+
+func TestNotify_CallAPI_RedactsToken(t *testing.T) {
+	n := newTestNotify(t, "http://127.0.0.1:0")
+
+	err := n.callAPI(context.Background(), n.bots["ops"], sendMessageAPI, map[string]any{"chat_id": "1", "text": "x"}, nil)
+	if err == nil {
+		t.Fatal("callAPI() expected error for unreachable server")
+	}
+
+	if strings.Contains(err.Error(), testToken) {
+		t.Errorf("error %q contains the bot token", err)
+	}
+}
+
+func TestNotify_CallAPI_RetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+
+	srv, requests := newTestServer(t, func(string, map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls == 1 {
+			return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`
+		}
+		return okHandler("", nil)
+	})
+	n := newTestNotify(t, srv.URL)
+
+	// The retry policy waits for retry_after, although its own backoff is shorter
+	start := time.Now()
+	if _, attempts, err := n.SendContext(context.Background(), Message{SendTo: "-1001", Content: "hello"}); err != nil || attempts != 2 {
+		t.Fatalf("SendContext() = %d attempts, %v, want sent after 2 attempts", attempts, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least retry_after of 1s", elapsed)
+	}
+
+	if len(requests()) != 2 {
+		t.Errorf("server received %d requests, want 2", len(requests()))
+	}
+}
+
+func TestNotify_CallAPI_RateLimited(t *testing.T) {
+	srv, requests := newTestServer(t, func(string, map[string]any) (int, string) {
+		return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 2","parameters":{"retry_after":2}}`
+	})
+	n := newTestNotify(t, srv.URL)
+
+	// A rate limited request is not retried in place, but left to the retry policy
+	_, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: "hello"})
+
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.code != http.StatusTooManyRequests {
+		t.Errorf("sendMsg() error = %v, want 429 apiError", err)
+	}
+	if after, ok := retry.RetryAfter(err); !ok || after != 2*time.Second {
+		t.Errorf("RetryAfter() = %v, %t, want 2s", after, ok)
+	}
+
+	if len(requests()) != 1 {
+		t.Errorf("server received %d requests, want 1", len(requests()))
+	}
+}
+
+func TestNotify_SendContext_RateLimitWait(t *testing.T) {
+	srv, requests := newTestServer(t, okHandler)
+	n := newTestNotify(t, srv.URL)
+	n.limiter = newRateLimiter(0, 0.1)
+
+	// The first message takes the only token of the chat, the second waits 10s for the next one
+	if _, err := n.sendMsg(context.Background(), Message{SendTo: "-1001", Content: "first"}); err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := n.SendContext(ctx, Message{SendTo: "-1001", Content: "second"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SendContext() returned after %v, want the rate limit wait aborted", elapsed)
+	}
+
+	if len(requests()) != 1 {
+		t.Errorf("server received %d requests, want 1", len(requests()))
+	}
+}
+
+func TestNotify_SendMsg_ChatRate(t *testing.T) {
+	srv, requests := newTestServer(t, okHandler)
+	n := newTestNotify(t, srv.URL)
+	n.limiter = newRateLimiter(0, 10)
+
+	n.StartProcessor()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, _ = n.SubmitMessage(Message{SendTo: "-1001", Content: "burst"})
+	}
+	n.Close()
+
+	// The first message is sent at once, the others are spaced by 100ms instead of being dropped
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("3 messages sent in %v, want at least 200ms", elapsed)
+	}
+
+	if len(requests()) != 3 {
+		t.Errorf("server received %d requests, want 3", len(requests()))
+	}
+}
+
+func TestNotify_SendMsg_DeliveryOptions(t *testing.T) {
+	levels := map[string]LevelOptions{
+		"info":  {MessageThreadID: 10, Silent: true},
+		"error": {MessageThreadID: 20},
+	}
+
+	tests := []struct {
+		name    string
+		message Message
+		want    map[string]any
+	}{
+		{
+			name:    "silent level",
+			message: Message{MsgLevel: "info", Content: "x"},
+			want:    map[string]any{"message_thread_id": float64(10), "disable_notification": true},
+		},
+		{
+			name:    "loud level",
+			message: Message{MsgLevel: "error", Content: "x"},
+			want:    map[string]any{"message_thread_id": float64(20)},
+		},
+		{
+			name:    "message overrides level topic",
+			message: Message{MsgLevel: "error", MessageThreadID: 30, DisableNotification: true, Content: "x"},
+			want:    map[string]any{"message_thread_id": float64(30), "disable_notification": true},
+		},
+		{
+			name:    "unknown level",
+			message: Message{MsgLevel: "warn", ProtectContent: true, ReplyToMessageID: 5, Content: "x"},
+			want:    map[string]any{"protect_content": true, "reply_to_message_id": float64(5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newTestServer(t, okHandler)
+			n := newTestNotify(t, srv.URL)
+			n.levels = levels
+
+			tt.message.SendTo = "-1001"
+			if _, err := n.sendMsg(context.Background(), tt.message); err != nil {
+				t.Fatalf("sendMsg() error = %v", err)
+			}
+
+			got := requests()[0].Params
+			for _, key := range []string{"message_thread_id", "disable_notification", "protect_content", "reply_to_message_id"} {
+				if got[key] != tt.want[key] {
+					t.Errorf("%s = %v, want %v", key, got[key], tt.want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestNotify_SendMsg_DeliveryOptions_Parts(t *testing.T) {
+	srv, requests := newTestServer(t, mediaHandler)
+	n := newTestNotify(t, srv.URL)
+
+	_, err := n.sendMsg(context.Background(), Message{
+		SendTo:           "-1001",
+		MessageThreadID:  10,
+		ReplyToMessageID: 5,
+		Content:          strings.Repeat("line\n", 1000),
+		Document:         &Media{Data: []byte("x")},
+	})
+	if err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	got := requests()
+	if len(got) < 3 {
+		t.Fatalf("server received %d requests, want a document and several text parts", len(got))
+	}
+
+	// The uploaded document carries the options as form fields
+	if got[0].Params["message_thread_id"] != "10" || got[0].Params["reply_to_message_id"] != "5" {
+		t.Errorf("document params = %v", got[0].Params)
+	}
+
+	for i, r := range got[1:] {
+		if r.Params["message_thread_id"] != float64(10) {
+			t.Errorf("part %d is not sent to the topic: %v", i+1, r.Params)
+		}
+
+		if _, ok := r.Params["reply_to_message_id"]; ok {
+			t.Errorf("part %d replies to a message, want only the first part to reply", i+1)
+		}
+	}
+}
+
+func TestNotify_SubmitMessage_Retry(t *testing.T) {
+	var mu sync.Mutex
+	failures := map[string]int{}
+
+	srv, requests := newTestServer(t, func(method string, params map[string]any) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		chatID, _ := params["chat_id"].(string)
+		switch {
+		case chatID == "-1002" && failures[chatID] == 0:
+			failures[chatID]++
+			return http.StatusBadGateway, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`
+		case chatID == "-1003":
+			return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`
+		}
+
+		return okHandler(method, params)
+	})
+	n := newTestNotify(t, srv.URL)
+
+	// The queued deliveries are reported to the subscribers
+	var states []string
+	n.Subscribe(func(r SendResult) { states = append(states, r.MsgID+":"+r.DeliveryID+":"+r.State) })
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{ID: "msg-1", DeliveryID: "1", SendTo: "-1001,-1002,-1003", Content: "hello"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	// Close waits for the scheduled retry, which is only sent to the chat that failed with a retryable error
+	n.Close()
+
+	var chats []any
+	for _, r := range requests() {
+		chats = append(chats, r.Params["chat_id"])
+	}
+	if fmt.Sprint(chats) != "[-1001 -1002 -1003 -1002]" {
+		t.Errorf("requests sent to chats %v, want [-1001 -1002 -1003 -1002]", chats)
+	}
+	if want := "[msg-1:1:retrying msg-1:1:sent]"; fmt.Sprint(states) != want {
+		t.Errorf("published %v, want %s", states, want)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		DefaultSendChannelName: "ops",
+		Bots:                   map[string]Bot{"ops": {Token: testToken}, "dev": {Token: testToken}},
+		Outbox:                 outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	// A message is queued once per bot and list of chats
+	message := Message{ID: "msg-1", Content: "disk full"}
+	for _, target := range []Message{{SendChannelName: "ops", SendTo: "-1001"}, {SendChannelName: "ops", SendTo: "-1002"}, {SendChannelName: "dev", SendTo: "-1001"}} {
+		message.SendChannelName, message.SendTo = target.SendChannelName, target.SendTo
+		if _, err = i.SubmitMessage(message); err != nil {
+			t.Fatalf("SubmitMessage() to %s %s error = %v", target.SendChannelName, target.SendTo, err)
+		}
+	}
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Bot API methods for receiving updates.
+const (
+	// getUpdatesAPI is the Bot API method for long polling updates.
+	getUpdatesAPI = "getUpdates"
+
+	// answerCallbackQueryAPI is the Bot API method for answering callback queries.
+	answerCallbackQueryAPI = "answerCallbackQuery"
+
+	// defaultPollTimeout is the default timeout of a long polling request.
+	defaultPollTimeout = 30 * time.Second
+
+	// pollRetryInterval is the time waited before polling again after a failed request.
+	pollRetryInterval = 5 * time.Second
+)
+
+// User represents a Telegram user.
+type User struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// CallbackQuery represents a press of an inline button with callback data.
+type CallbackQuery struct {
+	// ID is the unique identifier of the query.
+	ID string
+
+	// BotName is the name of the bot that received the query, as configured in Bots.
+	BotName string
+
+	// From is the user who pressed the button.
+	From User
+
+	// ChatID is the chat of the message the button belongs to. It is 0 if the message is too old.
+	ChatID int64
+
+	// MessageID is the message the button belongs to. It is 0 if the message is too old.
+	MessageID int64
+
+	// Data is the callback data of the button.
+	Data string
+}
+
+// CallbackAnswer is the answer shown to the user who pressed a button.
+type CallbackAnswer struct {
+	// Text is shown as a notification at the top of the chat. If empty, nothing is shown.
+	Text string
+
+	// ShowAlert shows Text as an alert the user must dismiss instead of a notification.
+	ShowAlert bool
+}
+
+// CallbackHandler handles a callback query and returns the answer shown to the user.
+type CallbackHandler func(query CallbackQuery) CallbackAnswer
+
+// update represents an incoming update from getUpdates. Only callback queries are requested.
+type update struct {
+	UpdateID      int64 `json:"update_id"`
+	CallbackQuery *struct {
+		ID      string `json:"id"`
+		From    User   `json:"from"`
+		Message *struct {
+			MessageID int64 `json:"message_id"`
+			Chat      struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+		Data string `json:"data"`
+	} `json:"callback_query"`
+}
+
+// HandleCallback registers a handler for callback queries whose data starts with prefix.
+// When several prefixes match, the longest one wins. An empty prefix matches every query.
+//
+// Parameters:
+//   - prefix: The prefix of the callback data, e.g. "ack:".
+//   - handler: The handler called with the query. Handlers of a bot are called one at a time.
+func (n *notify) HandleCallback(prefix string, handler CallbackHandler) {
+	n.handlersMu.Lock()
+	defer n.handlersMu.Unlock()
+
+	n.handlers[prefix] = handler
+}
+
+// callbackHandler returns the handler with the longest prefix matching the callback data.
+func (n *notify) callbackHandler(data string) CallbackHandler {
+	n.handlersMu.RLock()
+	defer n.handlersMu.RUnlock()
+
+	var (
+		handler CallbackHandler
+		longest = -1
+	)
+	for prefix, h := range n.handlers {
+		if strings.HasPrefix(data, prefix) && len(prefix) > longest {
+			handler, longest = h, len(prefix)
+		}
+	}
+
+	return handler
+}
+
+// startPolling starts a long polling goroutine for every bot with PollUpdates enabled.
+func (n *notify) startPolling() {
+	for name, bot := range n.bots {
+		if !bot.PollUpdates {
+			continue
+		}
+
+		n.pollWG.Add(1)
+		go func(name string, bot Bot) {
+			defer n.pollWG.Done()
+			n.pollUpdates(n.pollCtx, name, bot)
+		}(name, bot)
+	}
+}
+
+// pollUpdates long polls the callback queries of a bot until ctx is canceled.
+//
+// Parameters:
+//   - ctx: The context stopping the loop when canceled.
+//   - name: The name of the bot.
+//   - bot: The Bot configuration.
+func (n *notify) pollUpdates(ctx context.Context, name string, bot Bot) {
+	var offset int64
+
+	for {
+		params := map[string]any{
+			"offset":          offset,
+			"timeout":         int(n.pollTimeout.Seconds()),
+			"allowed_updates": []string{"callback_query"},
+		}
+
+		var updates []update
+		err := n.callAPI(ctx, bot, getUpdatesAPI, params, &updates)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("failed to get telegram updates for bot %s: %v\n", name, err)
+
+			select {
+			case <-time.After(pollRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for _, u := range updates {
+			// Confirm the update with the next request, even if handling it fails
+			offset = u.UpdateID + 1
+
+			if u.CallbackQuery != nil {
+				n.handleCallbackQuery(ctx, name, bot, u)
+			}
+		}
+	}
+}
+
+// handleCallbackQuery dispatches a callback query to its handler and answers it.
+// Queries without a handler are answered without text, so that the button stops loading.
+func (n *notify) handleCallbackQuery(ctx context.Context, name string, bot Bot, u update) {
+	cq := u.CallbackQuery
+	query := CallbackQuery{ID: cq.ID, BotName: name, From: cq.From, Data: cq.Data}
+	if cq.Message != nil {
+		query.ChatID = cq.Message.Chat.ID
+		query.MessageID = cq.Message.MessageID
+	}
+
+	var answer CallbackAnswer
+	if handler := n.callbackHandler(query.Data); handler != nil {
+		answer = handler(query)
+	}
+
+	params := map[string]any{"callback_query_id": query.ID}
+	if answer.Text != "" {
+		params["text"] = answer.Text
+		params["show_alert"] = answer.ShowAlert
+	}
+
+	if err := n.callAPI(ctx, bot, answerCallbackQueryAPI, params, nil); err != nil && ctx.Err() == nil {
+		log.Printf("failed to answer telegram callback query %s: %v\n", query.ID, err)
+	}
+}
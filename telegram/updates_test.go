@@ -0,0 +1,149 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotify_CallbackHandler(t *testing.T) {
+	n := newTestNotify(t, "http://127.0.0.1:0")
+
+	answers := map[string]CallbackAnswer{"": {Text: "any"}, "ack:": {Text: "ack"}, "ack:db": {Text: "ack db"}}
+	for prefix, answer := range answers {
+		answer := answer
+		n.HandleCallback(prefix, func(CallbackQuery) CallbackAnswer { return answer })
+	}
+
+	tests := map[string]string{"ack:db-1": "ack db", "ack:api": "ack", "silence:1h": "any"}
+	for data, want := range tests {
+		if got := n.callbackHandler(data)(CallbackQuery{}).Text; got != want {
+			t.Errorf("handler for %q answered %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestNotify_PollUpdates(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		offsets []any
+		served  bool
+	)
+
+	srv, requests := newTestServer(t, func(method string, params map[string]any) (int, string) {
+		if method != getUpdatesAPI {
+			return okHandler(method, params)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		offsets = append(offsets, params["offset"])
+		if !served {
+			served = true
+			return http.StatusOK, `{"ok":true,"result":[
+				{"update_id":10,"callback_query":{"id":"q1","from":{"id":7,"username":"alice"},"message":{"message_id":3,"chat":{"id":-1001}},"data":"ack:db-1"}},
+				{"update_id":11,"callback_query":{"id":"q2","from":{"id":7},"data":"unknown"}}
+			]}`
+		}
+
+		// Later polls return no updates after a short wait
+		time.Sleep(20 * time.Millisecond)
+		return http.StatusOK, `{"ok":true,"result":[]}`
+	})
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "ops",
+		APIBaseURL:             srv.URL,
+		PollTimeout:            time.Second,
+		Bots:                   map[string]Bot{"ops": {Token: testToken, PollUpdates: true}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n := i.(*notify)
+
+	queries := make(chan CallbackQuery, 1)
+	n.HandleCallback("ack:", func(q CallbackQuery) CallbackAnswer {
+		queries <- q
+		return CallbackAnswer{Text: fmt.Sprintf("Acked by %s", q.From.Username), ShowAlert: true}
+	})
+
+	n.StartProcessor()
+
+	select {
+	case q := <-queries:
+		want := CallbackQuery{ID: "q1", BotName: "ops", From: User{ID: 7, Username: "alice"}, ChatID: -1001, MessageID: 3, Data: "ack:db-1"}
+		if q != want {
+			t.Errorf("query = %+v, want %+v", q, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback handler was not called")
+	}
+
+	// Wait for the next poll, which confirms the updates
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		polls := len(offsets)
+		mu.Unlock()
+
+		if polls >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not stop polling")
+	}
+
+	mu.Lock()
+	if len(offsets) < 2 || offsets[0] != float64(0) || offsets[1] != float64(12) {
+		t.Errorf("offsets = %v, want 0 then 12", offsets)
+	}
+	mu.Unlock()
+
+	answers := map[any]map[string]any{}
+	for _, r := range requests() {
+		if r.Method == answerCallbackQueryAPI {
+			answers[r.Params["callback_query_id"]] = r.Params
+		}
+	}
+
+	if a := answers["q1"]; a == nil || a["text"] != "Acked by alice" || a["show_alert"] != true {
+		t.Errorf("answer to q1 = %v", a)
+	}
+
+	// Queries without a handler are answered without text
+	if a := answers["q2"]; a == nil || a["text"] != nil {
+		t.Errorf("answer to q2 = %v", a)
+	}
+}
+
+func TestNotify_Close_WithoutPolling(t *testing.T) {
+	srv, requests := newTestServer(t, okHandler)
+	n := newTestNotify(t, srv.URL)
+
+	n.StartProcessor()
+	n.Close()
+
+	if len(requests()) != 0 {
+		t.Errorf("server received %d requests, want no polling for bots without PollUpdates", len(requests()))
+	}
+}
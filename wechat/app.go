@@ -0,0 +1,262 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/cache"
+	"github.com/sk-pkg/notify/util"
+	"log"
+	"strings"
+)
+
+// Error codes returned by WeCom when the access token is invalid or expired.
+const (
+	errCodeInvalidToken = 40014
+	errCodeTokenExpired = 42001
+)
+
+// appMessageResp represents the response from the WeCom app message API.
+type appMessageResp struct {
+	ErrCode      int    `json:"errcode"`      // Response code, 0 indicates success
+	ErrMsg       string `json:"errmsg"`       // Error message if the request failed
+	InvalidUser  string `json:"invaliduser"`  // Users that could not receive the message
+	InvalidParty string `json:"invalidparty"` // Departments that could not receive the message
+	InvalidTag   string `json:"invalidtag"`   // Tags that could not receive the message
+	MsgID        string `json:"msgid"`        // ID of the sent message
+}
+
+// target represents the recipients of an app message.
+type target struct {
+	toUser  []string
+	toParty []string
+	toTag   []string
+}
+
+// parseSendTo parses the SendTo field of a Message into app message recipients.
+//
+// Parameters:
+//   - sendTo: The recipients, e.g. "zhangsan|lisi;party:2;tag:5".
+//
+// Returns:
+//   - target: The parsed recipients.
+//   - error: An error if a target has an unknown prefix or no recipients are found.
+func parseSendTo(sendTo string) (target, error) {
+	var t target
+
+	for _, segment := range strings.Split(sendTo, ";") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+
+		kind, ids := "user", segment
+		if i := strings.Index(segment, ":"); i >= 0 {
+			kind, ids = segment[:i], segment[i+1:]
+		}
+
+		ids = strings.ReplaceAll(ids, ",", "|")
+		for _, id := range strings.Split(ids, "|") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+
+			switch kind {
+			case "user":
+				t.toUser = append(t.toUser, id)
+			case "party":
+				t.toParty = append(t.toParty, id)
+			case "tag":
+				t.toTag = append(t.toTag, id)
+			default:
+				return t, fmt.Errorf("invalid wechat sendTo target: %s", segment)
+			}
+		}
+	}
+
+	if len(t.toUser) == 0 && len(t.toParty) == 0 && len(t.toTag) == 0 {
+		return t, fmt.Errorf("no recipients found in wechat sendTo: %q", sendTo)
+	}
+
+	return t, nil
+}
+
+// buildAppParams serializes a Message into the format of the app message API.
+//
+// Parameters:
+//   - agentID: The agent ID of the app.
+//   - m: The Message struct containing the message details. Message.SendTo must be set.
+//
+// Returns:
+//   - map[string]any: The request body for the app message API.
+//   - error: An error if the recipients or message type are invalid or the payload is missing.
+func buildAppParams(agentID int64, m Message) (map[string]any, error) {
+	t, err := parseSendTo(m.SendTo)
+	if err != nil {
+		return nil, err
+	}
+
+	msgType := m.MsgType
+	if msgType == "" {
+		msgType = "text"
+	}
+
+	params := map[string]any{"msgtype": msgType, "agentid": agentID}
+	if len(t.toUser) > 0 {
+		params["touser"] = strings.Join(t.toUser, "|")
+	}
+	if len(t.toParty) > 0 {
+		params["toparty"] = strings.Join(t.toParty, "|")
+	}
+	if len(t.toTag) > 0 {
+		params["totag"] = strings.Join(t.toTag, "|")
+	}
+
+	switch msgType {
+	case "text":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr(m.Title, "\n", m.Content)
+		}
+		params["text"] = map[string]string{"content": content}
+	case "markdown":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr("### ", m.Title, "\n", m.Content)
+		}
+		params["markdown"] = map[string]string{"content": content}
+	case "textcard":
+		if m.TextCard == nil || m.TextCard.URL == "" {
+			return nil, errors.New("textcard payload with URL is required for textcard message")
+		}
+
+		card := map[string]string{"title": m.Title, "description": m.Content, "url": m.TextCard.URL}
+		if m.TextCard.BtnTxt != "" {
+			card["btntxt"] = m.TextCard.BtnTxt
+		}
+		params["textcard"] = card
+	case "template_card":
+		if m.TemplateCard == nil {
+			return nil, errors.New("template card payload is required for template_card message")
+		}
+		params["template_card"] = m.TemplateCard
+	default:
+		return nil, fmt.Errorf("invalid app message type: %s", msgType)
+	}
+
+	return params, nil
+}
+
+// getToken retrieves an access token for a self-built app.
+// The token is cached until 100 seconds before it expires, or half of its lifetime for short-lived tokens.
+//
+// Parameters:
+//   - corpID: The ID of the enterprise.
+//   - corpSecret: The secret of the app.
+//   - agentID: The agent ID of the app, used to tell apart tokens of the same enterprise.
+//
+// Returns:
+//   - string: The access token if successful, an empty string otherwise.
+//   - error: An error if the token cannot be retrieved, nil otherwise.
+func (n *notify) getToken(corpID, corpSecret string, agentID int64) (string, error) {
+	cacheKey := fmt.Sprintf(tokenCacheKey, corpID, agentID)
+
+	token, err := n.cache.GetString(cacheKey)
+	if err == nil && token != "" {
+		return token, nil
+	}
+
+	request := &Request{
+		Method: "GET",
+		URL:    util.SpliceStr(n.host, accessTokenAPI),
+		QueryParams: map[string]string{
+			"corpid":     corpID,
+			"corpsecret": corpSecret,
+		},
+	}
+
+	response, err := n.sendWecomAPIRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to request WeCom access token: %w", err)
+	}
+
+	var rs accessTokenResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return "", fmt.Errorf("failed to obtain WeCom access token: %w", err)
+	}
+
+	if err = n.cache.SetString(cacheKey, rs.AccessToken, cache.TokenExpiration(rs.ExpiresIn, 100)); err != nil {
+		log.Printf("failed to cache token: %s", err)
+	}
+
+	return rs.AccessToken, nil
+}
+
+// sendAppMessage sends a message via a self-built app.
+// If WeCom reports that the access token is invalid or expired, the cached token is
+// dropped and the message is sent once more with a fresh token.
+//
+// Parameters:
+//   - a: The app to send the message through.
+//   - m: The Message struct containing the message details. Message.SendTo must be set.
+//
+// Returns:
+//   - string: The message ID assigned by WeCom, which can be used to recall the message.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendAppMessage(a *app, m Message) (string, error) {
+	params, err := buildAppParams(a.agentID, m)
+	if err != nil {
+		return "", err
+	}
+
+	for refreshed := false; ; refreshed = true {
+		token, err := a.token()
+		if err != nil {
+			return "", fmt.Errorf("failed to get token for wechat app: %w", err)
+		}
+
+		request := &Request{
+			Method:      "POST",
+			URL:         util.SpliceStr(n.host, appMessageAPI),
+			Headers:     map[string]string{"Content-Type": "application/json; charset=utf-8"},
+			QueryParams: map[string]string{"access_token": token},
+			Body:        params,
+		}
+
+		response, err := n.sendWecomAPIRequest(request)
+		if err != nil {
+			return "", fmt.Errorf("failed to send app message: %w", err)
+		}
+
+		// Check response status
+		var rs appMessageResp
+		if err = json.Unmarshal(response.Body, &rs); err != nil {
+			return "", fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		if (rs.ErrCode == errCodeInvalidToken || rs.ErrCode == errCodeTokenExpired) && !refreshed {
+			a.invalidate()
+			continue
+		}
+
+		if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+			return "", fmt.Errorf("failed to send app message: %w", err)
+		}
+
+		if rs.InvalidUser != "" || rs.InvalidParty != "" || rs.InvalidTag != "" {
+			log.Printf("wechat app message %s partially delivered, invalid user: %q, party: %q, tag: %q",
+				m.ID, rs.InvalidUser, rs.InvalidParty, rs.InvalidTag)
+		}
+
+		return rs.MsgID, nil
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const (
+	testCorpID     = "ww_test_corp"
+	testCorpSecret = "test_corp_secret"
+	testAgentID    = 1000002
+)
+
+// testAppServer is a fake WeCom server serving the token and app message APIs.
+// Only the most recently issued token is accepted; older ones are reported as expired.
+type testAppServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	tokenCalls int
+	last       map[string]any
+}
+
+func newTestAppServer(t *testing.T) *testAppServer {
+	t.Helper()
+
+	s := &testAppServer{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(accessTokenAPI, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.URL.Query().Get("corpid") != testCorpID || r.URL.Query().Get("corpsecret") != testCorpSecret {
+			_ = json.NewEncoder(w).Encode(accessTokenResp{ErrCode: 40001, ErrMsg: "invalid credential"})
+			return
+		}
+
+		s.tokenCalls++
+		_ = json.NewEncoder(w).Encode(accessTokenResp{AccessToken: s.currentToken(), ExpiresIn: 7200})
+	})
+	mux.HandleFunc(appMessageAPI, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.URL.Query().Get("access_token") != s.currentToken() {
+			_ = json.NewEncoder(w).Encode(appMessageResp{ErrCode: errCodeTokenExpired, ErrMsg: "access_token expired"})
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		s.last = nil
+		_ = json.Unmarshal(body, &s.last)
+
+		_ = json.NewEncoder(w).Encode(appMessageResp{ErrMsg: "ok", MsgID: "msg_1"})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// currentToken returns the only token currently accepted by the server. Callers must hold mu.
+func (s *testAppServer) currentToken() string {
+	return fmt.Sprintf("token_%d", s.tokenCalls)
+}
+
+// expireToken makes the server reject the token it issued last.
+func (s *testAppServer) expireToken() {
+	s.mu.Lock()
+	s.tokenCalls++
+	s.mu.Unlock()
+}
+
+func newTestAppNotify(t *testing.T, host string) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "app",
+		Apps: map[string]App{
+			"app": {AgentID: testAgentID, CorpID: testCorpID, CorpSecret: testCorpSecret},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	n := i.(*notify)
+	n.host = host
+
+	return n
+}
+
+func TestNotify_SendAppMessage(t *testing.T) {
+	srv := newTestAppServer(t)
+	n := newTestAppNotify(t, srv.URL)
+
+	msgID, err := n.Send(Message{SendTo: "zhangsan|lisi;party:2", Title: "Title", Content: "Content"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if msgID != "msg_1" {
+		t.Errorf("Send() message ID = %q, want msg_1", msgID)
+	}
+
+	got, _ := json.Marshal(srv.last)
+	want := `{"agentid":1000002,"msgtype":"text","text":{"content":"Title\nContent"},"toparty":"2","touser":"zhangsan|lisi"}`
+	if string(got) != want {
+		t.Errorf("app message = %s, want %s", got, want)
+	}
+
+	if _, err = n.sendMsg(Message{Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error without sendTo")
+	}
+}
+
+func TestNotify_SendAppMessage_RefreshToken(t *testing.T) {
+	srv := newTestAppServer(t)
+	n := newTestAppNotify(t, srv.URL)
+
+	if _, err := n.sendMsg(Message{SendTo: "zhangsan", Content: "first"}); err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	// The cached token is now rejected, the notifier must fetch a new one
+	srv.expireToken()
+
+	if _, err := n.sendMsg(Message{SendTo: "zhangsan", Content: "second"}); err != nil {
+		t.Fatalf("sendMsg() error after token expired = %v", err)
+	}
+
+	token, err := n.Token("app")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if token != srv.currentToken() {
+		t.Errorf("Token() = %v, want refreshed token %v", token, srv.currentToken())
+	}
+}
+
+func TestParseSendTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		sendTo  string
+		want    target
+		wantErr bool
+	}{
+		{
+			name:   "users",
+			sendTo: "zhangsan|lisi,wangwu",
+			want:   target{toUser: []string{"zhangsan", "lisi", "wangwu"}},
+		},
+		{
+			name:   "all users",
+			sendTo: "@all",
+			want:   target{toUser: []string{"@all"}},
+		},
+		{
+			name:   "mixed targets",
+			sendTo: "user:zhangsan; party:1|2 ;tag:5",
+			want:   target{toUser: []string{"zhangsan"}, toParty: []string{"1", "2"}, toTag: []string{"5"}},
+		},
+		{
+			name:    "unknown prefix",
+			sendTo:  "group:1",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			sendTo:  " ; ",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSendTo(tt.sendTo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSendTo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSendTo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildAppParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "textcard",
+			message: Message{SendTo: "tag:5", MsgType: "textcard", Title: "Deploy", Content: "v1.2.3", TextCard: &TextCard{URL: "https://example.com", BtnTxt: "More"}},
+			want:    `{"agentid":1,"msgtype":"textcard","textcard":{"btntxt":"More","description":"v1.2.3","title":"Deploy","url":"https://example.com"},"totag":"5"}`,
+		},
+		{
+			name:    "markdown",
+			message: Message{SendTo: "zhangsan", MsgType: "markdown", Title: "Alert", Content: "**down**"},
+			want:    `{"agentid":1,"markdown":{"content":"### Alert\n**down**"},"msgtype":"markdown","touser":"zhangsan"}`,
+		},
+		{
+			name:    "template_card",
+			message: Message{SendTo: "zhangsan", MsgType: "template_card", TemplateCard: map[string]any{"card_type": "text_notice"}},
+			want:    `{"agentid":1,"msgtype":"template_card","template_card":{"card_type":"text_notice"},"touser":"zhangsan"}`,
+		},
+		{
+			name:    "textcard without url",
+			message: Message{SendTo: "zhangsan", MsgType: "textcard"},
+			wantErr: true,
+		},
+		{
+			name:    "robot only type",
+			message: Message{SendTo: "zhangsan", MsgType: "news"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := buildAppParams(1, tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildAppParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			got, _ := json.Marshal(params)
+			if string(got) != tt.want {
+				t.Errorf("buildAppParams() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import "github.com/sk-pkg/notify/internal/queue"
+
+// ErrClosed is returned when a message is submitted after Close.
+var ErrClosed = queue.ErrClosed
+
+// SendResult represents the result of an attempt to deliver a message.
+type SendResult struct {
+	// MsgID is the ID of the message.
+	MsgID string
+
+	// DeliveryID is the DeliveryID of the message.
+	DeliveryID string
+
+	// ProviderMsgID is the message ID of an app message, empty for robot messages.
+	ProviderMsgID string
+
+	// State is the state of the message: sent, failed, retrying or dropped.
+	State string
+
+	// Err is the error of the attempt, or the reason the message was dropped.
+	Err error
+
+	// Attempts is the number of times the message was sent, 0 if it was dropped before being sent.
+	Attempts int
+}
+
+// States of a SendResult.
+const (
+	// StateSent indicates that the message was sent successfully.
+	StateSent = queue.StateSent
+
+	// StateFailed indicates that the message was given up on after a failed attempt.
+	StateFailed = queue.StateFailed
+
+	// StateRetrying indicates that the attempt failed and the message will be sent again.
+	StateRetrying = queue.StateRetrying
+
+	// StateDropped indicates that the message was discarded before it could be sent.
+	StateDropped = queue.StateDropped
+)
+
+// Subscribe registers a handler called with the result of every delivery attempt.
+// The handlers are called in order from a single goroutine. Once Config.ResultBufferSize results are
+// waiting for a slow handler, the deliveries wait for it to catch up, so the handler must not wait for them.
+//
+// Parameters:
+//   - handler: The function called with each SendResult.
+//
+// Returns:
+//   - func(): A function removing the handler.
+func (n *notify) Subscribe(handler func(SendResult)) func() {
+	return n.results.Subscribe(handler)
+}
+
+// report publishes the result of an attempt to deliver a message, or of a message dropped before being sent.
+//
+// Parameters:
+//   - m: The Message struct that was sent.
+//   - r: The result of the attempt.
+func (n *notify) report(m Message, r queue.Result) {
+	n.results.Publish(SendResult{
+		MsgID:         m.ID,
+		DeliveryID:    m.DeliveryID,
+		ProviderMsgID: r.ProviderMsgID,
+		State:         r.State,
+		Err:           r.Err,
+		Attempts:      r.Attempts,
+	})
+}
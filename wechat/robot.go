@@ -0,0 +1,172 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/util"
+)
+
+// buildRobotParams serializes a Message into the format of the group robot API.
+// File messages must already carry a media ID.
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - map[string]any: The request body for the group robot API.
+//   - error: An error if the message type is invalid or its payload is missing.
+func buildRobotParams(m Message) (map[string]any, error) {
+	msgType := m.MsgType
+	if msgType == "" {
+		msgType = "text"
+	}
+
+	params := map[string]any{"msgtype": msgType}
+
+	switch msgType {
+	case "text":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr(m.Title, "\n", m.Content)
+		}
+
+		text := map[string]any{"content": content}
+		if len(m.MentionedList) > 0 {
+			text["mentioned_list"] = m.MentionedList
+		}
+		if len(m.MentionedMobileList) > 0 {
+			text["mentioned_mobile_list"] = m.MentionedMobileList
+		}
+		params["text"] = text
+	case "markdown":
+		content := m.Content
+		if m.Title != "" {
+			content = util.SpliceStr("### ", m.Title, "\n", m.Content)
+		}
+		params["markdown"] = map[string]string{"content": content}
+	case "image":
+		if m.Image == nil || len(m.Image.Data) == 0 {
+			return nil, errors.New("image payload with Data is required for image message")
+		}
+
+		sum := md5.Sum(m.Image.Data)
+		params["image"] = map[string]string{
+			"base64": base64.StdEncoding.EncodeToString(m.Image.Data),
+			"md5":    hex.EncodeToString(sum[:]),
+		}
+	case "news":
+		if m.News == nil || len(m.News.Articles) == 0 {
+			return nil, errors.New("news payload with Articles is required for news message")
+		}
+		params["news"] = m.News
+	case "file":
+		if m.File == nil || m.File.MediaID == "" {
+			return nil, errors.New("file payload with MediaID is required for file message")
+		}
+		params["file"] = map[string]string{"media_id": m.File.MediaID}
+	default:
+		return nil, fmt.Errorf("invalid message type: %s", msgType)
+	}
+
+	return params, nil
+}
+
+// uploadMedia uploads a file for a group robot and returns its media ID.
+//
+// Parameters:
+//   - robot: The Robot configuration.
+//   - file: The File payload containing the name and content to upload.
+//
+// Returns:
+//   - string: The media ID of the uploaded file.
+//   - error: An error if the file cannot be uploaded, nil otherwise.
+func (n *notify) uploadMedia(robot Robot, file *File) (string, error) {
+	if len(file.Data) == 0 {
+		return "", errors.New("file payload with MediaID or Data is required for file message")
+	}
+
+	name := file.Name
+	if name == "" {
+		name = "file"
+	}
+
+	resp, err := n.request.R().
+		SetQueryParams(map[string]string{"key": robot.Key, "type": "file"}).
+		SetFileReader("media", name, bytes.NewReader(file.Data)).
+		Post(util.SpliceStr(n.host, webhookUploadAPI))
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	var rs uploadResp
+	if err = json.Unmarshal(resp.Body(), &rs); err != nil {
+		return "", fmt.Errorf("failed to parse upload response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return rs.MediaID, nil
+}
+
+// sendRobotMessage sends a message via a group robot webhook.
+//
+// Parameters:
+//   - robot: The Robot configuration.
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendRobotMessage(robot Robot, m Message) error {
+	// Upload the file first if the message only carries its content
+	if m.MsgType == "file" && m.File != nil && m.File.MediaID == "" {
+		mediaID, err := n.uploadMedia(robot, m.File)
+		if err != nil {
+			return err
+		}
+
+		file := *m.File
+		file.MediaID = mediaID
+		m.File = &file
+	}
+
+	params, err := buildRobotParams(m)
+	if err != nil {
+		return err
+	}
+
+	request := &Request{
+		Method:      "POST",
+		URL:         util.SpliceStr(n.host, webhookSendAPI),
+		Headers:     map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		QueryParams: map[string]string{"key": robot.Key},
+		Body:        params,
+	}
+
+	response, err := n.sendWecomAPIRequest(request)
+	if err != nil {
+		return fmt.Errorf("failed to send robot message: %w", err)
+	}
+
+	// Check response status
+	var rs messageResp
+	if err = json.Unmarshal(response.Body, &rs); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if err = checkCode(rs.ErrCode, rs.ErrMsg); err != nil {
+		return fmt.Errorf("failed to send robot message: %w", err)
+	}
+
+	return nil
+}
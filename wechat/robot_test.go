@@ -0,0 +1,110 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildRobotParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "text with mentions",
+			message: Message{
+				Title:               "Title",
+				Content:             "Content",
+				MentionedList:       []string{"wangqing", "@all"},
+				MentionedMobileList: []string{"13800001111"},
+			},
+			want: `{"msgtype":"text","text":{"content":"Title\nContent","mentioned_list":["wangqing","@all"],"mentioned_mobile_list":["13800001111"]}}`,
+		},
+		{
+			name:    "markdown",
+			message: Message{MsgType: "markdown", Title: "Alert", Content: "**CPU** 95%"},
+			want:    `{"markdown":{"content":"### Alert\n**CPU** 95%"},"msgtype":"markdown"}`,
+		},
+		{
+			name:    "image",
+			message: Message{MsgType: "image", Image: &Image{Data: []byte("hello")}},
+			want:    `{"image":{"base64":"aGVsbG8=","md5":"5d41402abc4b2a76b9719d911017c592"},"msgtype":"image"}`,
+		},
+		{
+			name: "news",
+			message: Message{
+				MsgType: "news",
+				News:    &News{Articles: []Article{{Title: "Release", URL: "https://example.com"}}},
+			},
+			want: `{"msgtype":"news","news":{"articles":[{"title":"Release","url":"https://example.com"}]}}`,
+		},
+		{
+			name:    "file",
+			message: Message{MsgType: "file", File: &File{MediaID: "media_1"}},
+			want:    `{"file":{"media_id":"media_1"},"msgtype":"file"}`,
+		},
+		{
+			name:    "image without data",
+			message: Message{MsgType: "image"},
+			wantErr: true,
+		},
+		{
+			name:    "news without articles",
+			message: Message{MsgType: "news", News: &News{}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid type",
+			message: Message{MsgType: "voice"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := buildRobotParams(tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRobotParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			got, _ := json.Marshal(params)
+			if string(got) != tt.want {
+				t.Errorf("buildRobotParams() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotify_SendRobotMessage_File(t *testing.T) {
+	srv, requests := newTestServer(t, 0)
+	n := newTestNotify(t, srv.URL)
+
+	_, err := n.sendMsg(Message{MsgType: "file", File: &File{Name: "report.txt", Data: []byte("report")}})
+	if err != nil {
+		t.Fatalf("sendMsg() error = %v", err)
+	}
+
+	file, _ := requests()[0].Body["file"].(map[string]any)
+	if file["media_id"] != "media_report.txt" {
+		t.Errorf("media_id = %v, want %v", file["media_id"], "media_report.txt")
+	}
+}
+
+func TestNotify_SendRobotMessage_ErrorCode(t *testing.T) {
+	srv, _ := newTestServer(t, 93000)
+	n := newTestNotify(t, srv.URL)
+
+	if _, err := n.sendMsg(Message{Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error for non-zero errcode")
+	}
+}
@@ -2,46 +2,641 @@
 // Use of this source code is governed by a MIT style
 // license that can be found in the LICENSE file.
 
+// Package wechat provides functionality for sending messages via WeCom (WeChat Work).
+// It supports sending messages through group robot webhooks and self-built apps.
 package wechat
 
-type (
-	Config struct {
-		Enabled bool
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/sk-pkg/notify/cache"
+	"github.com/sk-pkg/notify/internal/queue"
+	"github.com/sk-pkg/notify/msgid"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"log"
+	"runtime"
+)
+
+// Constants used throughout the package
+const (
+	// wecomHost is the base URL for WeCom API calls.
+	wecomHost = "https://qyapi.weixin.qq.com"
+
+	// webhookSendAPI is the URL for sending messages through group robots.
+	webhookSendAPI = "/cgi-bin/webhook/send"
+
+	// webhookUploadAPI is the URL for uploading files for group robots.
+	webhookUploadAPI = "/cgi-bin/webhook/upload_media"
+
+	// accessTokenAPI is the URL for retrieving the access token of a self-built app.
+	accessTokenAPI = "/cgi-bin/gettoken"
+
+	// appMessageAPI is the URL for sending messages through self-built apps.
+	appMessageAPI = "/cgi-bin/message/send"
+
+	// tokenCacheKey is the key used to store the app access token in the cache.
+	// %s will be replaced with the corp ID and %d with the agent ID.
+	tokenCacheKey = "wechat:token:%s:%d"
+)
+
+// Config represents the configuration for the WeCom notifier.
+type Config struct {
+	// Enabled indicates whether the notifier is active. Set to true to enable the notifier.
+	Enabled bool
+
+	// DefaultSendChannelName is the default channel name for sending messages when not specified in the message.
+	// This must be set to a valid channel name from either Robots or Apps.
+	DefaultSendChannelName string
+
+	// ChannelSize defines the buffer size for the message channel.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	ChannelSize int
+
+	// PoolSize defines the number of goroutines in the worker pool.
+	// If set to 0, it defaults to 10 * GOMAXPROCS.
+	PoolSize int
+
+	// ResultBufferSize defines the number of results buffered for the handlers registered with Subscribe.
+	// Once it is reached, the deliveries wait for the handlers to catch up instead of buffering more results.
+	// If set to 0, it defaults to ChannelSize.
+	ResultBufferSize int
+
+	// IdleSize is kept for compatibility and is currently unused.
+	IdleSize int
+
+	// Robots is a map of robot names to their corresponding group robot configurations.
+	// The key will be used as the send channel name.
+	Robots map[string]Robot
+
+	// Apps is a map of self-built app configurations, keyed by a unique identifier for each app.
+	// Use this to send messages to individual users, departments or tags.
+	// The key will be used as the send channel name.
+	Apps map[string]App
+
+	// Retry is the policy applied to failed deliveries: transport errors, 408, 429 and 5xx statuses,
+	// and the error codes meaning that WeCom is busy or the call frequency limit is exceeded.
+	// The zero value retries 3 times with exponential backoff.
+	Retry retry.Policy
+
+	// Outbox persists the queued messages to disk before SubmitMessage returns, so that the messages not
+	// delivered yet are delivered by StartProcessor after a restart. It is disabled if Outbox.Dir is empty.
+	Outbox outbox.Config
+}
+
+// Robot represents the configuration for a WeCom group robot.
+type Robot struct {
+	// Key is the key query parameter of the robot webhook URL.
+	Key string
+}
+
+// App represents the configuration for a WeCom self-built app.
+type App struct {
+	// AgentID is the agent ID of the app.
+	AgentID int64
+
+	// Token is a function that returns the access token for the app.
+	// If not provided, a default function using CorpID and CorpSecret will be used.
+	Token func() (string, error)
+
+	// CorpID is the ID of the enterprise.
+	// This is required if Token is not provided.
+	CorpID string
+
+	// CorpSecret is the secret of the app.
+	// This is required if Token is not provided.
+	CorpSecret string
+}
+
+// Notify is the interface that wraps the basic methods for the notifier.
+type Notify interface {
+	// StartProcessor initiates the message processing routine.
+	// It should be called once before submitting any messages.
+	StartProcessor()
+
+	// SubmitMessage adds a new message to the processing queue.
+	// The message will be processed asynchronously by the processor started with StartProcessor.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message.
+	SubmitMessage(message Message) (msgID string, err error)
+
+	// SubmitMessageContext is like SubmitMessage, but the message is bound to ctx.
+	// If ctx is done before the message is queued or sent, the message is dropped.
+	//
+	// Parameters:
+	// 	- ctx: The context of the message.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- msgID: A unique identifier for the submitted message.
+	// 	- err: An error that occurred while submitting the message, or the error of ctx.
+	SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error)
+
+	// Token retrieves the access token for a specific self-built app.
+	//
+	// Parameters:
+	// 	- appName: The name of the app.
+	//
+	// Returns:
+	// 	- token: The access token for the app.
+	// 	- err: An error that occurred while retrieving the token.
+	Token(appName string) (token string, err error)
+
+	// Send sends a message immediately, bypassing the processing queue.
+	//
+	// Parameters:
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The message ID of an app message, empty for robot messages.
+	// 	- err: An error if the message cannot be sent.
+	Send(message Message) (providerMsgID string, err error)
+
+	// SendContext sends a message immediately, bypassing the processing queue, and stops retrying
+	// once ctx is done.
+	//
+	// Parameters:
+	// 	- ctx: The context of the delivery.
+	// 	- message: The Message struct containing all necessary information for sending the notification.
+	//
+	// Returns:
+	// 	- providerMsgID: The message ID of an app message, empty for robot messages.
+	// 	- attempts: The number of attempts made.
+	// 	- err: An error if the message cannot be sent.
+	SendContext(ctx context.Context, message Message) (providerMsgID string, attempts int, err error)
+
+	// Subscribe registers a handler called with the result of every delivery attempt, including the
+	// retries and the messages dropped before delivery, of the queued messages and of SendContext.
+	// Handlers are called one at a time from a single goroutine, and should return quickly.
+	//
+	// Parameters:
+	// 	- handler: The function called with each SendResult.
+	//
+	// Returns:
+	// 	- unsubscribe: A function removing the handler.
+	Subscribe(handler func(SendResult)) (unsubscribe func())
+
+	// Close stops the notifier, ensuring all pending messages are processed
+	// before shutting down. After calling Close, the notifier should not be used anymore.
+	Close()
+}
+
+// notify implements the Notify interface.
+type notify struct {
+	// defaultSendChannelName is the default channel name for sending messages when not specified in the message.
+	defaultSendChannelName string
+
+	// host is the base URL for WeCom API calls.
+	host string
+
+	// msgID is ID instance used for generating unique message IDs.
+	msgID *msgid.ID
+
+	// request is a resty client used for making HTTP requests to the WeCom API.
+	request *resty.Client
+
+	// robots is a map of robot names to their corresponding configurations.
+	robots map[string]Robot
+
+	// apps is a map of self-built app names to their corresponding configurations.
+	apps map[string]*app
+
+	// cache is a cache instance used for caching tokens.
+	cache cache.Cache
+
+	// retry is the policy applied to failed deliveries.
+	retry retry.Policy
+
+	// queue delivers the submitted messages, retrying them and persisting them to the outbox.
+	queue *queue.Queue[Message]
+
+	// results passes the results of the deliveries to the subscribers.
+	results *queue.Results[SendResult]
+}
+
+// app represents an initialized self-built app.
+type app struct {
+	// agentID is the agent ID of the app.
+	agentID int64
+
+	// token is a function that returns the access token for the app.
+	token func() (string, error)
+
+	// invalidate drops the cached access token so that the next call to token fetches a new one.
+	// It is a no-op for apps configured with a custom Token function.
+	invalidate func()
+}
+
+// Message represents a message to be sent via the notifier.
+type Message struct {
+	// ID is a unique identifier for the message. It can be used for tracking or deduplication.
+	ID string
+
+	// DeliveryID identifies the delivery of the message to one of its targets. It is reported in its SendResults.
+	DeliveryID string
+
+	// SendChannelName specifies the robot or app through which the message should be sent.
+	// If empty, the DefaultSendChannelName from the Config will be used.
+	SendChannelName string
+
+	// SendTo specifies the recipients of an app message and is ignored by robots.
+	// It is a ";" separated list of targets, each optionally prefixed with "user:", "party:" or "tag:".
+	// Targets without a prefix are user IDs. IDs of the same kind are separated by "|" or ",".
+	//
+	// Example: "zhangsan|lisi;party:2;tag:5", or "@all" to send to every user of the app.
+	SendTo string
+
+	// MsgType specifies the type of message. If empty, text will be used.
+	//
+	// Robot messages support text, markdown, image, news, file.
+	//
+	// App messages support text, textcard, markdown, template_card.
+	MsgType string
+
+	// Title is the title of the message.
+	// It is prepended to the content of text messages and rendered as a heading of markdown messages.
+	Title string
+
+	// Content contains the main body of the message. It is used by text and markdown messages.
+	Content string
+
+	// MentionedList is a list of user IDs to mention in a text message. Use "@all" to mention everyone.
+	MentionedList []string
+
+	// MentionedMobileList is a list of mobile numbers to mention in a text message. Use "@all" to mention everyone.
+	MentionedMobileList []string
+
+	// Image contains the payload of an image message. Required if MsgType is image.
+	Image *Image
+
+	// News contains the payload of a news message. Required if MsgType is news.
+	News *News
+
+	// File contains the payload of a file message. Required if MsgType is file.
+	File *File
+
+	// TextCard contains the payload of a textcard app message. Required if MsgType is textcard.
+	// Title and Content are used as the title and description of the card.
+	TextCard *TextCard
+
+	// TemplateCard contains the template_card object of an app message. Required if MsgType is template_card.
+	// More details about the format can be found in the WeCom API documentation.
+	// https://developer.work.weixin.qq.com/document/path/90236
+	TemplateCard any
+}
+
+// TextCard represents the payload of a textcard app message.
+type TextCard struct {
+	// URL is the URL opened when the card is clicked.
+	URL string
+
+	// BtnTxt is the text of the button. Defaults to "详情" if empty.
+	BtnTxt string
+}
+
+// Image represents the payload of an image message.
+// The base64 content and md5 checksum are computed from Data when the message is sent.
+type Image struct {
+	// Data is the raw JPG or PNG image, no larger than 2MB.
+	Data []byte
+}
+
+// News represents the payload of a news message.
+type News struct {
+	// Articles are the articles of the message, 1 to 8 entries.
+	Articles []Article `json:"articles"`
+}
 
-		ChannelSize int
-		PoolSize    int
-		IdleSize    int
+// Article represents an article of a news message.
+type Article struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"`
+	PicURL      string `json:"picurl,omitempty"`
+}
+
+// File represents the payload of a file message.
+// If MediaID is empty, Data is uploaded as Name to obtain a media ID before the message is sent.
+type File struct {
+	// MediaID is the ID of a file previously uploaded for the robot.
+	MediaID string
+
+	// Name is the file name shown to the recipients.
+	Name string
+
+	// Data is the raw file content, no larger than 20MB.
+	Data []byte
+}
+
+// messageResp represents the response from the WeCom API.
+type messageResp struct {
+	ErrCode int    `json:"errcode"` // Response code, 0 indicates success
+	ErrMsg  string `json:"errmsg"`  // Error message if the request failed
+}
+
+// accessTokenResp represents the response from the WeCom access token API.
+type accessTokenResp struct {
+	ErrCode     int    `json:"errcode"`      // Response code, 0 indicates success
+	ErrMsg      string `json:"errmsg"`       // Error message if the request failed
+	AccessToken string `json:"access_token"` // The access token for the app
+	ExpiresIn   int    `json:"expires_in"`   // Token expiration time in seconds
+}
+
+// uploadResp represents the response from the WeCom robot upload API.
+type uploadResp struct {
+	ErrCode int    `json:"errcode"`  // Response code, 0 indicates success
+	ErrMsg  string `json:"errmsg"`   // Error message if the request failed
+	MediaID string `json:"media_id"` // ID of the uploaded file
+}
+
+// validateConfig checks the provided configuration for validity.
+//
+// Parameters:
+//   - config: A pointer to the Config struct to be validated.
+//
+// Returns:
+//   - error: An error if the configuration is invalid, nil otherwise.
+func validateConfig(config *Config) error {
+	if len(config.Robots) == 0 && len(config.Apps) == 0 {
+		return errors.New("there are no available sending channels for wechat, please configure Robots or Apps")
 	}
 
-	Notify interface {
-		StartProcessor()
-		SubmitMessage(message Message)
-		Close()
+	if config.DefaultSendChannelName == "" {
+		return errors.New("DefaultSendChannelName is required")
 	}
 
-	notify struct {
-		messages chan Message
+	for name, robot := range config.Robots {
+		if robot.Key == "" {
+			return fmt.Errorf("wechat robot config error: %s", name)
+		}
 	}
 
-	Message struct {
-		ID      string
-		Title   string
-		Content string
+	for name, a := range config.Apps {
+		if a.AgentID == 0 || (a.Token == nil && (a.CorpID == "" || a.CorpSecret == "")) {
+			return fmt.Errorf("wechat app config error: %s", name)
+		}
+	}
+
+	_, isRobot := config.Robots[config.DefaultSendChannelName]
+	_, isApp := config.Apps[config.DefaultSendChannelName]
+	if !isRobot && !isApp {
+		return fmt.Errorf("default send channel %s is not found in wechat", config.DefaultSendChannelName)
+	}
+
+	// Set default values for ChannelSize and PoolSize if not provided
+	// Default to GOMAXPROCS * 10
+	if config.ChannelSize == 0 {
+		config.ChannelSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+
+	if config.ResultBufferSize == 0 {
+		config.ResultBufferSize = config.ChannelSize
+	}
+
+	if err := config.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid wechat retry policy: %w", err)
 	}
-)
 
-func (n notify) Close() {
-	return
+	if err := config.Outbox.Validate(); err != nil {
+		return fmt.Errorf("invalid wechat outbox: %w", err)
+	}
+
+	return nil
 }
 
-func (n notify) StartProcessor() {
+// StartProcessor starts the message processing goroutine.
+// It first delivers the messages recovered from the outbox, then continuously delivers the submitted messages.
+func (n *notify) StartProcessor() {
+	n.queue.Start()
+}
 
+// SubmitMessage submits a message to the notifier's message queue.
+//
+// Parameters:
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     or outbox.ErrDuplicate if the message is already queued for the same target.
+func (n *notify) SubmitMessage(message Message) (msgID string, err error) {
+	return n.SubmitMessageContext(context.Background(), message)
 }
 
-func (n notify) SubmitMessage(message Message) {
-	n.messages <- message
+// SubmitMessageContext submits a message bound to ctx to the notifier's message queue.
+//
+// Parameters:
+//   - ctx: The context of the message. It is kept with the message until it is delivered.
+//   - message: The Message struct to be submitted.
+//
+// Returns:
+//   - msgID: The ID of the submitted message. If the message ID is not provided, a new one will be generated.
+//   - error: Any error encountered during the process, such as a failure to write the message to the outbox,
+//     outbox.ErrDuplicate if the message is already queued for the same target, ErrClosed after Close,
+//     or the error of ctx if it is done before the message is queued.
+func (n *notify) SubmitMessageContext(ctx context.Context, message Message) (msgID string, err error) {
+	if message.ID == "" {
+		message.ID = n.msgID.New()
+	}
+
+	key := outbox.Key(message.ID, message.SendChannelName, message.SendTo)
+
+	return message.ID, n.queue.Submit(ctx, key, message)
 }
 
+// New creates a new Notify instance with the provided configuration.
+//
+// Parameters:
+//   - config: The Config struct containing the notifier configuration.
+//
+// Returns:
+//   - Notify: A new Notify instance.
+//   - error: An error if the configuration is invalid or if the goroutine pool cannot be created.
 func New(config Config) (Notify, error) {
-	return &notify{messages: make(chan Message, config.ChannelSize)}, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	n := &notify{
+		defaultSendChannelName: config.DefaultSendChannelName,
+		host:                   wecomHost,
+		msgID:                  msgid.NewMessageID(),
+		request:                resty.New(),
+		robots:                 config.Robots,
+		apps:                   make(map[string]*app),
+		cache:                  cache.New(),
+		retry:                  config.Retry,
+	}
+
+	// Initialize self-built apps
+	for name, a := range config.Apps {
+		ap := &app{agentID: a.AgentID, token: a.Token, invalidate: func() {}}
+
+		// If Token is not provided,
+		// a.CorpID and a.CorpSecret will be used to generate the token.
+		if a.Token == nil {
+			corpID, corpSecret, agentID := a.CorpID, a.CorpSecret, a.AgentID
+			ap.token = func() (string, error) {
+				return n.getToken(corpID, corpSecret, agentID)
+			}
+			ap.invalidate = func() {
+				if d, ok := n.cache.(cache.Deleter); ok {
+					_ = d.Delete(fmt.Sprintf(tokenCacheKey, corpID, agentID))
+				}
+			}
+		}
+
+		n.apps[name] = ap
+	}
+
+	q, err := queue.New(queue.Config[Message]{
+		Name:     "wechat",
+		Size:     config.ChannelSize,
+		PoolSize: config.PoolSize,
+		Retry:    config.Retry,
+		Outbox:   config.Outbox,
+		Send: func(_ context.Context, m Message) (string, error) {
+			return n.sendMsg(m)
+		},
+		Report: n.report,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.queue = q
+	n.results = queue.NewResults[SendResult](config.ResultBufferSize)
+
+	return n, nil
+}
+
+// Token retrieves the access token for a specific self-built app.
+//
+// Parameters:
+//   - appName: The name of the app.
+//
+// Returns:
+//   - token: The access token for the app.
+//   - err: An error that occurred while retrieving the token.
+func (n *notify) Token(appName string) (token string, err error) {
+	a, ok := n.apps[appName]
+	if !ok {
+		return "", fmt.Errorf("wechat app %s not found", appName)
+	}
+
+	return a.token()
+}
+
+// Send sends a message immediately.
+//
+// Parameters:
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The message ID of an app message, empty for robot messages.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) Send(message Message) (string, error) {
+	providerMsgID, _, err := n.SendContext(context.Background(), message)
+
+	return providerMsgID, err
+}
+
+// SendContext sends a message immediately, and stops retrying once ctx is done.
+//
+// Parameters:
+//   - ctx: The context of the delivery.
+//   - message: The Message struct to be sent.
+//
+// Returns:
+//   - string: The message ID of an app message, empty for robot messages.
+//   - int: The number of attempts made.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) SendContext(ctx context.Context, message Message) (string, int, error) {
+	if message.ID == "" {
+		message.ID = n.msgID.New()
+	}
+
+	if err := ctx.Err(); err != nil {
+		n.report(message, queue.Result{State: StateDropped, Err: err})
+		return "", 0, err
+	}
+
+	// Retries are waited for in place, as the caller waits for the result anyway
+	for attempt := 1; ; attempt++ {
+		providerMsgID, err := n.sendMsg(message)
+		result := queue.Result{State: StateSent, ProviderMsgID: providerMsgID, Err: err, Attempts: attempt}
+
+		delay, ok := n.retry.Delay(attempt, err)
+		if !ok {
+			if err != nil {
+				result.State = StateFailed
+			}
+			n.report(message, result)
+
+			return providerMsgID, attempt, err
+		}
+
+		result.State = StateRetrying
+		n.report(message, result)
+
+		if waitErr := retry.Wait(ctx, delay); waitErr != nil {
+			result.State, result.Err = StateFailed, fmt.Errorf("%w, retry aborted: %w", err, waitErr)
+			n.report(message, result)
+
+			return providerMsgID, attempt, result.Err
+		}
+	}
+}
+
+// sendMsg sends a message using the appropriate channel (robot or self-built app).
+//
+// Parameters:
+//   - m: The Message struct containing the message details.
+//
+// Returns:
+//   - string: The message ID of an app message, empty for robot messages.
+//   - error: An error if the message cannot be sent, nil otherwise.
+func (n *notify) sendMsg(m Message) (string, error) {
+	channel := m.SendChannelName
+	if channel == "" {
+		channel = n.defaultSendChannelName
+	}
+
+	// Check if the channel is a robot
+	if robot, ok := n.robots[channel]; ok {
+		return "", n.sendRobotMessage(robot, m)
+	}
+
+	// Check if the channel is a self-built app
+	if a, ok := n.apps[channel]; ok {
+		if m.SendTo == "" {
+			return "", fmt.Errorf("sendTo is required for wechat app %s", channel)
+		}
+
+		return n.sendAppMessage(a, m)
+	}
+
+	return "", fmt.Errorf("channel %s is not found in wechat", channel)
+}
+
+// Close stops the notifier, waits for all messages to be processed, and releases resources.
+func (n *notify) Close() {
+	// Stop accepting new messages and wait for the queued ones to be processed
+	n.queue.Close()
+
+	// Deliver the remaining results to the subscribers
+	n.results.Close()
+
+	log.Println("Wechat notify closed")
 }
@@ -0,0 +1,271 @@
+// Copyright 2024 Seakee.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sk-pkg/notify/outbox"
+	"github.com/sk-pkg/notify/retry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRobotKey = "test_robot_key"
+
+// robotRequest records a request received by the fake robot server.
+type robotRequest struct {
+	Query url.Values
+	Body  map[string]any
+}
+
+// newTestServer starts a fake WeCom server that records every robot message
+// and answers with the given errcode.
+func newTestServer(t *testing.T, errCode int) (*httptest.Server, func() []robotRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []robotRequest
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookSendAPI, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var params map[string]any
+		_ = json.Unmarshal(body, &params)
+
+		mu.Lock()
+		requests = append(requests, robotRequest{Query: r.URL.Query(), Body: params})
+		mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(messageResp{ErrCode: errCode, ErrMsg: "test"})
+	})
+	mux.HandleFunc(webhookUploadAPI, func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("media")
+		if err != nil || r.URL.Query().Get("type") != "file" {
+			_ = json.NewEncoder(w).Encode(uploadResp{ErrCode: 40004, ErrMsg: "invalid media"})
+			return
+		}
+		defer file.Close()
+
+		_ = json.NewEncoder(w).Encode(uploadResp{MediaID: "media_" + header.Filename})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, func() []robotRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]robotRequest(nil), requests...)
+	}
+}
+
+func newTestNotify(t *testing.T, host string) *notify {
+	t.Helper()
+
+	i, err := New(Config{
+		Enabled:                true,
+		DefaultSendChannelName: "ops",
+		Robots:                 map[string]Robot{"ops": {Key: testRobotKey}},
+		Retry:                  retry.Policy{MaxAttempts: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	n := i.(*notify)
+	n.host = host
+
+	return n
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name: "Valid config with robot",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "ops",
+				Robots:                 map[string]Robot{"ops": {Key: testRobotKey}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid config - no robots",
+			config:  Config{Enabled: true, DefaultSendChannelName: "ops"},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - missing key",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "ops",
+				Robots:                 map[string]Robot{"ops": {}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid config with app",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "app",
+				Apps:                   map[string]App{"app": {AgentID: 1, CorpID: "corp", CorpSecret: "secret"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid config - app without agent ID",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "app",
+				Apps:                   map[string]App{"app": {CorpID: "corp", CorpSecret: "secret"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid config - unknown default channel",
+			config: Config{
+				Enabled:                true,
+				DefaultSendChannelName: "other",
+				Robots:                 map[string]Robot{"ops": {Key: testRobotKey}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotify_SubmitMessage(t *testing.T) {
+	srv, requests := newTestServer(t, 0)
+	n := newTestNotify(t, srv.URL)
+
+	n.StartProcessor()
+
+	for i := 0; i < 5; i++ {
+		if _, err := n.SubmitMessage(Message{Title: "Title", Content: "Content"}); err != nil {
+			t.Fatalf("SubmitMessage() error = %v", err)
+		}
+	}
+
+	// Close waits for the queued messages to be delivered
+	n.Close()
+
+	got := requests()
+	if len(got) != 5 {
+		t.Fatalf("server received %d requests, want 5", len(got))
+	}
+
+	if got[0].Query.Get("key") != testRobotKey {
+		t.Errorf("key = %v, want %v", got[0].Query.Get("key"), testRobotKey)
+	}
+}
+
+func TestNotify_SendMsg_UnknownChannel(t *testing.T) {
+	n := newTestNotify(t, "http://127.0.0.1:0")
+
+	if _, err := n.sendMsg(Message{SendChannelName: "missing", Content: "hello"}); err == nil {
+		t.Error("sendMsg() expected error for unknown channel")
+	}
+}
+
+func TestNotify_Send_Retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		errCode   int
+		wantCalls int
+	}{
+		{name: "Frequency limit exceeded", errCode: 45009, wantCalls: 3},
+		{name: "Permanent error", errCode: 93000, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newTestServer(t, tt.errCode)
+			n := newTestNotify(t, srv.URL)
+			n.retry = retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+			_, attempts, err := n.SendContext(context.Background(), Message{Content: "hello"})
+			if err == nil || attempts != tt.wantCalls {
+				t.Errorf("SendContext() = %d attempts, %v, want %d attempts and an error", attempts, err, tt.wantCalls)
+			}
+
+			if got := len(requests()); got != tt.wantCalls {
+				t.Errorf("server received %d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNotify_SubmitMessage_Retry(t *testing.T) {
+	srv, requests := newTestServer(t, -1)
+	n := newTestNotify(t, srv.URL)
+
+	// The queued deliveries are reported to the subscribers
+	var states []string
+	n.Subscribe(func(r SendResult) { states = append(states, r.MsgID+":"+r.DeliveryID+":"+r.State) })
+	n.StartProcessor()
+
+	if _, err := n.SubmitMessage(Message{ID: "msg-1", DeliveryID: "1", Content: "hello"}); err != nil {
+		t.Fatalf("SubmitMessage() error = %v", err)
+	}
+
+	// Close waits for the scheduled retry
+	n.Close()
+
+	if got := len(requests()); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+	if want := "[msg-1:1:retrying msg-1:1:failed]"; fmt.Sprint(states) != want {
+		t.Errorf("published %v, want %s", states, want)
+	}
+}
+
+func TestNotify_Outbox(t *testing.T) {
+	i, err := New(Config{
+		DefaultSendChannelName: "ops",
+		Robots:                 map[string]Robot{"ops": {Key: testRobotKey}},
+		Apps:                   map[string]App{"app": {AgentID: 1, Token: func() (string, error) { return "token", nil }}},
+		Outbox:                 outbox.Config{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer i.Close()
+
+	// A message is queued once per robot or app recipient
+	message := Message{ID: "msg-1", Content: "disk full"}
+	for _, target := range []Message{{SendChannelName: "ops"}, {SendChannelName: "app", SendTo: "zhangsan"}, {SendChannelName: "app", SendTo: "lisi"}} {
+		message.SendChannelName, message.SendTo = target.SendChannelName, target.SendTo
+		if _, err = i.SubmitMessage(message); err != nil {
+			t.Fatalf("SubmitMessage() to %s %s error = %v", target.SendChannelName, target.SendTo, err)
+		}
+	}
+
+	if _, err = i.SubmitMessage(message); !errors.Is(err, outbox.ErrDuplicate) {
+		t.Errorf("SubmitMessage() again error = %v, want outbox.ErrDuplicate", err)
+	}
+}